@@ -0,0 +1,2157 @@
+// Protobuf definition of the proxy worker API.
+//
+// Regenerate the Go code from the repository root with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	       --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//	       api/proxierpb/proxier.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: api/proxierpb/proxier.proto
+
+package proxierpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ProxyJob struct {
+	state                protoimpl.MessageState  `protogen:"open.v1"`
+	Url                  string                  `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Method               string                  `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	Headers              map[string]string       `protobuf:"bytes,3,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Body                 []byte                  `protobuf:"bytes,4,opt,name=body,proto3" json:"body,omitempty"`
+	Cookies              map[string]string       `protobuf:"bytes,5,rep,name=cookies,proto3" json:"cookies,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Timeout              int32                   `protobuf:"varint,6,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	TimeoutMs            int32                   `protobuf:"varint,7,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	BasicAuthUser        string                  `protobuf:"bytes,8,opt,name=basic_auth_user,json=basicAuthUser,proto3" json:"basic_auth_user,omitempty"`
+	BasicAuthPass        string                  `protobuf:"bytes,9,opt,name=basic_auth_pass,json=basicAuthPass,proto3" json:"basic_auth_pass,omitempty"`
+	KeepHopByHopHeaders  bool                    `protobuf:"varint,10,opt,name=keep_hop_by_hop_headers,json=keepHopByHopHeaders,proto3" json:"keep_hop_by_hop_headers,omitempty"`
+	RawBytes             bool                    `protobuf:"varint,11,opt,name=raw_bytes,json=rawBytes,proto3" json:"raw_bytes,omitempty"`
+	CacheBody            bool                    `protobuf:"varint,12,opt,name=cache_body,json=cacheBody,proto3" json:"cache_body,omitempty"`
+	Script               string                  `protobuf:"bytes,13,opt,name=script,proto3" json:"script,omitempty"`
+	CompressResponseBody bool                    `protobuf:"varint,14,opt,name=compress_response_body,json=compressResponseBody,proto3" json:"compress_response_body,omitempty"`
+	Sni                  string                  `protobuf:"bytes,15,opt,name=sni,proto3" json:"sni,omitempty"`
+	FollowMetaRefresh    bool                    `protobuf:"varint,16,opt,name=follow_meta_refresh,json=followMetaRefresh,proto3" json:"follow_meta_refresh,omitempty"`
+	DetectJsRedirect     bool                    `protobuf:"varint,17,opt,name=detect_js_redirect,json=detectJsRedirect,proto3" json:"detect_js_redirect,omitempty"`
+	SigningScheme        string                  `protobuf:"bytes,18,opt,name=signing_scheme,json=signingScheme,proto3" json:"signing_scheme,omitempty"`
+	MaxRetries           int32                   `protobuf:"varint,19,opt,name=max_retries,json=maxRetries,proto3" json:"max_retries,omitempty"`
+	RetryOnStatus        []int32                 `protobuf:"varint,20,rep,packed,name=retry_on_status,json=retryOnStatus,proto3" json:"retry_on_status,omitempty"`
+	RetryNonIdempotent   bool                    `protobuf:"varint,21,opt,name=retry_non_idempotent,json=retryNonIdempotent,proto3" json:"retry_non_idempotent,omitempty"`
+	ProxyUrl             string                  `protobuf:"bytes,22,opt,name=proxy_url,json=proxyUrl,proto3" json:"proxy_url,omitempty"`
+	QueryParams          map[string]*QueryValues `protobuf:"bytes,23,rep,name=query_params,json=queryParams,proto3" json:"query_params,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	InsecureSkipVerify   bool                    `protobuf:"varint,24,opt,name=insecure_skip_verify,json=insecureSkipVerify,proto3" json:"insecure_skip_verify,omitempty"`
+	CaCert               string                  `protobuf:"bytes,25,opt,name=ca_cert,json=caCert,proto3" json:"ca_cert,omitempty"`
+	FollowRedirects      bool                    `protobuf:"varint,26,opt,name=follow_redirects,json=followRedirects,proto3" json:"follow_redirects,omitempty"`
+	MaxRedirects         int32                   `protobuf:"varint,27,opt,name=max_redirects,json=maxRedirects,proto3" json:"max_redirects,omitempty"`
+	Retry                *RetrySpec              `protobuf:"bytes,28,opt,name=retry,proto3" json:"retry,omitempty"`
+	CacheTtl             int32                   `protobuf:"varint,29,opt,name=cache_ttl,json=cacheTtl,proto3" json:"cache_ttl,omitempty"`
+	NoCache              bool                    `protobuf:"varint,30,opt,name=no_cache,json=noCache,proto3" json:"no_cache,omitempty"`
+	SessionId            string                  `protobuf:"bytes,31,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	CallbackUrl          string                  `protobuf:"bytes,32,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	WorkerTags           map[string]string       `protobuf:"bytes,33,rep,name=worker_tags,json=workerTags,proto3" json:"worker_tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Extract              map[string]*Extractor   `protobuf:"bytes,34,rep,name=extract,proto3" json:"extract,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ExtractOnly          bool                    `protobuf:"varint,35,opt,name=extract_only,json=extractOnly,proto3" json:"extract_only,omitempty"`
+	Pagination           *Pagination             `protobuf:"bytes,36,opt,name=pagination,proto3" json:"pagination,omitempty"`
+	RewriteRules         []*RewriteRule          `protobuf:"bytes,37,rep,name=rewrite_rules,json=rewriteRules,proto3" json:"rewrite_rules,omitempty"`
+	MaxBodyBytes         int64                   `protobuf:"varint,38,opt,name=max_body_bytes,json=maxBodyBytes,proto3" json:"max_body_bytes,omitempty"`
+	// auth_type is basic to send basic auth right away or digest to only
+	// answer Digest challenges, empty answers the challenge of the upstream
+	AuthType string `protobuf:"bytes,39,opt,name=auth_type,json=authType,proto3" json:"auth_type,omitempty"`
+	// retry_if_body_matches is a regular expression of bodies that are
+	// retried like retry_on_status, even with a 2xx
+	RetryIfBodyMatches string `protobuf:"bytes,40,opt,name=retry_if_body_matches,json=retryIfBodyMatches,proto3" json:"retry_if_body_matches,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *ProxyJob) Reset() {
+	*x = ProxyJob{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProxyJob) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProxyJob) ProtoMessage() {}
+
+func (x *ProxyJob) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProxyJob.ProtoReflect.Descriptor instead.
+func (*ProxyJob) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ProxyJob) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetHeaders() map[string]string {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetCookies() map[string]string {
+	if x != nil {
+		return x.Cookies
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetTimeout() int32 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+func (x *ProxyJob) GetTimeoutMs() int32 {
+	if x != nil {
+		return x.TimeoutMs
+	}
+	return 0
+}
+
+func (x *ProxyJob) GetBasicAuthUser() string {
+	if x != nil {
+		return x.BasicAuthUser
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetBasicAuthPass() string {
+	if x != nil {
+		return x.BasicAuthPass
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetKeepHopByHopHeaders() bool {
+	if x != nil {
+		return x.KeepHopByHopHeaders
+	}
+	return false
+}
+
+func (x *ProxyJob) GetRawBytes() bool {
+	if x != nil {
+		return x.RawBytes
+	}
+	return false
+}
+
+func (x *ProxyJob) GetCacheBody() bool {
+	if x != nil {
+		return x.CacheBody
+	}
+	return false
+}
+
+func (x *ProxyJob) GetScript() string {
+	if x != nil {
+		return x.Script
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetCompressResponseBody() bool {
+	if x != nil {
+		return x.CompressResponseBody
+	}
+	return false
+}
+
+func (x *ProxyJob) GetSni() string {
+	if x != nil {
+		return x.Sni
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetFollowMetaRefresh() bool {
+	if x != nil {
+		return x.FollowMetaRefresh
+	}
+	return false
+}
+
+func (x *ProxyJob) GetDetectJsRedirect() bool {
+	if x != nil {
+		return x.DetectJsRedirect
+	}
+	return false
+}
+
+func (x *ProxyJob) GetSigningScheme() string {
+	if x != nil {
+		return x.SigningScheme
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetMaxRetries() int32 {
+	if x != nil {
+		return x.MaxRetries
+	}
+	return 0
+}
+
+func (x *ProxyJob) GetRetryOnStatus() []int32 {
+	if x != nil {
+		return x.RetryOnStatus
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetRetryNonIdempotent() bool {
+	if x != nil {
+		return x.RetryNonIdempotent
+	}
+	return false
+}
+
+func (x *ProxyJob) GetProxyUrl() string {
+	if x != nil {
+		return x.ProxyUrl
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetQueryParams() map[string]*QueryValues {
+	if x != nil {
+		return x.QueryParams
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetInsecureSkipVerify() bool {
+	if x != nil {
+		return x.InsecureSkipVerify
+	}
+	return false
+}
+
+func (x *ProxyJob) GetCaCert() string {
+	if x != nil {
+		return x.CaCert
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetFollowRedirects() bool {
+	if x != nil {
+		return x.FollowRedirects
+	}
+	return false
+}
+
+func (x *ProxyJob) GetMaxRedirects() int32 {
+	if x != nil {
+		return x.MaxRedirects
+	}
+	return 0
+}
+
+func (x *ProxyJob) GetRetry() *RetrySpec {
+	if x != nil {
+		return x.Retry
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetCacheTtl() int32 {
+	if x != nil {
+		return x.CacheTtl
+	}
+	return 0
+}
+
+func (x *ProxyJob) GetNoCache() bool {
+	if x != nil {
+		return x.NoCache
+	}
+	return false
+}
+
+func (x *ProxyJob) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetWorkerTags() map[string]string {
+	if x != nil {
+		return x.WorkerTags
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetExtract() map[string]*Extractor {
+	if x != nil {
+		return x.Extract
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetExtractOnly() bool {
+	if x != nil {
+		return x.ExtractOnly
+	}
+	return false
+}
+
+func (x *ProxyJob) GetPagination() *Pagination {
+	if x != nil {
+		return x.Pagination
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetRewriteRules() []*RewriteRule {
+	if x != nil {
+		return x.RewriteRules
+	}
+	return nil
+}
+
+func (x *ProxyJob) GetMaxBodyBytes() int64 {
+	if x != nil {
+		return x.MaxBodyBytes
+	}
+	return 0
+}
+
+func (x *ProxyJob) GetAuthType() string {
+	if x != nil {
+		return x.AuthType
+	}
+	return ""
+}
+
+func (x *ProxyJob) GetRetryIfBodyMatches() string {
+	if x != nil {
+		return x.RetryIfBodyMatches
+	}
+	return ""
+}
+
+// Pagination follows next links, mode is link_header or json_path
+type Pagination struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mode          string                 `protobuf:"bytes,1,opt,name=mode,proto3" json:"mode,omitempty"`
+	NextPath      string                 `protobuf:"bytes,2,opt,name=next_path,json=nextPath,proto3" json:"next_path,omitempty"`
+	MaxPages      int32                  `protobuf:"varint,3,opt,name=max_pages,json=maxPages,proto3" json:"max_pages,omitempty"`
+	DelayMs       int32                  `protobuf:"varint,4,opt,name=delay_ms,json=delayMs,proto3" json:"delay_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Pagination) Reset() {
+	*x = Pagination{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Pagination) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Pagination) ProtoMessage() {}
+
+func (x *Pagination) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Pagination.ProtoReflect.Descriptor instead.
+func (*Pagination) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Pagination) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Pagination) GetNextPath() string {
+	if x != nil {
+		return x.NextPath
+	}
+	return ""
+}
+
+func (x *Pagination) GetMaxPages() int32 {
+	if x != nil {
+		return x.MaxPages
+	}
+	return 0
+}
+
+func (x *Pagination) GetDelayMs() int32 {
+	if x != nil {
+		return x.DelayMs
+	}
+	return 0
+}
+
+// RewriteRule rewrites the requests and responses of jobs whose target
+// matches host and path, like the rewrite_rules of POST /proxy
+type RewriteRule struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Host          string                 `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	Path          string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Request       *RewriteActions        `protobuf:"bytes,3,opt,name=request,proto3" json:"request,omitempty"`
+	Response      *RewriteActions        `protobuf:"bytes,4,opt,name=response,proto3" json:"response,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RewriteRule) Reset() {
+	*x = RewriteRule{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RewriteRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RewriteRule) ProtoMessage() {}
+
+func (x *RewriteRule) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RewriteRule.ProtoReflect.Descriptor instead.
+func (*RewriteRule) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RewriteRule) GetHost() string {
+	if x != nil {
+		return x.Host
+	}
+	return ""
+}
+
+func (x *RewriteRule) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *RewriteRule) GetRequest() *RewriteActions {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *RewriteRule) GetResponse() *RewriteActions {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+type RewriteActions struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RemoveHeaders []string               `protobuf:"bytes,1,rep,name=remove_headers,json=removeHeaders,proto3" json:"remove_headers,omitempty"`
+	SetHeaders    map[string]string      `protobuf:"bytes,2,rep,name=set_headers,json=setHeaders,proto3" json:"set_headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	AddHeaders    map[string]string      `protobuf:"bytes,3,rep,name=add_headers,json=addHeaders,proto3" json:"add_headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Body          []*BodyRewrite         `protobuf:"bytes,4,rep,name=body,proto3" json:"body,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RewriteActions) Reset() {
+	*x = RewriteActions{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RewriteActions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RewriteActions) ProtoMessage() {}
+
+func (x *RewriteActions) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RewriteActions.ProtoReflect.Descriptor instead.
+func (*RewriteActions) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RewriteActions) GetRemoveHeaders() []string {
+	if x != nil {
+		return x.RemoveHeaders
+	}
+	return nil
+}
+
+func (x *RewriteActions) GetSetHeaders() map[string]string {
+	if x != nil {
+		return x.SetHeaders
+	}
+	return nil
+}
+
+func (x *RewriteActions) GetAddHeaders() map[string]string {
+	if x != nil {
+		return x.AddHeaders
+	}
+	return nil
+}
+
+func (x *RewriteActions) GetBody() []*BodyRewrite {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+type BodyRewrite struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pattern       string                 `protobuf:"bytes,1,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	Replace       string                 `protobuf:"bytes,2,opt,name=replace,proto3" json:"replace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BodyRewrite) Reset() {
+	*x = BodyRewrite{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BodyRewrite) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BodyRewrite) ProtoMessage() {}
+
+func (x *BodyRewrite) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BodyRewrite.ProtoReflect.Descriptor instead.
+func (*BodyRewrite) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BodyRewrite) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *BodyRewrite) GetReplace() string {
+	if x != nil {
+		return x.Replace
+	}
+	return ""
+}
+
+// Extractor selects a fragment of the response body by one of json, css or
+// xpath, like the extractors of POST /proxy
+type Extractor struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Json          string                 `protobuf:"bytes,1,opt,name=json,proto3" json:"json,omitempty"`
+	Css           string                 `protobuf:"bytes,2,opt,name=css,proto3" json:"css,omitempty"`
+	Xpath         string                 `protobuf:"bytes,3,opt,name=xpath,proto3" json:"xpath,omitempty"`
+	Attr          string                 `protobuf:"bytes,4,opt,name=attr,proto3" json:"attr,omitempty"`
+	All           bool                   `protobuf:"varint,5,opt,name=all,proto3" json:"all,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Extractor) Reset() {
+	*x = Extractor{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Extractor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Extractor) ProtoMessage() {}
+
+func (x *Extractor) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Extractor.ProtoReflect.Descriptor instead.
+func (*Extractor) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Extractor) GetJson() string {
+	if x != nil {
+		return x.Json
+	}
+	return ""
+}
+
+func (x *Extractor) GetCss() string {
+	if x != nil {
+		return x.Css
+	}
+	return ""
+}
+
+func (x *Extractor) GetXpath() string {
+	if x != nil {
+		return x.Xpath
+	}
+	return ""
+}
+
+func (x *Extractor) GetAttr() string {
+	if x != nil {
+		return x.Attr
+	}
+	return ""
+}
+
+func (x *Extractor) GetAll() bool {
+	if x != nil {
+		return x.All
+	}
+	return false
+}
+
+type RetrySpec struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	MaxAttempts          int32                  `protobuf:"varint,1,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+	BackoffBaseMs        int32                  `protobuf:"varint,2,opt,name=backoff_base_ms,json=backoffBaseMs,proto3" json:"backoff_base_ms,omitempty"`
+	BackoffMaxMs         int32                  `protobuf:"varint,3,opt,name=backoff_max_ms,json=backoffMaxMs,proto3" json:"backoff_max_ms,omitempty"`
+	RetryOnStatus        []int32                `protobuf:"varint,4,rep,packed,name=retry_on_status,json=retryOnStatus,proto3" json:"retry_on_status,omitempty"`
+	RetryOnNetworkErrors bool                   `protobuf:"varint,5,opt,name=retry_on_network_errors,json=retryOnNetworkErrors,proto3" json:"retry_on_network_errors,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *RetrySpec) Reset() {
+	*x = RetrySpec{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RetrySpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetrySpec) ProtoMessage() {}
+
+func (x *RetrySpec) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetrySpec.ProtoReflect.Descriptor instead.
+func (*RetrySpec) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RetrySpec) GetMaxAttempts() int32 {
+	if x != nil {
+		return x.MaxAttempts
+	}
+	return 0
+}
+
+func (x *RetrySpec) GetBackoffBaseMs() int32 {
+	if x != nil {
+		return x.BackoffBaseMs
+	}
+	return 0
+}
+
+func (x *RetrySpec) GetBackoffMaxMs() int32 {
+	if x != nil {
+		return x.BackoffMaxMs
+	}
+	return 0
+}
+
+func (x *RetrySpec) GetRetryOnStatus() []int32 {
+	if x != nil {
+		return x.RetryOnStatus
+	}
+	return nil
+}
+
+func (x *RetrySpec) GetRetryOnNetworkErrors() bool {
+	if x != nil {
+		return x.RetryOnNetworkErrors
+	}
+	return false
+}
+
+type QueryValues struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        []string               `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryValues) Reset() {
+	*x = QueryValues{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryValues) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryValues) ProtoMessage() {}
+
+func (x *QueryValues) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryValues.ProtoReflect.Descriptor instead.
+func (*QueryValues) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *QueryValues) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type HeaderValues struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        []string               `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeaderValues) Reset() {
+	*x = HeaderValues{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeaderValues) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeaderValues) ProtoMessage() {}
+
+func (x *HeaderValues) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeaderValues.ProtoReflect.Descriptor instead.
+func (*HeaderValues) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *HeaderValues) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type ProxyResponse struct {
+	state         protoimpl.MessageState   `protogen:"open.v1"`
+	StatusCode    int32                    `protobuf:"varint,1,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Body          []byte                   `protobuf:"bytes,2,opt,name=body,proto3" json:"body,omitempty"`
+	Errs          []string                 `protobuf:"bytes,3,rep,name=errs,proto3" json:"errs,omitempty"`
+	BodyId        string                   `protobuf:"bytes,4,opt,name=body_id,json=bodyId,proto3" json:"body_id,omitempty"`
+	BodySize      int64                    `protobuf:"varint,5,opt,name=body_size,json=bodySize,proto3" json:"body_size,omitempty"`
+	BodyEncoding  string                   `protobuf:"bytes,6,opt,name=body_encoding,json=bodyEncoding,proto3" json:"body_encoding,omitempty"`
+	FinalUrl      string                   `protobuf:"bytes,7,opt,name=final_url,json=finalUrl,proto3" json:"final_url,omitempty"`
+	MetaRefreshes int32                    `protobuf:"varint,8,opt,name=meta_refreshes,json=metaRefreshes,proto3" json:"meta_refreshes,omitempty"`
+	JsRedirect    string                   `protobuf:"bytes,9,opt,name=js_redirect,json=jsRedirect,proto3" json:"js_redirect,omitempty"`
+	Headers       map[string]*HeaderValues `protobuf:"bytes,10,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Redirects     int32                    `protobuf:"varint,11,opt,name=redirects,proto3" json:"redirects,omitempty"`
+	UpstreamProxy string                   `protobuf:"bytes,12,opt,name=upstream_proxy,json=upstreamProxy,proto3" json:"upstream_proxy,omitempty"`
+	DurationMs    int64                    `protobuf:"varint,13,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	Attempts      int32                    `protobuf:"varint,14,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	AttemptErrors []*AttemptError          `protobuf:"bytes,15,rep,name=attempt_errors,json=attemptErrors,proto3" json:"attempt_errors,omitempty"`
+	CacheStatus   string                   `protobuf:"bytes,16,opt,name=cache_status,json=cacheStatus,proto3" json:"cache_status,omitempty"`
+	// error_details are errs with their code, one for each
+	ErrorDetails []*ErrorDetail `protobuf:"bytes,17,rep,name=error_details,json=errorDetails,proto3" json:"error_details,omitempty"`
+	// extracted holds the values of the extractors of the job by name
+	Extracted map[string]*structpb.Value `protobuf:"bytes,18,rep,name=extracted,proto3" json:"extracted,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Cookies   []*ResponseCookie          `protobuf:"bytes,19,rep,name=cookies,proto3" json:"cookies,omitempty"`
+	// pages are the bodies of a paginated job, body is empty then
+	Pages       [][]byte `protobuf:"bytes,20,rep,name=pages,proto3" json:"pages,omitempty"`
+	PageCount   int32    `protobuf:"varint,21,opt,name=page_count,json=pageCount,proto3" json:"page_count,omitempty"`
+	StartedAtMs int64    `protobuf:"varint,22,opt,name=started_at_ms,json=startedAtMs,proto3" json:"started_at_ms,omitempty"`
+	// headers_truncated tells that headers past the server limits were left out
+	HeadersTruncated bool `protobuf:"varint,23,opt,name=headers_truncated,json=headersTruncated,proto3" json:"headers_truncated,omitempty"`
+	// served_from is cache or upstream
+	ServedFrom    string `protobuf:"bytes,24,opt,name=served_from,json=servedFrom,proto3" json:"served_from,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProxyResponse) Reset() {
+	*x = ProxyResponse{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProxyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProxyResponse) ProtoMessage() {}
+
+func (x *ProxyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProxyResponse.ProtoReflect.Descriptor instead.
+func (*ProxyResponse) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ProxyResponse) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetBody() []byte {
+	if x != nil {
+		return x.Body
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetErrs() []string {
+	if x != nil {
+		return x.Errs
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetBodyId() string {
+	if x != nil {
+		return x.BodyId
+	}
+	return ""
+}
+
+func (x *ProxyResponse) GetBodySize() int64 {
+	if x != nil {
+		return x.BodySize
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetBodyEncoding() string {
+	if x != nil {
+		return x.BodyEncoding
+	}
+	return ""
+}
+
+func (x *ProxyResponse) GetFinalUrl() string {
+	if x != nil {
+		return x.FinalUrl
+	}
+	return ""
+}
+
+func (x *ProxyResponse) GetMetaRefreshes() int32 {
+	if x != nil {
+		return x.MetaRefreshes
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetJsRedirect() string {
+	if x != nil {
+		return x.JsRedirect
+	}
+	return ""
+}
+
+func (x *ProxyResponse) GetHeaders() map[string]*HeaderValues {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetRedirects() int32 {
+	if x != nil {
+		return x.Redirects
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetUpstreamProxy() string {
+	if x != nil {
+		return x.UpstreamProxy
+	}
+	return ""
+}
+
+func (x *ProxyResponse) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetAttemptErrors() []*AttemptError {
+	if x != nil {
+		return x.AttemptErrors
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetCacheStatus() string {
+	if x != nil {
+		return x.CacheStatus
+	}
+	return ""
+}
+
+func (x *ProxyResponse) GetErrorDetails() []*ErrorDetail {
+	if x != nil {
+		return x.ErrorDetails
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetExtracted() map[string]*structpb.Value {
+	if x != nil {
+		return x.Extracted
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetCookies() []*ResponseCookie {
+	if x != nil {
+		return x.Cookies
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetPages() [][]byte {
+	if x != nil {
+		return x.Pages
+	}
+	return nil
+}
+
+func (x *ProxyResponse) GetPageCount() int32 {
+	if x != nil {
+		return x.PageCount
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetStartedAtMs() int64 {
+	if x != nil {
+		return x.StartedAtMs
+	}
+	return 0
+}
+
+func (x *ProxyResponse) GetHeadersTruncated() bool {
+	if x != nil {
+		return x.HeadersTruncated
+	}
+	return false
+}
+
+func (x *ProxyResponse) GetServedFrom() string {
+	if x != nil {
+		return x.ServedFrom
+	}
+	return ""
+}
+
+// ResponseCookie is a Set-Cookie header of the response, expires_ms is Unix
+// milliseconds and 0 without Expires
+type ResponseCookie struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value         string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Domain        string                 `protobuf:"bytes,3,opt,name=domain,proto3" json:"domain,omitempty"`
+	Path          string                 `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	ExpiresMs     int64                  `protobuf:"varint,5,opt,name=expires_ms,json=expiresMs,proto3" json:"expires_ms,omitempty"`
+	MaxAge        int32                  `protobuf:"varint,6,opt,name=max_age,json=maxAge,proto3" json:"max_age,omitempty"`
+	Secure        bool                   `protobuf:"varint,7,opt,name=secure,proto3" json:"secure,omitempty"`
+	HttpOnly      bool                   `protobuf:"varint,8,opt,name=http_only,json=httpOnly,proto3" json:"http_only,omitempty"`
+	SameSite      string                 `protobuf:"bytes,9,opt,name=same_site,json=sameSite,proto3" json:"same_site,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResponseCookie) Reset() {
+	*x = ResponseCookie{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResponseCookie) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResponseCookie) ProtoMessage() {}
+
+func (x *ResponseCookie) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResponseCookie.ProtoReflect.Descriptor instead.
+func (*ResponseCookie) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ResponseCookie) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ResponseCookie) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *ResponseCookie) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *ResponseCookie) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *ResponseCookie) GetExpiresMs() int64 {
+	if x != nil {
+		return x.ExpiresMs
+	}
+	return 0
+}
+
+func (x *ResponseCookie) GetMaxAge() int32 {
+	if x != nil {
+		return x.MaxAge
+	}
+	return 0
+}
+
+func (x *ResponseCookie) GetSecure() bool {
+	if x != nil {
+		return x.Secure
+	}
+	return false
+}
+
+func (x *ResponseCookie) GetHttpOnly() bool {
+	if x != nil {
+		return x.HttpOnly
+	}
+	return false
+}
+
+func (x *ResponseCookie) GetSameSite() string {
+	if x != nil {
+		return x.SameSite
+	}
+	return ""
+}
+
+type AttemptError struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Attempt       int32                  `protobuf:"varint,1,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	StatusCode    int32                  `protobuf:"varint,2,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Errors        []string               `protobuf:"bytes,3,rep,name=errors,proto3" json:"errors,omitempty"`
+	Proxy         string                 `protobuf:"bytes,4,opt,name=proxy,proto3" json:"proxy,omitempty"`
+	ErrorDetails  []*ErrorDetail         `protobuf:"bytes,5,rep,name=error_details,json=errorDetails,proto3" json:"error_details,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttemptError) Reset() {
+	*x = AttemptError{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttemptError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttemptError) ProtoMessage() {}
+
+func (x *AttemptError) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttemptError.ProtoReflect.Descriptor instead.
+func (*AttemptError) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *AttemptError) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *AttemptError) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *AttemptError) GetErrors() []string {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+func (x *AttemptError) GetProxy() string {
+	if x != nil {
+		return x.Proxy
+	}
+	return ""
+}
+
+func (x *AttemptError) GetErrorDetails() []*ErrorDetail {
+	if x != nil {
+		return x.ErrorDetails
+	}
+	return nil
+}
+
+// ErrorDetail is an upstream error, code is one of dns_failure,
+// connection_refused, connection_reset, tls_error, timeout,
+// upstream_proxy_failure, body_too_large, headers_too_large, upstream_error
+// or the code of a policy rejection
+type ErrorDetail struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Retryable     bool                   `protobuf:"varint,3,opt,name=retryable,proto3" json:"retryable,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ErrorDetail) Reset() {
+	*x = ErrorDetail{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ErrorDetail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErrorDetail) ProtoMessage() {}
+
+func (x *ErrorDetail) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErrorDetail.ProtoReflect.Descriptor instead.
+func (*ErrorDetail) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ErrorDetail) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *ErrorDetail) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ErrorDetail) GetRetryable() bool {
+	if x != nil {
+		return x.Retryable
+	}
+	return false
+}
+
+type SubmitBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jobs          []*ProxyJob            `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitBatchRequest) Reset() {
+	*x = SubmitBatchRequest{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitBatchRequest) ProtoMessage() {}
+
+func (x *SubmitBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitBatchRequest.ProtoReflect.Descriptor instead.
+func (*SubmitBatchRequest) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SubmitBatchRequest) GetJobs() []*ProxyJob {
+	if x != nil {
+		return x.Jobs
+	}
+	return nil
+}
+
+type SubmitBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jobs          []*JobStatus           `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitBatchResponse) Reset() {
+	*x = SubmitBatchResponse{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitBatchResponse) ProtoMessage() {}
+
+func (x *SubmitBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitBatchResponse.ProtoReflect.Descriptor instead.
+func (*SubmitBatchResponse) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *SubmitBatchResponse) GetJobs() []*JobStatus {
+	if x != nil {
+		return x.Jobs
+	}
+	return nil
+}
+
+type GetJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetJobRequest) Reset() {
+	*x = GetJobRequest{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetJobRequest) ProtoMessage() {}
+
+func (x *GetJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetJobRequest.ProtoReflect.Descriptor instead.
+func (*GetJobRequest) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *GetJobRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// JobStatus mirrors the async job of GET /jobs/{id}, times are Unix
+// milliseconds and 0 when not reached yet
+type JobStatus struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Id           string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	State        string                 `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`
+	CreatedAtMs  int64                  `protobuf:"varint,3,opt,name=created_at_ms,json=createdAtMs,proto3" json:"created_at_ms,omitempty"`
+	StartedAtMs  int64                  `protobuf:"varint,4,opt,name=started_at_ms,json=startedAtMs,proto3" json:"started_at_ms,omitempty"`
+	FinishedAtMs int64                  `protobuf:"varint,5,opt,name=finished_at_ms,json=finishedAtMs,proto3" json:"finished_at_ms,omitempty"`
+	Response     *ProxyResponse         `protobuf:"bytes,6,opt,name=response,proto3" json:"response,omitempty"`
+	// error is why the job could not be performed, errors its upstream errors
+	Error         string          `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	Errors        []string        `protobuf:"bytes,8,rep,name=errors,proto3" json:"errors,omitempty"`
+	Callback      *CallbackStatus `protobuf:"bytes,9,opt,name=callback,proto3" json:"callback,omitempty"`
+	ErrorCode     string          `protobuf:"bytes,10,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	ErrorDetails  []*ErrorDetail  `protobuf:"bytes,11,rep,name=error_details,json=errorDetails,proto3" json:"error_details,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *JobStatus) Reset() {
+	*x = JobStatus{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *JobStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*JobStatus) ProtoMessage() {}
+
+func (x *JobStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use JobStatus.ProtoReflect.Descriptor instead.
+func (*JobStatus) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *JobStatus) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *JobStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *JobStatus) GetCreatedAtMs() int64 {
+	if x != nil {
+		return x.CreatedAtMs
+	}
+	return 0
+}
+
+func (x *JobStatus) GetStartedAtMs() int64 {
+	if x != nil {
+		return x.StartedAtMs
+	}
+	return 0
+}
+
+func (x *JobStatus) GetFinishedAtMs() int64 {
+	if x != nil {
+		return x.FinishedAtMs
+	}
+	return 0
+}
+
+func (x *JobStatus) GetResponse() *ProxyResponse {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *JobStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *JobStatus) GetErrors() []string {
+	if x != nil {
+		return x.Errors
+	}
+	return nil
+}
+
+func (x *JobStatus) GetCallback() *CallbackStatus {
+	if x != nil {
+		return x.Callback
+	}
+	return nil
+}
+
+func (x *JobStatus) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+func (x *JobStatus) GetErrorDetails() []*ErrorDetail {
+	if x != nil {
+		return x.ErrorDetails
+	}
+	return nil
+}
+
+type CallbackStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	State         string                 `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"`
+	Attempts      []*CallbackAttempt     `protobuf:"bytes,2,rep,name=attempts,proto3" json:"attempts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CallbackStatus) Reset() {
+	*x = CallbackStatus{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CallbackStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CallbackStatus) ProtoMessage() {}
+
+func (x *CallbackStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CallbackStatus.ProtoReflect.Descriptor instead.
+func (*CallbackStatus) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *CallbackStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *CallbackStatus) GetAttempts() []*CallbackAttempt {
+	if x != nil {
+		return x.Attempts
+	}
+	return nil
+}
+
+type CallbackAttempt struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Attempt       int32                  `protobuf:"varint,1,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	TimeMs        int64                  `protobuf:"varint,2,opt,name=time_ms,json=timeMs,proto3" json:"time_ms,omitempty"`
+	StatusCode    int32                  `protobuf:"varint,3,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	DurationMs    int64                  `protobuf:"varint,5,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CallbackAttempt) Reset() {
+	*x = CallbackAttempt{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CallbackAttempt) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CallbackAttempt) ProtoMessage() {}
+
+func (x *CallbackAttempt) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CallbackAttempt.ProtoReflect.Descriptor instead.
+func (*CallbackAttempt) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *CallbackAttempt) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *CallbackAttempt) GetTimeMs() int64 {
+	if x != nil {
+		return x.TimeMs
+	}
+	return 0
+}
+
+func (x *CallbackAttempt) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *CallbackAttempt) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CallbackAttempt) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+type BatchResult struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Index    int64                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Response *ProxyResponse         `protobuf:"bytes,2,opt,name=response,proto3" json:"response,omitempty"`
+	// error is set when the job could not be performed at all
+	Error         string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchResult) Reset() {
+	*x = BatchResult{}
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResult) ProtoMessage() {}
+
+func (x *BatchResult) ProtoReflect() protoreflect.Message {
+	mi := &file_api_proxierpb_proxier_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResult.ProtoReflect.Descriptor instead.
+func (*BatchResult) Descriptor() ([]byte, []int) {
+	return file_api_proxierpb_proxier_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *BatchResult) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *BatchResult) GetResponse() *ProxyResponse {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *BatchResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_api_proxierpb_proxier_proto protoreflect.FileDescriptor
+
+const file_api_proxierpb_proxier_proto_rawDesc = "" +
+	"\n" +
+	"\x1bapi/proxierpb/proxier.proto\x12\n" +
+	"proxier.v1\x1a\x1cgoogle/protobuf/struct.proto\"\xbc\x0f\n" +
+	"\bProxyJob\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x16\n" +
+	"\x06method\x18\x02 \x01(\tR\x06method\x12;\n" +
+	"\aheaders\x18\x03 \x03(\v2!.proxier.v1.ProxyJob.HeadersEntryR\aheaders\x12\x12\n" +
+	"\x04body\x18\x04 \x01(\fR\x04body\x12;\n" +
+	"\acookies\x18\x05 \x03(\v2!.proxier.v1.ProxyJob.CookiesEntryR\acookies\x12\x18\n" +
+	"\atimeout\x18\x06 \x01(\x05R\atimeout\x12\x1d\n" +
+	"\n" +
+	"timeout_ms\x18\a \x01(\x05R\ttimeoutMs\x12&\n" +
+	"\x0fbasic_auth_user\x18\b \x01(\tR\rbasicAuthUser\x12&\n" +
+	"\x0fbasic_auth_pass\x18\t \x01(\tR\rbasicAuthPass\x124\n" +
+	"\x17keep_hop_by_hop_headers\x18\n" +
+	" \x01(\bR\x13keepHopByHopHeaders\x12\x1b\n" +
+	"\traw_bytes\x18\v \x01(\bR\brawBytes\x12\x1d\n" +
+	"\n" +
+	"cache_body\x18\f \x01(\bR\tcacheBody\x12\x16\n" +
+	"\x06script\x18\r \x01(\tR\x06script\x124\n" +
+	"\x16compress_response_body\x18\x0e \x01(\bR\x14compressResponseBody\x12\x10\n" +
+	"\x03sni\x18\x0f \x01(\tR\x03sni\x12.\n" +
+	"\x13follow_meta_refresh\x18\x10 \x01(\bR\x11followMetaRefresh\x12,\n" +
+	"\x12detect_js_redirect\x18\x11 \x01(\bR\x10detectJsRedirect\x12%\n" +
+	"\x0esigning_scheme\x18\x12 \x01(\tR\rsigningScheme\x12\x1f\n" +
+	"\vmax_retries\x18\x13 \x01(\x05R\n" +
+	"maxRetries\x12&\n" +
+	"\x0fretry_on_status\x18\x14 \x03(\x05R\rretryOnStatus\x120\n" +
+	"\x14retry_non_idempotent\x18\x15 \x01(\bR\x12retryNonIdempotent\x12\x1b\n" +
+	"\tproxy_url\x18\x16 \x01(\tR\bproxyUrl\x12H\n" +
+	"\fquery_params\x18\x17 \x03(\v2%.proxier.v1.ProxyJob.QueryParamsEntryR\vqueryParams\x120\n" +
+	"\x14insecure_skip_verify\x18\x18 \x01(\bR\x12insecureSkipVerify\x12\x17\n" +
+	"\aca_cert\x18\x19 \x01(\tR\x06caCert\x12)\n" +
+	"\x10follow_redirects\x18\x1a \x01(\bR\x0ffollowRedirects\x12#\n" +
+	"\rmax_redirects\x18\x1b \x01(\x05R\fmaxRedirects\x12+\n" +
+	"\x05retry\x18\x1c \x01(\v2\x15.proxier.v1.RetrySpecR\x05retry\x12\x1b\n" +
+	"\tcache_ttl\x18\x1d \x01(\x05R\bcacheTtl\x12\x19\n" +
+	"\bno_cache\x18\x1e \x01(\bR\anoCache\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x1f \x01(\tR\tsessionId\x12!\n" +
+	"\fcallback_url\x18  \x01(\tR\vcallbackUrl\x12E\n" +
+	"\vworker_tags\x18! \x03(\v2$.proxier.v1.ProxyJob.WorkerTagsEntryR\n" +
+	"workerTags\x12;\n" +
+	"\aextract\x18\" \x03(\v2!.proxier.v1.ProxyJob.ExtractEntryR\aextract\x12!\n" +
+	"\fextract_only\x18# \x01(\bR\vextractOnly\x126\n" +
+	"\n" +
+	"pagination\x18$ \x01(\v2\x16.proxier.v1.PaginationR\n" +
+	"pagination\x12<\n" +
+	"\rrewrite_rules\x18% \x03(\v2\x17.proxier.v1.RewriteRuleR\frewriteRules\x12$\n" +
+	"\x0emax_body_bytes\x18& \x01(\x03R\fmaxBodyBytes\x12\x1b\n" +
+	"\tauth_type\x18' \x01(\tR\bauthType\x121\n" +
+	"\x15retry_if_body_matches\x18( \x01(\tR\x12retryIfBodyMatches\x1a:\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a:\n" +
+	"\fCookiesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aW\n" +
+	"\x10QueryParamsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12-\n" +
+	"\x05value\x18\x02 \x01(\v2\x17.proxier.v1.QueryValuesR\x05value:\x028\x01\x1a=\n" +
+	"\x0fWorkerTagsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aQ\n" +
+	"\fExtractEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12+\n" +
+	"\x05value\x18\x02 \x01(\v2\x15.proxier.v1.ExtractorR\x05value:\x028\x01\"u\n" +
+	"\n" +
+	"Pagination\x12\x12\n" +
+	"\x04mode\x18\x01 \x01(\tR\x04mode\x12\x1b\n" +
+	"\tnext_path\x18\x02 \x01(\tR\bnextPath\x12\x1b\n" +
+	"\tmax_pages\x18\x03 \x01(\x05R\bmaxPages\x12\x19\n" +
+	"\bdelay_ms\x18\x04 \x01(\x05R\adelayMs\"\xa3\x01\n" +
+	"\vRewriteRule\x12\x12\n" +
+	"\x04host\x18\x01 \x01(\tR\x04host\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x124\n" +
+	"\arequest\x18\x03 \x01(\v2\x1a.proxier.v1.RewriteActionsR\arequest\x126\n" +
+	"\bresponse\x18\x04 \x01(\v2\x1a.proxier.v1.RewriteActionsR\bresponse\"\xfc\x02\n" +
+	"\x0eRewriteActions\x12%\n" +
+	"\x0eremove_headers\x18\x01 \x03(\tR\rremoveHeaders\x12K\n" +
+	"\vset_headers\x18\x02 \x03(\v2*.proxier.v1.RewriteActions.SetHeadersEntryR\n" +
+	"setHeaders\x12K\n" +
+	"\vadd_headers\x18\x03 \x03(\v2*.proxier.v1.RewriteActions.AddHeadersEntryR\n" +
+	"addHeaders\x12+\n" +
+	"\x04body\x18\x04 \x03(\v2\x17.proxier.v1.BodyRewriteR\x04body\x1a=\n" +
+	"\x0fSetHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a=\n" +
+	"\x0fAddHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"A\n" +
+	"\vBodyRewrite\x12\x18\n" +
+	"\apattern\x18\x01 \x01(\tR\apattern\x12\x18\n" +
+	"\areplace\x18\x02 \x01(\tR\areplace\"m\n" +
+	"\tExtractor\x12\x12\n" +
+	"\x04json\x18\x01 \x01(\tR\x04json\x12\x10\n" +
+	"\x03css\x18\x02 \x01(\tR\x03css\x12\x14\n" +
+	"\x05xpath\x18\x03 \x01(\tR\x05xpath\x12\x12\n" +
+	"\x04attr\x18\x04 \x01(\tR\x04attr\x12\x10\n" +
+	"\x03all\x18\x05 \x01(\bR\x03all\"\xdb\x01\n" +
+	"\tRetrySpec\x12!\n" +
+	"\fmax_attempts\x18\x01 \x01(\x05R\vmaxAttempts\x12&\n" +
+	"\x0fbackoff_base_ms\x18\x02 \x01(\x05R\rbackoffBaseMs\x12$\n" +
+	"\x0ebackoff_max_ms\x18\x03 \x01(\x05R\fbackoffMaxMs\x12&\n" +
+	"\x0fretry_on_status\x18\x04 \x03(\x05R\rretryOnStatus\x125\n" +
+	"\x17retry_on_network_errors\x18\x05 \x01(\bR\x14retryOnNetworkErrors\"%\n" +
+	"\vQueryValues\x12\x16\n" +
+	"\x06values\x18\x01 \x03(\tR\x06values\"&\n" +
+	"\fHeaderValues\x12\x16\n" +
+	"\x06values\x18\x01 \x03(\tR\x06values\"\xcf\b\n" +
+	"\rProxyResponse\x12\x1f\n" +
+	"\vstatus_code\x18\x01 \x01(\x05R\n" +
+	"statusCode\x12\x12\n" +
+	"\x04body\x18\x02 \x01(\fR\x04body\x12\x12\n" +
+	"\x04errs\x18\x03 \x03(\tR\x04errs\x12\x17\n" +
+	"\abody_id\x18\x04 \x01(\tR\x06bodyId\x12\x1b\n" +
+	"\tbody_size\x18\x05 \x01(\x03R\bbodySize\x12#\n" +
+	"\rbody_encoding\x18\x06 \x01(\tR\fbodyEncoding\x12\x1b\n" +
+	"\tfinal_url\x18\a \x01(\tR\bfinalUrl\x12%\n" +
+	"\x0emeta_refreshes\x18\b \x01(\x05R\rmetaRefreshes\x12\x1f\n" +
+	"\vjs_redirect\x18\t \x01(\tR\n" +
+	"jsRedirect\x12@\n" +
+	"\aheaders\x18\n" +
+	" \x03(\v2&.proxier.v1.ProxyResponse.HeadersEntryR\aheaders\x12\x1c\n" +
+	"\tredirects\x18\v \x01(\x05R\tredirects\x12%\n" +
+	"\x0eupstream_proxy\x18\f \x01(\tR\rupstreamProxy\x12\x1f\n" +
+	"\vduration_ms\x18\r \x01(\x03R\n" +
+	"durationMs\x12\x1a\n" +
+	"\battempts\x18\x0e \x01(\x05R\battempts\x12?\n" +
+	"\x0eattempt_errors\x18\x0f \x03(\v2\x18.proxier.v1.AttemptErrorR\rattemptErrors\x12!\n" +
+	"\fcache_status\x18\x10 \x01(\tR\vcacheStatus\x12<\n" +
+	"\rerror_details\x18\x11 \x03(\v2\x17.proxier.v1.ErrorDetailR\ferrorDetails\x12F\n" +
+	"\textracted\x18\x12 \x03(\v2(.proxier.v1.ProxyResponse.ExtractedEntryR\textracted\x124\n" +
+	"\acookies\x18\x13 \x03(\v2\x1a.proxier.v1.ResponseCookieR\acookies\x12\x14\n" +
+	"\x05pages\x18\x14 \x03(\fR\x05pages\x12\x1d\n" +
+	"\n" +
+	"page_count\x18\x15 \x01(\x05R\tpageCount\x12\"\n" +
+	"\rstarted_at_ms\x18\x16 \x01(\x03R\vstartedAtMs\x12+\n" +
+	"\x11headers_truncated\x18\x17 \x01(\bR\x10headersTruncated\x12\x1f\n" +
+	"\vserved_from\x18\x18 \x01(\tR\n" +
+	"servedFrom\x1aT\n" +
+	"\fHeadersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12.\n" +
+	"\x05value\x18\x02 \x01(\v2\x18.proxier.v1.HeaderValuesR\x05value:\x028\x01\x1aT\n" +
+	"\x0eExtractedEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.google.protobuf.ValueR\x05value:\x028\x01\"\xf0\x01\n" +
+	"\x0eResponseCookie\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\x12\x16\n" +
+	"\x06domain\x18\x03 \x01(\tR\x06domain\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12\x1d\n" +
+	"\n" +
+	"expires_ms\x18\x05 \x01(\x03R\texpiresMs\x12\x17\n" +
+	"\amax_age\x18\x06 \x01(\x05R\x06maxAge\x12\x16\n" +
+	"\x06secure\x18\a \x01(\bR\x06secure\x12\x1b\n" +
+	"\thttp_only\x18\b \x01(\bR\bhttpOnly\x12\x1b\n" +
+	"\tsame_site\x18\t \x01(\tR\bsameSite\"\xb5\x01\n" +
+	"\fAttemptError\x12\x18\n" +
+	"\aattempt\x18\x01 \x01(\x05R\aattempt\x12\x1f\n" +
+	"\vstatus_code\x18\x02 \x01(\x05R\n" +
+	"statusCode\x12\x16\n" +
+	"\x06errors\x18\x03 \x03(\tR\x06errors\x12\x14\n" +
+	"\x05proxy\x18\x04 \x01(\tR\x05proxy\x12<\n" +
+	"\rerror_details\x18\x05 \x03(\v2\x17.proxier.v1.ErrorDetailR\ferrorDetails\"Y\n" +
+	"\vErrorDetail\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1c\n" +
+	"\tretryable\x18\x03 \x01(\bR\tretryable\">\n" +
+	"\x12SubmitBatchRequest\x12(\n" +
+	"\x04jobs\x18\x01 \x03(\v2\x14.proxier.v1.ProxyJobR\x04jobs\"@\n" +
+	"\x13SubmitBatchResponse\x12)\n" +
+	"\x04jobs\x18\x01 \x03(\v2\x15.proxier.v1.JobStatusR\x04jobs\"\x1f\n" +
+	"\rGetJobRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\x99\x03\n" +
+	"\tJobStatus\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\tR\x05state\x12\"\n" +
+	"\rcreated_at_ms\x18\x03 \x01(\x03R\vcreatedAtMs\x12\"\n" +
+	"\rstarted_at_ms\x18\x04 \x01(\x03R\vstartedAtMs\x12$\n" +
+	"\x0efinished_at_ms\x18\x05 \x01(\x03R\ffinishedAtMs\x125\n" +
+	"\bresponse\x18\x06 \x01(\v2\x19.proxier.v1.ProxyResponseR\bresponse\x12\x14\n" +
+	"\x05error\x18\a \x01(\tR\x05error\x12\x16\n" +
+	"\x06errors\x18\b \x03(\tR\x06errors\x126\n" +
+	"\bcallback\x18\t \x01(\v2\x1a.proxier.v1.CallbackStatusR\bcallback\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\n" +
+	" \x01(\tR\terrorCode\x12<\n" +
+	"\rerror_details\x18\v \x03(\v2\x17.proxier.v1.ErrorDetailR\ferrorDetails\"_\n" +
+	"\x0eCallbackStatus\x12\x14\n" +
+	"\x05state\x18\x01 \x01(\tR\x05state\x127\n" +
+	"\battempts\x18\x02 \x03(\v2\x1b.proxier.v1.CallbackAttemptR\battempts\"\x9c\x01\n" +
+	"\x0fCallbackAttempt\x12\x18\n" +
+	"\aattempt\x18\x01 \x01(\x05R\aattempt\x12\x17\n" +
+	"\atime_ms\x18\x02 \x01(\x03R\x06timeMs\x12\x1f\n" +
+	"\vstatus_code\x18\x03 \x01(\x05R\n" +
+	"statusCode\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12\x1f\n" +
+	"\vduration_ms\x18\x05 \x01(\x03R\n" +
+	"durationMs\"p\n" +
+	"\vBatchResult\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x03R\x05index\x125\n" +
+	"\bresponse\x18\x02 \x01(\v2\x19.proxier.v1.ProxyResponseR\bresponse\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error2\x8e\x03\n" +
+	"\aProxier\x12:\n" +
+	"\aPerform\x12\x14.proxier.v1.ProxyJob\x1a\x19.proxier.v1.ProxyResponse\x12A\n" +
+	"\fPerformBatch\x12\x14.proxier.v1.ProxyJob\x1a\x17.proxier.v1.BatchResult(\x010\x01\x128\n" +
+	"\tSubmitJob\x12\x14.proxier.v1.ProxyJob\x1a\x15.proxier.v1.JobStatus\x12N\n" +
+	"\vSubmitBatch\x12\x1e.proxier.v1.SubmitBatchRequest\x1a\x1f.proxier.v1.SubmitBatchResponse\x12:\n" +
+	"\x06GetJob\x12\x19.proxier.v1.GetJobRequest\x1a\x15.proxier.v1.JobStatus\x12>\n" +
+	"\bWatchJob\x12\x19.proxier.v1.GetJobRequest\x1a\x15.proxier.v1.JobStatus0\x01B&Z$aslon1213/proxy_worker/api/proxierpbb\x06proto3"
+
+var (
+	file_api_proxierpb_proxier_proto_rawDescOnce sync.Once
+	file_api_proxierpb_proxier_proto_rawDescData []byte
+)
+
+func file_api_proxierpb_proxier_proto_rawDescGZIP() []byte {
+	file_api_proxierpb_proxier_proto_rawDescOnce.Do(func() {
+		file_api_proxierpb_proxier_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_proxierpb_proxier_proto_rawDesc), len(file_api_proxierpb_proxier_proto_rawDesc)))
+	})
+	return file_api_proxierpb_proxier_proto_rawDescData
+}
+
+var file_api_proxierpb_proxier_proto_msgTypes = make([]protoimpl.MessageInfo, 29)
+var file_api_proxierpb_proxier_proto_goTypes = []any{
+	(*ProxyJob)(nil),            // 0: proxier.v1.ProxyJob
+	(*Pagination)(nil),          // 1: proxier.v1.Pagination
+	(*RewriteRule)(nil),         // 2: proxier.v1.RewriteRule
+	(*RewriteActions)(nil),      // 3: proxier.v1.RewriteActions
+	(*BodyRewrite)(nil),         // 4: proxier.v1.BodyRewrite
+	(*Extractor)(nil),           // 5: proxier.v1.Extractor
+	(*RetrySpec)(nil),           // 6: proxier.v1.RetrySpec
+	(*QueryValues)(nil),         // 7: proxier.v1.QueryValues
+	(*HeaderValues)(nil),        // 8: proxier.v1.HeaderValues
+	(*ProxyResponse)(nil),       // 9: proxier.v1.ProxyResponse
+	(*ResponseCookie)(nil),      // 10: proxier.v1.ResponseCookie
+	(*AttemptError)(nil),        // 11: proxier.v1.AttemptError
+	(*ErrorDetail)(nil),         // 12: proxier.v1.ErrorDetail
+	(*SubmitBatchRequest)(nil),  // 13: proxier.v1.SubmitBatchRequest
+	(*SubmitBatchResponse)(nil), // 14: proxier.v1.SubmitBatchResponse
+	(*GetJobRequest)(nil),       // 15: proxier.v1.GetJobRequest
+	(*JobStatus)(nil),           // 16: proxier.v1.JobStatus
+	(*CallbackStatus)(nil),      // 17: proxier.v1.CallbackStatus
+	(*CallbackAttempt)(nil),     // 18: proxier.v1.CallbackAttempt
+	(*BatchResult)(nil),         // 19: proxier.v1.BatchResult
+	nil,                         // 20: proxier.v1.ProxyJob.HeadersEntry
+	nil,                         // 21: proxier.v1.ProxyJob.CookiesEntry
+	nil,                         // 22: proxier.v1.ProxyJob.QueryParamsEntry
+	nil,                         // 23: proxier.v1.ProxyJob.WorkerTagsEntry
+	nil,                         // 24: proxier.v1.ProxyJob.ExtractEntry
+	nil,                         // 25: proxier.v1.RewriteActions.SetHeadersEntry
+	nil,                         // 26: proxier.v1.RewriteActions.AddHeadersEntry
+	nil,                         // 27: proxier.v1.ProxyResponse.HeadersEntry
+	nil,                         // 28: proxier.v1.ProxyResponse.ExtractedEntry
+	(*structpb.Value)(nil),      // 29: google.protobuf.Value
+}
+var file_api_proxierpb_proxier_proto_depIdxs = []int32{
+	20, // 0: proxier.v1.ProxyJob.headers:type_name -> proxier.v1.ProxyJob.HeadersEntry
+	21, // 1: proxier.v1.ProxyJob.cookies:type_name -> proxier.v1.ProxyJob.CookiesEntry
+	22, // 2: proxier.v1.ProxyJob.query_params:type_name -> proxier.v1.ProxyJob.QueryParamsEntry
+	6,  // 3: proxier.v1.ProxyJob.retry:type_name -> proxier.v1.RetrySpec
+	23, // 4: proxier.v1.ProxyJob.worker_tags:type_name -> proxier.v1.ProxyJob.WorkerTagsEntry
+	24, // 5: proxier.v1.ProxyJob.extract:type_name -> proxier.v1.ProxyJob.ExtractEntry
+	1,  // 6: proxier.v1.ProxyJob.pagination:type_name -> proxier.v1.Pagination
+	2,  // 7: proxier.v1.ProxyJob.rewrite_rules:type_name -> proxier.v1.RewriteRule
+	3,  // 8: proxier.v1.RewriteRule.request:type_name -> proxier.v1.RewriteActions
+	3,  // 9: proxier.v1.RewriteRule.response:type_name -> proxier.v1.RewriteActions
+	25, // 10: proxier.v1.RewriteActions.set_headers:type_name -> proxier.v1.RewriteActions.SetHeadersEntry
+	26, // 11: proxier.v1.RewriteActions.add_headers:type_name -> proxier.v1.RewriteActions.AddHeadersEntry
+	4,  // 12: proxier.v1.RewriteActions.body:type_name -> proxier.v1.BodyRewrite
+	27, // 13: proxier.v1.ProxyResponse.headers:type_name -> proxier.v1.ProxyResponse.HeadersEntry
+	11, // 14: proxier.v1.ProxyResponse.attempt_errors:type_name -> proxier.v1.AttemptError
+	12, // 15: proxier.v1.ProxyResponse.error_details:type_name -> proxier.v1.ErrorDetail
+	28, // 16: proxier.v1.ProxyResponse.extracted:type_name -> proxier.v1.ProxyResponse.ExtractedEntry
+	10, // 17: proxier.v1.ProxyResponse.cookies:type_name -> proxier.v1.ResponseCookie
+	12, // 18: proxier.v1.AttemptError.error_details:type_name -> proxier.v1.ErrorDetail
+	0,  // 19: proxier.v1.SubmitBatchRequest.jobs:type_name -> proxier.v1.ProxyJob
+	16, // 20: proxier.v1.SubmitBatchResponse.jobs:type_name -> proxier.v1.JobStatus
+	9,  // 21: proxier.v1.JobStatus.response:type_name -> proxier.v1.ProxyResponse
+	17, // 22: proxier.v1.JobStatus.callback:type_name -> proxier.v1.CallbackStatus
+	12, // 23: proxier.v1.JobStatus.error_details:type_name -> proxier.v1.ErrorDetail
+	18, // 24: proxier.v1.CallbackStatus.attempts:type_name -> proxier.v1.CallbackAttempt
+	9,  // 25: proxier.v1.BatchResult.response:type_name -> proxier.v1.ProxyResponse
+	7,  // 26: proxier.v1.ProxyJob.QueryParamsEntry.value:type_name -> proxier.v1.QueryValues
+	5,  // 27: proxier.v1.ProxyJob.ExtractEntry.value:type_name -> proxier.v1.Extractor
+	8,  // 28: proxier.v1.ProxyResponse.HeadersEntry.value:type_name -> proxier.v1.HeaderValues
+	29, // 29: proxier.v1.ProxyResponse.ExtractedEntry.value:type_name -> google.protobuf.Value
+	0,  // 30: proxier.v1.Proxier.Perform:input_type -> proxier.v1.ProxyJob
+	0,  // 31: proxier.v1.Proxier.PerformBatch:input_type -> proxier.v1.ProxyJob
+	0,  // 32: proxier.v1.Proxier.SubmitJob:input_type -> proxier.v1.ProxyJob
+	13, // 33: proxier.v1.Proxier.SubmitBatch:input_type -> proxier.v1.SubmitBatchRequest
+	15, // 34: proxier.v1.Proxier.GetJob:input_type -> proxier.v1.GetJobRequest
+	15, // 35: proxier.v1.Proxier.WatchJob:input_type -> proxier.v1.GetJobRequest
+	9,  // 36: proxier.v1.Proxier.Perform:output_type -> proxier.v1.ProxyResponse
+	19, // 37: proxier.v1.Proxier.PerformBatch:output_type -> proxier.v1.BatchResult
+	16, // 38: proxier.v1.Proxier.SubmitJob:output_type -> proxier.v1.JobStatus
+	14, // 39: proxier.v1.Proxier.SubmitBatch:output_type -> proxier.v1.SubmitBatchResponse
+	16, // 40: proxier.v1.Proxier.GetJob:output_type -> proxier.v1.JobStatus
+	16, // 41: proxier.v1.Proxier.WatchJob:output_type -> proxier.v1.JobStatus
+	36, // [36:42] is the sub-list for method output_type
+	30, // [30:36] is the sub-list for method input_type
+	30, // [30:30] is the sub-list for extension type_name
+	30, // [30:30] is the sub-list for extension extendee
+	0,  // [0:30] is the sub-list for field type_name
+}
+
+func init() { file_api_proxierpb_proxier_proto_init() }
+func file_api_proxierpb_proxier_proto_init() {
+	if File_api_proxierpb_proxier_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_proxierpb_proxier_proto_rawDesc), len(file_api_proxierpb_proxier_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   29,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_proxierpb_proxier_proto_goTypes,
+		DependencyIndexes: file_api_proxierpb_proxier_proto_depIdxs,
+		MessageInfos:      file_api_proxierpb_proxier_proto_msgTypes,
+	}.Build()
+	File_api_proxierpb_proxier_proto = out.File
+	file_api_proxierpb_proxier_proto_goTypes = nil
+	file_api_proxierpb_proxier_proto_depIdxs = nil
+}
@@ -0,0 +1,341 @@
+// Protobuf definition of the proxy worker API.
+//
+// Regenerate the Go code from the repository root with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	       --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//	       api/proxierpb/proxier.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: api/proxierpb/proxier.proto
+
+package proxierpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Proxier_Perform_FullMethodName      = "/proxier.v1.Proxier/Perform"
+	Proxier_PerformBatch_FullMethodName = "/proxier.v1.Proxier/PerformBatch"
+	Proxier_SubmitJob_FullMethodName    = "/proxier.v1.Proxier/SubmitJob"
+	Proxier_SubmitBatch_FullMethodName  = "/proxier.v1.Proxier/SubmitBatch"
+	Proxier_GetJob_FullMethodName       = "/proxier.v1.Proxier/GetJob"
+	Proxier_WatchJob_FullMethodName     = "/proxier.v1.Proxier/WatchJob"
+)
+
+// ProxierClient is the client API for Proxier service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Proxier performs proxy jobs, mirroring POST /proxy
+type ProxierClient interface {
+	// Perform runs a single proxy job
+	Perform(ctx context.Context, in *ProxyJob, opts ...grpc.CallOption) (*ProxyResponse, error)
+	// PerformBatch runs the streamed jobs concurrently and streams back each
+	// result as soon as it completes, tagged with the index of its job
+	PerformBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ProxyJob, BatchResult], error)
+	// SubmitJob queues a job like POST /proxy?async=true and returns its
+	// pending status right away
+	SubmitJob(ctx context.Context, in *ProxyJob, opts ...grpc.CallOption) (*JobStatus, error)
+	// SubmitBatch queues every job of the request, the statuses are in the
+	// order of the jobs. Jobs that could not be queued only have an error.
+	SubmitBatch(ctx context.Context, in *SubmitBatchRequest, opts ...grpc.CallOption) (*SubmitBatchResponse, error)
+	// GetJob returns the status of a queued job, like GET /jobs/{id}
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*JobStatus, error)
+	// WatchJob streams the status of a queued job on every change, ending
+	// once the job is done or failed
+	WatchJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobStatus], error)
+}
+
+type proxierClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewProxierClient(cc grpc.ClientConnInterface) ProxierClient {
+	return &proxierClient{cc}
+}
+
+func (c *proxierClient) Perform(ctx context.Context, in *ProxyJob, opts ...grpc.CallOption) (*ProxyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProxyResponse)
+	err := c.cc.Invoke(ctx, Proxier_Perform_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxierClient) PerformBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ProxyJob, BatchResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Proxier_ServiceDesc.Streams[0], Proxier_PerformBatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ProxyJob, BatchResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Proxier_PerformBatchClient = grpc.BidiStreamingClient[ProxyJob, BatchResult]
+
+func (c *proxierClient) SubmitJob(ctx context.Context, in *ProxyJob, opts ...grpc.CallOption) (*JobStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JobStatus)
+	err := c.cc.Invoke(ctx, Proxier_SubmitJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxierClient) SubmitBatch(ctx context.Context, in *SubmitBatchRequest, opts ...grpc.CallOption) (*SubmitBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitBatchResponse)
+	err := c.cc.Invoke(ctx, Proxier_SubmitBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxierClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*JobStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(JobStatus)
+	err := c.cc.Invoke(ctx, Proxier_GetJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *proxierClient) WatchJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobStatus], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Proxier_ServiceDesc.Streams[1], Proxier_WatchJob_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetJobRequest, JobStatus]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Proxier_WatchJobClient = grpc.ServerStreamingClient[JobStatus]
+
+// ProxierServer is the server API for Proxier service.
+// All implementations must embed UnimplementedProxierServer
+// for forward compatibility.
+//
+// Proxier performs proxy jobs, mirroring POST /proxy
+type ProxierServer interface {
+	// Perform runs a single proxy job
+	Perform(context.Context, *ProxyJob) (*ProxyResponse, error)
+	// PerformBatch runs the streamed jobs concurrently and streams back each
+	// result as soon as it completes, tagged with the index of its job
+	PerformBatch(grpc.BidiStreamingServer[ProxyJob, BatchResult]) error
+	// SubmitJob queues a job like POST /proxy?async=true and returns its
+	// pending status right away
+	SubmitJob(context.Context, *ProxyJob) (*JobStatus, error)
+	// SubmitBatch queues every job of the request, the statuses are in the
+	// order of the jobs. Jobs that could not be queued only have an error.
+	SubmitBatch(context.Context, *SubmitBatchRequest) (*SubmitBatchResponse, error)
+	// GetJob returns the status of a queued job, like GET /jobs/{id}
+	GetJob(context.Context, *GetJobRequest) (*JobStatus, error)
+	// WatchJob streams the status of a queued job on every change, ending
+	// once the job is done or failed
+	WatchJob(*GetJobRequest, grpc.ServerStreamingServer[JobStatus]) error
+	mustEmbedUnimplementedProxierServer()
+}
+
+// UnimplementedProxierServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedProxierServer struct{}
+
+func (UnimplementedProxierServer) Perform(context.Context, *ProxyJob) (*ProxyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Perform not implemented")
+}
+func (UnimplementedProxierServer) PerformBatch(grpc.BidiStreamingServer[ProxyJob, BatchResult]) error {
+	return status.Error(codes.Unimplemented, "method PerformBatch not implemented")
+}
+func (UnimplementedProxierServer) SubmitJob(context.Context, *ProxyJob) (*JobStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method SubmitJob not implemented")
+}
+func (UnimplementedProxierServer) SubmitBatch(context.Context, *SubmitBatchRequest) (*SubmitBatchResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method SubmitBatch not implemented")
+}
+func (UnimplementedProxierServer) GetJob(context.Context, *GetJobRequest) (*JobStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedProxierServer) WatchJob(*GetJobRequest, grpc.ServerStreamingServer[JobStatus]) error {
+	return status.Error(codes.Unimplemented, "method WatchJob not implemented")
+}
+func (UnimplementedProxierServer) mustEmbedUnimplementedProxierServer() {}
+func (UnimplementedProxierServer) testEmbeddedByValue()                 {}
+
+// UnsafeProxierServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ProxierServer will
+// result in compilation errors.
+type UnsafeProxierServer interface {
+	mustEmbedUnimplementedProxierServer()
+}
+
+func RegisterProxierServer(s grpc.ServiceRegistrar, srv ProxierServer) {
+	// If the following call panics, it indicates UnimplementedProxierServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Proxier_ServiceDesc, srv)
+}
+
+func _Proxier_Perform_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProxyJob)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxierServer).Perform(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Proxier_Perform_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxierServer).Perform(ctx, req.(*ProxyJob))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxier_PerformBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ProxierServer).PerformBatch(&grpc.GenericServerStream[ProxyJob, BatchResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Proxier_PerformBatchServer = grpc.BidiStreamingServer[ProxyJob, BatchResult]
+
+func _Proxier_SubmitJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProxyJob)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxierServer).SubmitJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Proxier_SubmitJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxierServer).SubmitJob(ctx, req.(*ProxyJob))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxier_SubmitBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxierServer).SubmitBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Proxier_SubmitBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxierServer).SubmitBatch(ctx, req.(*SubmitBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxier_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProxierServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Proxier_GetJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxierServer).GetJob(ctx, req.(*GetJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Proxier_WatchJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetJobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ProxierServer).WatchJob(m, &grpc.GenericServerStream[GetJobRequest, JobStatus]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Proxier_WatchJobServer = grpc.ServerStreamingServer[JobStatus]
+
+// Proxier_ServiceDesc is the grpc.ServiceDesc for Proxier service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Proxier_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proxier.v1.Proxier",
+	HandlerType: (*ProxierServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Perform",
+			Handler:    _Proxier_Perform_Handler,
+		},
+		{
+			MethodName: "SubmitJob",
+			Handler:    _Proxier_SubmitJob_Handler,
+		},
+		{
+			MethodName: "SubmitBatch",
+			Handler:    _Proxier_SubmitBatch_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _Proxier_GetJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PerformBatch",
+			Handler:       _Proxier_PerformBatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchJob",
+			Handler:       _Proxier_WatchJob_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/proxierpb/proxier.proto",
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"aslon1213/proxy_worker/api/proxierpb"
+
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// APIKey is a client allowed to use the proxy. The key is given either in
+// plain text or as the hex SHA-256 of it, so key files need not hold secrets.
+// RateLimit is in requests per second with Burst requests allowed at once,
+// DailyQuota resets at midnight UTC. Zero values mean no limit.
+//
+// With mutual TLS a client certificate whose identity is CertIdentity
+// authenticates as the key when no key is sent, such a key needs no Key.
+// Admin keys may also use the endpoints that change server wide state.
+type APIKey struct {
+	ID           string  `json:"id"`
+	Key          string  `json:"key"`
+	KeySHA256    string  `json:"key_sha256"`
+	CertIdentity string  `json:"cert_identity"`
+	RateLimit    float64 `json:"rate_limit"`
+	Burst        int     `json:"burst"`
+	DailyQuota   int     `json:"daily_quota"`
+	Admin        bool    `json:"admin"`
+}
+
+type keyState struct {
+	APIKey
+
+	tokens  float64
+	updated time.Time
+	day     string
+	used    int
+}
+
+// KeyStore checks API keys and keeps their rate limit and quota usage
+type KeyStore struct {
+	mu     sync.Mutex
+	keys   map[string]*keyState
+	byCert map[string]*keyState
+	byID   map[string]*keyState
+}
+
+// apiKeys is loaded from api_keys_file, authentication is off without it
+var apiKeys *KeyStore
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadAPIKeys reads a JSON array of API keys
+func LoadAPIKeys(path string) (*KeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %w", path, err)
+	}
+
+	store := &KeyStore{keys: make(map[string]*keyState, len(keys)), byCert: map[string]*keyState{}, byID: map[string]*keyState{}}
+	for i, key := range keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("API key %d has no id", i)
+		}
+		digest := strings.ToLower(key.KeySHA256)
+		if key.Key != "" {
+			digest = hashKey(key.Key)
+		}
+		if len(digest) != sha256.Size*2 && (digest != "" || key.CertIdentity == "") {
+			return nil, fmt.Errorf("API key %q needs a key, a key_sha256 or a cert_identity", key.ID)
+		}
+		if _, ok := store.byID[key.ID]; ok {
+			return nil, fmt.Errorf("API key id %q is used twice", key.ID)
+		}
+		if key.RateLimit < 0 || key.Burst < 0 || key.DailyQuota < 0 {
+			return nil, fmt.Errorf("API key %q: limits must not be negative", key.ID)
+		}
+		if key.RateLimit > 0 && key.Burst == 0 {
+			key.Burst = int(math.Max(1, math.Ceil(key.RateLimit)))
+		}
+		if _, ok := store.keys[digest]; ok && digest != "" {
+			return nil, fmt.Errorf("API key %q is listed twice", key.ID)
+		}
+		if _, ok := store.byCert[key.CertIdentity]; ok && key.CertIdentity != "" {
+			return nil, fmt.Errorf("API key %q: cert_identity %q is listed twice", key.ID, key.CertIdentity)
+		}
+		key.Key = ""
+		state := &keyState{APIKey: key, tokens: float64(key.Burst)}
+		if digest != "" {
+			store.keys[digest] = state
+		}
+		if key.CertIdentity != "" {
+			store.byCert[key.CertIdentity] = state
+		}
+		store.byID[key.ID] = state
+	}
+	return store, nil
+}
+
+// IsAdmin tells whether the key of key_id is an admin key
+func (s *KeyStore) IsAdmin(key_id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.byID[key_id]
+	return ok && state.Admin
+}
+
+// lookup finds the key, or else the key of the client certificate identity.
+// The mutex must be held.
+func (s *KeyStore) lookup(key string, identity string) (*keyState, *JobError) {
+	if key == "" && identity != "" {
+		state, ok := s.byCert[identity]
+		if !ok {
+			return nil, &JobError{fiber.StatusUnauthorized, "Unknown client certificate"}
+		}
+		return state, nil
+	}
+	if key == "" {
+		return nil, &JobError{fiber.StatusUnauthorized, "Missing API key"}
+	}
+	state, ok := s.keys[hashKey(key)]
+	if !ok {
+		return nil, &JobError{fiber.StatusUnauthorized, "Invalid API key"}
+	}
+	return state, nil
+}
+
+// Allow authenticates the key, or without one the client certificate
+// identity, and takes one request off its rate limit and quota. It returns
+// the key ID, or a *JobError with how long to wait until the request would
+// be allowed when it is limited.
+func (s *KeyStore) Allow(key string, identity string, now time.Time) (string, time.Duration, *JobError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.lookup(key, identity)
+	if err != nil {
+		return "", 0, err
+	}
+	return s.take(state, now)
+}
+
+// Check authenticates like Allow without counting a request, for batches
+// whose jobs are counted one by one with Charge
+func (s *KeyStore) Check(key string, identity string) (string, *JobError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, err := s.lookup(key, identity)
+	if err != nil {
+		return "", err
+	}
+	return state.ID, nil
+}
+
+// Charge takes one job off the rate limit and quota of an authenticated key
+func (s *KeyStore) Charge(key_id string, now time.Time) (time.Duration, *JobError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.byID[key_id]
+	if !ok {
+		return 0, &JobError{fiber.StatusUnauthorized, "Invalid API key"}
+	}
+	_, retry_after, err := s.take(state, now)
+	return retry_after, err
+}
+
+// take counts one request against the limits of the key, the mutex must be held
+func (s *KeyStore) take(state *keyState, now time.Time) (string, time.Duration, *JobError) {
+	if state.DailyQuota > 0 {
+		day := now.UTC().Format(time.DateOnly)
+		if state.day != day {
+			state.day, state.used = day, 0
+		}
+		if state.used >= state.DailyQuota {
+			midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			return state.ID, midnight.Sub(now), &JobError{fiber.StatusTooManyRequests, "Daily quota exceeded"}
+		}
+	}
+
+	if state.RateLimit > 0 {
+		if !state.updated.IsZero() {
+			state.tokens = math.Min(float64(state.Burst), state.tokens+now.Sub(state.updated).Seconds()*state.RateLimit)
+		}
+		state.updated = now
+		if state.tokens < 1 {
+			wait := time.Duration((1 - state.tokens) / state.RateLimit * float64(time.Second))
+			return state.ID, wait, &JobError{fiber.StatusTooManyRequests, "Rate limit exceeded"}
+		}
+		state.tokens--
+	}
+
+	state.used++
+	return state.ID, 0, nil
+}
+
+// apiKeyFrom takes the key from X-API-Key or a bearer Authorization header
+func apiKeyFrom(api_key string, authorization string) string {
+	if api_key != "" {
+		return api_key
+	}
+	if scheme, token, ok := strings.Cut(authorization, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+type keyIDContextKey struct{}
+
+// WithKeyID tags the context with the API key the job was submitted with
+func WithKeyID(ctx context.Context, key_id string) context.Context {
+	if key_id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, keyIDContextKey{}, key_id)
+}
+
+// KeyIDFrom returns the API key ID of the context, empty without authentication
+func KeyIDFrom(ctx context.Context) string {
+	key_id, _ := ctx.Value(keyIDContextKey{}).(string)
+	return key_id
+}
+
+const keyIDLocal = "api_key_id"
+
+// authenticate checks a key, or else the identity of a client certificate,
+// counting a request against its limits unless charge_jobs is set. Without
+// API keys clients of a verified certificate are told apart by its identity.
+func authenticate(key string, identity string, charge_jobs bool, now time.Time) (string, time.Duration, *JobError) {
+	if apiKeys == nil {
+		return identity, 0, nil
+	}
+	if charge_jobs {
+		key_id, err := apiKeys.Check(key, identity)
+		return key_id, 0, err
+	}
+	return apiKeys.Allow(key, identity, now)
+}
+
+// ChargeJob counts a job of a batch against the limits of the API key of
+// ctx, a *JobError tells the job is over them
+func ChargeJob(ctx context.Context) error {
+	key_id := KeyIDFrom(ctx)
+	if apiKeys == nil || key_id == "" {
+		return nil
+	}
+	if _, err := apiKeys.Charge(key_id, time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func requireAPIKey(c *fiber.Ctx, charge_jobs bool, admin bool) error {
+	identity := certIdentity(c.Context().TLSConnectionState())
+	if apiKeys == nil && identity == "" {
+		return c.Next()
+	}
+
+	key_id, retry_after, err := authenticate(apiKeyFrom(c.Get("X-API-Key"), c.Get(fiber.HeaderAuthorization)), identity, charge_jobs, time.Now())
+	if err != nil {
+		if retry_after > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retry_after.Seconds()))))
+		}
+		return c.Status(err.Status).JSON(errorBody(err, err.Message))
+	}
+	if admin && apiKeys != nil && !apiKeys.IsAdmin(key_id) {
+		err := &JobError{fiber.StatusForbidden, "Admin API key required"}
+		return c.Status(err.Status).JSON(errorBody(err, err.Message))
+	}
+	c.Locals(keyIDLocal, key_id)
+	return c.Next()
+}
+
+// RequireAPIKey rejects requests without a valid API key or client
+// certificate, or over its limits
+func RequireAPIKey(c *fiber.Ctx) error {
+	return requireAPIKey(c, false, false)
+}
+
+// RequireBatchAPIKey is RequireAPIKey for batches, which count each of their
+// jobs against the limits with ChargeJob instead of the request
+func RequireBatchAPIKey(c *fiber.Ctx) error {
+	return requireAPIKey(c, true, false)
+}
+
+// RequireAdminKey is RequireAPIKey for endpoints that change server wide
+// state, which only admin keys may use
+func RequireAdminKey(c *fiber.Ctx) error {
+	return requireAPIKey(c, false, true)
+}
+
+// requestContext is the context jobs of the request run with
+func requestContext(c *fiber.Ctx) context.Context {
+	key_id, _ := c.Locals(keyIDLocal).(string)
+	return WithKeyID(context.Background(), key_id)
+}
+
+// grpcBatchMethods count each of their jobs with ChargeJob
+var grpcBatchMethods = map[string]bool{
+	proxierpb.Proxier_PerformBatch_FullMethodName: true,
+	proxierpb.Proxier_SubmitBatch_FullMethodName:  true,
+}
+
+func grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	identity := grpcCertIdentity(ctx)
+	if apiKeys == nil && identity == "" {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	key_id, _, err := authenticate(apiKeyFrom(first("x-api-key"), first("authorization")), identity, grpcBatchMethods[method], time.Now())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return WithKeyID(ctx, key_id), nil
+}
+
+func unaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := grpcAuthenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func streamAuthInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcAuthenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
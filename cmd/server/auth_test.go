@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func writeAPIKeys(t *testing.T, keys []APIKey) string {
+	t.Helper()
+	data, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []APIKey
+		err  bool
+	}{
+		{"valid", []APIKey{{ID: "a", Key: "secret"}, {ID: "b", KeySHA256: hashKey("other")}, {ID: "c", CertIdentity: "client"}}, false},
+		{"without id", []APIKey{{Key: "secret"}}, true},
+		{"without key", []APIKey{{ID: "a"}}, true},
+		{"id used twice", []APIKey{{ID: "a", Key: "one"}, {ID: "a", Key: "two"}}, true},
+		{"key listed twice", []APIKey{{ID: "a", Key: "secret"}, {ID: "b", KeySHA256: hashKey("secret")}}, true},
+		{"cert identity listed twice", []APIKey{{ID: "a", CertIdentity: "client"}, {ID: "b", CertIdentity: "client"}}, true},
+		{"negative limits", []APIKey{{ID: "a", Key: "secret", RateLimit: -1}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadAPIKeys(writeAPIKeys(t, test.keys))
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want an error: %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestKeyStoreAllow(t *testing.T) {
+	store, err := LoadAPIKeys(writeAPIKeys(t, []APIKey{
+		{ID: "limited", Key: "limited-key", RateLimit: 1, Burst: 2},
+		{ID: "quota", Key: "quota-key", DailyQuota: 2},
+		{ID: "default-burst", Key: "burst-key", RateLimit: 3},
+		{ID: "cert", CertIdentity: "client.example.com"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 23, 59, 0, 0, time.UTC)
+
+	type call struct {
+		key      string
+		identity string
+		// after is the time since now of the request
+		after  time.Duration
+		status int
+	}
+	tests := []struct {
+		name  string
+		calls []call
+	}{
+		{"missing key", []call{{"", "", 0, fiber.StatusUnauthorized}}},
+		{"invalid key", []call{{"wrong", "", 0, fiber.StatusUnauthorized}}},
+		{"unknown certificate", []call{{"", "other.example.com", 0, fiber.StatusUnauthorized}}},
+		{"certificate identity", []call{{"", "client.example.com", 0, fiber.StatusOK}, {"", "client.example.com", 0, fiber.StatusOK}}},
+		{"burst then refill", []call{
+			{"limited-key", "", 0, fiber.StatusOK},
+			{"limited-key", "", 0, fiber.StatusOK},
+			{"limited-key", "", 0, fiber.StatusTooManyRequests},
+			{"limited-key", "", time.Second, fiber.StatusOK},
+			{"limited-key", "", time.Second, fiber.StatusTooManyRequests},
+		}},
+		{"burst defaults to the rate", []call{
+			{"burst-key", "", 0, fiber.StatusOK},
+			{"burst-key", "", 0, fiber.StatusOK},
+			{"burst-key", "", 0, fiber.StatusOK},
+			{"burst-key", "", 0, fiber.StatusTooManyRequests},
+		}},
+		{"quota resets at midnight", []call{
+			{"quota-key", "", 0, fiber.StatusOK},
+			{"quota-key", "", 0, fiber.StatusOK},
+			{"quota-key", "", 0, fiber.StatusTooManyRequests},
+			{"quota-key", "", time.Minute, fiber.StatusOK},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, call := range test.calls {
+				key_id, retry_after, err := store.Allow(call.key, call.identity, now.Add(call.after))
+				status := fiber.StatusOK
+				if err != nil {
+					status = err.Status
+				}
+				if status != call.status {
+					t.Fatalf("request %d got %d %v, want %d", i+1, status, err, call.status)
+				}
+				if status == fiber.StatusTooManyRequests && retry_after <= 0 {
+					t.Fatalf("request %d was limited without a retry after", i+1)
+				}
+				if status == fiber.StatusOK && key_id == "" {
+					t.Fatalf("request %d was allowed without a key id", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyStoreCharge(t *testing.T) {
+	store, err := LoadAPIKeys(writeAPIKeys(t, []APIKey{{ID: "batch", Key: "batch-key", RateLimit: 1, Burst: 2}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// a check doesn't count, each charged job does
+	for i := 0; i < 3; i++ {
+		if _, err := store.Check("batch-key", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range []int{fiber.StatusOK, fiber.StatusOK, fiber.StatusTooManyRequests} {
+		status := fiber.StatusOK
+		if _, err := store.Charge("batch", now); err != nil {
+			status = err.Status
+		}
+		if status != want {
+			t.Fatalf("job %d got %d, want %d", i+1, status, want)
+		}
+	}
+	if _, err := store.Charge("unknown", now); err == nil || err.Status != fiber.StatusUnauthorized {
+		t.Fatalf("charging an unknown key got %v, want 401", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HostBackoff spreads the retries of every job to a failing host. Each
+// failed attempt doubles the delay of the host, from Base up to Max, and a
+// success resets it. Retries take turns: each waits for the next free slot
+// of the host, the slots being a jittered delay apart, so jobs failing
+// together don't retry together.
+//
+// Hosts are forgotten on success, or once they last failed Max ago.
+type HostBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostBackoff
+}
+
+type hostBackoff struct {
+	failures int
+	delay    time.Duration
+	// next is the earliest a retry to the host may be sent
+	next   time.Time
+	failed time.Time
+}
+
+func NewHostBackoff(base time.Duration, max_delay time.Duration) *HostBackoff {
+	return &HostBackoff{Base: base, Max: max_delay, hosts: map[string]*hostBackoff{}}
+}
+
+// hostBackoffs is set from host_backoff_base, nil when retries back off per job only
+var hostBackoffs *HostBackoff
+
+// backoffHost is the host of target_url the backoff is shared by
+func backoffHost(target_url string) string {
+	parsed, err := url.Parse(target_url)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Host)
+}
+
+// entry returns the backoff of host, nil when it is not failing. The mutex
+// must be held.
+func (b *HostBackoff) entry(host string, now time.Time) *hostBackoff {
+	entry, ok := b.hosts[host]
+	if ok && now.Sub(entry.failed) > b.Max {
+		delete(b.hosts, host)
+		return nil
+	}
+	return entry
+}
+
+// Failed records a failed attempt to host, growing its delay
+func (b *HostBackoff) Failed(host string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := b.entry(host, now)
+	if entry == nil {
+		entry = &hostBackoff{}
+		b.hosts[host] = entry
+	}
+	entry.failures++
+	entry.delay = b.Base << (entry.failures - 1)
+	if entry.delay <= 0 || entry.delay > b.Max {
+		entry.delay = b.Max
+	}
+	entry.failed = now
+}
+
+// Succeeded resets the backoff of host
+func (b *HostBackoff) Succeeded(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.hosts, host)
+}
+
+// Reserve takes the next retry slot of host and returns how long to wait
+// for it, 0 when the host is not failing
+func (b *HostBackoff) Reserve(host string, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := b.entry(host, now)
+	if entry == nil {
+		return 0
+	}
+	slot := entry.next
+	if slot.Before(now) {
+		slot = now
+	}
+	// half the delay plus up to as much again
+	spacing := entry.delay/2 + time.Duration(rand.Int63n(int64(entry.delay/2)+1))
+	entry.next = slot.Add(spacing)
+	return slot.Sub(now)
+}
+
+type hostBackoffStatus struct {
+	Host        string `json:"host"`
+	Failures    int    `json:"failures"`
+	DelayMs     int64  `json:"delay_ms"`
+	NextRetryMs int64  `json:"next_retry_in_ms,omitempty"`
+}
+
+// Status reports every failing host, the longest delays first
+func (b *HostBackoff) Status() []hostBackoffStatus {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	statuses := make([]hostBackoffStatus, 0, len(b.hosts))
+	for host := range b.hosts {
+		entry := b.entry(host, now)
+		if entry == nil {
+			continue
+		}
+		status := hostBackoffStatus{Host: host, Failures: entry.failures, DelayMs: entry.delay.Milliseconds()}
+		if entry.next.After(now) {
+			status.NextRetryMs = entry.next.Sub(now).Milliseconds()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].DelayMs != statuses[j].DelayMs {
+			return statuses[i].DelayMs > statuses[j].DelayMs
+		}
+		return statuses[i].Host < statuses[j].Host
+	})
+	return statuses
+}
+
+// GetBackoffs returns the shared retry backoff of every failing target host
+// @Description Returns the failures in a row, retry delay and next retry slot of every target host that is backed off
+func GetBackoffs(c *fiber.Ctx) error {
+	if hostBackoffs == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Shared host backoff is disabled",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"base_ms":  hostBackoffs.Base.Milliseconds(),
+		"max_ms":   hostBackoffs.Max.Milliseconds(),
+		"backoffs": hostBackoffs.Status(),
+	})
+}
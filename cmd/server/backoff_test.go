@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostBackoffDelays(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		failures int
+		// after is the time since the last failure the backoff is looked at
+		after time.Duration
+		delay time.Duration
+	}{
+		{"first failure", 1, 0, 100 * time.Millisecond},
+		{"doubled", 3, 0, 400 * time.Millisecond},
+		{"capped", 10, 0, time.Second},
+		{"forgotten after max", 3, 2 * time.Second, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewHostBackoff(100*time.Millisecond, time.Second)
+			for i := 0; i < test.failures; i++ {
+				b.Failed("api.example.com", now)
+			}
+			b.mu.Lock()
+			entry := b.entry("api.example.com", now.Add(test.after))
+			b.mu.Unlock()
+			var delay time.Duration
+			if entry != nil {
+				delay = entry.delay
+			}
+			if delay != test.delay {
+				t.Fatalf("delay = %s, want %s", delay, test.delay)
+			}
+		})
+	}
+
+	b := NewHostBackoff(100*time.Millisecond, time.Second)
+	b.Failed("api.example.com", now)
+	b.Succeeded("api.example.com")
+	if wait := b.Reserve("api.example.com", now); wait != 0 || len(b.Status()) != 0 {
+		t.Fatalf("host still backed off after a success, wait %s", wait)
+	}
+}
+
+func TestHostBackoffReserve(t *testing.T) {
+	b := NewHostBackoff(100*time.Millisecond, time.Second)
+	now := time.Now()
+	b.Failed("api.example.com", now)
+
+	if wait := b.Reserve("other.example.com", now); wait != 0 {
+		t.Fatalf("host without failures waits %s", wait)
+	}
+	// retries of one host take turns, half to a whole delay apart
+	previous := time.Duration(-1)
+	for i := 0; i < 5; i++ {
+		wait := b.Reserve("api.example.com", now)
+		if i == 0 && wait != 0 {
+			t.Fatalf("first retry waits %s, want 0", wait)
+		}
+		if i > 0 && (wait-previous < 50*time.Millisecond || wait-previous > 100*time.Millisecond) {
+			t.Fatalf("retry %d waits %s after %s, want 50ms to 100ms later", i+1, wait, previous)
+		}
+		previous = wait
+	}
+	statuses := b.Status()
+	if len(statuses) != 1 || statuses[0].Host != "api.example.com" || statuses[0].Failures != 1 || statuses[0].NextRetryMs <= 0 {
+		t.Fatalf("status = %+v, want api.example.com with a pending retry", statuses)
+	}
+}
+
+func TestRetriesShareHostBackoff(t *testing.T) {
+	previous := hostBackoffs
+	defer func() { hostBackoffs = previous }()
+	hostBackoffs = NewHostBackoff(10*time.Millisecond, time.Second)
+
+	const jobs = 4
+	var (
+		mu      sync.Mutex
+		first   int
+		retries []time.Time
+	)
+	failing := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first++
+		if first <= jobs {
+			// the first attempts of every job fail together
+			if first == jobs {
+				close(failing)
+			}
+			mu.Unlock()
+			<-failing
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		retries = append(retries, time.Now())
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := ExecuteJob(context.Background(), ProxyJob{
+				URL:    server.URL,
+				Method: http.MethodGet,
+				Retry:  &RetrySpec{MaxAttempts: 2, BackoffBaseMs: 1, RetryOnStatus: []int{http.StatusServiceUnavailable}},
+			})
+			if err != nil || response.StatusCode != http.StatusOK {
+				t.Errorf("job got %d %v, want 200 after a retry", response.StatusCode, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// alone each job would retry after 1ms, all at once
+	sort.Slice(retries, func(i, j int) bool { return retries[i].Before(retries[j]) })
+	if len(retries) != jobs {
+		t.Fatalf("got %d retries, want %d", len(retries), jobs)
+	}
+	for i := 1; i < len(retries); i++ {
+		if gap := retries[i].Sub(retries[i-1]); gap < 4*time.Millisecond {
+			t.Fatalf("retries %d and %d were %s apart, want them spread by the host backoff", i, i+1, gap)
+		}
+	}
+	if statuses := hostBackoffs.Status(); len(statuses) != 0 {
+		t.Fatalf("backoffs = %+v, want the host reset by the successes", statuses)
+	}
+}
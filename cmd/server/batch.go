@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultBatchConcurrency = 16
+	defaultBatchMaxJobs     = 1000
+)
+
+var (
+	// batchConcurrency caps the jobs of a batch that run at the same time,
+	// set from PROXIER_BATCH_CONCURRENCY
+	batchConcurrency = defaultBatchConcurrency
+	// batchMaxJobs caps the jobs of a batch, set from PROXIER_BATCH_MAX_JOBS
+	batchMaxJobs = defaultBatchMaxJobs
+)
+
+// BatchSummary aggregates the responses of a batch. Jobs that could not be
+// performed are failed and counted by the code of their first error, the
+// others by status. DurationMs is the time the whole batch took, JobDurationMs
+// the sum of the durations of its jobs.
+type BatchSummary struct {
+	Jobs          int            `json:"jobs"`
+	Succeeded     int            `json:"succeeded"`
+	Failed        int            `json:"failed"`
+	ByStatus      map[int]int    `json:"by_status"`
+	Errors        map[string]int `json:"errors"`
+	Bytes         int64          `json:"bytes"`
+	DurationMs    int64          `json:"duration_ms"`
+	JobDurationMs int64          `json:"job_duration_ms"`
+}
+
+// batchSummary collects a BatchSummary as the jobs of a batch complete
+type batchSummary struct {
+	mu      sync.Mutex
+	summary BatchSummary
+}
+
+func newBatchSummary() *batchSummary {
+	return &batchSummary{summary: BatchSummary{ByStatus: map[int]int{}, Errors: map[string]int{}}}
+}
+
+func (s *batchSummary) Add(response ProxyResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary.Jobs++
+	s.summary.JobDurationMs += response.DurationMs
+	if len(response.Errs) > 0 {
+		s.summary.Failed++
+		s.summary.Errors[ClassifyError(response.Errs[0]).Code]++
+		return
+	}
+	s.summary.Succeeded++
+	s.summary.ByStatus[response.StatusCode]++
+	s.summary.Bytes += int64(len(response.Body) + response.BodySize)
+	for _, page := range response.Pages {
+		s.summary.Bytes += int64(len(page))
+	}
+}
+
+// ExecuteBatch performs the jobs concurrently, at most concurrency at a time,
+// and returns their responses in the order of the jobs. Every job is counted
+// against the limits of the API key of ctx, a job that is over them or
+// cannot be performed gets its error in the Errs of its slot.
+func ExecuteBatch(ctx context.Context, jobs []ProxyJob, concurrency int) []ProxyResponse {
+	responses := make([]ProxyResponse, len(jobs))
+	runBatch(ctx, jobs, concurrency, func(i int, response ProxyResponse) {
+		responses[i] = response
+	})
+	return responses
+}
+
+// runBatch performs the jobs like ExecuteBatch, handing each response to
+// done with the index of its job as soon as it completes
+func runBatch(ctx context.Context, jobs []ProxyJob, concurrency int, done func(i int, response ProxyResponse)) {
+	semaphore := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, job ProxyJob) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			err := ChargeJob(ctx)
+			response := ProxyResponse{}
+			if err == nil {
+				response, err = ExecuteJob(ctx, job)
+			}
+			if err != nil {
+				response = ProxyResponse{Errs: []error{err}}
+			}
+			done(i, response)
+		}(i, job)
+	}
+	wg.Wait()
+}
+
+// PerformProxyBatch handles a batch of proxy jobs
+// @Description Performs a JSON array of proxy jobs and returns their responses in the same order
+// @Description Every job counts against the rate limit and quota of the API key, at most PROXIER_BATCH_MAX_JOBS jobs
+// @Description With summary=true the responses come as {"summary": ..., "results": [...]}, with summary=only just the summary is returned
+// @Param concurrency query int false "Jobs to run at the same time, at most PROXIER_BATCH_CONCURRENCY"
+// @Param summary query string false "true to add a summary of the batch, only to return nothing else"
+func PerformProxyBatch(c *fiber.Ctx) error {
+	logger := log.With().Str("handler", "PerformProxyBatch").Logger()
+
+	concurrency := c.QueryInt("concurrency", batchConcurrency)
+	if concurrency <= 0 || concurrency > batchConcurrency {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid concurrency",
+		})
+	}
+
+	summary_mode := c.Query("summary")
+	if summary_mode != "" && summary_mode != "true" && summary_mode != "false" && summary_mode != "only" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid summary",
+		})
+	}
+
+	var jobs []ProxyJob
+	if err := c.BodyParser(&jobs); err != nil {
+		logger.Error().Err(err).Msg("Failed to parse request body")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if len(jobs) > batchMaxJobs {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Too many jobs, at most %d", batchMaxJobs),
+		})
+	}
+
+	logger.Info().Int("jobs", len(jobs)).Int("concurrency", concurrency).Str("summary", summary_mode).Msg("Received proxy batch")
+	if summary_mode == "" || summary_mode == "false" {
+		return c.JSON(ExecuteBatch(requestContext(c), jobs, concurrency))
+	}
+
+	started := time.Now()
+	summary := newBatchSummary()
+	var responses []ProxyResponse
+	if summary_mode != "only" {
+		responses = make([]ProxyResponse, len(jobs))
+	}
+	runBatch(requestContext(c), jobs, concurrency, func(i int, response ProxyResponse) {
+		summary.Add(response)
+		// without the results the bodies are dropped as soon as they are counted
+		if responses != nil {
+			responses[i] = response
+		}
+	})
+	summary.summary.DurationMs = time.Since(started).Milliseconds()
+
+	if responses == nil {
+		return c.JSON(fiber.Map{"summary": summary.summary})
+	}
+	return c.JSON(fiber.Map{"summary": summary.summary, "results": responses})
+}
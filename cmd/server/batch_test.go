@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// statusServer answers /status/<code> with that status and a body of its
+// path, after /delay/<ms> when the path starts with it
+func statusServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if rest, ok := strings.CutPrefix(path, "/delay/"); ok {
+			delay, rest, _ := strings.Cut(rest, "/")
+			ms, _ := strconv.Atoi(delay)
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			path = "/" + rest
+		}
+		code := http.StatusOK
+		if rest, ok := strings.CutPrefix(path, "/status/"); ok {
+			code, _ = strconv.Atoi(rest)
+		}
+		w.WriteHeader(code)
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// refusedURL is the URL of a port nothing listens on
+func refusedURL(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return "http://" + addr + "/"
+}
+
+func postBatch(t *testing.T, query string, jobs []ProxyJob) (int, []byte) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/proxy/batch", PerformProxyBatch)
+	body, err := json.Marshal(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(fiber.MethodPost, "/proxy/batch"+query, strings.NewReader(string(body)))
+	request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	return response.StatusCode, data
+}
+
+func TestBatchSummary(t *testing.T) {
+	server := statusServer(t)
+	jobs := []ProxyJob{
+		{URL: server.URL + "/status/200", Method: fiber.MethodGet},
+		{URL: server.URL + "/status/200", Method: fiber.MethodGet},
+		{URL: server.URL + "/status/404", Method: fiber.MethodGet},
+		{URL: server.URL + "/status/503", Method: fiber.MethodGet},
+		{URL: refusedURL(t), Method: fiber.MethodGet},
+		{URL: server.URL + "/", Method: "BREW"},
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		results bool
+	}{
+		{"with results", "?summary=true", true},
+		{"summary only", "?summary=only", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, data := postBatch(t, test.query, jobs)
+			if status != fiber.StatusOK {
+				t.Fatalf("status = %d: %s", status, data)
+			}
+			var body struct {
+				Summary BatchSummary      `json:"summary"`
+				Results []json.RawMessage `json:"results"`
+			}
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Fatal(err)
+			}
+
+			summary := body.Summary
+			if summary.Jobs != 6 || summary.Succeeded != 4 || summary.Failed != 2 {
+				t.Fatalf("jobs %d, succeeded %d, failed %d, want 6, 4 and 2", summary.Jobs, summary.Succeeded, summary.Failed)
+			}
+			want_status := map[int]int{200: 2, 404: 1, 503: 1}
+			if len(summary.ByStatus) != len(want_status) {
+				t.Fatalf("by_status = %v, want %v", summary.ByStatus, want_status)
+			}
+			for code, count := range want_status {
+				if summary.ByStatus[code] != count {
+					t.Fatalf("by_status = %v, want %v", summary.ByStatus, want_status)
+				}
+			}
+			if summary.Errors[ErrorConnectionRefused] != 1 || summary.Errors[ErrorInvalidJob] != 1 {
+				t.Fatalf("errors = %v, want one connection_refused and one invalid_job", summary.Errors)
+			}
+			// the bodies are the request paths
+			if want := int64(len("/status/200")*2 + len("/status/404") + len("/status/503")); summary.Bytes != want {
+				t.Fatalf("bytes = %d, want %d", summary.Bytes, want)
+			}
+			if (len(body.Results) == len(jobs)) != test.results {
+				t.Fatalf("got %d results, want them: %v", len(body.Results), test.results)
+			}
+		})
+	}
+
+	status, data := postBatch(t, "?summary=maybe", jobs)
+	if status != fiber.StatusBadRequest {
+		t.Fatalf("invalid summary got %d %s", status, data)
+	}
+}
+
+func TestBatchOrder(t *testing.T) {
+	var in_flight, peak atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := in_flight.Add(1)
+		defer in_flight.Add(-1)
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		// later jobs answer first
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/job/"))
+		time.Sleep(time.Duration(10-n) * 5 * time.Millisecond)
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer server.Close()
+
+	refused := refusedURL(t)
+	jobs := make([]ProxyJob, 10)
+	for i := range jobs {
+		jobs[i] = ProxyJob{URL: fmt.Sprintf("%s/job/%d", server.URL, i), Method: fiber.MethodGet}
+	}
+	// a failing job keeps its slot and doesn't stop the others
+	jobs[3].URL = refused
+
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{"one at a time", 1},
+		{"bounded", 3},
+		{"default", defaultBatchConcurrency},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peak.Store(0)
+			status, data := postBatch(t, fmt.Sprintf("?concurrency=%d", test.concurrency), jobs)
+			if status != fiber.StatusOK {
+				t.Fatalf("status = %d: %s", status, data)
+			}
+			var results []struct {
+				StatusCode int               `json:"status_code"`
+				Body       string            `json:"body"`
+				Errs       []json.RawMessage `json:"errs"`
+			}
+			if err := json.Unmarshal(data, &results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(jobs) {
+				t.Fatalf("got %d results, want %d", len(results), len(jobs))
+			}
+			for i, result := range results {
+				if i == 3 {
+					if len(result.Errs) == 0 {
+						t.Fatalf("result 3 of the refused job has no error: %+v", result)
+					}
+					continue
+				}
+				if want := fmt.Sprintf("/job/%d", i); result.StatusCode != fiber.StatusOK || result.Body != want {
+					t.Fatalf("result %d is %d %q, want 200 %q", i, result.StatusCode, result.Body, want)
+				}
+			}
+			if got := peak.Load(); got > int64(test.concurrency) {
+				t.Fatalf("%d jobs ran at once, over the concurrency of %d", got, test.concurrency)
+			}
+		})
+	}
+}
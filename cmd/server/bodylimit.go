@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/valyala/fasthttp"
+)
+
+// defaultMaxBodyBytes is the server wide response body limit unless
+// PROXIER_MAX_BODY_BYTES sets another one
+const defaultMaxBodyBytes = 32 << 20
+
+// maxBodyBytes is the largest response body a job may read, jobs can only lower it
+var maxBodyBytes = defaultMaxBodyBytes
+
+// BodyLimitFor returns the response body limit of the job
+func BodyLimitFor(job ProxyJob) int {
+	if job.MaxBodyBytes > 0 {
+		return job.MaxBodyBytes
+	}
+	return maxBodyBytes
+}
+
+// isBodyTooLarge reports whether the upstream body went over the limit. The
+// client checks Content-Length first, so such bodies are not downloaded at all.
+func isBodyTooLarge(errs []error) bool {
+	for _, err := range errs {
+		if errors.Is(err, fasthttp.ErrBodyTooLarge) {
+			return true
+		}
+	}
+	return false
+}
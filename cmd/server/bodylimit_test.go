@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// largeBodyServer answers with a 10MB body, with Content-Length unless the
+// path is /chunked. It pauses after the first chunk so an early rejection
+// shows in the time the job takes.
+func largeBodyServer(t *testing.T) *httptest.Server {
+	chunk := bytes.Repeat([]byte("x"), 64<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chunked" {
+			w.Header().Set("Content-Length", strconv.Itoa(160*len(chunk)))
+		}
+		for i := 0; i < 160; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			if i == 0 {
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(500 * time.Millisecond):
+				}
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	server := largeBodyServer(t)
+	previous := maxBodyBytes
+	defer func() { maxBodyBytes = previous }()
+
+	tests := []struct {
+		name        string
+		path        string
+		server_max  int
+		job_max     int
+		too_large   bool
+		max_elapsed time.Duration
+	}{
+		{"content-length over the job limit", "/", defaultMaxBodyBytes, 1 << 20, true, 250 * time.Millisecond},
+		{"content-length over the server limit", "/", 1 << 20, 0, true, 250 * time.Millisecond},
+		{"chunked body over the job limit", "/chunked", defaultMaxBodyBytes, 1 << 20, true, 5 * time.Second},
+		{"within the limit", "/", defaultMaxBodyBytes, 0, false, 5 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxBodyBytes = test.server_max
+			start := time.Now()
+			response, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL + test.path, Method: http.MethodGet, MaxBodyBytes: test.job_max})
+			elapsed := time.Since(start)
+			if test.too_large {
+				var job_err *JobError
+				if !errors.As(err, &job_err) || job_err.Status != http.StatusBadGateway || !strings.Contains(job_err.Message, "exceeds") {
+					t.Fatalf("err = %v, want a 502 for the body size", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(response.Body) != 10<<20 {
+					t.Fatalf("got %d bytes, want 10MB", len(response.Body))
+				}
+			}
+			if elapsed > test.max_elapsed {
+				t.Fatalf("took %s, want at most %s", elapsed, test.max_elapsed)
+			}
+		})
+	}
+
+	_, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet, MaxBodyBytes: maxBodyBytes + 1})
+	if err == nil || ClassifyError(err).Code != ErrorInvalidJob {
+		t.Fatalf("err = %v, want an invalid_job error", err)
+	}
+}
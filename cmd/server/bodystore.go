@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// bodyCacheTTL is how long a cached body can be paged through
+	bodyCacheTTL = time.Minute
+
+	defaultChunkLength = 1 << 20
+)
+
+type cachedBody struct {
+	data    []byte
+	expires time.Time
+}
+
+// BodyStore keeps fetched response bodies for a short time so that clients
+// can read them in chunks with follow-up calls
+type BodyStore struct {
+	mu     sync.Mutex
+	bodies map[string]cachedBody
+	ttl    time.Duration
+}
+
+func NewBodyStore(ttl time.Duration) *BodyStore {
+	store := &BodyStore{
+		bodies: make(map[string]cachedBody),
+		ttl:    ttl,
+	}
+	go store.janitor()
+	return store
+}
+
+var bodies = NewBodyStore(bodyCacheTTL)
+
+// Put stores the body and returns the ID to fetch it with
+func (s *BodyStore) Put(data []byte) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies[id] = cachedBody{data: data, expires: time.Now().Add(s.ttl)}
+	return id, nil
+}
+
+// Get returns the body stored under id, if it has not expired yet
+func (s *BodyStore) Get(id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.bodies[id]
+	if !ok || time.Now().After(body.expires) {
+		return nil, false
+	}
+	return body.data, true
+}
+
+func (s *BodyStore) janitor() {
+	ticker := time.NewTicker(s.ttl / 2)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.mu.Lock()
+		for id, body := range s.bodies {
+			if now.After(body.expires) {
+				delete(s.bodies, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// GetBodyChunk returns a range of a cached response body
+// @Description Returns length bytes of the cached body starting at offset
+// @Param id path string true "Body ID returned by the proxy job"
+// @Param offset query int false "Offset of the first byte"
+// @Param length query int false "Number of bytes to return"
+func GetBodyChunk(c *fiber.Ctx) error {
+	data, ok := bodies.Get(c.Params("id"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Body not found or expired",
+		})
+	}
+
+	c.Set("X-Body-Size", strconv.Itoa(len(data)))
+	if len(data) == 0 {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	offset, err := strconv.Atoi(c.Query("offset", "0"))
+	if err != nil || offset < 0 || offset >= len(data) {
+		return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{
+			"error": "Invalid offset",
+		})
+	}
+	length, err := strconv.Atoi(c.Query("length", strconv.Itoa(defaultChunkLength)))
+	if err != nil || length <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid length",
+		})
+	}
+
+	end := offset + length
+	if end > len(data) {
+		end = len(data)
+	}
+
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", offset, end-1, len(data)))
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	return c.Status(fiber.StatusPartialContent).Send(data[offset:end])
+}
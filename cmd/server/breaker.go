@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitOpenError is returned for jobs to a target whose breaker is open,
+// answered with 503 and a Retry-After
+type CircuitOpenError struct {
+	Host       string
+	Proxy      string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.Proxy != "" {
+		return fmt.Sprintf("circuit open for %s through %s", e.Host, e.Proxy)
+	}
+	return "circuit open for " + e.Host
+}
+
+// CircuitBreakers stop sending jobs to a target host after Failures failed
+// jobs in a row. Once Cooldown has passed a single probe job is let through:
+// it closes the breaker when it succeeds and opens it again when it fails.
+// With PerProxy each upstream proxy has its own breaker for a host.
+//
+// Only hosts that failed are tracked, a success forgets the host.
+type CircuitBreakers struct {
+	Failures int
+	Cooldown time.Duration
+	PerProxy bool
+
+	mu       sync.Mutex
+	breakers map[breakerKey]*breaker
+}
+
+type breakerKey struct {
+	host  string
+	proxy string
+}
+
+type breaker struct {
+	failures int
+	openedAt time.Time
+	probeAt  time.Time
+	opened   int
+}
+
+func NewCircuitBreakers(failures int, cooldown time.Duration, per_proxy bool) *CircuitBreakers {
+	return &CircuitBreakers{
+		Failures: failures,
+		Cooldown: cooldown,
+		PerProxy: per_proxy,
+		breakers: map[breakerKey]*breaker{},
+	}
+}
+
+// breakers is set from breaker_failures, nil when circuit breaking is off
+var breakers *CircuitBreakers
+
+// keyFor returns the breaker key of a job, the proxy is the redacted one
+func (b *CircuitBreakers) keyFor(job ProxyJob) breakerKey {
+	var key breakerKey
+	if parsed, err := url.Parse(job.URL); err == nil {
+		key.host = strings.ToLower(parsed.Host)
+	}
+	if b.PerProxy {
+		key.proxy = RedactProxyURL(UpstreamProxyFor(job))
+	}
+	return key
+}
+
+// state is the state of the breaker at now, the mutex must be held
+func (b *CircuitBreakers) state(entry *breaker, now time.Time) string {
+	switch {
+	case entry.failures < b.Failures:
+		return BreakerClosed
+	case now.Sub(entry.openedAt) < b.Cooldown:
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
+
+// Allow returns a *CircuitOpenError when the job must fail fast. A half-open
+// breaker lets one probe through per cooldown, so a probe that never reports
+// back doesn't keep it shut.
+func (b *CircuitBreakers) Allow(job ProxyJob) error {
+	key := b.keyFor(job)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.breakers[key]
+	if !ok {
+		return nil
+	}
+	switch b.state(entry, now) {
+	case BreakerOpen:
+		return &CircuitOpenError{key.host, key.proxy, b.Cooldown - now.Sub(entry.openedAt)}
+	case BreakerHalfOpen:
+		if now.Sub(entry.probeAt) < b.Cooldown {
+			return &CircuitOpenError{key.host, key.proxy, b.Cooldown - now.Sub(entry.probeAt)}
+		}
+		entry.probeAt = now
+	}
+	return nil
+}
+
+// Record reports the outcome of a job let through by Allow
+func (b *CircuitBreakers) Record(job ProxyJob, failed bool) {
+	key := b.keyFor(job)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if entry, ok := b.breakers[key]; ok && entry.failures >= b.Failures {
+			log.Info().Str("host", key.host).Str("proxy", key.proxy).Msg("Circuit closed")
+		}
+		delete(b.breakers, key)
+		return
+	}
+
+	entry, ok := b.breakers[key]
+	if !ok {
+		entry = &breaker{}
+		b.breakers[key] = entry
+	}
+	entry.failures++
+	if entry.failures >= b.Failures {
+		// the first trip, or a failed probe
+		entry.openedAt = now
+		entry.probeAt = time.Time{}
+		entry.opened++
+		log.Warn().Str("host", key.host).Str("proxy", key.proxy).Int("failures", entry.failures).Dur("cooldown", b.Cooldown).Msg("Circuit opened")
+	}
+}
+
+// Reset closes the breakers of host, or every breaker when host is empty,
+// and returns how many were reset
+func (b *CircuitBreakers) Reset(host string) int {
+	host = strings.ToLower(host)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reset := 0
+	for key := range b.breakers {
+		if host == "" || key.host == host {
+			delete(b.breakers, key)
+			reset++
+		}
+	}
+	return reset
+}
+
+// breakerFailed tells whether a response counts against the breaker of its
+// target: network errors, timeouts and gateway errors do, rejections by the
+// target policy and oversized bodies don't
+func breakerFailed(status_code int, errs []error) bool {
+	if len(errs) > 0 {
+		return policyError(errs) == nil && !isBodyTooLarge(errs)
+	}
+	switch status_code {
+	case fiber.StatusBadGateway, fiber.StatusServiceUnavailable, fiber.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+type breakerStatus struct {
+	Host      string     `json:"host"`
+	Proxy     string     `json:"proxy,omitempty"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	Opened    int        `json:"opened,omitempty"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RetryInMs int64      `json:"retry_in_ms,omitempty"`
+}
+
+// Status reports every tracked breaker, open ones first
+func (b *CircuitBreakers) Status() []breakerStatus {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	statuses := make([]breakerStatus, 0, len(b.breakers))
+	for key, entry := range b.breakers {
+		status := breakerStatus{
+			Host:     key.host,
+			Proxy:    key.proxy,
+			State:    b.state(entry, now),
+			Failures: entry.failures,
+			Opened:   entry.opened,
+		}
+		if status.State != BreakerClosed {
+			opened_at := entry.openedAt
+			status.OpenedAt = &opened_at
+		}
+		if status.State == BreakerOpen {
+			status.RetryInMs = (b.Cooldown - now.Sub(entry.openedAt)).Milliseconds()
+		}
+		statuses = append(statuses, status)
+	}
+	rank := map[string]int{BreakerOpen: 0, BreakerHalfOpen: 1, BreakerClosed: 2}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.State != b.State {
+			return rank[a.State] < rank[b.State]
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Proxy < b.Proxy
+	})
+	return statuses
+}
+
+// GetBreakers returns the circuit breaker of every failing target host
+// @Description Returns the state, failures in a row and cool-down of every target host that failed recently
+func GetBreakers(c *fiber.Ctx) error {
+	if breakers == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Circuit breaking is disabled",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"failures":    breakers.Failures,
+		"cooldown_ms": breakers.Cooldown.Milliseconds(),
+		"per_proxy":   breakers.PerProxy,
+		"breakers":    breakers.Status(),
+	})
+}
+
+// ResetBreakers closes circuit breakers
+// @Description Closes the breakers of a target host, or all of them without host
+// @Description Needs an admin API key when API keys are configured
+// @Param host query string false "Target host, with its port if the job URL has one"
+func ResetBreakers(c *fiber.Ctx) error {
+	if breakers == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Circuit breaking is disabled",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"reset": breakers.Reset(c.Query("host")),
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+
+	type step struct {
+		// action is allow, fail, succeed or cooldown
+		action string
+		// open is whether allow fails fast
+		open bool
+		// state is the breaker state after the step, "" while untracked
+		state string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens after the failures in a row", []step{
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerClosed},
+			{"allow", false, BreakerClosed},
+			{"fail", false, BreakerOpen},
+			{"allow", true, BreakerOpen},
+		}},
+		{"success forgets the host", []step{
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerClosed},
+			{"succeed", false, ""},
+			{"fail", false, BreakerClosed},
+			{"allow", false, BreakerClosed},
+		}},
+		{"probe success closes", []step{
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerOpen},
+			{"cooldown", false, BreakerHalfOpen},
+			{"allow", false, BreakerHalfOpen},
+			// one probe per cooldown
+			{"allow", true, BreakerHalfOpen},
+			{"succeed", false, ""},
+			{"allow", false, ""},
+		}},
+		{"probe failure opens again", []step{
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerOpen},
+			{"cooldown", false, BreakerHalfOpen},
+			{"allow", false, BreakerHalfOpen},
+			{"fail", false, BreakerOpen},
+			{"allow", true, BreakerOpen},
+		}},
+		{"probe that never reports back", []step{
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerClosed},
+			{"fail", false, BreakerOpen},
+			{"cooldown", false, BreakerHalfOpen},
+			{"allow", false, BreakerHalfOpen},
+			{"cooldown", false, BreakerHalfOpen},
+			{"allow", false, BreakerHalfOpen},
+		}},
+	}
+	job := ProxyJob{URL: "http://Down.example.com:8080/path", Method: fiber.MethodGet}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewCircuitBreakers(3, cooldown, false)
+			for i, step := range test.steps {
+				switch step.action {
+				case "allow":
+					err := b.Allow(job)
+					var open_err *CircuitOpenError
+					if errors.As(err, &open_err) != step.open {
+						t.Fatalf("step %d: allow got %v, want open: %v", i+1, err, step.open)
+					}
+					if step.open && (open_err.Host != "down.example.com:8080" || open_err.RetryAfter <= 0 || open_err.RetryAfter > cooldown) {
+						t.Fatalf("step %d: got %+v, want the host and a retry within the cooldown", i+1, open_err)
+					}
+				case "fail":
+					b.Record(job, true)
+				case "succeed":
+					b.Record(job, false)
+				case "cooldown":
+					time.Sleep(cooldown + 10*time.Millisecond)
+				}
+
+				state := ""
+				if statuses := b.Status(); len(statuses) > 0 {
+					state = statuses[0].State
+				}
+				if state != step.state {
+					t.Fatalf("step %d (%s): state = %q, want %q", i+1, step.action, state, step.state)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerKeys(t *testing.T) {
+	previous := upstreamProxy
+	defer func() { upstreamProxy = previous }()
+	upstreamProxy = ""
+
+	tests := []struct {
+		name      string
+		per_proxy bool
+		other     ProxyJob
+		open      bool
+	}{
+		{"same host", false, ProxyJob{URL: "http://down.example.com/other"}, true},
+		{"other host", false, ProxyJob{URL: "http://up.example.com/"}, false},
+		{"other port", false, ProxyJob{URL: "http://down.example.com:8080/"}, false},
+		{"other proxy shares the breaker", false, ProxyJob{URL: "http://down.example.com/", ProxyURL: "http://proxy-b:3128"}, true},
+		{"other proxy per proxy", true, ProxyJob{URL: "http://down.example.com/", ProxyURL: "http://proxy-b:3128"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewCircuitBreakers(1, time.Minute, test.per_proxy)
+			b.Record(ProxyJob{URL: "http://down.example.com/", ProxyURL: "http://proxy-a:3128"}, true)
+			err := b.Allow(test.other)
+			if (err != nil) != test.open {
+				t.Fatalf("allow got %v, want open: %v", err, test.open)
+			}
+			if b.Reset("DOWN.example.com") != 1 || b.Allow(test.other) != nil {
+				t.Fatal("reset didn't close the breaker")
+			}
+		})
+	}
+}
+
+func TestBreakerFailed(t *testing.T) {
+	tests := []struct {
+		name        string
+		status_code int
+		errs        []error
+		want        bool
+	}{
+		{"ok", fiber.StatusOK, nil, false},
+		{"not found", fiber.StatusNotFound, nil, false},
+		{"internal server error", fiber.StatusInternalServerError, nil, false},
+		{"bad gateway", fiber.StatusBadGateway, nil, true},
+		{"service unavailable", fiber.StatusServiceUnavailable, nil, true},
+		{"gateway timeout", fiber.StatusGatewayTimeout, nil, true},
+		{"network error", 0, []error{errors.New("dial tcp: connection refused")}, true},
+		{"policy rejection", 0, []error{&PolicyError{Code: PolicyPrivateAddress}}, false},
+	}
+	for _, test := range tests {
+		if got := breakerFailed(test.status_code, test.errs); got != test.want {
+			t.Errorf("%s: breakerFailed = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Cache statuses of ProxyResponse.CacheStatus
+const (
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheBypass = "BYPASS"
+)
+
+// Values of ProxyResponse.ServedFrom
+const (
+	ServedFromCache    = "cache"
+	ServedFromUpstream = "upstream"
+)
+
+const (
+	// maxCacheTTL is the longest cache_ttl a job may ask for
+	maxCacheTTL = 24 * time.Hour
+
+	cacheTimeout   = time.Second
+	redisKeyPrefix = "proxier:cache:"
+)
+
+// cacheableStatuses are cached without explicit freshness, as RFC 9111 allows
+var cacheableStatuses = map[int]bool{
+	fiber.StatusOK:                          true,
+	fiber.StatusNonAuthoritativeInformation: true,
+	fiber.StatusNoContent:                   true,
+	fiber.StatusMovedPermanently:            true,
+	fiber.StatusNotFound:                    true,
+	fiber.StatusGone:                        true,
+}
+
+// CachedResponse is the upstream part of a response, before scripts,
+// compression and encoding which depend on the job
+type CachedResponse struct {
+	StatusCode    int                 `json:"status_code"`
+	Headers       map[string][]string `json:"headers"`
+	Body          []byte              `json:"body"`
+	FinalURL      string              `json:"final_url"`
+	Redirects     int                 `json:"redirects"`
+	MetaRefreshes int                 `json:"meta_refreshes"`
+	JSRedirect    string              `json:"js_redirect"`
+}
+
+func cachedFrom(response ProxyResponse) CachedResponse {
+	return CachedResponse{
+		StatusCode:    response.StatusCode,
+		Headers:       response.Headers,
+		Body:          response.Body,
+		FinalURL:      response.FinalURL,
+		Redirects:     response.Redirects,
+		MetaRefreshes: response.MetaRefreshes,
+		JSRedirect:    response.JSRedirect,
+	}
+}
+
+func (c CachedResponse) response() ProxyResponse {
+	return ProxyResponse{
+		StatusCode:    c.StatusCode,
+		Headers:       c.Headers,
+		Body:          c.Body,
+		FinalURL:      c.FinalURL,
+		Redirects:     c.Redirects,
+		MetaRefreshes: c.MetaRefreshes,
+		JSRedirect:    c.JSRedirect,
+	}
+}
+
+// ResponseCache stores upstream responses under the keys of CacheKeyFor.
+// Keys start with a hash of the URL so that all variants of a URL can be
+// invalidated together.
+type ResponseCache interface {
+	Get(ctx context.Context, key string) (CachedResponse, bool, error)
+	Set(ctx context.Context, key string, response CachedResponse, ttl time.Duration) error
+	// Invalidate deletes the entries of target_url, or every entry if it is
+	// empty, and returns how many were deleted
+	Invalidate(ctx context.Context, target_url string) (int, error)
+}
+
+// responseCache is configured with cache_backend, nil when caching is off
+var responseCache ResponseCache
+
+// defaultCacheTTL applies to responses without Cache-Control or Expires, set from cache_ttl
+var defaultCacheTTL = time.Minute
+
+func cacheURLPrefix(target_url string) string {
+	sum := sha256.Sum256([]byte(target_url))
+	return hex.EncodeToString(sum[:16]) + ":"
+}
+
+// CacheKeyFor returns the cache key of the job with CacheMiss, or CacheBypass
+// when the job cannot be served from the cache. The key covers the method,
+// URL, headers, cookies, credentials and TLS settings of the job along with
+// the options that change which response is returned.
+func CacheKeyFor(job ProxyJob) (string, string) {
+	if responseCache == nil {
+		return "", ""
+	}
+	if job.Method != fiber.MethodGet && job.Method != fiber.MethodHead {
+		return "", CacheBypass
+	}
+	// the cookies of a session and the rules of a job are not part of the key
+	if job.NoCache || job.Pagination != nil || job.SessionID != "" || len(job.RewriteRules) > 0 {
+		return "", CacheBypass
+	}
+	for key, value := range job.Headers {
+		if textproto.CanonicalMIMEHeaderKey(key) != fiber.HeaderCacheControl {
+			continue
+		}
+		directives := cacheDirectives([]string{value})
+		if _, ok := directives["no-store"]; ok {
+			return "", CacheBypass
+		}
+		if _, ok := directives["no-cache"]; ok {
+			return "", CacheBypass
+		}
+	}
+
+	var variant strings.Builder
+	variant.WriteString(job.Method + "\n")
+	write := func(kind string, values map[string]string, canonical bool) {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := key
+			if canonical {
+				name = textproto.CanonicalMIMEHeaderKey(key)
+			}
+			variant.WriteString(kind + " " + name + ": " + values[key] + "\n")
+		}
+	}
+	write("header", job.Headers, true)
+	write("cookie", job.Cookies, false)
+	variant.WriteString("auth " + job.AuthType + " " + job.BasicAuthUser + ":" + job.BasicAuthPass + "\n")
+	variant.WriteString("proxy " + job.ProxyURL + "\n")
+	variant.WriteString("sni " + job.SNI + "\n")
+	variant.WriteString("tls " + strconv.FormatBool(job.InsecureSkipVerify) + " " + job.CACert + "\n")
+	variant.WriteString("body " + strconv.Itoa(job.MaxBodyBytes) + " " + strconv.FormatBool(job.RawBytes) + "\n")
+	variant.WriteString("hop " + strconv.FormatBool(job.KeepHopByHopHeaders) + "\n")
+	variant.WriteString("redirects " + strconv.FormatBool(job.FollowRedirects) + " " + strconv.Itoa(job.MaxRedirects) + "\n")
+	variant.WriteString("html " + strconv.FormatBool(job.FollowMetaRefresh) + " " + strconv.FormatBool(job.DetectJSRedirect) + "\n")
+	variant.WriteString("signing " + job.SigningScheme + "\n")
+	variant.WriteString("retry body " + job.RetryIfBodyMatches + "\n")
+
+	sum := sha256.Sum256([]byte(variant.String()))
+	return cacheURLPrefix(job.URL) + hex.EncodeToString(sum[:]), CacheMiss
+}
+
+// cacheDirectives parses Cache-Control values into their directives, with
+// the value of those that have one
+func cacheDirectives(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(argument, `"`)
+		}
+	}
+	return directives
+}
+
+// CacheTTLFor returns how long the response may be cached, false if it may
+// not be. no-store, no-cache, private, Vary: * and Set-Cookie keep responses
+// out of the cache; otherwise the cache_ttl of the job wins over s-maxage,
+// max-age and Expires of the upstream, which win over the default TTL.
+func CacheTTLFor(job ProxyJob, response ProxyResponse) (time.Duration, bool) {
+	if !cacheableStatuses[response.StatusCode] {
+		return 0, false
+	}
+
+	directives := cacheDirectives(headerValues(response.Headers, fiber.HeaderCacheControl))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[directive]; ok {
+			return 0, false
+		}
+	}
+	if headerValue(response.Headers, fiber.HeaderVary) == "*" || len(headerValues(response.Headers, fiber.HeaderSetCookie)) > 0 {
+		return 0, false
+	}
+
+	if job.CacheTTL > 0 {
+		return time.Duration(job.CacheTTL) * time.Second, true
+	}
+
+	age, _ := strconv.Atoi(headerValue(response.Headers, fiber.HeaderAge))
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return 0, false
+			}
+			ttl := time.Duration(seconds-age) * time.Second
+			return min(ttl, maxCacheTTL), ttl > 0
+		}
+	}
+
+	if expires := headerValue(response.Headers, fiber.HeaderExpires); expires != "" {
+		expires_at, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid dates, like "0", mean already expired
+			return 0, false
+		}
+		now := time.Now()
+		if date, err := http.ParseTime(headerValue(response.Headers, fiber.HeaderDate)); err == nil {
+			now = date
+		}
+		ttl := expires_at.Sub(now)
+		return min(ttl, maxCacheTTL), ttl > 0
+	}
+
+	return defaultCacheTTL, true
+}
+
+// StoreResponse caches the response of the job if its headers allow it
+func StoreResponse(key string, job ProxyJob, response ProxyResponse) {
+	ttl, ok := CacheTTLFor(job, response)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	if err := responseCache.Set(ctx, key, cachedFrom(response), ttl); err != nil {
+		log.Warn().Err(err).Str("url", job.URL).Msg("Failed to cache response")
+	}
+}
+
+// LoadResponse returns the cached response of key, errors of the cache
+// counting as a miss
+func LoadResponse(key string) (ProxyResponse, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	cached, ok, err := responseCache.Get(ctx, key)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read cached response")
+		return ProxyResponse{}, false
+	}
+	if !ok {
+		return ProxyResponse{}, false
+	}
+	return cached.response(), true
+}
+
+// MemoryCache is a ResponseCache in memory, dropping the least recently used
+// entries beyond maxEntries
+type MemoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	maxEntries int
+}
+
+type memoryEntry struct {
+	key      string
+	response CachedResponse
+	expires  time.Time
+}
+
+func NewMemoryCache(max_entries int) *MemoryCache {
+	return &MemoryCache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: max_entries,
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) (CachedResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return CachedResponse{}, false, nil
+	}
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return CachedResponse{}, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.response, true, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, response CachedResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &memoryEntry{key: key, response: response, expires: time.Now().Add(ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Invalidate(ctx context.Context, target_url string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if target_url == "" {
+		deleted := len(c.entries)
+		c.entries = make(map[string]*list.Element)
+		c.order.Init()
+		return deleted, nil
+	}
+
+	prefix := cacheURLPrefix(target_url)
+	deleted := 0
+	for key, element := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(element)
+			delete(c.entries, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// RedisCache is a ResponseCache in Redis, shared by every worker using it
+type RedisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache connects to the Redis server of a redis:// or rediss:// URL
+func NewRedisCache(redis_url string) (*RedisCache, error) {
+	options, err := redis.ParseURL(redis_url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisCache{client: client}, nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) (CachedResponse, bool, error) {
+	data, err := c.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return CachedResponse{}, false, nil
+	}
+	if err != nil {
+		return CachedResponse{}, false, err
+	}
+	var cached CachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return CachedResponse{}, false, err
+	}
+	return cached, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, response CachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, redisKeyPrefix+key, data, ttl).Err()
+}
+
+func (c *RedisCache) Invalidate(ctx context.Context, target_url string) (int, error) {
+	pattern := redisKeyPrefix + "*"
+	if target_url != "" {
+		pattern = redisKeyPrefix + cacheURLPrefix(target_url) + "*"
+	}
+
+	deleted := 0
+	iter := c.client.Scan(ctx, 0, pattern, 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 100 {
+			n, err := c.client.Del(ctx, keys...).Result()
+			if err != nil {
+				return deleted, err
+			}
+			deleted += int(n)
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, err
+	}
+	if len(keys) > 0 {
+		n, err := c.client.Del(ctx, keys...).Result()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(n)
+	}
+	return deleted, nil
+}
+
+// DeleteCache invalidates cached responses
+// @Description Deletes the cached responses of a URL, or the whole cache when no URL is given
+// @Description Needs an admin API key when API keys are configured
+// @Param url query string false "URL whose cached responses are deleted, query parameters included"
+func DeleteCache(c *fiber.Ctx) error {
+	if responseCache == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Cache is disabled",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), 30*time.Second)
+	defer cancel()
+	deleted, err := responseCache.Invalidate(ctx, c.Query("url"))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to invalidate cache")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to invalidate cache",
+		})
+	}
+	log.Info().Str("url", c.Query("url")).Int("deleted", deleted).Str("key_id", KeyIDFrom(requestContext(c))).Msg("Cache invalidated")
+	return c.JSON(fiber.Map{"deleted": deleted})
+}
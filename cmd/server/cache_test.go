@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheKeyFor(t *testing.T) {
+	previous := responseCache
+	responseCache = NewMemoryCache(10)
+	defer func() { responseCache = previous }()
+
+	base := ProxyJob{URL: "http://example.com/a", Method: http.MethodGet, Headers: map[string]string{"Accept": "text/html", "X-Trace": "1"}}
+	with := func(change func(job *ProxyJob)) ProxyJob {
+		job := base
+		job.Headers = map[string]string{"Accept": "text/html", "X-Trace": "1"}
+		change(&job)
+		return job
+	}
+	base_key, status := CacheKeyFor(base)
+	if status != CacheMiss || !strings.HasPrefix(base_key, cacheURLPrefix(base.URL)) {
+		t.Fatalf("key %q %s, want a miss under the URL prefix", base_key, status)
+	}
+
+	tests := []struct {
+		name   string
+		job    ProxyJob
+		same   bool
+		bypass bool
+	}{
+		{"same job", with(func(job *ProxyJob) {}), true, false},
+		{"header name case", with(func(job *ProxyJob) { job.Headers = map[string]string{"accept": "text/html", "x-trace": "1"} }), true, false},
+		{"head method", with(func(job *ProxyJob) { job.Method = http.MethodHead }), false, false},
+		{"other url", with(func(job *ProxyJob) { job.URL = "http://example.com/b" }), false, false},
+		{"header value", with(func(job *ProxyJob) { job.Headers["Accept"] = "application/json" }), false, false},
+		{"cookie", with(func(job *ProxyJob) { job.Cookies = map[string]string{"id": "1"} }), false, false},
+		{"credentials", with(func(job *ProxyJob) { job.BasicAuthUser, job.BasicAuthPass = "user", "pass" }), false, false},
+		{"auth type", with(func(job *ProxyJob) { job.AuthType = AuthTypeDigest }), false, false},
+		{"proxy", with(func(job *ProxyJob) { job.ProxyURL = "http://proxy:3128" }), false, false},
+		{"insecure tls", with(func(job *ProxyJob) { job.InsecureSkipVerify = true }), false, false},
+		{"raw bytes", with(func(job *ProxyJob) { job.RawBytes = true }), false, false},
+		{"body limit", with(func(job *ProxyJob) { job.MaxBodyBytes = 1024 }), false, false},
+		{"redirects", with(func(job *ProxyJob) { job.FollowRedirects = true }), false, false},
+		{"retry body pattern", with(func(job *ProxyJob) { job.RetryIfBodyMatches = "captcha" }), false, false},
+		{"post", with(func(job *ProxyJob) { job.Method = http.MethodPost }), false, true},
+		{"no_cache", with(func(job *ProxyJob) { job.NoCache = true }), false, true},
+		{"session", with(func(job *ProxyJob) { job.SessionID = "s1" }), false, true},
+		{"pagination", with(func(job *ProxyJob) { job.Pagination = &PaginationOptions{Mode: "link_header"} }), false, true},
+		{"rewrite rules", with(func(job *ProxyJob) { job.RewriteRules = []RewriteRule{{Host: "example.com"}} }), false, true},
+		{"no-store request", with(func(job *ProxyJob) { job.Headers["cache-control"] = "no-store" }), false, true},
+		{"no-cache request", with(func(job *ProxyJob) { job.Headers["Cache-Control"] = "max-age=0, no-cache" }), false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, status := CacheKeyFor(test.job)
+			if test.bypass {
+				if status != CacheBypass || key != "" {
+					t.Fatalf("got key %q %s, want a bypass", key, status)
+				}
+				return
+			}
+			if status != CacheMiss {
+				t.Fatalf("status = %s, want %s", status, CacheMiss)
+			}
+			if (key == base_key) != test.same {
+				t.Fatalf("key %q of the base job %q, want the same key: %v", key, base_key, test.same)
+			}
+		})
+	}
+
+	responseCache = nil
+	if key, status := CacheKeyFor(base); key != "" || status != "" {
+		t.Fatalf("got key %q %s without a cache", key, status)
+	}
+}
+
+func TestCacheTTLFor(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name      string
+		cache_ttl int
+		status    int
+		headers   map[string][]string
+		ttl       time.Duration
+		cacheable bool
+	}{
+		{"default ttl", 0, 200, nil, defaultCacheTTL, true},
+		{"404 cached", 0, 404, nil, defaultCacheTTL, true},
+		{"500 not cached", 0, 500, nil, 0, false},
+		{"max-age", 0, 200, map[string][]string{"Cache-Control": {"public, max-age=120"}}, 120 * time.Second, true},
+		{"s-maxage over max-age", 0, 200, map[string][]string{"Cache-Control": {"max-age=120, s-maxage=30"}}, 30 * time.Second, true},
+		{"age subtracted", 0, 200, map[string][]string{"Cache-Control": {"max-age=120"}, "Age": {"100"}}, 20 * time.Second, true},
+		{"older than max-age", 0, 200, map[string][]string{"Cache-Control": {"max-age=60"}, "Age": {"90"}}, -30 * time.Second, false},
+		{"max-age capped", 0, 200, map[string][]string{"Cache-Control": {"max-age=999999"}}, maxCacheTTL, true},
+		{"job ttl over max-age", 300, 200, map[string][]string{"Cache-Control": {"max-age=60"}}, 300 * time.Second, true},
+		{"expires", 0, 200, map[string][]string{"Date": {now.Format(http.TimeFormat)}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, time.Hour, true},
+		{"invalid expires", 0, 200, map[string][]string{"Expires": {"0"}}, 0, false},
+		{"no-store", 300, 200, map[string][]string{"Cache-Control": {"no-store"}}, 0, false},
+		{"private", 0, 200, map[string][]string{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{"set-cookie", 300, 200, map[string][]string{"Set-Cookie": {"id=1"}}, 0, false},
+		{"vary star", 0, 200, map[string][]string{"Vary": {"*"}}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ttl, cacheable := CacheTTLFor(ProxyJob{CacheTTL: test.cache_ttl}, ProxyResponse{StatusCode: test.status, Headers: test.headers})
+			if cacheable != test.cacheable || (cacheable && ttl != test.ttl) {
+				t.Fatalf("got %s %v, want %s %v", ttl, cacheable, test.ttl, test.cacheable)
+			}
+		})
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(2)
+	a, b, c := cacheURLPrefix("http://a/")+"1", cacheURLPrefix("http://b/")+"1", cacheURLPrefix("http://c/")+"1"
+
+	cache.Set(ctx, a, CachedResponse{StatusCode: 200}, time.Minute)
+	cache.Set(ctx, b, CachedResponse{StatusCode: 201}, time.Minute)
+	// a is used last, so b is the one evicted for c
+	cache.Get(ctx, a)
+	cache.Set(ctx, c, CachedResponse{StatusCode: 202}, time.Minute)
+	if _, ok, _ := cache.Get(ctx, b); ok {
+		t.Fatal("least recently used entry kept over the limit")
+	}
+	if cached, ok, _ := cache.Get(ctx, a); !ok || cached.StatusCode != 200 {
+		t.Fatalf("got %+v %v, want the entry of a", cached, ok)
+	}
+
+	if deleted, _ := cache.Invalidate(ctx, "http://a/"); deleted != 1 {
+		t.Fatalf("invalidated %d entries of http://a/, want 1", deleted)
+	}
+	if _, ok, _ := cache.Get(ctx, a); ok {
+		t.Fatal("invalidated entry returned")
+	}
+	if deleted, _ := cache.Invalidate(ctx, ""); deleted != 1 {
+		t.Fatalf("invalidated %d entries, want the entry of c", deleted)
+	}
+
+	cache.Set(ctx, b, CachedResponse{StatusCode: 201}, 20*time.Millisecond)
+	if _, ok, _ := cache.Get(ctx, b); !ok {
+		t.Fatal("fresh entry missing")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok, _ := cache.Get(ctx, b); ok {
+		t.Fatal("expired entry returned")
+	}
+}
+
+func TestJobsServedFromCache(t *testing.T) {
+	previous := responseCache
+	responseCache = NewMemoryCache(10)
+	defer func() { responseCache = previous }()
+	previous_metrics := metrics
+	metrics = NewMetrics()
+	defer func() { metrics = previous_metrics }()
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "response %d", requests.Add(1))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		job         ProxyJob
+		status      string
+		served_from string
+		body        string
+	}{
+		{"first request", ProxyJob{}, CacheMiss, ServedFromUpstream, "response 1"},
+		{"from the cache", ProxyJob{}, CacheHit, ServedFromCache, "response 1"},
+		{"other variant", ProxyJob{Headers: map[string]string{"Accept": "text/plain"}}, CacheMiss, ServedFromUpstream, "response 2"},
+		{"no_cache", ProxyJob{NoCache: true}, CacheBypass, ServedFromUpstream, "response 3"},
+		{"still cached", ProxyJob{}, CacheHit, ServedFromCache, "response 1"},
+	}
+	for _, test := range tests {
+		job := test.job
+		job.URL = server.URL
+		job.Method = http.MethodGet
+		response, err := ExecuteJob(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.CacheStatus != test.status || response.ServedFrom != test.served_from || string(response.Body) != test.body {
+			t.Fatalf("%s got %s from %s %q, want %s from %s %q", test.name, response.CacheStatus, response.ServedFrom, response.Body, test.status, test.served_from, test.body)
+		}
+	}
+
+	// hits are counted like upstream requests, their latency kept apart
+	snapshot := metrics.Snapshot()
+	if len(snapshot.Requests) != 1 || snapshot.Requests[0].Value != uint64(len(tests)) {
+		t.Fatalf("requests = %+v, want %d GET 2xx", snapshot.Requests, len(tests))
+	}
+	if snapshot.CacheHit.Count != 2 || snapshot.Upstream.Count != 3 {
+		t.Fatalf("observed %d cache hits and %d upstream requests, want 2 and 3", snapshot.CacheHit.Count, snapshot.Upstream.Count)
+	}
+}
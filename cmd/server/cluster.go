@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// headers of the requests between the coordinator and its workers
+const (
+	clusterTokenHeader = "X-Cluster-Token"
+	clusterKeyIDHeader = "X-Cluster-Key-Id"
+)
+
+const (
+	// maxDispatchAttempts caps the workers a job is sent to before it fails
+	maxDispatchAttempts = 3
+	// dispatchSlack is how much longer than the job timeout the coordinator
+	// waits for a worker, the worker answers a timed out job itself
+	dispatchSlack = 5 * time.Second
+	// heartbeatTimeout bounds a heartbeat of a worker
+	heartbeatTimeout = 5 * time.Second
+)
+
+// ErrNoWorker is returned by the coordinator when no live worker has the
+// tags of a job, answered with 503
+var ErrNoWorker = errors.New("no worker available")
+
+// clusterToken authenticates the coordinator and its workers to each other
+var clusterToken string
+
+// ClusterWorker is what a worker tells the coordinator with every heartbeat
+type ClusterWorker struct {
+	ID       string            `json:"id"`
+	URL      string            `json:"url"`
+	Tags     map[string]string `json:"tags"`
+	InFlight int64             `json:"in_flight"`
+	Draining bool              `json:"draining"`
+}
+
+// ParseClusterTags reads "name=value" tags, validated with the config
+func ParseClusterTags(tags []string) map[string]string {
+	parsed := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		name, value, _ := strings.Cut(tag, "=")
+		parsed[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return parsed
+}
+
+type workerEntry struct {
+	ClusterWorker
+	lastSeen time.Time
+	// active counts the jobs dispatched to the worker and not answered yet
+	active int
+	// failed is set when the worker could not be reached, until its next heartbeat
+	failed bool
+}
+
+func (w *workerEntry) matches(tags map[string]string) bool {
+	for name, value := range tags {
+		if w.Tags[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Coordinator dispatches the jobs it takes to the workers registered with
+// it. Workers are dropped once they miss three heartbeats, jobs sent to a
+// worker that fails or is draining go to another one. Sessions, cached
+// bodies and recordings stay on the worker a job ran on, jobs of a session
+// always go to the same worker while it is up.
+type Coordinator struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	workers map[string]*workerEntry
+}
+
+// coordinator is set in cluster_mode coordinator, ExecuteJob hands every job to it
+var coordinator *Coordinator
+
+func NewCoordinator(heartbeat_interval time.Duration) *Coordinator {
+	return &Coordinator{ttl: 3 * heartbeat_interval, workers: map[string]*workerEntry{}}
+}
+
+// Register adds the worker or refreshes it
+func (c *Coordinator) Register(worker ClusterWorker, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.workers[worker.ID]
+	if !ok {
+		log.Info().Str("worker_id", worker.ID).Str("url", worker.URL).Interface("tags", worker.Tags).Msg("Worker joined")
+		entry = &workerEntry{}
+		c.workers[worker.ID] = entry
+	}
+	if worker.Draining && !entry.Draining {
+		log.Info().Str("worker_id", worker.ID).Msg("Worker draining")
+	}
+	entry.ClusterWorker = worker
+	entry.lastSeen = now
+	entry.failed = false
+}
+
+// pick takes the least busy live worker with the tags of the job that was
+// not tried yet, or the worker of its session
+func (c *Coordinator) pick(job ProxyJob, tried map[string]bool, now time.Time) *workerEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var candidates []*workerEntry
+	for id, entry := range c.workers {
+		if now.Sub(entry.lastSeen) > c.ttl {
+			log.Warn().Str("worker_id", id).Msg("Worker missed its heartbeats, dropping it")
+			delete(c.workers, id)
+			continue
+		}
+		if entry.failed || entry.Draining || tried[id] || !entry.matches(job.WorkerTags) {
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var picked *workerEntry
+	if job.SessionID != "" {
+		// rendezvous hashing, the session only moves when its worker leaves
+		var best uint64
+		for _, entry := range candidates {
+			h := fnv.New64a()
+			h.Write([]byte(job.SessionID + "\x00" + entry.ID))
+			if score := h.Sum64(); picked == nil || score > best {
+				picked, best = entry, score
+			}
+		}
+	} else {
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		for _, entry := range candidates {
+			if picked == nil || entry.active < picked.active {
+				picked = entry
+			}
+		}
+	}
+	picked.active++
+	return picked
+}
+
+func (c *Coordinator) release(entry *workerEntry, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.active--
+	if failed {
+		entry.failed = true
+	}
+}
+
+// Dispatch sends the job to a worker with its tags and returns the answer of
+// the worker. Jobs are sent on when a worker is unreachable or too busy to
+// take them, requests that may have reached the upstream through a worker
+// that failed midway are only sent again when their method is idempotent.
+func (c *Coordinator) Dispatch(parent context.Context, job ProxyJob) (ProxyResponse, error) {
+	if _, async := AsyncJobIDFrom(parent); draining.Load() && !async {
+		return ProxyResponse{}, ErrDraining
+	}
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Str("key_id", KeyIDFrom(parent)).Logger()
+
+	metrics.AddInFlight(1)
+	defer metrics.AddInFlight(-1)
+
+	ctx, cancel := context.WithTimeout(parent, JobTimeout(job)+dispatchSlack)
+	defer cancel()
+
+	// bodies are kept where GET /bodies/{id} is asked for them
+	cache_body := job.CacheBody
+	job.CacheBody = false
+
+	tried := map[string]bool{}
+	var last_err error = ErrNoWorker
+	for attempt := 0; attempt < maxDispatchAttempts; attempt++ {
+		worker := c.pick(job, tried, time.Now())
+		if worker == nil {
+			break
+		}
+		tried[worker.ID] = true
+
+		worker_logger := logger.With().Str("worker_id", worker.ID).Int("attempt", attempt+1).Logger()
+		worker_logger.Debug().Msg("Dispatching job")
+		response, status_code, err := postClusterJob(ctx, worker.URL, KeyIDFrom(parent), job)
+		// a worker that answered is up, even with an error
+		c.release(worker, err != nil && status_code == 0)
+		if err == nil {
+			if cache_body {
+				return cacheDispatchedBody(response)
+			}
+			return response, nil
+		}
+
+		if status_code == fiber.StatusServiceUnavailable {
+			// draining, overloaded or its circuit is open, another one may take it
+			worker_logger.Warn().Err(err).Msg("Worker unavailable, dispatching elsewhere")
+			last_err = relayedError(status_code, err)
+			continue
+		}
+		if status_code != 0 {
+			return ProxyResponse{}, relayedError(status_code, err)
+		}
+
+		worker_logger.Warn().Err(err).Msg("Worker failed")
+		if ctx.Err() != nil {
+			return ProxyResponse{}, &JobError{fiber.StatusGatewayTimeout, "Request timed out"}
+		}
+		if !isIdempotent(strings.ToUpper(job.Method)) && !isDialError(err) {
+			return ProxyResponse{}, &JobError{fiber.StatusBadGateway, "Worker failed while performing the job"}
+		}
+		last_err = &JobError{fiber.StatusBadGateway, "Worker failed: " + err.Error()}
+	}
+	if errors.Is(last_err, ErrNoWorker) {
+		logger.Warn().Interface("worker_tags", job.WorkerTags).Msg("No worker available")
+	}
+	return ProxyResponse{}, last_err
+}
+
+// cacheDispatchedBody keeps the body of a dispatched cache_body job on the coordinator
+func cacheDispatchedBody(response ProxyResponse) (ProxyResponse, error) {
+	id, err := bodies.Put(response.Body)
+	if err != nil {
+		return ProxyResponse{}, &JobError{fiber.StatusInternalServerError, "Failed to cache body"}
+	}
+	response.BodyID = id
+	response.BodySize = len(response.Body)
+	response.Body = nil
+	response.BodyEncoding = ""
+	return response, nil
+}
+
+// postClusterJob sends the job to the worker at worker_url. A worker that
+// answered with an error returns its status code along with the error.
+func postClusterJob(ctx context.Context, worker_url string, key_id string, job ProxyJob) (ProxyResponse, int, error) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return ProxyResponse{}, 0, err
+	}
+	agent := NewAgent(&fiber.Client{}, fiber.MethodPost, strings.TrimSuffix(worker_url, "/")+"/cluster/execute")
+	ApplyDeadline(ctx, agent)
+	agent.ContentType(fiber.MIMEApplicationJSON)
+	agent.Set(clusterTokenHeader, clusterToken)
+	agent.Set(clusterKeyIDHeader, key_id)
+	agent.Body(body)
+
+	status_code, data, errs := agent.Bytes()
+	if len(errs) > 0 {
+		return ProxyResponse{}, 0, errs[0]
+	}
+	if status_code != fiber.StatusOK {
+		var failure struct {
+			Error     string `json:"error"`
+			Code      string `json:"code"`
+			Retryable bool   `json:"retryable"`
+		}
+		if json.Unmarshal(data, &failure) != nil || failure.Error == "" {
+			return ProxyResponse{}, status_code, fmt.Errorf("worker answered %d", status_code)
+		}
+		if failure.Code == "" {
+			return ProxyResponse{}, status_code, errors.New(failure.Error)
+		}
+		return ProxyResponse{}, status_code, ErrorDetail{failure.Code, failure.Error, failure.Retryable}
+	}
+	var response ProxyResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return ProxyResponse{}, fiber.StatusBadGateway, fmt.Errorf("invalid worker response: %w", err)
+	}
+	return response, status_code, nil
+}
+
+// workerError is an error a worker answered with, relayed with its status
+// and the ErrorDetail of the worker
+type workerError struct {
+	status int
+	detail ErrorDetail
+}
+
+func (e *workerError) Error() string {
+	return e.detail.Message
+}
+
+func (e *workerError) Unwrap() []error {
+	return []error{e.detail, &JobError{e.status, e.detail.Message}}
+}
+
+// relayedError is the error of a worker that answered with status
+func relayedError(status int, err error) error {
+	var detail ErrorDetail
+	if errors.As(err, &detail) {
+		return &workerError{status, detail}
+	}
+	return &JobError{status, err.Error()}
+}
+
+// isDialError tells whether the worker was never reached
+func isDialError(err error) bool {
+	var op_err *net.OpError
+	return errors.Is(err, fasthttp.ErrDialTimeout) || (errors.As(err, &op_err) && op_err.Op == "dial")
+}
+
+type clusterWorkerStatus struct {
+	ClusterWorker
+	Active   int       `json:"active"`
+	Failed   bool      `json:"failed,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Status reports the live workers by ID
+func (c *Coordinator) Status() []clusterWorkerStatus {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statuses := make([]clusterWorkerStatus, 0, len(c.workers))
+	for _, entry := range c.workers {
+		if now.Sub(entry.lastSeen) > c.ttl {
+			continue
+		}
+		statuses = append(statuses, clusterWorkerStatus{entry.ClusterWorker, entry.active, entry.failed, entry.lastSeen})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+// ClusterNode registers a worker with its coordinator
+type ClusterNode struct {
+	coordinatorURL string
+	self           ClusterWorker
+}
+
+// clusterNode is set in cluster_mode worker
+var clusterNode *ClusterNode
+
+func NewClusterNode(coordinator_url string, self ClusterWorker) *ClusterNode {
+	return &ClusterNode{coordinatorURL: strings.TrimSuffix(coordinator_url, "/"), self: self}
+}
+
+// Heartbeat tells the coordinator the worker is up, and whether it drains
+func (n *ClusterNode) Heartbeat() error {
+	worker := n.self
+	worker.InFlight = metrics.InFlight()
+	worker.Draining = draining.Load()
+	body, err := json.Marshal(worker)
+	if err != nil {
+		return err
+	}
+
+	agent := NewAgent(&fiber.Client{}, fiber.MethodPost, n.coordinatorURL+"/cluster/heartbeat")
+	agent.Timeout(heartbeatTimeout)
+	agent.ContentType(fiber.MIMEApplicationJSON)
+	agent.Set(clusterTokenHeader, clusterToken)
+	agent.Body(body)
+	status_code, _, errs := agent.Bytes()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	if status_code != fiber.StatusOK {
+		return fmt.Errorf("coordinator answered %d", status_code)
+	}
+	return nil
+}
+
+// Run sends a heartbeat every interval
+func (n *ClusterNode) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	joined := false
+	for ; ; <-ticker.C {
+		if err := n.Heartbeat(); err != nil {
+			log.Warn().Err(err).Str("coordinator", n.coordinatorURL).Msg("Heartbeat failed")
+			joined = false
+			continue
+		}
+		if !joined {
+			log.Info().Str("coordinator", n.coordinatorURL).Str("worker_id", n.self.ID).Msg("Registered with the coordinator")
+			joined = true
+		}
+	}
+}
+
+// RequireClusterToken lets through the requests of the coordinator or its workers
+func RequireClusterToken(c *fiber.Ctx) error {
+	if subtle.ConstantTimeCompare([]byte(c.Get(clusterTokenHeader)), []byte(clusterToken)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid cluster token",
+		})
+	}
+	return c.Next()
+}
+
+// PostClusterHeartbeat registers a worker with the coordinator
+func PostClusterHeartbeat(c *fiber.Ctx) error {
+	var worker ClusterWorker
+	if err := json.Unmarshal(c.Body(), &worker); err != nil || worker.ID == "" || worker.URL == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid heartbeat",
+		})
+	}
+	coordinator.Register(worker, time.Now())
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// ExecuteClusterJob performs a job dispatched by the coordinator, for the
+// API key the coordinator authenticated
+func ExecuteClusterJob(c *fiber.Ctx) error {
+	var job ProxyJob
+	if err := json.Unmarshal(c.Body(), &job); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	response, err := ExecuteJob(WithKeyID(c.UserContext(), c.Get(clusterKeyIDHeader)), job)
+	if err != nil {
+		return sendJobError(c, err)
+	}
+	return c.JSON(response)
+}
+
+// GetClusterWorkers lists the workers of the coordinator
+// @Description Returns every live worker with its tags, the jobs dispatched to it and its last heartbeat
+func GetClusterWorkers(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"workers": coordinator.Status(),
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clusterWorker answers /cluster/execute as a worker would: ok answers the
+// job, busy answers 503, rejects answers 400 and broken drops the
+// connection once the job was read
+func clusterWorker(t *testing.T, behaviour string) (*httptest.Server, *atomic.Int64) {
+	var jobs atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jobs.Add(1)
+		if r.URL.Path != "/cluster/execute" || r.Header.Get(clusterTokenHeader) != clusterToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch behaviour {
+		case "ok":
+			fmt.Fprint(w, `{"status_code": 200}`)
+		case "busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, `{"error": "Server is overloaded", "code": %q, "retryable": true}`, ErrorOverloaded)
+		case "rejects":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "Invalid URL"}`)
+		case "broken":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &jobs
+}
+
+func TestCoordinatorDispatch(t *testing.T) {
+	previous_token := clusterToken
+	defer func() { clusterToken = previous_token }()
+	clusterToken = "cluster-secret"
+
+	tests := []struct {
+		name   string
+		first  string
+		method string
+		// resent is whether the job reaches the second worker
+		resent bool
+		status int
+	}{
+		{"busy worker fails over", "busy", fiber.MethodPost, true, fiber.StatusOK},
+		{"unreachable worker fails over", "unreachable", fiber.MethodPost, true, fiber.StatusOK},
+		{"broken worker fails over an idempotent job", "broken", fiber.MethodGet, true, fiber.StatusOK},
+		{"broken worker doesn't resend a post", "broken", fiber.MethodPost, false, fiber.StatusBadGateway},
+		{"rejection is relayed", "rejects", fiber.MethodGet, false, fiber.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			second, second_jobs := clusterWorker(t, "ok")
+			first_url := refusedURL(t)
+			if test.first != "unreachable" {
+				first, _ := clusterWorker(t, test.first)
+				first_url = first.URL
+			}
+
+			c := NewCoordinator(time.Minute)
+			now := time.Now()
+			c.Register(ClusterWorker{ID: "first", URL: first_url}, now)
+			c.Register(ClusterWorker{ID: "second", URL: second.URL}, now)
+			// the least busy worker is picked first
+			c.workers["second"].active = 1
+
+			response, err := c.Dispatch(context.Background(), ProxyJob{URL: "http://example.com/", Method: test.method, Timeout: 5})
+			c.workers["second"].active--
+			if got := second_jobs.Load() > 0; got != test.resent {
+				t.Fatalf("second worker got %d jobs, want the job resent: %v", second_jobs.Load(), test.resent)
+			}
+			if test.status == fiber.StatusOK {
+				if err != nil || response.StatusCode != fiber.StatusOK {
+					t.Fatalf("got %d %v, want the answer of the second worker", response.StatusCode, err)
+				}
+				return
+			}
+			var job_err *JobError
+			if !errors.As(err, &job_err) || job_err.Status != test.status {
+				t.Fatalf("got %v, want a %d error", err, test.status)
+			}
+			if active := c.workers["first"].active; active != 0 {
+				t.Fatalf("first worker has %d active jobs after the dispatch", active)
+			}
+		})
+	}
+}
+
+func TestCoordinatorPick(t *testing.T) {
+	c := NewCoordinator(time.Second)
+	now := time.Now()
+	c.Register(ClusterWorker{ID: "eu", URL: "http://eu", Tags: map[string]string{"region": "eu"}}, now)
+	c.Register(ClusterWorker{ID: "us", URL: "http://us", Tags: map[string]string{"region": "us"}}, now)
+	c.Register(ClusterWorker{ID: "draining", URL: "http://draining", Draining: true}, now)
+	c.Register(ClusterWorker{ID: "stale", URL: "http://stale"}, now.Add(-time.Minute))
+
+	tests := []struct {
+		name  string
+		job   ProxyJob
+		tried map[string]bool
+		want  string
+	}{
+		{"by tags", ProxyJob{WorkerTags: map[string]string{"region": "us"}}, nil, "us"},
+		{"tags of no worker", ProxyJob{WorkerTags: map[string]string{"region": "ap"}}, nil, ""},
+		{"tried workers skipped", ProxyJob{}, map[string]bool{"eu": true}, "us"},
+		{"draining and stale workers skipped", ProxyJob{}, map[string]bool{"eu": true, "us": true}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			picked := c.pick(test.job, test.tried, now)
+			got := ""
+			if picked != nil {
+				got = picked.ID
+				c.release(picked, false)
+			}
+			if got != test.want {
+				t.Fatalf("picked %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// a session sticks to its worker
+	session := ProxyJob{SessionID: "session-1"}
+	first := c.pick(session, nil, now)
+	c.release(first, false)
+	for i := 0; i < 5; i++ {
+		picked := c.pick(session, nil, now)
+		c.release(picked, false)
+		if picked.ID != first.ID {
+			t.Fatalf("session moved from %s to %s", first.ID, picked.ID)
+		}
+	}
+	if statuses := c.Status(); len(statuses) != 3 || strings.Join([]string{statuses[0].ID, statuses[1].ID, statuses[2].ID}, ",") != "draining,eu,us" {
+		t.Fatalf("status = %+v, want the live workers by id", statuses)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// BodyEncodingGzip marks a body that was gzipped before being base64 encoded into the JSON envelope
+const BodyEncodingGzip = "gzip+base64"
+
+// GzipBody compresses the body for the JSON envelope
+func GzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
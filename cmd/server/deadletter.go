@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/textproto"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are redacted from jobs before they leave the worker
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+}
+
+// sensitiveResponseHeaders are redacted from responses before they are persisted
+var sensitiveResponseHeaders = map[string]bool{
+	"Set-Cookie": true,
+}
+
+// DeadLetter is the record of a job that ultimately failed
+type DeadLetter struct {
+	Time   time.Time `json:"time"`
+	Job    ProxyJob  `json:"job"`
+	Errors []string  `json:"errors"`
+}
+
+// DeadLetterSink stores dead letters for later replay or analysis
+type DeadLetterSink interface {
+	Write(letter DeadLetter) error
+}
+
+// FileDeadLetterSink appends dead letters as JSON lines to a file
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileDeadLetterSink(path string) *FileDeadLetterSink {
+	return &FileDeadLetterSink{path: path}
+}
+
+func (s *FileDeadLetterSink) Write(letter DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// HTTPDeadLetterSink posts dead letters as JSON to an endpoint
+type HTTPDeadLetterSink struct {
+	url string
+}
+
+func NewHTTPDeadLetterSink(url string) *HTTPDeadLetterSink {
+	return &HTTPDeadLetterSink{url: url}
+}
+
+func (s *HTTPDeadLetterSink) Write(letter DeadLetter) error {
+	status_code, _, errs := fiber.Post(s.url).JSON(letter).Timeout(10 * time.Second).Bytes()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	if status_code >= 300 {
+		return fmt.Errorf("dead letter endpoint returned %d", status_code)
+	}
+	return nil
+}
+
+// deadLetterSinks are configured from PROXIER_DEAD_LETTER_FILE and PROXIER_DEAD_LETTER_URL
+var deadLetterSinks []DeadLetterSink
+
+// RecordDeadLetter hands the redacted job and its errors to every configured sink
+func RecordDeadLetter(job ProxyJob, errs []string) {
+	if len(deadLetterSinks) == 0 {
+		return
+	}
+
+	letter := DeadLetter{
+		Time:   time.Now(),
+		Job:    RedactJob(job),
+		Errors: errs,
+	}
+	for _, sink := range deadLetterSinks {
+		go func(sink DeadLetterSink) {
+			if err := sink.Write(letter); err != nil {
+				log.Error().Err(err).Str("url", job.URL).Msg("Failed to write dead letter")
+			}
+		}(sink)
+	}
+}
+
+// RedactJob returns a copy of the job without credentials or cookie values,
+// including the headers its rewrite rules set
+func RedactJob(job ProxyJob) ProxyJob {
+	if job.BasicAuthPass != "" {
+		job.BasicAuthPass = redacted
+	}
+
+	job.ProxyURL = RedactProxyURL(job.ProxyURL)
+	job.Headers = redactHeaders(job.Headers)
+
+	if job.RewriteRules != nil {
+		rules := make([]RewriteRule, len(job.RewriteRules))
+		for i, rule := range job.RewriteRules {
+			rule.Request = redactActions(rule.Request)
+			rule.Response = redactActions(rule.Response)
+			rules[i] = rule
+		}
+		job.RewriteRules = rules
+	}
+
+	cookies := make(map[string]string, len(job.Cookies))
+	for key := range job.Cookies {
+		cookies[key] = redacted
+	}
+	job.Cookies = cookies
+
+	return job
+}
+
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redacted_headers := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if sensitiveHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+			value = redacted
+		}
+		redacted_headers[key] = value
+	}
+	return redacted_headers
+}
+
+func redactActions(actions *RewriteActions) *RewriteActions {
+	if actions == nil {
+		return nil
+	}
+	copied := *actions
+	copied.SetHeaders = redactHeaders(actions.SetHeaders)
+	copied.AddHeaders = redactHeaders(actions.AddHeaders)
+	return &copied
+}
+
+// RedactProxyURL drops the password of a proxy URL, keeping the username
+func RedactProxyURL(proxy_url string) string {
+	parsed, err := url.Parse(proxy_url)
+	if err != nil || parsed.User == nil {
+		return proxy_url
+	}
+	parsed.User = url.User(parsed.User.Username())
+	return parsed.String()
+}
+
+// RedactURL drops the userinfo of a target URL
+func RedactURL(target_url string) string {
+	parsed, err := url.Parse(target_url)
+	if err != nil || parsed.User == nil {
+		return target_url
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// RedactResponseHeaders redacts every value of the sensitive response headers
+func RedactResponseHeaders(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	redacted_headers := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		if sensitiveResponseHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+			redacted_values := make([]string, len(values))
+			for i := range values {
+				redacted_values[i] = redacted
+			}
+			values = redacted_values
+		}
+		redacted_headers[key] = values
+	}
+	return redacted_headers
+}
+
+func errorStrings(errs []error) []string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return messages
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// AuthTypes of a job, without one the challenge of the upstream is answered
+const (
+	AuthTypeBasic  = "basic"
+	AuthTypeDigest = "digest"
+)
+
+// DigestChallenge holds the parameters of a WWW-Authenticate: Digest challenge (RFC 7616)
+type DigestChallenge struct {
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	Qop       []string
+	Userhash  bool
+}
+
+// digest algorithms in order of preference
+var digestAlgorithms = map[string]int{
+	"SHA-512-256":      6,
+	"SHA-512-256-SESS": 5,
+	"SHA-256":          4,
+	"SHA-256-SESS":     3,
+	"MD5":              2,
+	"MD5-SESS":         1,
+}
+
+// ParseDigestChallenges picks the strongest supported Digest challenge
+// out of the WWW-Authenticate header values of a response
+func ParseDigestChallenges(headers [][]byte) (*DigestChallenge, error) {
+	var best *DigestChallenge
+	for _, header := range headers {
+		for _, challenge := range splitChallenges(string(header)) {
+			scheme, params, _ := strings.Cut(challenge, " ")
+			if !strings.EqualFold(scheme, "Digest") {
+				continue
+			}
+
+			values := parseAuthParams(params)
+			parsed := &DigestChallenge{
+				Realm:     values["realm"],
+				Nonce:     values["nonce"],
+				Opaque:    values["opaque"],
+				Algorithm: strings.ToUpper(values["algorithm"]),
+				Userhash:  strings.EqualFold(values["userhash"], "true"),
+			}
+			if parsed.Algorithm == "" {
+				parsed.Algorithm = "MD5"
+			}
+			for _, qop := range strings.Split(values["qop"], ",") {
+				if qop = strings.TrimSpace(qop); qop != "" {
+					parsed.Qop = append(parsed.Qop, qop)
+				}
+			}
+
+			if parsed.Nonce == "" || digestAlgorithms[parsed.Algorithm] == 0 {
+				continue
+			}
+			if best == nil || digestAlgorithms[parsed.Algorithm] > digestAlgorithms[best.Algorithm] {
+				best = parsed
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, errors.New("no supported digest challenge")
+	}
+	return best, nil
+}
+
+// Authorization computes the Authorization header value answering the challenge
+func (c *DigestChallenge) Authorization(username, password, method, uri string, body []byte) (string, error) {
+	algorithm := strings.TrimSuffix(c.Algorithm, "-SESS")
+	var h func() hash.Hash
+	switch algorithm {
+	case "MD5":
+		h = md5.New
+	case "SHA-256":
+		h = sha256.New
+	case "SHA-512-256":
+		h = sha512.New512_256
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", c.Algorithm)
+	}
+	digest := func(parts ...string) string {
+		sum := h()
+		sum.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	cnonce, err := newCnonce()
+	if err != nil {
+		return "", err
+	}
+	const nc = "00000001"
+
+	ha1 := digest(username, c.Realm, password)
+	if strings.HasSuffix(c.Algorithm, "-SESS") {
+		ha1 = digest(ha1, c.Nonce, cnonce)
+	}
+
+	qop := ""
+	for _, offered := range c.Qop {
+		if offered == "auth-int" && qop == "" {
+			qop = offered
+		}
+		if offered == "auth" {
+			qop = offered
+		}
+	}
+
+	ha2 := digest(method, uri)
+	if qop == "auth-int" {
+		ha2 = digest(method, uri, digest(string(body)))
+	}
+
+	var response string
+	if qop == "" {
+		response = digest(ha1, c.Nonce, ha2)
+	} else {
+		response = digest(ha1, c.Nonce, nc, cnonce, qop, ha2)
+	}
+
+	user := username
+	if c.Userhash {
+		user = digest(username, c.Realm)
+	}
+
+	fields := []string{
+		fmt.Sprintf(`username=%q`, user),
+		fmt.Sprintf(`realm=%q`, c.Realm),
+		fmt.Sprintf(`nonce=%q`, c.Nonce),
+		fmt.Sprintf(`uri=%q`, uri),
+		fmt.Sprintf(`algorithm=%s`, c.Algorithm),
+		fmt.Sprintf(`response=%q`, response),
+	}
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce=%q`, cnonce))
+	}
+	if c.Opaque != "" {
+		fields = append(fields, fmt.Sprintf(`opaque=%q`, c.Opaque))
+	}
+	if c.Userhash {
+		fields = append(fields, "userhash=true")
+	}
+
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+func newCnonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// splitChallenges splits a WWW-Authenticate value holding several challenges,
+// e.g. `Basic realm="a", Digest realm="b", nonce="c"`
+func splitChallenges(header string) []string {
+	var challenges []string
+	for _, part := range splitQuoted(header, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		// a new challenge starts with a token that is not followed by "="
+		token, _, _ := strings.Cut(part, " ")
+		if len(challenges) == 0 || !strings.Contains(token, "=") {
+			challenges = append(challenges, part)
+			continue
+		}
+		challenges[len(challenges)-1] += ", " + part
+	}
+	return challenges
+}
+
+// parseAuthParams parses comma separated key=value auth params with optional quoting
+func parseAuthParams(params string) map[string]string {
+	values := make(map[string]string)
+	for _, param := range splitQuoted(params, ',') {
+		key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+		}
+		values[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return values
+}
+
+// splitQuoted splits s on sep, ignoring separators inside quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// HasBasicChallenge tells whether a WWW-Authenticate header value of a
+// response asks for basic auth
+func HasBasicChallenge(headers [][]byte) bool {
+	for _, header := range headers {
+		for _, challenge := range splitChallenges(string(header)) {
+			scheme, _, _ := strings.Cut(challenge, " ")
+			if strings.EqualFold(scheme, "Basic") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RetryWithAuth answers the challenge found on the 401 response, Digest when
+// offered and else Basic unless the job is limited to digest, and resends the
+// request on the same agent, which must have been marked for reuse. The
+// original response is returned when there is no challenge to answer.
+func RetryWithAuth(agent *fiber.Agent, resp *fiber.Response, job ProxyJob, status_code int, body []byte) (int, []byte, []error) {
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Logger()
+
+	headers := resp.Header.PeekAll(fiber.HeaderWWWAuthenticate)
+	challenge, err := ParseDigestChallenges(headers)
+	if err != nil {
+		if job.AuthType == AuthTypeDigest || !HasBasicChallenge(headers) {
+			return status_code, body, nil
+		}
+		logger.Debug().Msg("Retrying with basic auth")
+		agent.BasicAuth(job.BasicAuthUser, job.BasicAuthPass)
+		return SendRequest(agent)
+	}
+
+	req := agent.Request()
+	authorization, err := challenge.Authorization(
+		job.BasicAuthUser,
+		job.BasicAuthPass,
+		string(req.Header.Method()),
+		string(req.URI().RequestURI()),
+		req.Body(),
+	)
+	if err != nil {
+		return status_code, body, []error{err}
+	}
+
+	logger.Debug().Str("algorithm", challenge.Algorithm).Msg("Retrying with digest auth")
+	req.Header.Set(fiber.HeaderAuthorization, authorization)
+	return SendRequest(agent)
+}
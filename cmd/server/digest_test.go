@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseDigestChallenges(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   []string
+		algorithm string
+		qop       []string
+		err       bool
+	}{
+		{"default algorithm", []string{`Digest realm="r", nonce="n"`}, "MD5", nil, false},
+		{"qop list", []string{`Digest realm="r", nonce="n", qop="auth, auth-int"`}, "MD5", []string{"auth", "auth-int"}, false},
+		{"strongest of one header", []string{`Digest realm="r", nonce="n", algorithm=MD5, Digest realm="r", nonce="n", algorithm=SHA-256`}, "SHA-256", nil, false},
+		{"strongest of several headers", []string{`Digest realm="r", nonce="n", algorithm=SHA-512-256`, `Digest realm="r", nonce="n", algorithm=MD5`}, "SHA-512-256", nil, false},
+		{"after a basic challenge", []string{`Basic realm="r", Digest realm="r", nonce="n", algorithm=SHA-256-sess`}, "SHA-256-SESS", nil, false},
+		{"unsupported algorithm", []string{`Digest realm="r", nonce="n", algorithm=SHA-1`}, "", nil, true},
+		{"without nonce", []string{`Digest realm="r"`}, "", nil, true},
+		{"basic only", []string{`Basic realm="r"`}, "", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := make([][]byte, len(test.headers))
+			for i, header := range test.headers {
+				headers[i] = []byte(header)
+			}
+			challenge, err := ParseDigestChallenges(headers)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got challenge %+v, want an error", challenge)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if challenge.Algorithm != test.algorithm {
+				t.Fatalf("algorithm = %q, want %q", challenge.Algorithm, test.algorithm)
+			}
+			if strings.Join(challenge.Qop, ",") != strings.Join(test.qop, ",") {
+				t.Fatalf("qop = %v, want %v", challenge.Qop, test.qop)
+			}
+		})
+	}
+}
+
+func TestHasBasicChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`Basic realm="r"`, true},
+		{`basic realm="r"`, true},
+		{`Digest realm="r", nonce="n", Basic realm="r"`, true},
+		{`Digest realm="r", nonce="n"`, false},
+		{`Bearer realm="r"`, false},
+	}
+	for _, test := range tests {
+		if got := HasBasicChallenge([][]byte{[]byte(test.header)}); got != test.want {
+			t.Errorf("HasBasicChallenge(%q) = %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+// authServer answers 401 with a challenge of scheme until the request
+// authenticates as user:pass, recording the Authorization of every request
+type authServer struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	authorizations []string
+}
+
+func newAuthServer(t *testing.T, scheme string, algorithm string) *authServer {
+	server := &authServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		server.mu.Lock()
+		server.authorizations = append(server.authorizations, authorization)
+		server.mu.Unlock()
+
+		if scheme == "Basic" {
+			if user, pass, ok := r.BasicAuth(); ok && user == "user" && pass == "pass" {
+				fmt.Fprint(w, "ok")
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if validDigest(authorization, r.Method, algorithm) {
+			fmt.Fprint(w, "ok")
+			return
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="test", nonce="abc123", qop="auth", algorithm=%s, opaque="xyz"`, algorithm))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *authServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.authorizations...)
+}
+
+// validDigest checks a qop=auth digest response of user:pass as RFC 7616 has it
+func validDigest(authorization string, method string, algorithm string) bool {
+	params, ok := strings.CutPrefix(authorization, "Digest ")
+	if !ok {
+		return false
+	}
+	values := parseAuthParams(params)
+	var h func() hash.Hash
+	switch algorithm {
+	case "MD5":
+		h = md5.New
+	case "SHA-256":
+		h = sha256.New
+	default:
+		return false
+	}
+	digest := func(parts ...string) string {
+		sum := h()
+		sum.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	if values["username"] != "user" || values["nonce"] != "abc123" || values["opaque"] != "xyz" || values["qop"] != "auth" || values["algorithm"] != algorithm {
+		return false
+	}
+	ha1 := digest("user", "test", "pass")
+	ha2 := digest(method, values["uri"])
+	return values["response"] == digest(ha1, values["nonce"], values["nc"], values["cnonce"], values["qop"], ha2)
+}
+
+func TestAuthChallenges(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheme    string
+		algorithm string
+		auth_type string
+		password  string
+		status    int
+		// sent is the scheme of the Authorization of each request, "" without one
+		sent []string
+	}{
+		{"basic challenge answered", "Basic", "", "", "pass", http.StatusOK, []string{"", "Basic"}},
+		{"basic sent right away", "Basic", "", AuthTypeBasic, "pass", http.StatusOK, []string{"Basic"}},
+		{"basic challenge with digest only", "Basic", "", AuthTypeDigest, "pass", http.StatusUnauthorized, []string{""}},
+		{"md5 digest challenge answered", "Digest", "MD5", "", "pass", http.StatusOK, []string{"", "Digest"}},
+		{"sha-256 digest challenge answered", "Digest", "SHA-256", AuthTypeDigest, "pass", http.StatusOK, []string{"", "Digest"}},
+		{"digest challenge with basic only", "Digest", "MD5", AuthTypeBasic, "pass", http.StatusUnauthorized, []string{"Basic"}},
+		{"wrong digest password", "Digest", "MD5", "", "wrong", http.StatusUnauthorized, []string{"", "Digest"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newAuthServer(t, test.scheme, test.algorithm)
+			response, err := ExecuteJob(context.Background(), ProxyJob{
+				URL:           server.URL + "/private?page=1",
+				Method:        http.MethodGet,
+				BasicAuthUser: "user",
+				BasicAuthPass: test.password,
+				AuthType:      test.auth_type,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("status = %d, want %d", response.StatusCode, test.status)
+			}
+
+			requests := server.requests()
+			if len(requests) != len(test.sent) {
+				t.Fatalf("sent %d requests %q, want %d", len(requests), requests, len(test.sent))
+			}
+			for i, authorization := range requests {
+				scheme, _, _ := strings.Cut(authorization, " ")
+				if scheme != test.sent[i] {
+					t.Fatalf("request %d sent Authorization %q, want scheme %q", i+1, authorization, test.sent[i])
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidAuthType(t *testing.T) {
+	_, err := ExecuteJob(context.Background(), ProxyJob{URL: "http://127.0.0.1/", Method: http.MethodGet, AuthType: "ntlm"})
+	if err == nil || ClassifyError(err).Code != ErrorInvalidJob {
+		t.Fatalf("err = %v, want an invalid_job error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// ErrDraining is returned for jobs submitted once the server is shutting
+// down, answered with 503
+var ErrDraining = errors.New("server is shutting down")
+
+// draining is set on SIGINT or SIGTERM, new jobs are refused from then on
+var draining atomic.Bool
+
+// Livez tells whether the server is up
+// @Description Answers OK as long as the process serves requests, also while shutting down
+func Livez(c *fiber.Ctx) error {
+	return c.SendString("OK")
+}
+
+// Readyz tells whether the server takes new jobs
+// @Description Answers 503 once the server is shutting down or when no proxy of the pool passed its health checks
+func Readyz(c *fiber.Ctx) error {
+	if draining.Load() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Server is shutting down",
+		})
+	}
+	if proxyPool != nil && proxyPool.HealthyCount() == 0 {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "No healthy proxies in the pool",
+		})
+	}
+	return c.SendString("OK")
+}
+
+// waitInFlight waits until no job is being performed or ctx is done
+func waitInFlight(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for metrics.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Shutdown refuses new jobs, waits up to timeout for the queued and running
+// ones, then stops both listeners and flushes the job history. The listeners
+// keep serving while the jobs drain, so /readyz reports the shutdown and
+// clients get a 503 rather than a refused connection.
+func Shutdown(app *fiber.App, grpc_server *grpc.Server, timeout time.Duration) {
+	draining.Store(true)
+	if clusterNode != nil {
+		// the coordinator stops dispatching right away
+		if err := clusterNode.Heartbeat(); err != nil {
+			log.Warn().Err(err).Msg("Failed to tell the coordinator about the shutdown")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	started := time.Now()
+
+	if err := asyncJobs.Drain(ctx); err != nil {
+		log.Warn().Int("queue_depth", asyncJobs.Depth()).Msg("Shutdown timeout reached, dropping queued async jobs")
+	} else if err := waitInFlight(ctx); err != nil {
+		log.Warn().Int64("in_flight", metrics.InFlight()).Msg("Shutdown timeout reached, aborting running jobs")
+	} else {
+		log.Info().Dur("duration", time.Since(started)).Msg("Jobs drained")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpc_server.GracefulStop()
+		close(stopped)
+	}()
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to close open connections")
+	}
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// streams such as WatchJob hold GracefulStop
+		grpc_server.Stop()
+	}
+
+	// the history gets its own grace period, jobs that made it in time are recorded
+	flush_ctx, flush_cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flush_cancel()
+	if err := history.Flush(flush_ctx); err != nil {
+		log.Warn().Msg("Job history not flushed, some jobs are not recorded")
+	}
+	log.Info().Dur("duration", time.Since(started)).Msg("Server stopped")
+}
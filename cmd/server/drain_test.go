@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/grpc"
+)
+
+func TestReadyz(t *testing.T) {
+	previous := proxyPool
+	defer func() {
+		proxyPool = previous
+		draining.Store(false)
+	}()
+	healthy, _ := NewProxyPool(poolProxies, RotationRoundRobin)
+	unhealthy, _ := NewProxyPool(poolProxies, RotationRoundRobin)
+	for _, proxy := range unhealthy.proxies {
+		proxy.healthy = false
+	}
+
+	tests := []struct {
+		name     string
+		pool     *ProxyPool
+		draining bool
+		status   int
+	}{
+		{"no pool", nil, false, fiber.StatusOK},
+		{"healthy pool", healthy, false, fiber.StatusOK},
+		{"no healthy proxy", unhealthy, false, fiber.StatusServiceUnavailable},
+		{"draining", nil, true, fiber.StatusServiceUnavailable},
+	}
+	app := fiber.New()
+	app.Get("/livez", Livez)
+	app.Get("/readyz", Readyz)
+	for _, test := range tests {
+		proxyPool = test.pool
+		draining.Store(test.draining)
+		response, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/readyz", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != test.status {
+			t.Errorf("%s: readyz got %d, want %d", test.name, response.StatusCode, test.status)
+		}
+		// liveness never depends on the pool or the shutdown
+		if response, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/livez", nil), -1); err != nil || response.StatusCode != fiber.StatusOK {
+			t.Errorf("%s: livez got %v %v, want 200", test.name, response, err)
+		}
+	}
+}
+
+func TestShutdownDrainsJobs(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	previous := asyncJobs
+	defer func() {
+		asyncJobs = previous
+		draining.Store(false)
+	}()
+	asyncJobs = NewJobQueue(NewJobStore(time.Minute), 1, 1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Post("/proxy", PerformProxyJob)
+	app.Get("/readyz", Readyz)
+	go app.Listener(listener)
+	base := "http://" + listener.Addr().String()
+	submit := func(query string) (*http.Response, error) {
+		return http.Post(base+"/proxy"+query, fiber.MIMEApplicationJSON, strings.NewReader(`{"url": "`+upstream.URL+`", "method": "GET"}`))
+	}
+
+	// one job waits for the upstream, one async job is queued behind it
+	in_flight := make(chan *http.Response, 1)
+	go func() {
+		response, err := submit("")
+		if err != nil {
+			t.Error(err)
+		}
+		in_flight <- response
+	}()
+	for deadline := time.Now().Add(5 * time.Second); metrics.InFlight() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("job never started")
+		}
+	}
+	queued, err := asyncJobs.Submit("", ProxyJob{URL: upstream.URL, Method: http.MethodGet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		Shutdown(app, grpc.NewServer(), 10*time.Second)
+		close(stopped)
+	}()
+	for !draining.Load() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the listener still answers while the jobs drain
+	response, err := http.Get(base + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != fiber.StatusServiceUnavailable {
+		t.Fatalf("readyz got %d while draining, want 503", response.StatusCode)
+	}
+	for _, query := range []string{"", "?async=true"} {
+		response, err := submit(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != fiber.StatusServiceUnavailable {
+			t.Fatalf("new job%s got %d while draining, want 503", query, response.StatusCode)
+		}
+	}
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned before the jobs finished")
+	default:
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown never returned")
+	}
+	if response := <-in_flight; response == nil || response.StatusCode != fiber.StatusOK {
+		t.Fatalf("in-flight job got %v, want it completed", response)
+	} else {
+		response.Body.Close()
+	}
+	if status, _ := asyncJobs.store.Get(queued.ID); status.State != JobDone {
+		t.Fatalf("queued job is %s after the shutdown, want it done", status.State)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Body encodings of the JSON envelope, reported in ProxyResponse.BodyEncoding
+const (
+	BodyEncodingString = "string"
+	BodyEncodingBase64 = "base64"
+)
+
+// ResponseEncodingAuto returns textual content types as a string and
+// everything else as base64
+const ResponseEncodingAuto = "auto"
+
+// ValidResponseEncoding reports whether the job asks for a known body encoding
+func ValidResponseEncoding(encoding string) bool {
+	switch encoding {
+	case "", ResponseEncodingAuto, BodyEncodingString, BodyEncodingBase64:
+		return true
+	}
+	return false
+}
+
+// isTextual reports whether the content type is safe to return as a string
+func isTextual(content_type string) bool {
+	media_type, _, err := mime.ParseMediaType(content_type)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(media_type, "text/"),
+		media_type == "application/json",
+		media_type == "application/xml",
+		media_type == "application/javascript",
+		media_type == "application/x-www-form-urlencoded",
+		strings.HasSuffix(media_type, "+json"),
+		strings.HasSuffix(media_type, "+xml"):
+		return true
+	}
+	return false
+}
+
+// ChooseBodyEncoding decides how the body goes into the JSON envelope. Bodies
+// that are not valid UTF-8 are always base64 encoded, as are RawBytes bodies so
+// they reach the caller byte for byte.
+func ChooseBodyEncoding(job ProxyJob, response ProxyResponse) string {
+	if job.RawBytes || job.ResponseEncoding == BodyEncodingBase64 || !utf8.Valid(response.Body) {
+		return BodyEncodingBase64
+	}
+	if job.ResponseEncoding == BodyEncodingString {
+		return BodyEncodingString
+	}
+
+	if isTextual(headerValue(response.Headers, "Content-Type")) {
+		return BodyEncodingString
+	}
+	return BodyEncodingBase64
+}
+
+// MarshalJSON writes the body as a JSON string when BodyEncoding is "string",
+// []byte bodies are base64 encoded by encoding/json otherwise. Errors are
+// written as their ErrorDetail since error values have no JSON form of their own.
+func (r ProxyResponse) MarshalJSON() ([]byte, error) {
+	type envelope ProxyResponse
+	var errs []ErrorDetail
+	if r.Errs != nil {
+		errs = ErrorDetails(r.Errs)
+	}
+	if r.BodyEncoding != BodyEncodingString {
+		return json.Marshal(struct {
+			envelope
+			Errs []ErrorDetail `json:"errs"`
+		}{envelope(r), errs})
+	}
+	return json.Marshal(struct {
+		envelope
+		Body string        `json:"body"`
+		Errs []ErrorDetail `json:"errs"`
+	}{envelope(r), string(r.Body), errs})
+}
+
+// UnmarshalJSON reads what MarshalJSON writes, as the coordinator does with
+// the responses of its workers. Errors come back as their ErrorDetail.
+func (r *ProxyResponse) UnmarshalJSON(data []byte) error {
+	type envelope ProxyResponse
+	var decoded struct {
+		envelope
+		Body json.RawMessage `json:"body"`
+		Errs []ErrorDetail   `json:"errs"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*r = ProxyResponse(decoded.envelope)
+	if len(decoded.Body) > 0 {
+		if r.BodyEncoding == BodyEncodingString {
+			var body string
+			if err := json.Unmarshal(decoded.Body, &body); err != nil {
+				return err
+			}
+			r.Body = []byte(body)
+		} else if err := json.Unmarshal(decoded.Body, &r.Body); err != nil {
+			return err
+		}
+	}
+	for _, detail := range decoded.Errs {
+		r.Errs = append(r.Errs, detail)
+	}
+	return nil
+}
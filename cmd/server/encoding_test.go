@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write([]byte(data))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestRawBytesPassthrough(t *testing.T) {
+	archive := gzipped(t, "hello archive")
+	binary := []byte{0x00, 0xff, 0xfe, 'h', 'e', 'l', 'l', 'o', 0x80, '\r', '\n'}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/archive.tar.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(archive)
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipped(t, "hello encoded"))
+		case "/binary":
+			w.Write(binary)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello world"))
+		}
+	}))
+	defer server.Close()
+
+	rewrite := []RewriteRule{{Response: &RewriteActions{Body: []BodyRewrite{{Pattern: "hello", Replace: "bye"}}}}}
+	tests := []struct {
+		name     string
+		path     string
+		raw      bool
+		compress bool
+		body     []byte
+		encoding string
+	}{
+		{"text untouched", "/text", true, true, []byte("hello world"), BodyEncodingBase64},
+		{"text processed without raw_bytes", "/text", false, false, []byte("bye world"), BodyEncodingString},
+		{"archive untouched", "/archive.tar.gz", true, true, archive, BodyEncodingBase64},
+		{"content encoding kept", "/encoded", true, false, gzipped(t, "hello encoded"), BodyEncodingBase64},
+		{"binary untouched", "/binary", true, true, binary, BodyEncodingBase64},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := ExecuteJob(context.Background(), ProxyJob{
+				URL:                  server.URL + test.path,
+				Method:               http.MethodGet,
+				RawBytes:             test.raw,
+				CompressResponseBody: test.compress,
+				RewriteRules:         rewrite,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(response.Body, test.body) {
+				t.Fatalf("body = %q, want %q", response.Body, test.body)
+			}
+			if response.BodyEncoding != test.encoding {
+				t.Fatalf("body_encoding = %q, want %q", response.BodyEncoding, test.encoding)
+			}
+		})
+	}
+}
+
+func TestResponseEncoding(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0x00}
+	bodies := map[string][]byte{
+		"application/json":         []byte(`{"name": "café"}`),
+		"application/problem+json": []byte(`{"title": "oops"}`),
+		"text/html; charset=utf-8": []byte("<p>hello</p>"),
+		"image/png":                png,
+		"application/octet-stream": []byte("plain bytes"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content_type := r.URL.Query().Get("type")
+		w.Header().Set("Content-Type", content_type)
+		w.Write(bodies[content_type])
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		content_type string
+		encoding     string
+		want         string
+	}{
+		{"json as a string", "application/json", "", BodyEncodingString},
+		{"json suffix as a string", "application/problem+json", ResponseEncodingAuto, BodyEncodingString},
+		{"text as a string", "text/html; charset=utf-8", "", BodyEncodingString},
+		{"png as base64", "image/png", "", BodyEncodingBase64},
+		{"binary type as base64", "application/octet-stream", "", BodyEncodingBase64},
+		{"forced base64", "application/json", BodyEncodingBase64, BodyEncodingBase64},
+		{"forced string", "application/octet-stream", BodyEncodingString, BodyEncodingString},
+		{"invalid utf-8 never a string", "image/png", BodyEncodingString, BodyEncodingBase64},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job_url := server.URL + "/?type=" + url.QueryEscape(test.content_type)
+			status, data := postJob(t, ProxyJob{URL: job_url, Method: http.MethodGet, ResponseEncoding: test.encoding})
+			if status != http.StatusOK {
+				t.Fatalf("status = %d: %s", status, data)
+			}
+			var envelope struct {
+				Body         string `json:"body"`
+				BodyEncoding string `json:"body_encoding"`
+			}
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if envelope.BodyEncoding != test.want {
+				t.Fatalf("body_encoding = %q, want %q", envelope.BodyEncoding, test.want)
+			}
+			body := []byte(envelope.Body)
+			if test.want == BodyEncodingBase64 {
+				decoded, err := base64.StdEncoding.DecodeString(envelope.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = decoded
+			}
+			if !bytes.Equal(body, bodies[test.content_type]) {
+				t.Fatalf("body = %q, want %q", body, bodies[test.content_type])
+			}
+
+			// and the coordinator reads back the same bytes
+			var response ProxyResponse
+			if err := json.Unmarshal(data, &response); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(response.Body, bodies[test.content_type]) {
+				t.Fatalf("decoded body = %q, want %q", response.Body, bodies[test.content_type])
+			}
+		})
+	}
+
+	_, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet, ResponseEncoding: "utf-16"})
+	if err == nil || ClassifyError(err).Code != ErrorInvalidJob {
+		t.Fatalf("err = %v, want an invalid_job error", err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Codes of ErrorDetail. Policy rejections use the code of their
+// PolicyError: scheme_not_allowed, target_denied or private_address.
+const (
+	ErrorDNSFailure        = "dns_failure"
+	ErrorConnectionRefused = "connection_refused"
+	ErrorConnectionReset   = "connection_reset"
+	ErrorTLS               = "tls_error"
+	ErrorTimeout           = "timeout"
+	ErrorUpstreamProxy     = "upstream_proxy_failure"
+	ErrorBodyTooLarge      = "body_too_large"
+	ErrorHeadersTooLarge   = "headers_too_large"
+	ErrorUpstream          = "upstream_error"
+
+	ErrorInvalidJob   = "invalid_job"
+	ErrorUnauthorized = "unauthorized"
+	ErrorForbidden    = "forbidden"
+	ErrorNotFound     = "not_found"
+	ErrorRateLimited  = "rate_limited"
+	ErrorInternal     = "internal_error"
+	ErrorUnavailable  = "unavailable"
+
+	ErrorCircuitOpen       = "circuit_open"
+	ErrorDomainRateLimited = "domain_rate_limited"
+	ErrorOverloaded        = "overloaded"
+	ErrorQueueFull         = "queue_full"
+	ErrorShuttingDown      = "shutting_down"
+	ErrorNoWorker          = "no_worker"
+)
+
+// ErrorDetail is an error as reported to clients. Retryable tells whether
+// the same job may succeed when sent again later.
+type ErrorDetail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (d ErrorDetail) Error() string {
+	return d.Message
+}
+
+// ClassifyError describes err by its cause, upstream errors that match no
+// known cause are upstream_error
+func ClassifyError(err error) ErrorDetail {
+	detail := func(code string, retryable bool) ErrorDetail {
+		return ErrorDetail{Code: code, Message: err.Error(), Retryable: retryable}
+	}
+
+	// decoded from a worker, already classified
+	var decoded ErrorDetail
+	if errors.As(err, &decoded) {
+		return decoded
+	}
+	switch {
+	case errors.Is(err, ErrDraining):
+		return detail(ErrorShuttingDown, true)
+	case errors.Is(err, ErrNoWorker):
+		return detail(ErrorNoWorker, true)
+	case errors.Is(err, ErrOverloaded):
+		return detail(ErrorOverloaded, true)
+	case errors.Is(err, ErrQueueFull):
+		return detail(ErrorQueueFull, true)
+	}
+	var limit_err *DomainRateLimitError
+	if errors.As(err, &limit_err) {
+		return detail(ErrorDomainRateLimited, true)
+	}
+	var circuit_err *CircuitOpenError
+	if errors.As(err, &circuit_err) {
+		return detail(ErrorCircuitOpen, true)
+	}
+	var policy_err *PolicyError
+	if errors.As(err, &policy_err) {
+		return detail(policy_err.Code, false)
+	}
+	var job_err *JobError
+	if errors.As(err, &job_err) {
+		code, retryable := statusErrorCode(job_err.Status)
+		return detail(code, retryable)
+	}
+
+	// checked before the causes below, which it wraps
+	var proxy_err *ProxyDialError
+	if errors.As(err, &proxy_err) {
+		return detail(ErrorUpstreamProxy, true)
+	}
+	var dns_err *net.DNSError
+	if errors.As(err, &dns_err) {
+		return detail(ErrorDNSFailure, !dns_err.IsNotFound)
+	}
+	if isTLSError(err) {
+		return detail(ErrorTLS, false)
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return detail(ErrorConnectionRefused, true)
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, fasthttp.ErrConnectionClosed):
+		return detail(ErrorConnectionReset, true)
+	case errors.Is(err, context.DeadlineExceeded), isTimeout([]error{err}):
+		return detail(ErrorTimeout, true)
+	case errors.Is(err, fasthttp.ErrBodyTooLarge):
+		return detail(ErrorBodyTooLarge, false)
+	}
+	// the header block did not fit the read buffer, see headerReadBufferSize
+	var buffer_err *fasthttp.ErrSmallBuffer
+	if errors.As(err, &buffer_err) {
+		return detail(ErrorHeadersTooLarge, false)
+	}
+	return detail(ErrorUpstream, false)
+}
+
+func isTLSError(err error) bool {
+	var (
+		verify_err    *tls.CertificateVerificationError
+		record_err    tls.RecordHeaderError
+		alert_err     tls.AlertError
+		authority_err x509.UnknownAuthorityError
+		hostname_err  x509.HostnameError
+		invalid_err   x509.CertificateInvalidError
+	)
+	return errors.As(err, &verify_err) || errors.As(err, &record_err) || errors.As(err, &alert_err) ||
+		errors.As(err, &authority_err) || errors.As(err, &hostname_err) || errors.As(err, &invalid_err)
+}
+
+// statusErrorCode is the code of a *JobError answered with status
+func statusErrorCode(status int) (string, bool) {
+	switch status {
+	case fiber.StatusBadRequest:
+		return ErrorInvalidJob, false
+	case fiber.StatusUnauthorized:
+		return ErrorUnauthorized, false
+	case fiber.StatusForbidden:
+		return ErrorForbidden, false
+	case fiber.StatusNotFound:
+		return ErrorNotFound, false
+	case fiber.StatusRequestTimeout, fiber.StatusGatewayTimeout:
+		return ErrorTimeout, true
+	case fiber.StatusRequestEntityTooLarge:
+		return ErrorBodyTooLarge, false
+	case fiber.StatusTooManyRequests:
+		return ErrorRateLimited, true
+	case fiber.StatusBadGateway:
+		return ErrorUpstream, true
+	case fiber.StatusServiceUnavailable:
+		return ErrorUnavailable, true
+	}
+	return ErrorInternal, false
+}
+
+// ErrorDetails classifies every error, nil without errors
+func ErrorDetails(errs []error) []ErrorDetail {
+	if len(errs) == 0 {
+		return nil
+	}
+	details := make([]ErrorDetail, len(errs))
+	for i, err := range errs {
+		details[i] = ClassifyError(err)
+	}
+	return details
+}
+
+// failureStatus is the status a failed upstream request is answered with:
+// 504 when it timed out, 502 otherwise
+func failureStatus(details []ErrorDetail) int {
+	for _, detail := range details {
+		if detail.Code == ErrorTimeout {
+			return fiber.StatusGatewayTimeout
+		}
+	}
+	return fiber.StatusBadGateway
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/html"
+)
+
+// maxExtractors caps the fields a job may extract
+const maxExtractors = 64
+
+// Extractor pulls one field out of the response body. Exactly one of JSON, a
+// gjson path (https://github.com/tidwall/gjson/blob/master/SYNTAX.md), CSS
+// or XPath is set. CSS and XPath matches yield their text, or the value of
+// Attr when set, and only the first match unless All is set. XPath
+// expressions that evaluate to a number, string or boolean yield that value.
+type Extractor struct {
+	JSON  string `json:"json,omitempty"`
+	CSS   string `json:"css,omitempty"`
+	XPath string `json:"xpath,omitempty"`
+	Attr  string `json:"attr,omitempty"`
+	All   bool   `json:"all,omitempty"`
+
+	css   cascadia.SelectorGroup
+	xpath *xpath.Expr
+}
+
+// CompileExtractors returns a copy of the extractors with their selectors
+// compiled, naming the first invalid one in the error
+func CompileExtractors(extractors map[string]Extractor) (map[string]Extractor, error) {
+	if len(extractors) == 0 {
+		return nil, nil
+	}
+	compiled := make(map[string]Extractor, len(extractors))
+	for name, extractor := range extractors {
+		set := 0
+		for _, expression := range []string{extractor.JSON, extractor.CSS, extractor.XPath} {
+			if expression != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, fmt.Errorf("%q needs one of json, css or xpath", name)
+		}
+
+		var err error
+		switch {
+		case extractor.CSS != "":
+			extractor.css, err = cascadia.ParseGroup(extractor.CSS)
+		case extractor.XPath != "":
+			extractor.xpath, err = xpath.Compile(extractor.XPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", name, err)
+		}
+		compiled[name] = extractor
+	}
+	return compiled, nil
+}
+
+// Extract runs the extractors over body, fields without a match are nil.
+// The HTML is only parsed when a CSS or XPath extractor needs it.
+func Extract(extractors map[string]Extractor, body []byte) (map[string]any, error) {
+	var document *html.Node
+	parse := func() (*html.Node, error) {
+		if document == nil {
+			parsed, err := html.Parse(bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			document = parsed
+		}
+		return document, nil
+	}
+
+	extracted := make(map[string]any, len(extractors))
+	for name, extractor := range extractors {
+		if extractor.JSON != "" {
+			extracted[name] = extractJSON(extractor, body)
+			continue
+		}
+		document, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		if extractor.css != nil {
+			extracted[name] = extractCSS(extractor, document)
+		} else {
+			extracted[name] = extractXPath(extractor, document)
+		}
+	}
+	return extracted, nil
+}
+
+func extractJSON(extractor Extractor, body []byte) any {
+	if !gjson.ValidBytes(body) {
+		return nil
+	}
+	result := gjson.GetBytes(body, extractor.JSON)
+	if !result.Exists() {
+		return nil
+	}
+	return result.Value()
+}
+
+// nodeValue is the text of a matched node, or its attribute
+func nodeValue(extractor Extractor, node *html.Node) (string, bool) {
+	if extractor.Attr == "" {
+		return strings.TrimSpace(htmlquery.InnerText(node)), true
+	}
+	for _, attr := range node.Attr {
+		if attr.Key == extractor.Attr {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// matchValues is the first value, or every value with All. Matches without
+// the attribute asked for have no value.
+func matchValues(extractor Extractor, values []string) any {
+	if extractor.All {
+		return values
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+func extractCSS(extractor Extractor, document *html.Node) any {
+	nodes := cascadia.QueryAll(document, extractor.css)
+	if !extractor.All && len(nodes) > 1 {
+		nodes = nodes[:1]
+	}
+	values := []string{}
+	for _, node := range nodes {
+		if value, ok := nodeValue(extractor, node); ok {
+			values = append(values, value)
+		}
+	}
+	return matchValues(extractor, values)
+}
+
+func extractXPath(extractor Extractor, document *html.Node) any {
+	result := extractor.xpath.Evaluate(htmlquery.CreateXPathNavigator(document))
+	iterator, ok := result.(*xpath.NodeIterator)
+	if !ok {
+		// count(), string() and the like
+		return result
+	}
+	values := []string{}
+	for iterator.MoveNext() {
+		navigator := iterator.Current().(*htmlquery.NodeNavigator)
+		if navigator.NodeType() == xpath.AttributeNode {
+			// a selected attribute, such as //a/@href, yields its value
+			values = append(values, navigator.Value())
+		} else if value, ok := nodeValue(extractor, navigator.Current()); ok {
+			values = append(values, value)
+		}
+		if !extractor.All {
+			break
+		}
+	}
+	return matchValues(extractor, values)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const extractPage = `<html><body>
+<h1 class="title"> Widget </h1>
+<ul>
+	<li><a href="/a" class="item">First</a></li>
+	<li><a href="/b" class="item">Second</a></li>
+	<li><a class="item">No link</a></li>
+</ul>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	const json_body = `{"product": {"name": "Widget", "price": 9.5, "tags": ["a", "b"]}, "count": 2}`
+	tests := []struct {
+		name      string
+		extractor Extractor
+		body      string
+		want      any
+	}{
+		{"json string", Extractor{JSON: "product.name"}, json_body, "Widget"},
+		{"json number", Extractor{JSON: "product.price"}, json_body, 9.5},
+		{"json array", Extractor{JSON: "product.tags"}, json_body, []any{"a", "b"}},
+		{"json missing", Extractor{JSON: "product.color"}, json_body, nil},
+		{"json of an html body", Extractor{JSON: "product.name"}, extractPage, nil},
+		{"css text", Extractor{CSS: "h1.title"}, extractPage, "Widget"},
+		{"css first match", Extractor{CSS: "a.item"}, extractPage, "First"},
+		{"css every match", Extractor{CSS: "a.item", All: true}, extractPage, []string{"First", "Second", "No link"}},
+		{"css attribute", Extractor{CSS: "a.item", Attr: "href", All: true}, extractPage, []string{"/a", "/b"}},
+		{"css missing", Extractor{CSS: "table td"}, extractPage, nil},
+		{"css missing with all", Extractor{CSS: "table td", All: true}, extractPage, []string{}},
+		{"xpath text", Extractor{XPath: "//h1"}, extractPage, "Widget"},
+		{"xpath attribute", Extractor{XPath: "//a/@href", All: true}, extractPage, []string{"/a", "/b"}},
+		{"xpath count", Extractor{XPath: "count(//li)"}, extractPage, float64(3)},
+		{"xpath missing", Extractor{XPath: "//table"}, extractPage, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			extractors, err := CompileExtractors(map[string]Extractor{"field": test.extractor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			extracted, err := Extract(extractors, []byte(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := extracted["field"]
+			if !ok || !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("extracted %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileExtractors(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor Extractor
+		err       string
+	}{
+		{"css", Extractor{CSS: "a.item"}, ""},
+		{"invalid css", Extractor{CSS: "a[href"}, `"field"`},
+		{"invalid xpath", Extractor{XPath: "//a[@href"}, `"field"`},
+		{"no expression", Extractor{Attr: "href"}, "needs one of json, css or xpath"},
+		{"two expressions", Extractor{JSON: "name", CSS: "h1"}, "needs one of json, css or xpath"},
+	}
+	for _, test := range tests {
+		_, err := CompileExtractors(map[string]Extractor{"field": test.extractor})
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: got %v, want it compiled", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want an error with %s", test.name, err, test.err)
+		}
+	}
+}
+
+func TestJobExtract(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(extractPage))
+	}))
+	defer server.Close()
+
+	response, err := ExecuteJob(context.Background(), ProxyJob{
+		URL:         server.URL,
+		Method:      http.MethodGet,
+		Extract:     map[string]Extractor{"title": {CSS: "h1"}, "price": {CSS: ".price"}},
+		ExtractOnly: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Extracted["title"] != "Widget" || response.Extracted["price"] != nil || response.Body != nil {
+		t.Fatalf("extracted %v with body %q, want the title alone", response.Extracted, response.Body)
+	}
+
+	status, data := postJob(t, ProxyJob{URL: server.URL, Method: http.MethodGet, Extract: map[string]Extractor{"title": {CSS: "h1["}}})
+	var answer struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(data, &answer)
+	if status != http.StatusBadRequest || !strings.Contains(answer.Error, "title") {
+		t.Fatalf("invalid selector got %d %s, want 400 naming the field", status, data)
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"aslon1213/proxy_worker/api/proxierpb"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// GRPCServer exposes ExecuteJob and the async job queue over gRPC
+type GRPCServer struct {
+	proxierpb.UnimplementedProxierServer
+}
+
+func (s *GRPCServer) Perform(ctx context.Context, job *proxierpb.ProxyJob) (*proxierpb.ProxyResponse, error) {
+	response, err := ExecuteJob(ctx, jobFromProto(job))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return responseToProto(response), nil
+}
+
+func (s *GRPCServer) PerformBatch(stream grpc.BidiStreamingServer[proxierpb.ProxyJob, proxierpb.BatchResult]) error {
+	var (
+		wg        sync.WaitGroup
+		send_mu   sync.Mutex
+		send_err  error
+		semaphore = make(chan struct{}, batchConcurrency)
+	)
+
+	for index := int64(0); ; index++ {
+		job, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil && index >= int64(batchMaxJobs) {
+			err = status.Errorf(codes.InvalidArgument, "Too many jobs, at most %d", batchMaxJobs)
+		}
+		if err != nil {
+			wg.Wait()
+			return err
+		}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int64, job ProxyJob) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result := &proxierpb.BatchResult{Index: index}
+			err := ChargeJob(stream.Context())
+			response := ProxyResponse{}
+			if err == nil {
+				response, err = ExecuteJob(stream.Context(), job)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Response = responseToProto(response)
+			}
+
+			send_mu.Lock()
+			defer send_mu.Unlock()
+			if send_err == nil {
+				send_err = stream.Send(result)
+			}
+		}(index, jobFromProto(job))
+	}
+
+	wg.Wait()
+	return send_err
+}
+
+func (s *GRPCServer) SubmitJob(ctx context.Context, job *proxierpb.ProxyJob) (*proxierpb.JobStatus, error) {
+	status, err := QueueJob(ctx, jobFromProto(job))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return jobStatusToProto(status), nil
+}
+
+// SubmitBatch queues the jobs one by one, a job that could not be queued,
+// such as past a full queue or the limits of the API key, only has its error
+// in its slot
+func (s *GRPCServer) SubmitBatch(ctx context.Context, request *proxierpb.SubmitBatchRequest) (*proxierpb.SubmitBatchResponse, error) {
+	if len(request.GetJobs()) > batchMaxJobs {
+		return nil, status.Errorf(codes.InvalidArgument, "Too many jobs, at most %d", batchMaxJobs)
+	}
+
+	statuses := make([]*proxierpb.JobStatus, len(request.GetJobs()))
+	for i, job := range request.GetJobs() {
+		var queued AsyncJob
+		err := ChargeJob(ctx)
+		if err == nil {
+			queued, err = QueueJob(ctx, jobFromProto(job))
+		}
+		if err != nil {
+			statuses[i] = &proxierpb.JobStatus{Error: status.Convert(grpcError(err)).Message()}
+			continue
+		}
+		statuses[i] = jobStatusToProto(queued)
+	}
+	return &proxierpb.SubmitBatchResponse{Jobs: statuses}, nil
+}
+
+func (s *GRPCServer) GetJob(ctx context.Context, request *proxierpb.GetJobRequest) (*proxierpb.JobStatus, error) {
+	job, ok := LookupJob(ctx, request.GetId())
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Job not found or expired")
+	}
+	return jobStatusToProto(job), nil
+}
+
+func (s *GRPCServer) WatchJob(request *proxierpb.GetJobRequest, stream grpc.ServerStreamingServer[proxierpb.JobStatus]) error {
+	job, ok := LookupJob(stream.Context(), request.GetId())
+	if !ok {
+		return status.Error(codes.NotFound, "Job not found or expired")
+	}
+	if job.Finished() {
+		return stream.Send(jobStatusToProto(job))
+	}
+
+	var send_err error
+	err := asyncJobs.store.Watch(stream.Context(), job.ID, func(job AsyncJob) bool {
+		// a callback still being delivered doesn't hold the stream open
+		send_err = stream.Send(jobStatusToProto(job))
+		return send_err == nil && !job.Finished()
+	})
+	if send_err != nil {
+		return send_err
+	}
+	return err
+}
+
+func jobFromProto(job *proxierpb.ProxyJob) ProxyJob {
+	retry_on_status := make([]int, len(job.GetRetryOnStatus()))
+	for i, status := range job.GetRetryOnStatus() {
+		retry_on_status[i] = int(status)
+	}
+	query_params := make(map[string][]string, len(job.GetQueryParams()))
+	for key, values := range job.GetQueryParams() {
+		query_params[key] = values.GetValues()
+	}
+	return ProxyJob{
+		URL:                  job.GetUrl(),
+		Method:               job.GetMethod(),
+		Headers:              job.GetHeaders(),
+		Body:                 string(job.GetBody()),
+		Cookies:              job.GetCookies(),
+		Timeout:              int(job.GetTimeout()),
+		QueryParams:          query_params,
+		TimeoutMs:            int(job.GetTimeoutMs()),
+		BasicAuthUser:        job.GetBasicAuthUser(),
+		BasicAuthPass:        job.GetBasicAuthPass(),
+		AuthType:             job.GetAuthType(),
+		RetryIfBodyMatches:   job.GetRetryIfBodyMatches(),
+		KeepHopByHopHeaders:  job.GetKeepHopByHopHeaders(),
+		RawBytes:             job.GetRawBytes(),
+		CacheBody:            job.GetCacheBody(),
+		Script:               job.GetScript(),
+		CompressResponseBody: job.GetCompressResponseBody(),
+		ProxyURL:             job.GetProxyUrl(),
+		SNI:                  job.GetSni(),
+		InsecureSkipVerify:   job.GetInsecureSkipVerify(),
+		CACert:               job.GetCaCert(),
+		FollowRedirects:      job.GetFollowRedirects(),
+		MaxRedirects:         int(job.GetMaxRedirects()),
+		FollowMetaRefresh:    job.GetFollowMetaRefresh(),
+		DetectJSRedirect:     job.GetDetectJsRedirect(),
+		SigningScheme:        job.GetSigningScheme(),
+		MaxRetries:           int(job.GetMaxRetries()),
+		RetryOnStatus:        retry_on_status,
+		RetryNonIdempotent:   job.GetRetryNonIdempotent(),
+		Retry:                retryFromProto(job.GetRetry()),
+		CacheTTL:             int(job.GetCacheTtl()),
+		NoCache:              job.GetNoCache(),
+		SessionID:            job.GetSessionId(),
+		CallbackURL:          job.GetCallbackUrl(),
+		WorkerTags:           job.GetWorkerTags(),
+		Extract:              extractorsFromProto(job.GetExtract()),
+		ExtractOnly:          job.GetExtractOnly(),
+		Pagination:           paginationFromProto(job.GetPagination()),
+		RewriteRules:         rewriteRulesFromProto(job.GetRewriteRules()),
+		MaxBodyBytes:         int(job.GetMaxBodyBytes()),
+	}
+}
+
+func paginationFromProto(pagination *proxierpb.Pagination) *PaginationOptions {
+	if pagination == nil {
+		return nil
+	}
+	return &PaginationOptions{
+		Mode:     pagination.GetMode(),
+		NextPath: pagination.GetNextPath(),
+		MaxPages: int(pagination.GetMaxPages()),
+		DelayMs:  int(pagination.GetDelayMs()),
+	}
+}
+
+func rewriteRulesFromProto(rules []*proxierpb.RewriteRule) []RewriteRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	converted := make([]RewriteRule, len(rules))
+	for i, rule := range rules {
+		converted[i] = RewriteRule{
+			Host:     rule.GetHost(),
+			Path:     rule.GetPath(),
+			Request:  rewriteActionsFromProto(rule.GetRequest()),
+			Response: rewriteActionsFromProto(rule.GetResponse()),
+		}
+	}
+	return converted
+}
+
+func rewriteActionsFromProto(actions *proxierpb.RewriteActions) *RewriteActions {
+	if actions == nil {
+		return nil
+	}
+	var body []BodyRewrite
+	for _, rewrite := range actions.GetBody() {
+		body = append(body, BodyRewrite{Pattern: rewrite.GetPattern(), Replace: rewrite.GetReplace()})
+	}
+	return &RewriteActions{
+		RemoveHeaders: actions.GetRemoveHeaders(),
+		SetHeaders:    actions.GetSetHeaders(),
+		AddHeaders:    actions.GetAddHeaders(),
+		Body:          body,
+	}
+}
+
+func extractorsFromProto(extractors map[string]*proxierpb.Extractor) map[string]Extractor {
+	if len(extractors) == 0 {
+		return nil
+	}
+	converted := make(map[string]Extractor, len(extractors))
+	for name, extractor := range extractors {
+		converted[name] = Extractor{
+			JSON:  extractor.GetJson(),
+			CSS:   extractor.GetCss(),
+			XPath: extractor.GetXpath(),
+			Attr:  extractor.GetAttr(),
+			All:   extractor.GetAll(),
+		}
+	}
+	return converted
+}
+
+// extractedToProto converts the extracted values by way of their JSON, which
+// turns them into the types structpb takes
+func extractedToProto(extracted map[string]any) map[string]*structpb.Value {
+	if len(extracted) == 0 {
+		return nil
+	}
+	converted := make(map[string]*structpb.Value, len(extracted))
+	for name, value := range extracted {
+		data, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		var decoded any
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			continue
+		}
+		converted_value, err := structpb.NewValue(decoded)
+		if err != nil {
+			continue
+		}
+		converted[name] = converted_value
+	}
+	return converted
+}
+
+func retryFromProto(spec *proxierpb.RetrySpec) *RetrySpec {
+	if spec == nil {
+		return nil
+	}
+	retry_on_status := make([]int, len(spec.GetRetryOnStatus()))
+	for i, status := range spec.GetRetryOnStatus() {
+		retry_on_status[i] = int(status)
+	}
+	return &RetrySpec{
+		MaxAttempts:          int(spec.GetMaxAttempts()),
+		BackoffBaseMs:        int(spec.GetBackoffBaseMs()),
+		BackoffMaxMs:         int(spec.GetBackoffMaxMs()),
+		RetryOnStatus:        retry_on_status,
+		RetryOnNetworkErrors: spec.GetRetryOnNetworkErrors(),
+	}
+}
+
+func errorDetailsToProto(details []ErrorDetail) []*proxierpb.ErrorDetail {
+	if len(details) == 0 {
+		return nil
+	}
+	converted := make([]*proxierpb.ErrorDetail, len(details))
+	for i, detail := range details {
+		converted[i] = &proxierpb.ErrorDetail{Code: detail.Code, Message: detail.Message, Retryable: detail.Retryable}
+	}
+	return converted
+}
+
+// errorDetailMessages fills the string errors kept next to error_details
+func errorDetailMessages(details []ErrorDetail) []string {
+	if len(details) == 0 {
+		return nil
+	}
+	messages := make([]string, len(details))
+	for i, detail := range details {
+		messages[i] = detail.Message
+	}
+	return messages
+}
+
+func responseToProto(response ProxyResponse) *proxierpb.ProxyResponse {
+	headers := make(map[string]*proxierpb.HeaderValues, len(response.Headers))
+	for key, values := range response.Headers {
+		headers[key] = &proxierpb.HeaderValues{Values: values}
+	}
+	attempt_errors := make([]*proxierpb.AttemptError, len(response.AttemptErrors))
+	for i, attempt := range response.AttemptErrors {
+		attempt_errors[i] = &proxierpb.AttemptError{
+			Attempt:      int32(attempt.Attempt),
+			StatusCode:   int32(attempt.StatusCode),
+			Errors:       errorDetailMessages(attempt.Errors),
+			Proxy:        attempt.Proxy,
+			ErrorDetails: errorDetailsToProto(attempt.Errors),
+		}
+	}
+	// "string" and "base64" only describe the JSON envelope, bodies are raw bytes here
+	body_encoding := response.BodyEncoding
+	if body_encoding != BodyEncodingGzip {
+		body_encoding = ""
+	}
+	return &proxierpb.ProxyResponse{
+		StatusCode:       int32(response.StatusCode),
+		Body:             response.Body,
+		Headers:          headers,
+		Errs:             errorStrings(response.Errs),
+		ErrorDetails:     errorDetailsToProto(ErrorDetails(response.Errs)),
+		BodyId:           response.BodyID,
+		BodySize:         int64(response.BodySize),
+		BodyEncoding:     body_encoding,
+		FinalUrl:         response.FinalURL,
+		UpstreamProxy:    response.UpstreamProxy,
+		DurationMs:       response.DurationMs,
+		Attempts:         int32(response.Attempts),
+		AttemptErrors:    attempt_errors,
+		CacheStatus:      response.CacheStatus,
+		MetaRefreshes:    int32(response.MetaRefreshes),
+		Redirects:        int32(response.Redirects),
+		JsRedirect:       response.JSRedirect,
+		Extracted:        extractedToProto(response.Extracted),
+		Cookies:          cookiesToProto(response.Cookies),
+		Pages:            response.Pages,
+		PageCount:        int32(response.PageCount),
+		StartedAtMs:      startedAtMilli(response.StartedAt),
+		HeadersTruncated: response.HeadersTruncated,
+		ServedFrom:       response.ServedFrom,
+	}
+}
+
+func cookiesToProto(cookies []ResponseCookie) []*proxierpb.ResponseCookie {
+	if len(cookies) == 0 {
+		return nil
+	}
+	converted := make([]*proxierpb.ResponseCookie, len(cookies))
+	for i, cookie := range cookies {
+		converted[i] = &proxierpb.ResponseCookie{
+			Name:      cookie.Name,
+			Value:     cookie.Value,
+			Domain:    cookie.Domain,
+			Path:      cookie.Path,
+			ExpiresMs: unixMilli(cookie.Expires),
+			MaxAge:    int32(cookie.MaxAge),
+			Secure:    cookie.Secure,
+			HttpOnly:  cookie.HTTPOnly,
+			SameSite:  cookie.SameSite,
+		}
+	}
+	return converted
+}
+
+func startedAtMilli(started_at time.Time) int64 {
+	if started_at.IsZero() {
+		return 0
+	}
+	return started_at.UnixMilli()
+}
+
+func unixMilli(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func jobStatusToProto(job AsyncJob) *proxierpb.JobStatus {
+	status := &proxierpb.JobStatus{
+		Id:           job.ID,
+		State:        string(job.State),
+		CreatedAtMs:  job.CreatedAt.UnixMilli(),
+		StartedAtMs:  unixMilli(job.StartedAt),
+		FinishedAtMs: unixMilli(job.FinishedAt),
+		Error:        job.Error,
+		ErrorCode:    job.ErrorCode,
+		Errors:       errorDetailMessages(job.Errors),
+		ErrorDetails: errorDetailsToProto(job.Errors),
+	}
+	if job.Response != nil {
+		status.Response = responseToProto(*job.Response)
+	}
+	if job.Callback != nil {
+		attempts := make([]*proxierpb.CallbackAttempt, len(job.Callback.Attempts))
+		for i, attempt := range job.Callback.Attempts {
+			attempts[i] = &proxierpb.CallbackAttempt{
+				Attempt:    int32(attempt.Attempt),
+				TimeMs:     attempt.Time.UnixMilli(),
+				StatusCode: int32(attempt.StatusCode),
+				Error:      attempt.Error,
+				DurationMs: attempt.DurationMs,
+			}
+		}
+		status.Callback = &proxierpb.CallbackStatus{State: job.Callback.State, Attempts: attempts}
+	}
+	return status
+}
+
+// grpcError maps a JobError or PolicyError to the matching gRPC status, with
+// the code of its ErrorDetail as the reason of an ErrorInfo detail
+func grpcError(err error) error {
+	code, message := grpcCode(err)
+	detail := ClassifyError(err)
+	st, details_err := status.New(code, message).WithDetails(&errdetails.ErrorInfo{
+		Reason:   detail.Code,
+		Domain:   "proxier",
+		Metadata: map[string]string{"retryable": strconv.FormatBool(detail.Retryable)},
+	})
+	if details_err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
+
+func grpcCode(err error) (codes.Code, string) {
+	if errors.Is(err, ErrOverloaded) {
+		return codes.Unavailable, "Server is overloaded"
+	}
+	if errors.Is(err, ErrDraining) {
+		return codes.Unavailable, "Server is shutting down"
+	}
+	if errors.Is(err, ErrNoWorker) {
+		return codes.Unavailable, "No worker available for the job"
+	}
+	if errors.Is(err, ErrQueueFull) {
+		return codes.Unavailable, "Job queue is full"
+	}
+	var limit_err *DomainRateLimitError
+	if errors.As(err, &limit_err) {
+		return codes.ResourceExhausted, "Rate limit exceeded for " + limit_err.Domain
+	}
+	var circuit_err *CircuitOpenError
+	if errors.As(err, &circuit_err) {
+		return codes.Unavailable, circuit_err.Error()
+	}
+	var policy_err *PolicyError
+	if errors.As(err, &policy_err) {
+		return codes.PermissionDenied, policy_err.Message
+	}
+
+	var job_err *JobError
+	if !errors.As(err, &job_err) {
+		return codes.Internal, err.Error()
+	}
+
+	code := codes.Internal
+	switch job_err.Status {
+	case fiber.StatusBadRequest:
+		code = codes.InvalidArgument
+	case fiber.StatusRequestTimeout, fiber.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	case fiber.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case fiber.StatusForbidden:
+		code = codes.PermissionDenied
+	case fiber.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case fiber.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	}
+	return code, job_err.Message
+}
+
+// NewGRPCServer returns the gRPC API with the auth interceptors, over TLS
+// when the HTTP server has it
+func NewGRPCServer() *grpc.Server {
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(unaryAuthInterceptor),
+		grpc.StreamInterceptor(streamAuthInterceptor),
+	}
+	if serverTLS != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(serverTLS.Config("h2"))))
+	}
+	server := grpc.NewServer(options...)
+	proxierpb.RegisterProxierServer(server, &GRPCServer{})
+	return server
+}
+
+// ServeGRPC serves server on addr until it is stopped
+func ServeGRPC(server *grpc.Server, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Starting gRPC server on %s", addr)
+	return server.Serve(listener)
+}
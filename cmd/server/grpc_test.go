@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"aslon1213/proxy_worker/api/proxierpb"
+)
+
+func TestJobFromProto(t *testing.T) {
+	job := jobFromProto(&proxierpb.ProxyJob{
+		Url:          "https://example.com/items",
+		Method:       "GET",
+		MaxBodyBytes: 1 << 20,
+		Extract:      map[string]*proxierpb.Extractor{"title": {Css: "h1", All: true}, "id": {Json: "data.id"}},
+		ExtractOnly:  true,
+		Pagination:   &proxierpb.Pagination{Mode: "json_path", NextPath: "$.next", MaxPages: 3, DelayMs: 100},
+		RewriteRules: []*proxierpb.RewriteRule{{
+			Host:     "*.example.com",
+			Path:     "/api",
+			Request:  &proxierpb.RewriteActions{RemoveHeaders: []string{"Referer"}, SetHeaders: map[string]string{"Accept": "application/json"}},
+			Response: &proxierpb.RewriteActions{AddHeaders: map[string]string{"X-Proxied": "1"}, Body: []*proxierpb.BodyRewrite{{Pattern: "foo", Replace: "bar"}}},
+		}},
+	})
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"max_body_bytes", job.MaxBodyBytes, 1 << 20},
+		{"extract", job.Extract, map[string]Extractor{"title": {CSS: "h1", All: true}, "id": {JSON: "data.id"}}},
+		{"extract_only", job.ExtractOnly, true},
+		{"pagination", job.Pagination, &PaginationOptions{Mode: "json_path", NextPath: "$.next", MaxPages: 3, DelayMs: 100}},
+		{"rewrite_rules", job.RewriteRules, []RewriteRule{{
+			Host:     "*.example.com",
+			Path:     "/api",
+			Request:  &RewriteActions{RemoveHeaders: []string{"Referer"}, SetHeaders: map[string]string{"Accept": "application/json"}},
+			Response: &RewriteActions{AddHeaders: map[string]string{"X-Proxied": "1"}, Body: []BodyRewrite{{Pattern: "foo", Replace: "bar"}}},
+		}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !reflect.DeepEqual(test.got, test.want) {
+				t.Fatalf("got %#v, want %#v", test.got, test.want)
+			}
+		})
+	}
+
+	empty := jobFromProto(&proxierpb.ProxyJob{Url: "https://example.com/"})
+	if empty.Pagination != nil || empty.RewriteRules != nil || empty.Extract != nil {
+		t.Fatalf("job without options got %+v", empty)
+	}
+}
+
+func TestResponseToProto(t *testing.T) {
+	started_at := time.UnixMilli(1700000000000)
+	expires := time.UnixMilli(1800000000000)
+	response := responseToProto(ProxyResponse{
+		StatusCode: 200,
+		StartedAt:  started_at,
+		Extracted:  map[string]any{"titles": []string{"a", "b"}, "count": 2, "missing": nil},
+		Cookies:    []ResponseCookie{{Name: "id", Value: "1", Domain: "example.com", Path: "/", Expires: &expires, Secure: true, HTTPOnly: true, SameSite: "Lax"}},
+		Pages:      [][]byte{[]byte("first"), []byte("second")},
+		PageCount:  2,
+	})
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"started_at_ms", response.GetStartedAtMs(), started_at.UnixMilli()},
+		{"extracted list", response.GetExtracted()["titles"].AsInterface(), []any{"a", "b"}},
+		{"extracted number", response.GetExtracted()["count"].AsInterface(), float64(2)},
+		{"extracted missing value", response.GetExtracted()["missing"].AsInterface(), nil},
+		{"cookie name", response.GetCookies()[0].GetName(), "id"},
+		{"cookie expires_ms", response.GetCookies()[0].GetExpiresMs(), expires.UnixMilli()},
+		{"cookie flags", []bool{response.GetCookies()[0].GetSecure(), response.GetCookies()[0].GetHttpOnly()}, []bool{true, true}},
+		{"cookie same_site", response.GetCookies()[0].GetSameSite(), "Lax"},
+		{"pages", response.GetPages(), [][]byte{[]byte("first"), []byte("second")}},
+		{"page_count", response.GetPageCount(), int32(2)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !reflect.DeepEqual(test.got, test.want) {
+				t.Fatalf("got %#v, want %#v", test.got, test.want)
+			}
+		})
+	}
+
+	if got := responseToProto(ProxyResponse{}).GetStartedAtMs(); got != 0 {
+		t.Fatalf("started_at_ms of a response that never started = %d, want 0", got)
+	}
+}
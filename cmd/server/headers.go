@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultMaxResponseHeaders          = 256
+	defaultMaxResponseHeaderBytes      = 64 << 10
+	defaultMaxResponseHeaderValueBytes = 8 << 10
+
+	// OversizedHeaderTruncate and OversizedHeaderDrop are the actions of
+	// response_oversized_header
+	OversizedHeaderTruncate = "truncate"
+	OversizedHeaderDrop     = "drop"
+)
+
+var (
+	// maxResponseHeaders caps the captured response headers, set from
+	// response_max_headers
+	maxResponseHeaders = defaultMaxResponseHeaders
+	// maxResponseHeaderBytes caps the names and values of the captured
+	// response headers together, set from response_max_header_bytes
+	maxResponseHeaderBytes = defaultMaxResponseHeaderBytes
+	// maxResponseHeaderValueBytes caps a single response header value, set
+	// from response_max_header_value_bytes
+	maxResponseHeaderValueBytes = defaultMaxResponseHeaderValueBytes
+	// oversizedHeaderAction is what happens to a value over
+	// maxResponseHeaderValueBytes, set from response_oversized_header
+	oversizedHeaderAction = OversizedHeaderTruncate
+)
+
+// headerReadBufferSize is the read buffer of upstream connections, which
+// fasthttp needs to hold the whole header block. Blocks up to twice the cap
+// are truncated, larger ones fail the request.
+func headerReadBufferSize() int {
+	return 2 * maxResponseHeaderBytes
+}
+
+// AcquireUpstreamResponse returns a response to attach to an agent with
+// SetResponse, so the upstream headers can still be read after the request
+func AcquireUpstreamResponse() *fiber.Response {
+	return fiber.AcquireResponse()
+}
+
+// headerValue returns the first value of a captured header, matched case-insensitively
+func headerValue(headers map[string][]string, key string) string {
+	for name, values := range headers {
+		if strings.EqualFold(name, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// headerValues returns every value of the header, matched case insensitively
+func headerValues(headers map[string][]string, key string) []string {
+	for name, values := range headers {
+		if strings.EqualFold(name, key) {
+			return values
+		}
+	}
+	return nil
+}
+
+// CaptureHeaders copies the response headers, keeping repeated headers such as
+// Set-Cookie as separate values. Hop-by-hop headers are left out unless asked
+// for. Values over maxResponseHeaderValueBytes are cut or dropped as
+// oversizedHeaderAction has it, and headers past maxResponseHeaders or
+// maxResponseHeaderBytes are left out, either of which is reported as truncated.
+func CaptureHeaders(resp *fiber.Response, keep_hop_by_hop bool) (map[string][]string, bool) {
+	// don't report a made up Content-Type when the upstream sends none. This has
+	// to be set after the request, the client resets it before reading the response.
+	resp.Header.SetNoDefaultContentType(true)
+
+	var connection []string
+	resp.Header.VisitAll(func(key, value []byte) {
+		if strings.EqualFold(string(key), fiber.HeaderConnection) {
+			connection = append(connection, string(value))
+		}
+	})
+	hop_by_hop := HopByHopHeaders(strings.Join(connection, ","))
+
+	headers := make(map[string][]string)
+	count, size, full, truncated := 0, 0, false, false
+	resp.Header.VisitAll(func(key, value []byte) {
+		name := string(key)
+		if !keep_hop_by_hop && hop_by_hop[name] {
+			return
+		}
+		if len(value) > maxResponseHeaderValueBytes {
+			truncated = true
+			if oversizedHeaderAction == OversizedHeaderDrop {
+				return
+			}
+			value = value[:maxResponseHeaderValueBytes]
+		}
+		if full || count >= maxResponseHeaders || size+len(key)+len(value) > maxResponseHeaderBytes {
+			full, truncated = true, true
+			return
+		}
+		count++
+		size += len(key) + len(value)
+		headers[name] = append(headers[name], string(value))
+	})
+	return headers, truncated
+}
+
+// ResponseCookie is a cookie set by the upstream with Set-Cookie
+type ResponseCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Domain   string     `json:"domain,omitempty"`
+	Path     string     `json:"path,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	MaxAge   int        `json:"max_age,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+	HTTPOnly bool       `json:"http_only,omitempty"`
+	SameSite string     `json:"same_site,omitempty"`
+}
+
+// ParseSetCookies parses the captured Set-Cookie headers, skipping invalid ones
+func ParseSetCookies(headers map[string][]string) []ResponseCookie {
+	var set_cookie []string
+	for name, values := range headers {
+		if strings.EqualFold(name, fiber.HeaderSetCookie) {
+			set_cookie = append(set_cookie, values...)
+		}
+	}
+	if len(set_cookie) == 0 {
+		return nil
+	}
+
+	parsed := (&http.Response{Header: http.Header{fiber.HeaderSetCookie: set_cookie}}).Cookies()
+	cookies := make([]ResponseCookie, len(parsed))
+	for i, cookie := range parsed {
+		cookies[i] = ResponseCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			MaxAge:   cookie.MaxAge,
+			Secure:   cookie.Secure,
+			HTTPOnly: cookie.HttpOnly,
+		}
+		if !cookie.Expires.IsZero() {
+			expires := cookie.Expires
+			cookies[i].Expires = &expires
+		}
+		switch cookie.SameSite {
+		case http.SameSiteLaxMode:
+			cookies[i].SameSite = "Lax"
+		case http.SameSiteStrictMode:
+			cookies[i].SameSite = "Strict"
+		case http.SameSiteNoneMode:
+			cookies[i].SameSite = "None"
+		}
+	}
+	return cookies
+}
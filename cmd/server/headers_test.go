@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// headerServer answers with count X-Header-<n> headers of size bytes each
+func headerServer(t *testing.T, count int, size int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < count; i++ {
+			w.Header().Set(fmt.Sprintf("X-Header-%d", i), strings.Repeat("v", size))
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResponseHeaderLimits(t *testing.T) {
+	previous_count, previous_bytes := maxResponseHeaders, maxResponseHeaderBytes
+	defer func() { maxResponseHeaders, maxResponseHeaderBytes = previous_count, previous_bytes }()
+
+	tests := []struct {
+		name      string
+		max_count int
+		max_bytes int
+		headers   int
+		size      int
+		truncated bool
+		err_code  string
+	}{
+		{"within the limits", 256, 64 << 10, 20, 100, false, ""},
+		{"too many headers", 10, 64 << 10, 50, 10, true, ""},
+		{"too many header bytes", 256, 4 << 10, 20, 300, true, ""},
+		{"header block over the read buffer", 256, 4 << 10, 20, 1000, false, ErrorHeadersTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxResponseHeaders, maxResponseHeaderBytes = test.max_count, test.max_bytes
+			server := headerServer(t, test.headers, test.size)
+
+			response, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.err_code != "" {
+				// upstream failures are reported in the response
+				if len(response.Errs) == 0 || ClassifyError(response.Errs[0]).Code != test.err_code {
+					t.Fatalf("errs = %v, want a %s error", response.Errs, test.err_code)
+				}
+				return
+			}
+			if response.HeadersTruncated != test.truncated {
+				t.Fatalf("headers_truncated = %v, want %v", response.HeadersTruncated, test.truncated)
+			}
+
+			count, size := 0, 0
+			for name, values := range response.Headers {
+				for _, value := range values {
+					count++
+					size += len(name) + len(value)
+				}
+			}
+			if count > test.max_count || size > test.max_bytes {
+				t.Fatalf("captured %d headers of %d bytes, over %d and %d", count, size, test.max_count, test.max_bytes)
+			}
+			if !test.truncated && headerValue(response.Headers, "X-Header-0") == "" {
+				t.Fatalf("headers %v are missing X-Header-0", response.Headers)
+			}
+		})
+	}
+}
+
+func TestOversizedHeaderValue(t *testing.T) {
+	previous_bytes, previous_action := maxResponseHeaderValueBytes, oversizedHeaderAction
+	defer func() { maxResponseHeaderValueBytes, oversizedHeaderAction = previous_bytes, previous_action }()
+	maxResponseHeaderValueBytes = 8 << 10
+
+	// one enormous cookie, still within the read buffer
+	huge := "session=" + strings.Repeat("v", 100<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", huge)
+		w.Header().Add("Set-Cookie", "small=1")
+		w.Header().Set("X-Small", "kept")
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		action  string
+		cookies []string
+	}{
+		{OversizedHeaderTruncate, []string{huge[:8<<10], "small=1"}},
+		{OversizedHeaderDrop, []string{"small=1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.action, func(t *testing.T) {
+			oversizedHeaderAction = test.action
+			response, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) > 0 {
+				t.Fatal(response.Errs)
+			}
+			if !response.HeadersTruncated {
+				t.Fatal("response with an oversized header is not flagged headers_truncated")
+			}
+			cookies := headerValues(response.Headers, "Set-Cookie")
+			if len(cookies) != len(test.cookies) {
+				t.Fatalf("got %d Set-Cookie values, want %d", len(cookies), len(test.cookies))
+			}
+			for i, cookie := range cookies {
+				if cookie != test.cookies[i] {
+					t.Fatalf("Set-Cookie %d is %d bytes, want %d", i, len(cookie), len(test.cookies[i]))
+				}
+			}
+			if headerValue(response.Headers, "X-Small") != "kept" {
+				t.Fatalf("headers %v are missing X-Small", response.Headers)
+			}
+		})
+	}
+}
+
+func TestResponseHeadersReturned(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1; Path=/")
+		w.Header().Add("Set-Cookie", "b=2; HttpOnly")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"ok": true}`)
+	}))
+	defer server.Close()
+
+	status, data := postJob(t, ProxyJob{URL: server.URL, Method: http.MethodGet})
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, data)
+	}
+	var response struct {
+		Headers map[string][]string `json:"headers"`
+		Cookies []ResponseCookie    `json:"cookies"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"Set-Cookie", []string{"a=1; Path=/", "b=2; HttpOnly"}},
+		{"Content-Type", []string{"application/json; charset=utf-8"}},
+	}
+	for _, test := range tests {
+		if got := headerValues(response.Headers, test.header); strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s = %q, want %q", test.header, got, test.want)
+		}
+	}
+	if len(response.Cookies) != 2 || response.Cookies[0].Name != "a" || response.Cookies[1].Name != "b" || !response.Cookies[1].HTTPOnly {
+		t.Errorf("cookies = %+v, want a and an http only b", response.Cookies)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	_ "github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite"
+)
+
+// History drivers, set with PROXIER_HISTORY_DRIVER
+const (
+	HistorySQLite   = "sqlite"
+	HistoryPostgres = "postgres"
+)
+
+const (
+	// historyQueueSize is how many finished jobs may wait to be written,
+	// jobs are dropped from the history rather than slowed down past it
+	historyQueueSize = 1024
+
+	historyPruneInterval = 10 * time.Minute
+	historyTimeout       = 5 * time.Second
+
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// Job kinds of the history
+const (
+	JobKindSync  = "sync"
+	JobKindAsync = "async"
+)
+
+// HistoryJob is a finished job as kept in the history database, its response
+// body cut at history_max_body_bytes
+type HistoryJob struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	State      JobState  `json:"state"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMs int64     `json:"duration_ms"`
+
+	StatusCode    int           `json:"status_code,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	Errors        []ErrorDetail `json:"errors,omitempty"`
+	UpstreamProxy string        `json:"upstream_proxy,omitempty"`
+	Attempts      int           `json:"attempts,omitempty"`
+	ResponseSize  int           `json:"response_size"`
+
+	headers map[string][]string
+	body    []byte
+	owner   string
+}
+
+// HistoryQuery filters the jobs of an API key, zero fields match every job
+type HistoryQuery struct {
+	State JobState
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// History persists finished jobs in SQLite or Postgres and prunes them once
+// they are older than the retention
+type History struct {
+	db           *sql.DB
+	driver       string
+	retention    time.Duration
+	maxBodyBytes int
+	queue        chan HistoryJob
+	pending      atomic.Int64
+}
+
+// history is opened from history_driver and history_dsn, nil without a DSN
+var history *History
+
+const historySchema = `CREATE TABLE IF NOT EXISTS job_history (
+	id TEXT PRIMARY KEY,
+	key_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	state TEXT NOT NULL,
+	method TEXT NOT NULL,
+	url TEXT NOT NULL,
+	started_at BIGINT NOT NULL,
+	finished_at BIGINT NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	status_code INTEGER NOT NULL,
+	error TEXT NOT NULL,
+	errors TEXT NOT NULL,
+	upstream_proxy TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	response_size INTEGER NOT NULL,
+	response_headers TEXT NOT NULL,
+	response_body %s
+);
+CREATE INDEX IF NOT EXISTS job_history_key_finished ON job_history (key_id, finished_at);
+CREATE INDEX IF NOT EXISTS job_history_finished ON job_history (finished_at)`
+
+func OpenHistory(driver string, dsn string, retention time.Duration, max_body_bytes int) (*History, error) {
+	blob := "BLOB"
+	switch driver {
+	case HistorySQLite:
+	case HistoryPostgres:
+		blob = "BYTEA"
+	default:
+		return nil, fmt.Errorf("unknown history driver %q", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == HistorySQLite {
+		// SQLite allows a single writer, the others would fail with SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+	for _, statement := range strings.Split(fmt.Sprintf(historySchema, blob), ";\n") {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create the history table: %w", err)
+		}
+	}
+
+	h := &History{
+		db:           db,
+		driver:       driver,
+		retention:    retention,
+		maxBodyBytes: max_body_bytes,
+		queue:        make(chan HistoryJob, historyQueueSize),
+	}
+	go h.writer()
+	go h.janitor()
+	return h, nil
+}
+
+// rebind turns the ? placeholders of a query into $1, $2... for Postgres
+func (h *History) rebind(query string) string {
+	if h.driver != HistoryPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Record queues a job finished by ExecuteJob for writing, its credentials
+// and the cookies set by the upstream redacted
+func (h *History) Record(ctx context.Context, job ProxyJob, started time.Time, response ProxyResponse, err error) {
+	if h == nil {
+		return
+	}
+	job = RedactJob(job)
+	id, ok := AsyncJobIDFrom(ctx)
+	kind := JobKindAsync
+	if !ok {
+		kind = JobKindSync
+		var id_err error
+		if id, id_err = randomID(); id_err != nil {
+			return
+		}
+	}
+
+	finished := time.Now()
+	entry := HistoryJob{
+		ID:            id,
+		Kind:          kind,
+		State:         JobDone,
+		Method:        strings.ToUpper(strings.TrimSpace(job.Method)),
+		URL:           RedactURL(job.URL),
+		StartedAt:     started,
+		FinishedAt:    finished,
+		DurationMs:    finished.Sub(started).Milliseconds(),
+		StatusCode:    response.StatusCode,
+		UpstreamProxy: response.UpstreamProxy,
+		Attempts:      response.Attempts,
+		ResponseSize:  len(response.Body),
+		headers:       RedactResponseHeaders(response.Headers),
+		owner:         KeyIDFrom(ctx),
+	}
+	switch {
+	case err != nil:
+		entry.State = JobFailed
+		entry.Error = err.Error()
+	case len(response.Errs) > 0:
+		entry.State = JobFailed
+		entry.Errors = ErrorDetails(response.Errs)
+	}
+	if response.BodyID != "" {
+		entry.ResponseSize = response.BodySize
+	}
+	body := response.Body
+	if len(body) > h.maxBodyBytes {
+		body = body[:h.maxBodyBytes]
+	}
+	entry.body = append([]byte(nil), body...)
+
+	h.pending.Add(1)
+	select {
+	case h.queue <- entry:
+	default:
+		h.pending.Add(-1)
+		log.Warn().Str("job_id", id).Msg("History queue is full, job not recorded")
+	}
+}
+
+func (h *History) writer() {
+	for entry := range h.queue {
+		if err := h.insert(entry); err != nil {
+			log.Error().Err(err).Str("job_id", entry.ID).Msg("Failed to record job history")
+		}
+		h.pending.Add(-1)
+	}
+}
+
+// Flush waits until the queued jobs are written or ctx is done
+func (h *History) Flush(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for h.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (h *History) insert(entry HistoryJob) error {
+	errs, err := json.Marshal(entry.Errors)
+	if err != nil {
+		return err
+	}
+	headers, err := json.Marshal(entry.headers)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+	_, err = h.db.ExecContext(ctx, h.rebind(`INSERT INTO job_history (
+		id, key_id, kind, state, method, url, started_at, finished_at, duration_ms,
+		status_code, error, errors, upstream_proxy, attempts, response_size,
+		response_headers, response_body
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		entry.ID, entry.owner, entry.Kind, string(entry.State), entry.Method, entry.URL,
+		entry.StartedAt.UnixMilli(), entry.FinishedAt.UnixMilli(), entry.DurationMs,
+		entry.StatusCode, entry.Error, string(errs), entry.UpstreamProxy, entry.Attempts, entry.ResponseSize,
+		string(headers), entry.body,
+	)
+	return err
+}
+
+const historyColumns = `id, key_id, kind, state, method, url, started_at, finished_at, duration_ms,
+	status_code, error, errors, upstream_proxy, attempts, response_size, response_headers, response_body`
+
+func scanHistoryJob(scanner interface{ Scan(...any) error }) (HistoryJob, error) {
+	var (
+		entry       HistoryJob
+		state       string
+		started_at  int64
+		finished_at int64
+		errs        string
+		headers     string
+	)
+	err := scanner.Scan(
+		&entry.ID, &entry.owner, &entry.Kind, &state, &entry.Method, &entry.URL,
+		&started_at, &finished_at, &entry.DurationMs,
+		&entry.StatusCode, &entry.Error, &errs, &entry.UpstreamProxy, &entry.Attempts, &entry.ResponseSize,
+		&headers, &entry.body,
+	)
+	if err != nil {
+		return HistoryJob{}, err
+	}
+	entry.State = JobState(state)
+	entry.StartedAt = time.UnixMilli(started_at).UTC()
+	entry.FinishedAt = time.UnixMilli(finished_at).UTC()
+	entry.Errors = decodeHistoryErrors(errs)
+	json.Unmarshal([]byte(headers), &entry.headers)
+	return entry, nil
+}
+
+// decodeHistoryErrors reads the errors column, rows recorded before errors
+// had a code hold their messages only
+func decodeHistoryErrors(column string) []ErrorDetail {
+	var details []ErrorDetail
+	if json.Unmarshal([]byte(column), &details) == nil {
+		return details
+	}
+	var messages []string
+	json.Unmarshal([]byte(column), &messages)
+	details = nil
+	for _, message := range messages {
+		details = append(details, ErrorDetail{Code: ErrorUpstream, Message: message})
+	}
+	return details
+}
+
+// List returns the jobs of owner matching the query, newest first
+func (h *History) List(ctx context.Context, owner string, query HistoryQuery) ([]HistoryJob, error) {
+	statement := "SELECT " + historyColumns + " FROM job_history WHERE key_id = ?"
+	args := []any{owner}
+	if query.State != "" {
+		statement += " AND state = ?"
+		args = append(args, string(query.State))
+	}
+	if !query.Since.IsZero() {
+		statement += " AND finished_at >= ?"
+		args = append(args, query.Since.UnixMilli())
+	}
+	if !query.Until.IsZero() {
+		statement += " AND finished_at < ?"
+		args = append(args, query.Until.UnixMilli())
+	}
+	statement += " ORDER BY finished_at DESC LIMIT ?"
+	args = append(args, query.Limit)
+
+	rows, err := h.db.QueryContext(ctx, h.rebind(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []HistoryJob{}
+	for rows.Next() {
+		entry, err := scanHistoryJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, entry)
+	}
+	return jobs, rows.Err()
+}
+
+// Get returns the job of owner with that ID
+func (h *History) Get(ctx context.Context, owner string, id string) (HistoryJob, bool, error) {
+	row := h.db.QueryRowContext(ctx, h.rebind("SELECT "+historyColumns+" FROM job_history WHERE id = ? AND key_id = ?"), id, owner)
+	entry, err := scanHistoryJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return HistoryJob{}, false, nil
+	}
+	if err != nil {
+		return HistoryJob{}, false, err
+	}
+	return entry, true, nil
+}
+
+// Prune deletes the jobs that finished before the retention
+func (h *History) Prune(now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+	result, err := h.db.ExecContext(ctx, h.rebind("DELETE FROM job_history WHERE finished_at < ?"), now.Add(-h.retention).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (h *History) janitor() {
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		pruned, err := h.Prune(now)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to prune job history")
+			continue
+		}
+		if pruned > 0 {
+			log.Debug().Int64("jobs", pruned).Msg("Pruned job history")
+		}
+	}
+}
+
+// asyncJob returns the history entry in the shape of GET /jobs/:id, with
+// the truncated body as its response
+func (e HistoryJob) asyncJob() AsyncJob {
+	started_at, finished_at := e.StartedAt, e.FinishedAt
+	job := AsyncJob{
+		ID:         e.ID,
+		State:      e.State,
+		CreatedAt:  e.StartedAt,
+		StartedAt:  &started_at,
+		FinishedAt: &finished_at,
+		Error:      e.Error,
+		Errors:     e.Errors,
+		owner:      e.owner,
+	}
+	if e.State == JobDone {
+		job.Response = &ProxyResponse{
+			StatusCode:    e.StatusCode,
+			Body:          e.body,
+			Headers:       e.headers,
+			StartedAt:     e.StartedAt,
+			DurationMs:    e.DurationMs,
+			UpstreamProxy: e.UpstreamProxy,
+			Attempts:      e.Attempts,
+			BodyEncoding:  BodyEncodingBase64,
+		}
+	}
+	return job
+}
+
+// parseHistoryTime reads an RFC 3339 time, or a duration back from now
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// ListJobs queries the job history of the API key
+// @Description Returns the finished jobs of the API key from the history database, newest first
+// @Param status query string false "done or failed"
+// @Param since query string false "RFC 3339 time, or a duration back from now such as 24h"
+// @Param until query string false "RFC 3339 time, or a duration back from now"
+// @Param limit query int false "Number of jobs to return, 100 by default and at most 1000"
+func ListJobs(c *fiber.Ctx) error {
+	if history == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job history is disabled",
+		})
+	}
+
+	now := time.Now()
+	query := HistoryQuery{State: JobState(c.Query("status")), Limit: defaultHistoryLimit}
+	switch query.State {
+	case "", JobDone, JobFailed:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid status, use done or failed",
+		})
+	}
+	var err error
+	if query.Since, err = parseHistoryTime(c.Query("since"), now); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid since",
+		})
+	}
+	if query.Until, err = parseHistoryTime(c.Query("until"), now); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid until",
+		})
+	}
+	if raw := c.Query("limit"); raw != "" {
+		if query.Limit, err = strconv.Atoi(raw); err != nil || query.Limit <= 0 || query.Limit > maxHistoryLimit {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid limit",
+			})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), historyTimeout)
+	defer cancel()
+	jobs, err := history.List(ctx, KeyIDFrom(ctx), query)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query job history")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to query job history",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"jobs": jobs,
+	})
+}
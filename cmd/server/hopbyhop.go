@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/textproto"
+	"strings"
+)
+
+// hopByHopHeaders are only meaningful for a single connection and must not
+// be forwarded end-to-end (RFC 7230 section 6.1)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HopByHopHeaders returns the canonical names of the hop-by-hop headers,
+// including any extra header listed in the given Connection header value
+func HopByHopHeaders(connection string) map[string]bool {
+	names := make(map[string]bool, len(hopByHopHeaders))
+	for _, name := range hopByHopHeaders {
+		names[name] = true
+	}
+	for _, name := range strings.Split(connection, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[textproto.CanonicalMIMEHeaderKey(name)] = true
+		}
+	}
+	return names
+}
+
+// FilterHopByHop returns a copy of headers without the hop-by-hop headers
+func FilterHopByHop(headers map[string]string) map[string]string {
+	var connection string
+	for key, value := range headers {
+		if strings.EqualFold(key, "Connection") {
+			connection = value
+		}
+	}
+
+	hop_by_hop := HopByHopHeaders(connection)
+	filtered := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if !hop_by_hop[textproto.CanonicalMIMEHeaderKey(key)] {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFilterHopByHop(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []string
+	}{
+		{"end-to-end kept", map[string]string{"Accept": "*/*", "X-Trace": "1"}, []string{"Accept", "X-Trace"}},
+		{"standard hop-by-hop", map[string]string{"Keep-Alive": "timeout=5", "Proxy-Authorization": "Basic x", "te": "trailers", "Trailer": "X", "Transfer-Encoding": "chunked", "upgrade": "websocket", "Accept": "*/*"}, []string{"Accept"}},
+		{"listed in connection", map[string]string{"Connection": "close, x-private", "X-Private": "1", "X-Public": "1"}, []string{"X-Public"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for key := range FilterHopByHop(test.headers) {
+				got = append(got, key)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Fatalf("kept %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHopByHopStripped(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		w.Header().Set("X-End-To-End", "1")
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	request_hop := []string{"Proxy-Authorization", "X-Client-Hop", "Keep-Alive", "Upgrade"}
+	response_hop := []string{"X-Upstream-Hop", "Keep-Alive", "Proxy-Authenticate"}
+	tests := []struct {
+		name string
+		keep bool
+	}{
+		{"stripped by default", false},
+		{"kept on request", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := ExecuteJob(context.Background(), ProxyJob{
+				URL:    server.URL,
+				Method: http.MethodGet,
+				Headers: map[string]string{
+					"Connection":          "X-Client-Hop",
+					"X-Client-Hop":        "1",
+					"Keep-Alive":          "timeout=5",
+					"Proxy-Authorization": "Basic cHJveHk6c2VjcmV0",
+					"Upgrade":             "h2c",
+					"X-End-To-End":        "1",
+				},
+				KeepHopByHopHeaders: test.keep,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) > 0 {
+				t.Fatal(response.Errs)
+			}
+
+			sent := <-received
+			for _, name := range request_hop {
+				if (sent.Get(name) != "") != test.keep {
+					t.Errorf("upstream got %s %q, want it forwarded: %v", name, sent.Get(name), test.keep)
+				}
+			}
+			if sent.Get("X-End-To-End") == "" {
+				t.Error("upstream is missing X-End-To-End")
+			}
+			for _, name := range response_hop {
+				if (headerValue(response.Headers, name) != "") != test.keep {
+					t.Errorf("response has %s %q, want it returned: %v", name, headerValue(response.Headers, name), test.keep)
+				}
+			}
+			if headerValue(response.Headers, "X-End-To-End") == "" {
+				t.Error("response is missing X-End-To-End")
+			}
+		})
+	}
+}
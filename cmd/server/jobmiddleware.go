@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// JobMiddleware prepares a job before it is performed.
+//
+// Middlewares run in the order they are configured in PROXIER_JOB_MIDDLEWARES,
+// each one receiving the job returned by the previous one. Returning an error
+// short-circuits the chain: the remaining middlewares are skipped and the job is
+// rejected, with the status of the error if it is a *JobError, 403 for a
+// *PolicyError and 400 otherwise.
+type JobMiddleware interface {
+	Name() string
+	Process(job ProxyJob) (ProxyJob, error)
+}
+
+// jobMiddlewares is the configured chain, empty by default
+var jobMiddlewares []JobMiddleware
+
+// RunJobMiddlewares passes the job through the configured chain
+func RunJobMiddlewares(job ProxyJob) (ProxyJob, error) {
+	for _, middleware := range jobMiddlewares {
+		processed, err := middleware.Process(job)
+		if err != nil {
+			switch err.(type) {
+			case *JobError, *PolicyError:
+				return job, err
+			}
+			return job, &JobError{fiber.StatusBadRequest, fmt.Sprintf("%s: %s", middleware.Name(), err)}
+		}
+		job = processed
+	}
+	return job, nil
+}
+
+// LoadJobMiddlewares builds the chain from a comma separated list of built-in
+// middleware names: default_headers, normalize_url, timeout_clamp and ssrf_check
+func LoadJobMiddlewares(names string) ([]JobMiddleware, error) {
+	var chain []JobMiddleware
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+			continue
+		case "default_headers":
+			headers := map[string]string{}
+			if raw := os.Getenv("PROXIER_DEFAULT_HEADERS"); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+					return nil, fmt.Errorf("invalid PROXIER_DEFAULT_HEADERS: %w", err)
+				}
+			}
+			chain = append(chain, DefaultHeadersMiddleware{Headers: headers})
+		case "normalize_url":
+			chain = append(chain, NormalizeURLMiddleware{})
+		case "timeout_clamp":
+			chain = append(chain, TimeoutClampMiddleware{Max: maxTimeout})
+		case "ssrf_check":
+			chain = append(chain, SSRFCheckMiddleware{})
+		default:
+			return nil, fmt.Errorf("unknown job middleware %q", name)
+		}
+	}
+	return chain, nil
+}
+
+// DefaultHeadersMiddleware adds headers the job does not set itself,
+// e.g. a tracking header taken from PROXIER_DEFAULT_HEADERS
+type DefaultHeadersMiddleware struct {
+	Headers map[string]string
+}
+
+func (m DefaultHeadersMiddleware) Name() string { return "default_headers" }
+
+func (m DefaultHeadersMiddleware) Process(job ProxyJob) (ProxyJob, error) {
+	headers := make(map[string]string, len(job.Headers)+len(m.Headers))
+	for key, value := range job.Headers {
+		headers[key] = value
+	}
+	for key, value := range m.Headers {
+		found := false
+		for existing := range job.Headers {
+			if strings.EqualFold(existing, key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			headers[key] = value
+		}
+	}
+	job.Headers = headers
+	return job, nil
+}
+
+// NormalizeURLMiddleware lowercases the scheme and host, drops default ports and
+// fragments and makes sure the URL has a path
+type NormalizeURLMiddleware struct{}
+
+func (m NormalizeURLMiddleware) Name() string { return "normalize_url" }
+
+func (m NormalizeURLMiddleware) Process(job ProxyJob) (ProxyJob, error) {
+	parsed, err := url.Parse(strings.TrimSpace(job.URL))
+	if err != nil {
+		return job, err
+	}
+	if parsed.Host == "" {
+		return job, fmt.Errorf("url %q has no host", job.URL)
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	parsed.Host = host
+	parsed.Fragment = ""
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+
+	job.URL = parsed.String()
+	job.Method = strings.ToUpper(job.Method)
+	return job, nil
+}
+
+// TimeoutClampMiddleware caps the job timeout instead of rejecting long ones
+type TimeoutClampMiddleware struct {
+	Max time.Duration
+}
+
+func (m TimeoutClampMiddleware) Name() string { return "timeout_clamp" }
+
+func (m TimeoutClampMiddleware) Process(job ProxyJob) (ProxyJob, error) {
+	if max_seconds := int(m.Max / time.Second); job.Timeout > max_seconds {
+		job.Timeout = max_seconds
+	}
+	if max_ms := int(m.Max / time.Millisecond); job.TimeoutMs > max_ms {
+		job.TimeoutMs = max_ms
+	}
+	return job, nil
+}
+
+// SSRFCheckMiddleware resolves the target and proxy of the job and rejects
+// it when an address is not allowed by the target policy. The dialer checks
+// every connection anyway, this rejects jobs before they are queued or sent
+// to a worker. Placed after normalize_url it checks the normalized URL.
+type SSRFCheckMiddleware struct {
+	// Policy is the server wide target policy when nil
+	Policy *TargetPolicy
+}
+
+func (m SSRFCheckMiddleware) Name() string { return "ssrf_check" }
+
+func (m SSRFCheckMiddleware) Process(job ProxyJob) (ProxyJob, error) {
+	policy := m.Policy
+	if policy == nil {
+		policy = targetPolicy
+	}
+	if err := policy.CheckURL(job.URL); err != nil {
+		return job, err
+	}
+	parsed, err := url.Parse(job.URL)
+	if err != nil {
+		return job, err
+	}
+	if _, err := policy.Resolve(parsed.Hostname()); err != nil {
+		return job, err
+	}
+	if job.ProxyURL != "" {
+		if err := policy.CheckProxy(job.ProxyURL); err != nil {
+			return job, err
+		}
+	}
+	return job, nil
+}
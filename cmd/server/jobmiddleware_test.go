@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSSRFCheckMiddleware(t *testing.T) {
+	policy := &TargetPolicy{AllowSchemes: []string{"http", "https"}, DenyHosts: []string{"*.internal.example.com"}}
+	tests := []struct {
+		name      string
+		url       string
+		proxy_url string
+		code      string
+	}{
+		{"public address", "http://93.184.216.34/", "", ""},
+		{"loopback address", "http://127.0.0.1:8080/", "", PolicyPrivateAddress},
+		{"private address", "https://10.1.2.3/", "", PolicyPrivateAddress},
+		{"link local metadata address", "http://169.254.169.254/latest/meta-data/", "", PolicyPrivateAddress},
+		{"host resolving to loopback", "http://localhost/", "", PolicyPrivateAddress},
+		{"denied host", "http://db.internal.example.com/", "", PolicyTargetDenied},
+		{"scheme not allowed", "file:///etc/passwd", "", PolicySchemeNotAllowed},
+		{"private proxy", "http://93.184.216.34/", "http://192.168.1.1:3128", PolicyPrivateAddress},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := SSRFCheckMiddleware{Policy: policy}.Process(ProxyJob{URL: test.url, ProxyURL: test.proxy_url})
+			if test.code == "" {
+				if err != nil {
+					t.Fatalf("got %v, want the job allowed", err)
+				}
+				return
+			}
+			var policy_err *PolicyError
+			if !errors.As(err, &policy_err) || policy_err.Code != test.code {
+				t.Fatalf("got %v, want a %s policy error", err, test.code)
+			}
+		})
+	}
+}
+
+func TestRunJobMiddlewares(t *testing.T) {
+	previous := jobMiddlewares
+	defer func() { jobMiddlewares = previous }()
+	previous_policy := targetPolicy
+	defer func() { targetPolicy = previous_policy }()
+	targetPolicy = &TargetPolicy{AllowSchemes: []string{"http", "https"}}
+
+	tests := []struct {
+		name        string
+		middlewares string
+		job         ProxyJob
+		url         string
+		timeout     int
+		header      string
+		err_code    string
+	}{
+		{"normalized", "normalize_url", ProxyJob{URL: "HTTP://Example.COM:80", Method: "get"}, "http://example.com/", 0, "", ""},
+		{"timeout clamped", "timeout_clamp", ProxyJob{URL: "http://example.com/", Timeout: 100000}, "http://example.com/", int(maxTimeout / time.Second), "", ""},
+		{"default header kept by the job", "default_headers", ProxyJob{URL: "http://example.com/", Headers: map[string]string{"x-trace": "job"}}, "http://example.com/", 0, "job", ""},
+		{"ssrf checked after normalizing", "normalize_url,ssrf_check", ProxyJob{URL: "HTTP://127.0.0.1"}, "", 0, "", PolicyPrivateAddress},
+		{"ssrf short-circuits the chain", "ssrf_check,normalize_url", ProxyJob{URL: "http://10.0.0.1"}, "", 0, "", PolicyPrivateAddress},
+		{"invalid url", "normalize_url,ssrf_check", ProxyJob{URL: "no host"}, "", 0, "", ErrorInvalidJob},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PROXIER_DEFAULT_HEADERS", `{"X-Trace": "server"}`)
+			chain, err := LoadJobMiddlewares(test.middlewares)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jobMiddlewares = chain
+
+			job, err := RunJobMiddlewares(test.job)
+			if test.err_code != "" {
+				if err == nil {
+					t.Fatalf("got job %+v, want a %s error", job, test.err_code)
+				}
+				if code := ClassifyError(err).Code; code != test.err_code {
+					t.Fatalf("got %v, want %s", err, test.err_code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.URL != test.url {
+				t.Fatalf("url = %q, want %q", job.URL, test.url)
+			}
+			if test.timeout != 0 && job.Timeout != test.timeout {
+				t.Fatalf("timeout = %d, want %d", job.Timeout, test.timeout)
+			}
+			if test.header != "" && job.Headers["x-trace"] != test.header {
+				t.Fatalf("headers = %v, want x-trace %q", job.Headers, test.header)
+			}
+		})
+	}
+
+	if _, err := LoadJobMiddlewares("normalize_url,unknown"); err == nil {
+		t.Fatal("unknown middleware loaded")
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// asyncJobTTL is how long a finished job can still be fetched
+const asyncJobTTL = 10 * time.Minute
+
+// JobState is the lifecycle state of an async job
+type JobState string
+
+const (
+	JobPending JobState = "pending"
+	JobRunning JobState = "running"
+	JobDone    JobState = "done"
+	JobFailed  JobState = "failed"
+)
+
+// ErrQueueFull is returned when an async job is submitted while every worker
+// is busy and the queue has no room left
+var ErrQueueFull = errors.New("job queue is full")
+
+// AsyncJob is the status of a job submitted with async=true. Errors holds the
+// upstream errors of a failed job, Error and ErrorCode why the job could not
+// be performed.
+type AsyncJob struct {
+	ID         string         `json:"id"`
+	State      JobState       `json:"state"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Response   *ProxyResponse `json:"response,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	ErrorCode  string         `json:"error_code,omitempty"`
+	Errors     []ErrorDetail  `json:"errors,omitempty"`
+
+	// Callback is the delivery to the callback_url of the job, if it has one
+	Callback *CallbackStatus `json:"callback,omitempty"`
+
+	// owner is the API key ID the job was submitted with, only it may fetch the job
+	owner string
+	// changed is closed and replaced on every change of the job
+	changed chan struct{}
+}
+
+// Finished tells whether the job is done or failed
+func (j AsyncJob) Finished() bool {
+	return j.State == JobDone || j.State == JobFailed
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// JobStore keeps the state of async jobs, finished ones expire after the TTL
+type JobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*AsyncJob
+	ttl  time.Duration
+}
+
+func NewJobStore(ttl time.Duration) *JobStore {
+	store := &JobStore{
+		jobs: make(map[string]*AsyncJob),
+		ttl:  ttl,
+	}
+	go store.janitor()
+	return store
+}
+
+func (s *JobStore) create(owner string, callback bool) (AsyncJob, error) {
+	id, err := randomID()
+	if err != nil {
+		return AsyncJob{}, err
+	}
+	job := &AsyncJob{ID: id, State: JobPending, CreatedAt: time.Now(), owner: owner, changed: make(chan struct{})}
+	if callback {
+		job.Callback = &CallbackStatus{State: CallbackPending}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[id] = job
+	return *job, nil
+}
+
+// notify wakes the watchers of the job, the mutex must be held
+func (s *JobStore) notify(job *AsyncJob) {
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+func (s *JobStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+}
+
+func (s *JobStore) start(id string) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		job.State = JobRunning
+		job.StartedAt = &now
+		s.notify(job)
+	}
+}
+
+func (s *JobStore) finish(id string, response ProxyResponse, err error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	job.FinishedAt = &now
+	switch {
+	case err != nil:
+		job.State = JobFailed
+		job.Error = err.Error()
+		job.ErrorCode = ClassifyError(err).Code
+	case len(response.Errs) > 0:
+		job.State = JobFailed
+		job.Errors = ErrorDetails(response.Errs)
+	default:
+		job.State = JobDone
+		job.Response = &response
+	}
+	s.notify(job)
+}
+
+func (s *JobStore) callbackAttempt(id string, attempt CallbackAttempt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok && job.Callback != nil {
+		job.Callback.Attempts = append(job.Callback.Attempts, attempt)
+		s.notify(job)
+	}
+}
+
+func (s *JobStore) callbackDone(id string, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok && job.Callback != nil {
+		job.Callback.State = state
+		s.notify(job)
+	}
+}
+
+// Get returns a copy of the job status
+func (s *JobStore) Get(id string) (AsyncJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return AsyncJob{}, false
+	}
+	status := *job
+	if job.Callback != nil {
+		// deliveries keep appending to the attempts
+		callback := *job.Callback
+		callback.Attempts = append([]CallbackAttempt(nil), job.Callback.Attempts...)
+		status.Callback = &callback
+	}
+	return status, true
+}
+
+// Watch calls fn with the status of the job now and after every change,
+// until fn returns false, the job is no longer stored or ctx is done
+func (s *JobStore) Watch(ctx context.Context, id string, fn func(AsyncJob) bool) error {
+	for {
+		status, ok := s.Get(id)
+		if !ok || !fn(status) {
+			return nil
+		}
+		select {
+		case <-status.changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *JobStore) janitor() {
+	ticker := time.NewTicker(s.ttl / 2)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.mu.Lock()
+		for id, job := range s.jobs {
+			if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > s.ttl {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+type asyncJobContextKey struct{}
+
+// withAsyncJob marks the context of an async job with its ID
+func withAsyncJob(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, asyncJobContextKey{}, id)
+}
+
+// AsyncJobIDFrom returns the ID of the async job of ctx, if it is one
+func AsyncJobIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(asyncJobContextKey{}).(string)
+	return id, ok
+}
+
+type queuedJob struct {
+	id     string
+	key_id string
+	job    ProxyJob
+}
+
+// JobQueue performs async jobs on a fixed pool of workers
+type JobQueue struct {
+	store *JobStore
+	queue chan queuedJob
+	// unfinished counts the queued and running jobs
+	unfinished atomic.Int64
+}
+
+func NewJobQueue(store *JobStore, workers int, size int) *JobQueue {
+	q := &JobQueue{
+		store: store,
+		queue: make(chan queuedJob, size),
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// asyncJobs is started in main with the async_workers and async_queue_size settings
+var asyncJobs *JobQueue
+
+// Submit queues the job and returns its pending status right away
+func (q *JobQueue) Submit(key_id string, job ProxyJob) (AsyncJob, error) {
+	status, err := q.store.create(key_id, job.CallbackURL != "")
+	if err != nil {
+		return AsyncJob{}, err
+	}
+	q.unfinished.Add(1)
+	select {
+	case q.queue <- queuedJob{id: status.ID, key_id: key_id, job: job}:
+		return status, nil
+	default:
+		q.unfinished.Add(-1)
+		q.store.remove(status.ID)
+		return AsyncJob{}, ErrQueueFull
+	}
+}
+
+// Depth is the number of queued jobs no worker has picked up yet
+func (q *JobQueue) Depth() int {
+	return len(q.queue)
+}
+
+func (q *JobQueue) worker() {
+	for queued := range q.queue {
+		q.store.start(queued.id)
+		ctx := withAsyncJob(WithKeyID(context.Background(), queued.key_id), queued.id)
+		response, err := ExecuteJob(withDedicatedWorker(ctx), queued.job)
+		q.store.finish(queued.id, response, err)
+		log.Debug().Str("job_id", queued.id).Msg("Async job finished")
+		if queued.job.CallbackURL != "" {
+			go q.DeliverCallback(queued.id, queued.job.CallbackURL)
+		}
+		q.unfinished.Add(-1)
+	}
+}
+
+// Drain waits until the queue is empty and no job is running, or ctx is done.
+// Callbacks still being delivered are not waited for.
+func (q *JobQueue) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for q.unfinished.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// QueueJob checks the callback URL of the job and queues it for the API key of ctx
+func QueueJob(ctx context.Context, job ProxyJob) (AsyncJob, error) {
+	if draining.Load() {
+		return AsyncJob{}, ErrDraining
+	}
+	if job.CallbackURL != "" {
+		if err := CheckCallbackURL(job.CallbackURL); err != nil {
+			return AsyncJob{}, err
+		}
+	}
+	return asyncJobs.Submit(KeyIDFrom(ctx), job)
+}
+
+// LookupJob returns the job id of the API key of ctx, from memory or else
+// from the job history
+func LookupJob(ctx context.Context, id string) (AsyncJob, bool) {
+	owner := KeyIDFrom(ctx)
+	status, ok := asyncJobs.store.Get(id)
+	if ok && status.owner == owner {
+		return status, true
+	}
+
+	if history != nil {
+		ctx, cancel := context.WithTimeout(ctx, historyTimeout)
+		defer cancel()
+		entry, found, err := history.Get(ctx, owner, id)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to query job history")
+		}
+		if found {
+			return entry.asyncJob(), true
+		}
+	}
+	return AsyncJob{}, false
+}
+
+// SubmitAsyncJob queues a parsed job and answers with its ID
+func SubmitAsyncJob(c *fiber.Ctx, job ProxyJob) error {
+	// form bodies are parsed into strings backed by the request buffer, which
+	// is reused once the handler returns
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	var owned ProxyJob
+	if err := json.Unmarshal(data, &owned); err != nil {
+		return err
+	}
+
+	status, err := QueueJob(requestContext(c), owned)
+	if errors.Is(err, ErrQueueFull) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Job queue is full",
+		})
+	}
+	if err != nil {
+		return sendJobError(c, err)
+	}
+
+	c.Location("/jobs/" + status.ID)
+	return c.Status(fiber.StatusAccepted).JSON(status)
+}
+
+// GetJob returns the state of an async job, and its response once done
+// @Description Returns the state of a job submitted with async=true. Jobs no longer in memory are looked up in the job history, with their body truncated.
+// @Param id path string true "Job ID returned when the job was submitted"
+func GetJob(c *fiber.Ctx) error {
+	if status, ok := LookupJob(requestContext(c), c.Params("id")); ok {
+		return c.JSON(status)
+	}
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Job not found or expired",
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAsyncJobLifecycle(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	previous := asyncJobs
+	defer func() { asyncJobs = previous }()
+	asyncJobs = NewJobQueue(NewJobStore(time.Minute), 1, 1)
+
+	app := fiber.New()
+	app.Post("/proxy", PerformProxyJob)
+	app.Get("/jobs/:id", GetJob)
+	request := func(method string, target string, body string) (int, AsyncJob) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		response, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		var status AsyncJob
+		json.Unmarshal(data, &status)
+		return response.StatusCode, status
+	}
+
+	code, submitted := request(fiber.MethodPost, "/proxy?async=true", `{"url": "`+server.URL+`", "method": "GET"}`)
+	if code != fiber.StatusAccepted || submitted.ID == "" || submitted.State != JobPending {
+		t.Fatalf("submit got %d %+v, want 202 with a pending job", code, submitted)
+	}
+
+	// the upstream holds the job until it is released
+	var status AsyncJob
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if _, status = request(fiber.MethodGet, "/jobs/"+submitted.ID, ""); status.State == JobRunning {
+			break
+		}
+	}
+	if status.State != JobRunning || status.StartedAt == nil || status.Response != nil {
+		t.Fatalf("polled %+v, want the job running", status)
+	}
+	close(release)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && !status.Finished(); time.Sleep(5 * time.Millisecond) {
+		_, status = request(fiber.MethodGet, "/jobs/"+submitted.ID, "")
+	}
+	if status.State != JobDone || status.FinishedAt == nil || status.Response == nil || string(status.Response.Body) != "done" {
+		t.Fatalf("polled %+v, want the job done with its response", status)
+	}
+
+	if code, _ := request(fiber.MethodGet, "/jobs/unknown", ""); code != fiber.StatusNotFound {
+		t.Fatalf("unknown job got %d, want 404", code)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	// without workers the queue only fills up
+	q := NewJobQueue(NewJobStore(time.Minute), 0, 1)
+	queued, err := q.Submit("key-a", ProxyJob{URL: "http://example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.Submit("key-a", ProxyJob{URL: "http://example.com/"})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("second submit got %v, want ErrQueueFull", err)
+	}
+	if q.Depth() != 1 || len(q.store.jobs) != 1 {
+		t.Fatalf("depth %d with %d stored jobs, want the rejected job forgotten", q.Depth(), len(q.store.jobs))
+	}
+	if _, ok := q.store.Get(queued.ID); !ok {
+		t.Fatal("queued job is gone")
+	}
+
+	previous := asyncJobs
+	defer func() { asyncJobs = previous }()
+	asyncJobs = q
+	app := fiber.New()
+	app.Post("/proxy", PerformProxyJob)
+	req := httptest.NewRequest(fiber.MethodPost, "/proxy?async=true", strings.NewReader(`{"url": "http://example.com/", "method": "GET"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	response, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != fiber.StatusServiceUnavailable {
+		t.Fatalf("submit to a full queue got %d, want 503", response.StatusCode)
+	}
+}
+
+func TestJobStoreExpiry(t *testing.T) {
+	const ttl = 40 * time.Millisecond
+	store := NewJobStore(ttl)
+	finished, _ := store.create("", false)
+	running, _ := store.create("", false)
+	store.start(running.ID)
+	store.finish(finished.ID, ProxyResponse{StatusCode: fiber.StatusOK}, nil)
+
+	if _, ok := store.Get(finished.ID); !ok {
+		t.Fatal("finished job expired right away")
+	}
+	time.Sleep(3 * ttl)
+	if _, ok := store.Get(finished.ID); ok {
+		t.Fatalf("finished job still stored after %s, want it expired after the %s TTL", 3*ttl, ttl)
+	}
+	// unfinished jobs never expire
+	if status, ok := store.Get(running.ID); !ok || status.State != JobRunning {
+		t.Fatalf("running job got %+v %v, want it kept", status, ok)
+	}
+}
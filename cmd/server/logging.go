@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// LogConfig is the logger setup, from the log_level and log_format settings
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+// configureLogger sets up the global logger: human readable console output by
+// default, structured JSON lines to stdout with the json format
+func configureLogger(cfg LogConfig) error {
+	level := zerolog.InfoLevel
+	if cfg.Level != "" {
+		parsed, err := zerolog.ParseLevel(strings.ToLower(cfg.Level))
+		if err != nil || parsed == zerolog.NoLevel {
+			return fmt.Errorf("invalid PROXIER_LOG_LEVEL %q: use trace, debug, info, warn, error, fatal, panic or disabled", cfg.Level)
+		}
+		level = parsed
+	}
+
+	switch strings.ToLower(cfg.Format) {
+	case "", "console":
+		zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+	case "json":
+		zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	default:
+		return fmt.Errorf("invalid PROXIER_LOG_FORMAT %q: use json or console", cfg.Format)
+	}
+	zerolog.SetGlobalLevel(level)
+	return nil
+}
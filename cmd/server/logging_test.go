@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	server_config "aslon1213/proxy_worker/configs/server"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestConfigureLogger(t *testing.T) {
+	previous_logger, previous_format := log.Logger, zerolog.TimeFieldFormat
+	previous_level := zerolog.GlobalLevel()
+	defer func() {
+		log.Logger, zerolog.TimeFieldFormat = previous_logger, previous_format
+		zerolog.SetGlobalLevel(previous_level)
+	}()
+
+	tests := []struct {
+		name   string
+		config LogConfig
+		level  zerolog.Level
+		err    string
+	}{
+		{"defaults", LogConfig{}, zerolog.InfoLevel, ""},
+		{"debug console", LogConfig{Level: "debug", Format: "console"}, zerolog.DebugLevel, ""},
+		{"case insensitive", LogConfig{Level: "WARN", Format: "JSON"}, zerolog.WarnLevel, ""},
+		{"disabled", LogConfig{Level: "disabled"}, zerolog.Disabled, ""},
+		{"invalid level", LogConfig{Level: "loud"}, 0, "PROXIER_LOG_LEVEL"},
+		{"invalid format", LogConfig{Format: "xml"}, 0, "PROXIER_LOG_FORMAT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := configureLogger(test.config)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("err = %v, want one naming %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := zerolog.GlobalLevel(); got != test.level {
+				t.Fatalf("level = %s, want %s", got, test.level)
+			}
+		})
+	}
+
+	// the json format writes one structured line per event to stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	err = configureLogger(LogConfig{Level: "info", Format: "json"})
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Info().Str("url", "http://example.com/").Msg("Request completed")
+	log.Debug().Msg("below the level")
+	writer.Close()
+	output, _ := io.ReadAll(reader)
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines %q, want one", len(lines), output)
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", lines[0], err)
+	}
+	if event["level"] != "info" || event["message"] != "Request completed" || event["url"] != "http://example.com/" {
+		t.Fatalf("event = %v", event)
+	}
+	if _, ok := event["time"].(float64); !ok {
+		t.Fatalf("time = %v, want unix milliseconds", event["time"])
+	}
+}
+
+func TestServerSettingsFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		addr    string
+		level   string
+		format  string
+		invalid bool
+	}{
+		{"defaults", nil, ":3010", "info", "console", false},
+		{"overridden", map[string]string{"PROXIER_ADDR": "127.0.0.1:8080", "PROXIER_LOG_LEVEL": "debug", "PROXIER_LOG_FORMAT": "json"}, "127.0.0.1:8080", "debug", "json", false},
+		{"invalid address", map[string]string{"PROXIER_ADDR": "8080"}, "", "", "", true},
+		{"invalid level", map[string]string{"PROXIER_LOG_LEVEL": "verbose"}, "", "", "", true},
+		{"invalid format", map[string]string{"PROXIER_LOG_FORMAT": "text"}, "", "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := server_config.Load("")
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("loaded %+v, want an error", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Addr != test.addr || cfg.LogLevel != test.level || cfg.LogFormat != test.format {
+				t.Fatalf("addr %q, log_level %q, log_format %q, want %q, %q and %q", cfg.Addr, cfg.LogLevel, cfg.LogFormat, test.addr, test.level, test.format)
+			}
+		})
+	}
+}
@@ -2,24 +2,70 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"math"
+	"net"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	server_config "aslon1213/proxy_worker/configs/server"
+
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/swagger" // swagger handler
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
 )
 
 // ProxyJob represents the structure of a proxy job request
 // @Description Proxy job request structure
 // @Param url query string true "URL to proxy"
 // @Param method query string true "HTTP method"
+// @Param query_params query object false "Query parameters merged into the URL, a key may have several values"
 // @Param headers query object false "Request headers"
 // @Param body query string false "Request body"
 // @Param cookies query object false "Request cookies"
 // @Param timeout query int false "Request timeout in seconds"
+// @Param timeout_ms query int false "Request timeout in milliseconds, overrides timeout"
+// @Param basic_auth_user query string false "Username for basic or digest auth"
+// @Param basic_auth_pass query string false "Password for basic or digest auth"
+// @Param proxy_url query string false "Upstream proxy to route the request through"
+// @Param sni query string false "Server name to present in the TLS handshake"
+// @Param insecure_skip_verify query bool false "Skip certificate verification for this request"
+// @Param ca_cert query string false "PEM encoded CA certificate to trust for this request"
+// @Param keep_hop_by_hop_headers query bool false "Forward hop-by-hop headers instead of stripping them"
+// @Param max_body_bytes query int false "Reject responses with a larger body, defaults to the server limit"
+// @Param cache_body query bool false "Cache the body on the worker and return a body ID instead"
+// @Param raw_bytes query bool false "Return the exact upstream bytes, bypassing all body processing"
+// @Param max_retries query int false "Number of retries on transport errors or retry_on_status"
+// @Param retry_on_status query []int false "Status codes to retry"
+// @Param retry_non_idempotent query bool false "Also retry statuses of non-idempotent methods"
+// @Param retry query RetrySpec false "Retry policy, replaces max_retries and retry_on_status"
+// @Param retry_if_body_matches query string false "Regular expression of bodies to retry, even with a 2xx"
+// @Param cache_ttl query int false "Seconds to cache the response for, overrides the upstream Cache-Control"
+// @Param no_cache query bool false "Neither serve the job from the cache nor cache its response"
+// @Param callback_url query string false "URL the finished async job is posted to, signed with the webhook secret"
+// @Param session_id query string false "Session whose cookie jar is sent with the job and keeps the cookies it sets"
+// @Param signing_scheme query string false "Name of the HMAC signing scheme to sign the request with"
+// @Param script query string false "Name of the response script to apply"
+// @Param extract query object false "Fields to extract from the body by name, each with a json, css or xpath expression"
+// @Param extract_only query bool false "Return only the extracted fields, without the body"
+// @Param rewrite_rules query []RewriteRule false "Header and body rewrites of the request and response, after the server wide rules"
+// @Param worker_tags query object false "Tags the worker performing the job must have, such as region, when sent to a coordinator"
+// @Param follow_redirects query bool false "Follow 3xx redirects"
+// @Param max_redirects query int false "Maximum number of redirects to follow, defaults to 10"
+// @Param follow_meta_refresh query bool false "Follow HTML meta refresh redirects"
+// @Param detect_js_redirect query bool false "Report JavaScript redirect targets found in HTML"
+// @Param response_encoding query string false "Body encoding in the JSON envelope: auto, string or base64"
+// @Param compress_response_body query bool false "Gzip the body inside the JSON envelope"
 type ProxyJob struct {
 	URL     string            `json:"url"`
 	Method  string            `json:"method"`
@@ -27,133 +73,933 @@ type ProxyJob struct {
 	Body    string            `json:"body"`
 	Cookies map[string]string `json:"cookies"`
 	Timeout int               `json:"timeout"`
+
+	// QueryParams are encoded and appended to the query string already in URL
+	QueryParams map[string][]string `json:"query_params"`
+
+	// TimeoutMs takes precedence over Timeout when set, for sub-second deadlines
+	TimeoutMs int `json:"timeout_ms"`
+
+	// BasicAuthUser and BasicAuthPass answer the Basic or Digest challenge of
+	// a 401 from the upstream. AuthType "basic" sends them as basic auth
+	// right away instead, "digest" only answers Digest challenges.
+	BasicAuthUser string `json:"basic_auth_user"`
+	BasicAuthPass string `json:"basic_auth_pass"`
+	AuthType      string `json:"auth_type"`
+
+	// ProxyURL routes this job through an http://, https:// or socks5:// proxy
+	// instead of the server wide PROXIER_UPSTREAM_PROXY
+	ProxyURL string `json:"proxy_url"`
+
+	// SNI is presented in the TLS handshake instead of the URL host, which is
+	// still the host that is dialed. The Host header can be set separately.
+	SNI string `json:"sni"`
+
+	// InsecureSkipVerify disables certificate verification for this job only,
+	// CACert adds a PEM encoded root to verify self-signed or private CA
+	// certificates with instead
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	CACert             string `json:"ca_cert"`
+
+	// KeepHopByHopHeaders forwards hop-by-hop headers such as Connection
+	// and Upgrade instead of stripping them
+	KeepHopByHopHeaders bool `json:"keep_hop_by_hop_headers"`
+
+	// RawBytes turns the worker into a plain byte pipe: the upstream body is
+	// returned exactly as received and every body processing step is skipped
+	RawBytes bool `json:"raw_bytes"`
+
+	// MaxBodyBytes rejects responses with a larger body, it can only lower the
+	// server wide PROXIER_MAX_BODY_BYTES limit
+	MaxBodyBytes int `json:"max_body_bytes"`
+
+	// CacheBody keeps the response body on the worker for a short time
+	// instead of returning it, so it can be read in chunks from /bodies/:id
+	CacheBody bool `json:"cache_body"`
+
+	// ResponseEncoding is how the body is put into the JSON envelope: "auto"
+	// (the default) returns textual content types as a string and anything
+	// else as base64, "string" and "base64" force one or the other
+	ResponseEncoding string `json:"response_encoding"`
+
+	// CompressResponseBody gzips the body before it is base64 encoded into
+	// the JSON envelope, see BodyEncoding on the response
+	CompressResponseBody bool `json:"compress_response_body"`
+
+	// FollowRedirects follows 3xx responses, at most MaxRedirects times (10 by
+	// default). Without it the first 3xx is returned with its Location header.
+	FollowRedirects bool `json:"follow_redirects"`
+	MaxRedirects    int  `json:"max_redirects"`
+
+	// FollowMetaRefresh follows <meta http-equiv="refresh"> redirects of HTML
+	// responses, DetectJSRedirect only reports a detected JavaScript redirect
+	FollowMetaRefresh bool `json:"follow_meta_refresh"`
+	DetectJSRedirect  bool `json:"detect_js_redirect"`
+
+	// SigningScheme names an HMAC signing scheme loaded from
+	// PROXIER_SIGNING_SCHEMES_FILE that signs the request before it is sent
+	SigningScheme string `json:"signing_scheme"`
+
+	// MaxRetries retries transport errors and RetryOnStatus responses with
+	// exponential backoff. Statuses of non-idempotent methods such as POST are
+	// only retried with RetryNonIdempotent.
+	MaxRetries         int   `json:"max_retries"`
+	RetryOnStatus      []int `json:"retry_on_status"`
+	RetryNonIdempotent bool  `json:"retry_non_idempotent"`
+
+	// Retry is a full retry policy, see RetrySpec. Retries of jobs using the
+	// proxy pool go through another proxy of the pool when there is one.
+	Retry *RetrySpec `json:"retry"`
+
+	// RetryIfBodyMatches is a regular expression of bodies that fail the
+	// attempt even with a 2xx, such as a captcha page. They are retried like
+	// RetryOnStatus, within the attempts of the job.
+	RetryIfBodyMatches string `json:"retry_if_body_matches"`
+
+	// CacheTTL caches GET and HEAD responses for that many seconds instead of
+	// what the upstream Cache-Control or Expires allow, responses the upstream
+	// marks no-store or private are still never cached. NoCache bypasses the
+	// cache altogether.
+	CacheTTL int  `json:"cache_ttl"`
+	NoCache  bool `json:"no_cache"`
+
+	// Script names a response script loaded from PROXIER_SCRIPTS_FILE that
+	// transforms the response body before it is returned
+	Script string `json:"script"`
+
+	// Extract pulls named fields out of the response body into Extracted, see
+	// Extractor. ExtractOnly leaves the body out of the response.
+	Extract     map[string]Extractor `json:"extract"`
+	ExtractOnly bool                 `json:"extract_only"`
+
+	// RewriteRules edit the headers and body of the request and the response
+	// of matching targets, applied after PROXIER_REWRITE_RULES_FILE
+	RewriteRules []RewriteRule `json:"rewrite_rules"`
+
+	Pagination *PaginationOptions `json:"pagination"`
+
+	// SessionID shares a cookie jar between the jobs of an API key that use
+	// it: the cookies of the jar are sent along with Cookies, and the cookies
+	// set by every response and redirect are stored in it
+	SessionID string `json:"session_id"`
+
+	// CallbackURL receives the result of an async job as a signed POST once
+	// it finishes, see CallbackPayload
+	CallbackURL string `json:"callback_url"`
+
+	// WorkerTags pick the workers a coordinator may dispatch the job to, each
+	// must have every tag with the same value. Workers ignore them.
+	WorkerTags map[string]string `json:"worker_tags"`
+
+	// pooled is set when ProxyURL was picked from the proxy pool
+	pooled bool
+	// session is the jar of SessionID, opened by ExecuteJob
+	session *Session
+	// timer times the phases of the requests of PerformRequest
+	timer *phaseTimer
+	// retryBody is RetryIfBodyMatches, compiled by ValidateJob
+	retryBody *regexp.Regexp
 }
 
+// maxTimeout is the longest deadline a job may ask for
+const maxTimeout = 10 * time.Minute
+
+// defaultJobTimeout applies to jobs without a timeout, set from default_timeout
+var defaultJobTimeout = 30 * time.Second
+
 // ProxyResponse represents the structure of a proxy job response
 // @Description Proxy job response structure
 // @Param status_code query int true "HTTP status code"
-// @Param body query []byte true "Response body"
+// @Param body query string true "Response body, encoded as body_encoding says"
+// @Param body_encoding query string false "string, base64 or gzip+base64"
+// @Param headers query object false "Response headers, repeated headers keep every value"
+// @Param cookies query []ResponseCookie false "Cookies set by the final response"
+// @Param final_url query string false "URL of the final response, after redirects"
+// @Param started_at query string false "When the job started"
+// @Param duration_ms query int false "Time the job took, in milliseconds"
+// @Param extracted query object false "Fields extracted from the body, null when nothing matched"
+// @Param cache_status query string false "HIT, MISS or BYPASS when the response cache is enabled"
+// @Param attempts query int false "Number of times the request was sent"
+// @Param attempt_errors query []AttemptError false "Attempts that failed, including retried ones"
 // @Param errs query []error false "Errors encountered during the request"
 type ProxyResponse struct {
-	StatusCode int     `json:"status_code"`
-	Body       []byte  `json:"body"`
-	Errs       []error `json:"errs"`
+	StatusCode int                 `json:"status_code"`
+	Body       []byte              `json:"body"`
+	Headers    map[string][]string `json:"headers"`
+	Errs       []error             `json:"errs"`
+
+	// HeadersTruncated tells that response headers were left out of Headers
+	// past response_max_headers or response_max_header_bytes, or that a value
+	// over response_max_header_value_bytes was cut or dropped
+	HeadersTruncated bool `json:"headers_truncated,omitempty"`
+
+	BodyEncoding string `json:"body_encoding,omitempty"`
+
+	// Extracted holds the fields of the extract option of the job
+	Extracted map[string]any `json:"extracted,omitempty"`
+
+	// Cookies are parsed from the Set-Cookie headers of the final response
+	Cookies []ResponseCookie `json:"cookies,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+
+	// UpstreamProxy is the proxy the request went through, without its password
+	UpstreamProxy string `json:"upstream_proxy,omitempty"`
+
+	Attempts      int            `json:"attempts,omitempty"`
+	AttemptErrors []AttemptError `json:"attempt_errors,omitempty"`
+
+	CacheStatus string `json:"cache_status,omitempty"`
+	// ServedFrom is cache or upstream, DurationMs of a cache hit being the
+	// time it took to serve from the cache
+	ServedFrom string `json:"served_from,omitempty"`
+
+	// FinalURL is the job URL unless redirects or meta refreshes were followed
+	FinalURL      string `json:"final_url,omitempty"`
+	Redirects     int    `json:"redirects,omitempty"`
+	MetaRefreshes int    `json:"meta_refreshes,omitempty"`
+	JSRedirect    string `json:"js_redirect,omitempty"`
+
+	BodyID   string `json:"body_id,omitempty"`
+	BodySize int    `json:"body_size,omitempty"`
+
+	Pages     [][]byte `json:"pages,omitempty"`
+	PageCount int      `json:"page_count,omitempty"`
 }
 
-func PerformRequest(ctx context.Context, agent *fiber.Agent, job ProxyJob, response_chan chan ProxyResponse) {
-	logger := log.With().Str("url", job.URL).Str("method", job.Method).Logger()
+// NewAgent creates an agent on the client for the given HTTP method,
+// returning nil if the method is not supported. Every agent dials through its
+// own fasthttp.HostClient, so pooled connections are never shared between jobs
+// and per-job TLS or proxy settings cannot leak into one another.
+func NewAgent(client *fiber.Client, method string, url string) *fiber.Agent {
+	switch method {
+	case fiber.MethodGet:
+		return client.Get(url)
+	case fiber.MethodPost:
+		return client.Post(url)
+	case fiber.MethodPut:
+		return client.Put(url)
+	case fiber.MethodDelete:
+		return client.Delete(url)
+	case fiber.MethodPatch:
+		return client.Patch(url)
+	case fiber.MethodHead:
+		return client.Head(url)
+	case fiber.MethodOptions:
+		// fiber.Client has no helper for OPTIONS
+		agent := client.Get(url)
+		agent.Request().Header.SetMethod(fiber.MethodOptions)
+		return agent
+	}
+	return nil
+}
+
+// hostClient returns the host client of the agent. fiber only creates one
+// for http and https URLs, agents of any other scheme can't be sent.
+func hostClient(agent *fiber.Agent) (*fasthttp.HostClient, error) {
+	if agent.HostClient == nil {
+		scheme := string(agent.Request().URI().Scheme())
+		return nil, &PolicyError{PolicySchemeNotAllowed, fmt.Sprintf("Scheme %q is not allowed", scheme)}
+	}
+	return agent.HostClient, nil
+}
 
-	for key, value := range job.Headers {
+// PrepareAgent applies the headers, cookies, body and credentials of the job
+// to the agent, failing for agents without a host client
+func PrepareAgent(agent *fiber.Agent, job ProxyJob) error {
+	host_client, err := hostClient(agent)
+	if err != nil {
+		return err
+	}
+	headers := job.Headers
+	if !job.KeepHopByHopHeaders {
+		headers = FilterHopByHop(headers)
+	}
+	for key, value := range headers {
 		agent.Request().Header.Set(key, value)
 	}
+	job.session.Apply(agent)
 	for key, value := range job.Cookies {
 		agent.Cookie(key, value)
 	}
 
+	host_client.MaxResponseBodySize = BodyLimitFor(job)
+	host_client.ReadBufferSize = headerReadBufferSize()
+
 	if job.Body != "" {
 		agent.Body([]byte(job.Body))
 	}
 
-	logger.Debug().Msg("Sending request")
+	if job.BasicAuthUser != "" && job.AuthType == AuthTypeBasic {
+		agent.BasicAuth(job.BasicAuthUser, job.BasicAuthPass)
+	}
+
+	// validated by ExecuteJob
+	if config, err := TLSConfigForJob(job); err == nil && config != nil {
+		agent.TLSConfig(config)
+	}
+
+	if err := SetDialer(agent, job); err != nil {
+		return err
+	}
+
+	RewriteRequest(agent, job)
+
+	// signing goes last so the signature covers the final request
+	if scheme, ok := signingSchemes[job.SigningScheme]; ok {
+		scheme.Sign(agent.Request(), time.Now())
+	}
+	return nil
+}
+
+// SetDialer makes the agent dial through the upstream proxy of the job, if
+// any, guarded by the target policy
+func SetDialer(agent *fiber.Agent, job ProxyJob) error {
+	host_client, err := hostClient(agent)
+	if err != nil {
+		return err
+	}
+	var dial fasthttp.DialFunc
+	if proxy_url := UpstreamProxyFor(job); proxy_url != "" {
+		// validated by ExecuteJob and at startup
+		dial, _ = ProxyDialer(proxy_url)
+	}
+	host_client.Dial = job.timer.Dial(host_client, targetPolicy.guard(dial, job.timer))
+	return nil
+}
+
+// ApplyDeadline bounds the request by the context deadline, so the upstream call
+// is aborted, and its connection released, once the caller stops waiting for it
+func ApplyDeadline(ctx context.Context, agent *fiber.Agent) {
+	if deadline, ok := ctx.Deadline(); ok {
+		agent.Request().SetTimeout(time.Until(deadline))
+	}
+}
+
+func isTimeout(errs []error) bool {
+	for _, err := range errs {
+		var timeout interface{ Timeout() bool }
+		if errors.As(err, &timeout) && timeout.Timeout() {
+			return true
+		}
+	}
+	return false
+}
+
+// SendRequest sends the request of the agent and records the upstream latency
+func SendRequest(agent *fiber.Agent) (int, []byte, []error) {
+	start := time.Now()
 	status_code, body, errs := agent.Bytes()
+	metrics.ObserveUpstream(time.Since(start))
+	return status_code, body, errs
+}
+
+func PerformRequest(ctx context.Context, agent *fiber.Agent, job ProxyJob, response_chan chan ProxyResponse) {
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Logger()
+	job.timer = &phaseTimer{}
+
+	if err := PrepareAgent(agent, job); err != nil {
+		fiber.ReleaseAgent(agent)
+		logger.Error().Err(err).Msg("Request failed")
+		response_chan <- ProxyResponse{Errs: []error{err}, UpstreamProxy: UpstreamProxyFor(job), Attempts: 1}
+		return
+	}
+
+	resp := AcquireUpstreamResponse()
+	defer fiber.ReleaseResponse(resp)
+	agent.SetResponse(resp)
+
+	policy := RetryPolicyFor(job)
+
+	// keep the agent around so it can be resent for retries or to answer an auth challenge
+	if job.BasicAuthUser != "" || policy.Retries() {
+		agent.Reuse()
+		defer fiber.ReleaseAgent(agent)
+	}
+
+	var (
+		status_code    int
+		body           []byte
+		errs           []error
+		attempt        int
+		attempt_errors []AttemptError
+	)
+	for ; ; attempt++ {
+		ApplyDeadline(ctx, agent)
+
+		logger.Debug().Int("attempt", attempt+1).Msg("Sending request")
+		job.timer.Start()
+		status_code, body, errs = SendRequest(agent)
+
+		if len(errs) == 0 && status_code == fiber.StatusUnauthorized && job.BasicAuthUser != "" && job.AuthType != AuthTypeBasic {
+			status_code, body, errs = RetryWithAuth(agent, resp, job, status_code, body)
+		}
+
+		if hostBackoffs != nil {
+			// statuses and bodies count for the host when this job retries on them
+			if breakerFailed(status_code, errs) || (len(errs) == 0 && policy.Failed(status_code, body, nil)) {
+				hostBackoffs.Failed(backoffHost(job.URL), time.Now())
+			} else if len(errs) == 0 {
+				hostBackoffs.Succeeded(backoffHost(job.URL))
+			}
+		}
+
+		if policy.Failed(status_code, body, errs) {
+			attempt_errors = append(attempt_errors, AttemptError{
+				Attempt:    attempt + 1,
+				StatusCode: status_code,
+				Errors:     ErrorDetails(errs),
+				Proxy:      RedactProxyURL(UpstreamProxyFor(job)),
+			})
+		}
+
+		if attempt+1 >= policy.MaxAttempts || !policy.ShouldRetry(job.Method, status_code, body, errs) {
+			break
+		}
+
+		backoff := policy.Backoff(attempt)
+		if hostBackoffs != nil {
+			// jobs retrying the same host take turns
+			backoff = max(backoff, hostBackoffs.Reserve(backoffHost(job.URL), time.Now()))
+		}
+		if err := wait(ctx, backoff); err != nil {
+			break
+		}
+		if err := domainLimits.Wait(ctx, job.URL); err != nil {
+			errs = []error{err}
+			break
+		}
+
+		if job.pooled {
+			if next := proxyPool.PickOther(job.URL, job.ProxyURL); next != job.ProxyURL {
+				metrics.IncProxyRequest(RedactProxyURL(job.ProxyURL), false)
+				logger.Debug().Str("proxy", RedactProxyURL(next)).Msg("Switching upstream proxy")
+				job.ProxyURL = next
+				// the agent has a host client, PrepareAgent succeeded
+				_ = SetDialer(agent, job)
+				// idle connections are tunnels through the previous proxy
+				agent.HostClient.CloseIdleConnections()
+			}
+		}
+		logger.Warn().Int("status_code", status_code).Errs("errors", errs).Dur("backoff", backoff).Msg("Retrying request")
+	}
 
 	if len(errs) > 0 {
 		logger.Error().Errs("errors", errs).Msg("Request failed")
 		response_chan <- ProxyResponse{
-			StatusCode: 0,
-			Body:       nil,
-			Errs:       errs,
+			StatusCode:    0,
+			Body:          nil,
+			Errs:          errs,
+			UpstreamProxy: UpstreamProxyFor(job),
+			Attempts:      attempt + 1,
+			AttemptErrors: attempt_errors,
 		}
 		return
 	}
 
-	logger.Info().Int("status_code", status_code).Int("body_size", len(body)).Msg("Request completed")
+	job.timer.Done().Log(logger.Info().Int("status_code", status_code).Int("body_size", len(body))).Msg("Request completed")
+	headers, truncated := CaptureHeaders(resp, job.KeepHopByHopHeaders)
+	if truncated {
+		logger.Warn().Int("max_headers", maxResponseHeaders).Int("max_header_bytes", maxResponseHeaderBytes).Int("max_header_value_bytes", maxResponseHeaderValueBytes).Msg("Response headers truncated")
+	}
+	job.session.Record(job.URL, headers)
 	response_chan <- ProxyResponse{
-		StatusCode: status_code,
-		Body:       body,
-		Errs:       errs,
+		StatusCode:       status_code,
+		Body:             body,
+		Headers:          headers,
+		HeadersTruncated: truncated,
+		Errs:             errs,
+		UpstreamProxy:    UpstreamProxyFor(job),
+		Attempts:         attempt + 1,
+		AttemptErrors:    attempt_errors,
 	}
 }
 
-// @title Proxy Worker API
-// @version 1.0
-// @description Proxy Worker API
-// @BasePath /
-// PerformProxyJob handles the proxy job request
-// @Description Handles the proxy job request and returns the response
-func PerformProxyJob(c *fiber.Ctx) error {
-	logger := log.With().Str("handler", "PerformProxyJob").Logger()
+// JobError is a job that could not be performed, with the HTTP status it maps to
+type JobError struct {
+	Status  int
+	Message string
+}
 
-	var job ProxyJob
-	if err := c.BodyParser(&job); err != nil {
-		logger.Error().Err(err).Msg("Failed to parse request body")
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+func (e *JobError) Error() string {
+	return e.Message
+}
+
+// failJob records a failed job. Bodies over the size limit are returned as a
+// *JobError and blocked targets as a *PolicyError so callers get a clear
+// message instead of a transport error.
+func failJob(ctx context.Context, job ProxyJob, response ProxyResponse) (ProxyResponse, error) {
+	recorder.Add(ctx, job, response)
+	RecordDeadLetter(job, errorStrings(response.Errs))
+	response.DurationMs = time.Since(response.StartedAt).Milliseconds()
+	if policy_err := policyError(response.Errs); policy_err != nil {
+		return ProxyResponse{}, policy_err
+	}
+	if isBodyTooLarge(response.Errs) {
+		return ProxyResponse{}, &JobError{fiber.StatusBadGateway, fmt.Sprintf("Response body exceeds %d bytes", BodyLimitFor(job))}
+	}
+	return response, nil
+}
+
+// ValidateJob normalizes the job and runs the job middlewares on it, then
+// checks its options and target. Bodies may be up to max_body_bytes.
+func ValidateJob(parent context.Context, job ProxyJob, max_body_bytes int) (ProxyJob, error) {
+	job.Method = strings.ToUpper(strings.TrimSpace(job.Method))
+	job.URL = MergeQueryParams(job.URL, job.QueryParams)
+
+	if _, async := AsyncJobIDFrom(parent); draining.Load() && !async {
+		// async jobs queued before the shutdown are still performed
+		return job, ErrDraining
+	}
+	job, err := RunJobMiddlewares(job)
+	if err != nil {
+		return job, err
+	}
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Str("key_id", KeyIDFrom(parent)).Logger()
+
+	if job.Timeout < 0 || time.Duration(job.Timeout)*time.Second > maxTimeout {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid timeout"}
+	}
+
+	if job.TimeoutMs < 0 || time.Duration(job.TimeoutMs)*time.Millisecond > maxTimeout {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid timeout_ms"}
+	}
+
+	if job.MaxRetries < 0 || job.MaxRetries > maxRetries {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid max_retries"}
+	}
+
+	if !ValidRetrySpec(job.Retry) {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid retry"}
+	}
+
+	if len(job.RetryIfBodyMatches) > maxRewritePatternSize {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid retry_if_body_matches: too long"}
+	}
+	if job.RetryIfBodyMatches != "" {
+		if job.retryBody, err = regexp.Compile(job.RetryIfBodyMatches); err != nil {
+			return job, &JobError{fiber.StatusBadRequest, "Invalid retry_if_body_matches: " + err.Error()}
+		}
+	}
+
+	if len(job.SessionID) > maxSessionIDLength {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid session_id"}
+	}
+
+	if job.CacheTTL < 0 || time.Duration(job.CacheTTL)*time.Second > maxCacheTTL {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid cache_ttl"}
+	}
+
+	if job.MaxBodyBytes < 0 || job.MaxBodyBytes > max_body_bytes {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid max_body_bytes"}
+	}
+
+	if job.AuthType != "" && job.AuthType != AuthTypeBasic && job.AuthType != AuthTypeDigest {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid auth_type"}
+	}
+
+	if job.MaxRedirects < 0 || job.MaxRedirects > maxRedirectsLimit {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid max_redirects"}
+	}
+
+	if job.ProxyURL != "" {
+		if _, err := ProxyDialer(job.ProxyURL); err != nil {
+			return job, &JobError{fiber.StatusBadRequest, "Invalid proxy_url"}
+		}
+	}
+
+	if err := targetPolicy.CheckURL(job.URL); err != nil {
+		logger.Warn().Err(err).Msg("Target blocked by policy")
+		return job, err
 	}
 
-	if job.Timeout == 0 {
-		job.Timeout = 30 // Default timeout of 30 seconds
+	if _, ok := AsyncJobIDFrom(parent); job.CallbackURL != "" && !ok {
+		return job, &JobError{fiber.StatusBadRequest, "callback_url needs an async job"}
+	}
+
+	// proxies of the pool and the server wide proxy are trusted, those of jobs are not
+	if job.ProxyURL != "" {
+		if err := targetPolicy.CheckProxy(job.ProxyURL); err != nil {
+			logger.Warn().Err(err).Msg("Proxy blocked by policy")
+			return job, err
+		}
+	}
+
+	if _, err := TLSConfigForJob(job); err != nil {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid ca_cert"}
+	}
+
+	if job.InsecureSkipVerify {
+		logger.Warn().Msg("Certificate verification disabled for job")
+	}
+
+	if !ValidResponseEncoding(job.ResponseEncoding) {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid response_encoding"}
+	}
+
+	if job.Script != "" && !scripts.Has(job.Script) {
+		return job, &JobError{fiber.StatusBadRequest, "Unknown script"}
+	}
+
+	if _, ok := signingSchemes[job.SigningScheme]; job.SigningScheme != "" && !ok {
+		return job, &JobError{fiber.StatusBadRequest, "Unknown signing scheme"}
+	}
+
+	if len(job.RewriteRules) > maxJobRewriteRules {
+		return job, &JobError{fiber.StatusBadRequest, "Too many rewrite_rules"}
+	}
+	if job.RewriteRules, err = CompileRewriteRules(job.RewriteRules); err != nil {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid rewrite_rules: " + err.Error()}
+	}
+
+	if len(job.Extract) > maxExtractors {
+		return job, &JobError{fiber.StatusBadRequest, "Too many extract fields"}
+	}
+	if job.Extract, err = CompileExtractors(job.Extract); err != nil {
+		return job, &JobError{fiber.StatusBadRequest, "Invalid extract: " + err.Error()}
+	}
+	if job.ExtractOnly && len(job.Extract) == 0 {
+		return job, &JobError{fiber.StatusBadRequest, "extract_only needs extract"}
+	}
+	if job.RawBytes && len(job.Extract) > 0 {
+		return job, &JobError{fiber.StatusBadRequest, "extract cannot be used with raw_bytes"}
 	}
 
+	return job, nil
+}
+
+// JobTimeout is the deadline of the job: timeout_ms, timeout or the default
+func JobTimeout(job ProxyJob) time.Duration {
+	timeout := defaultJobTimeout
+	if job.Timeout > 0 {
+		timeout = time.Duration(job.Timeout) * time.Second
+	}
+	if job.TimeoutMs > 0 {
+		timeout = time.Duration(job.TimeoutMs) * time.Millisecond
+	}
+	return timeout
+}
+
+// ExecuteJob validates and performs a proxy job. It is shared by the HTTP and
+// gRPC surfaces; upstream failures are reported in ProxyResponse.Errs while
+// a *JobError is returned when the job could not be performed at all.
+func ExecuteJob(parent context.Context, job ProxyJob) (ProxyResponse, error) {
+	started := time.Now()
+	response, err := executeJob(parent, job, started)
+	history.Record(parent, job, started, response, err)
+	return response, err
+}
+
+func executeJob(parent context.Context, job ProxyJob, started time.Time) (ProxyResponse, error) {
+	if coordinator != nil {
+		// the worker validates the job, its options may need the worker's files
+		return coordinator.Dispatch(parent, job)
+	}
+	job, err := ValidateJob(parent, job, maxBodyBytes)
+	if err != nil {
+		return ProxyResponse{}, err
+	}
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Str("key_id", KeyIDFrom(parent)).Logger()
+
+	job, err = OpenSession(parent, job)
+	if err != nil {
+		return ProxyResponse{}, err
+	}
+
+	// before a proxy of the pool is picked, or each would have its own entry
+	cache_key, cache_status := CacheKeyFor(job)
+
+	if job.ProxyURL == "" && proxyPool != nil {
+		job.ProxyURL = proxyPool.Pick(job.URL)
+		job.pooled = true
+	}
+
+	timeout := JobTimeout(job)
+
 	logger.Info().
-		Str("url", job.URL).
-		Str("method", job.Method).
-		Int("timeout", job.Timeout).
+		Dur("timeout", timeout).
+		Str("signing_scheme", job.SigningScheme).
 		Msg("Received proxy request")
 
-	client := fiber.AcquireClient()
-	defer fiber.ReleaseClient(client)
+	if cache_status == CacheMiss {
+		if cached, ok := LoadResponse(cache_key); ok {
+			logger.Debug().Msg("Serving response from cache")
+			cached.CacheStatus = CacheHit
+			cached.ServedFrom = ServedFromCache
+			cached.StartedAt = started
+			response, err := finishJob(job, cached, logger)
+			metrics.IncRequest(job.Method, cached.StatusCode)
+			metrics.ObserveCacheHit(time.Since(started))
+			return response, err
+		}
+	}
+
+	if breakers != nil {
+		if err := breakers.Allow(job); err != nil {
+			logger.Warn().Err(err).Msg("Failing fast, circuit is open")
+			return ProxyResponse{}, err
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Duration(job.Timeout)*time.Second)
+	metrics.AddInFlight(1)
+	defer metrics.AddInFlight(-1)
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
-	var req *fiber.Agent
-	switch job.Method {
-	case "GET":
-		req = client.Get(job.URL)
-	case "POST":
-		req = client.Post(job.URL)
-	case "PUT":
-		req = client.Put(job.URL)
-	case "DELETE":
-		req = client.Delete(job.URL)
-	default:
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid HTTP method",
-		})
+	// before a worker is taken, jobs waiting for their turn don't hold one
+	if err := domainLimits.Wait(ctx, job.URL); err != nil {
+		logger.Warn().Err(err).Msg("Domain rate limit exceeded")
+		return ProxyResponse{}, err
+	}
+
+	// not taken from the client pool: the request goroutines may outlive this call
+	client := &fiber.Client{}
+
+	req := NewAgent(client, job.Method, job.URL)
+	if req == nil {
+		return ProxyResponse{}, &JobError{fiber.StatusBadRequest, "Invalid HTTP method"}
 	}
 
 	response_chan := make(chan ProxyResponse, 1)
-	go PerformRequest(ctx, req, job, response_chan)
+	err = RunOnWorker(ctx, func() {
+		if ctx.Err() != nil {
+			// timed out while queued, ExecuteJob has already answered
+			fiber.ReleaseAgent(req)
+			return
+		}
+		if job.Pagination != nil {
+			PerformPaginatedRequest(ctx, client, req, job, response_chan)
+		} else {
+			PerformRequest(ctx, req, job, response_chan)
+		}
+	})
+	if err != nil {
+		fiber.ReleaseAgent(req)
+		logger.Warn().Msg("Worker pool is full, shedding job")
+		return ProxyResponse{}, err
+	}
 
+	var response ProxyResponse
+	received := false
 	select {
 	case <-ctx.Done():
-		logger.Warn().Int("timeout", job.Timeout).Msg("Request timed out")
-		return c.Status(fiber.StatusRequestTimeout).JSON(fiber.Map{
-			"error": "Request timed out",
+	case response = <-response_chan:
+		received = true
+	}
+
+	// the upstream call is aborted at the deadline too, so a failed response
+	// racing ctx.Done is still a timeout
+	if !received || (len(response.Errs) > 0 && (ctx.Err() != nil || isTimeout(response.Errs))) {
+		logger.Warn().Dur("timeout", timeout).Msg("Request timed out")
+		metrics.IncTimeout()
+		metrics.IncRequest(job.Method, 0)
+		if breakers != nil {
+			breakers.Record(job, true)
+		}
+		if proxy_url := UpstreamProxyFor(job); proxy_url != "" {
+			metrics.IncProxyRequest(RedactProxyURL(proxy_url), false)
+		}
+		recorder.Add(parent, job, ProxyResponse{
+			StartedAt:     started,
+			Errs:          []error{ErrorDetail{ErrorTimeout, "request timed out", true}},
+			UpstreamProxy: RedactProxyURL(UpstreamProxyFor(job)),
 		})
+		RecordDeadLetter(job, []string{"request timed out"})
+		return ProxyResponse{}, &JobError{fiber.StatusGatewayTimeout, "Request timed out"}
+	}
+	metrics.IncRequest(job.Method, response.StatusCode)
+	if breakers != nil {
+		breakers.Record(job, breakerFailed(response.StatusCode, response.Errs))
+	}
+	if job.pooled && response.UpstreamProxy != "" {
+		// retries may have moved to another proxy, redirects follow it there
+		job.ProxyURL = response.UpstreamProxy
+	}
+	response.UpstreamProxy = RedactProxyURL(UpstreamProxyFor(job))
+	if response.UpstreamProxy != "" {
+		metrics.IncProxyRequest(response.UpstreamProxy, len(response.Errs) == 0)
+	}
+	response.StartedAt = started
+
+	if len(response.Errs) > 0 {
+		return failJob(parent, job, response)
+	}
+
+	if job.FollowRedirects && job.Pagination == nil {
+		response = FollowRedirects(ctx, client, job, response)
+		if len(response.Errs) > 0 {
+			return failJob(parent, job, response)
+		}
+	}
 
-	case response := <-response_chan:
+	if (job.FollowMetaRefresh || job.DetectJSRedirect) && !job.RawBytes {
+		response = FollowHTMLRedirects(ctx, client, job, response)
 		if len(response.Errs) > 0 {
+			return failJob(parent, job, response)
+		}
+	}
 
-			errors := append(response.Errs, errors.New("request timed out"))
-			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
-				"errs": errors,
-			})
+	if response.FinalURL == "" {
+		response.FinalURL = job.URL
+	}
+	response.CacheStatus = cache_status
+	response.ServedFrom = ServedFromUpstream
+	if cache_status == CacheMiss {
+		StoreResponse(cache_key, job, response)
+	}
+	recorder.Add(parent, job, response)
+
+	return finishJob(job, response, logger)
+}
+
+// finishJob applies the rewrite rules, extractors, script, compression and
+// encoding of the job to an upstream response, fresh or from the cache
+func finishJob(job ProxyJob, response ProxyResponse, logger zerolog.Logger) (ProxyResponse, error) {
+	response = RewriteResponse(job, response)
+
+	if len(job.Extract) > 0 {
+		extracted, err := Extract(job.Extract, response.Body)
+		if err != nil {
+			logger.Error().Err(err).Msg("Response extraction failed")
+			return ProxyResponse{}, &JobError{fiber.StatusInternalServerError, "Response extraction failed"}
+		}
+		response.Extracted = extracted
+		if job.ExtractOnly {
+			response.Body = nil
+		}
+	}
+
+	if job.Script != "" && !job.RawBytes {
+		transformed, err := scripts.Run(job.Script, response)
+		if err != nil {
+			logger.Error().Err(err).Str("script", job.Script).Msg("Response script failed")
+			return ProxyResponse{}, &JobError{fiber.StatusInternalServerError, "Response script failed"}
 		}
+		response = transformed
+	}
 
-		logger.Info().
-			Int("status_code", response.StatusCode).
-			Int("body_size", len(response.Body)).
-			Msg("Sending response")
+	if job.CompressResponseBody && !job.RawBytes {
+		compressed, err := GzipBody(response.Body)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to compress body")
+			return ProxyResponse{}, &JobError{fiber.StatusInternalServerError, "Failed to compress body"}
+		}
+		response.Body = compressed
+		response.BodyEncoding = BodyEncodingGzip
+	}
+
+	response.Cookies = ParseSetCookies(response.Headers)
+
+	if response.BodyEncoding == "" && !job.CacheBody {
+		response.BodyEncoding = ChooseBodyEncoding(job, response)
+	}
+
+	if job.CacheBody {
+		id, err := bodies.Put(response.Body)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to cache body")
+			return ProxyResponse{}, &JobError{fiber.StatusInternalServerError, "Failed to cache body"}
+		}
+		response.BodyID = id
+		response.BodySize = len(response.Body)
+		response.Body = nil
+	}
+
+	response.DurationMs = time.Since(response.StartedAt).Milliseconds()
+	return response, nil
+}
+
+// sendJobError answers with the status of a *JobError, 403 and the code of
+// a *PolicyError, or 503 and a Retry-After when the worker pool is full or
+// the circuit of the target is open
+func sendJobError(c *fiber.Ctx, err error) error {
+	var limit_err *DomainRateLimitError
+	if errors.As(err, &limit_err) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(limit_err.RetryAfter.Seconds()))))
+		return c.Status(fiber.StatusTooManyRequests).JSON(errorBody(err, "Rate limit exceeded for "+limit_err.Domain))
+	}
+	if errors.Is(err, ErrDraining) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(errorBody(err, "Server is shutting down"))
+	}
+	if errors.Is(err, ErrNoWorker) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(errorBody(err, "No worker available for the job"))
+	}
+	if errors.Is(err, ErrOverloaded) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(overloadRetryAfter.Seconds())))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(errorBody(err, "Server is overloaded"))
+	}
+	var circuit_err *CircuitOpenError
+	if errors.As(err, &circuit_err) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(circuit_err.RetryAfter.Seconds()))))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(errorBody(err, "Circuit open for "+circuit_err.Host))
+	}
+	var job_err *JobError
+	if errors.As(err, &job_err) {
+		return c.Status(job_err.Status).JSON(errorBody(err, job_err.Message))
+	}
+	var policy_err *PolicyError
+	if errors.As(err, &policy_err) {
+		return c.Status(fiber.StatusForbidden).JSON(errorBody(err, policy_err.Message))
+	}
+	return err
+}
+
+// errorBody is the JSON body of a failed job, with the code and retryable
+// of its ErrorDetail
+func errorBody(err error, message string) fiber.Map {
+	detail := ClassifyError(err)
+	return fiber.Map{
+		"error":     message,
+		"code":      detail.Code,
+		"retryable": detail.Retryable,
+	}
+}
+
+// @title Proxy Worker API
+// @version 1.0
+// @description Proxy Worker API
+// @BasePath /
+// PerformProxyJob handles the proxy job request
+// @Description Handles the proxy job request and returns the response
+// @Param async query bool false "Queue the job and return its ID instead of waiting, see GET /jobs/{id}"
+func PerformProxyJob(c *fiber.Ctx) error {
+	logger := log.With().Str("handler", "PerformProxyJob").Logger()
 
-		return c.Status(response.StatusCode).JSON(fiber.Map{
-			"status_code": response.StatusCode,
-			"body":        response.Body,
-			"errs":        response.Errs,
+	var job ProxyJob
+	if err := c.BodyParser(&job); err != nil {
+		logger.Error().Err(err).Msg("Failed to parse request body")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
 		})
 	}
+
+	if c.QueryBool("async") {
+		return SubmitAsyncJob(c, job)
+	}
+
+	response, err := ExecuteJob(requestContext(c), job)
+	if err != nil {
+		return sendJobError(c, err)
+	}
+
+	if len(response.Errs) > 0 {
+		// timeouts are answered with 504, other upstream failures with 502
+		details := ErrorDetails(response.Errs)
+		body := errorBody(details[0], "Upstream request failed: "+details[0].Message)
+		body["errs"] = details
+		body["attempts"] = response.Attempts
+		body["attempt_errors"] = response.AttemptErrors
+		return c.Status(failureStatus(details)).JSON(body)
+	}
+
+	logger.Info().
+		Int("status_code", response.StatusCode).
+		Int("body_size", len(response.Body)).
+		Msg("Sending response")
+
+	return c.Status(response.StatusCode).JSON(response)
 }
 
 // @title Proxy Worker API
@@ -168,16 +1014,86 @@ func Docs(c *fiber.Ctx) error {
 }
 
 func main() {
-	// Configure zerolog
+	cfg, err := server_config.Load(os.Getenv("PROXIER_CONFIG_FILE"))
+	if err != nil {
+		// the logger is not set up yet
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
 
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+	if err := configureLogger(LogConfig{Level: cfg.LogLevel, Format: cfg.LogFormat}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if cfg.ScriptsFile != "" {
+		registry, err := LoadScripts(cfg.ScriptsFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load response scripts")
+		}
+		scripts = registry
+	}
+
+	if cfg.RewriteRulesFile != "" {
+		rules, err := LoadRewriteRules(cfg.RewriteRulesFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load rewrite rules")
+		}
+		rewriteRules = rules
+	}
+
+	if cfg.DomainLimitsFile != "" {
+		limits, err := LoadDomainLimits(cfg.DomainLimitsFile)
+		if err == nil {
+			err = domainLimits.Set(limits)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load domain rate limits")
+		}
+	}
+
+	if cfg.APIKeysFile != "" {
+		store, err := LoadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load API keys")
+		}
+		apiKeys = store
+	} else {
+		log.Warn().Msg("No API keys configured, the proxy endpoints are open to anyone who can reach them")
+	}
 
 	app := fiber.New()
-	app.Post("/proxy", PerformProxyJob)
-	app.Get("/health", func(c *fiber.Ctx) error {
-		return c.SendString("OK")
-	})
+	app.Post("/proxy", RequireAPIKey, PerformProxyJob)
+	app.Post("/proxy/batch", RequireBatchAPIKey, PerformProxyBatch)
+	app.Post("/proxy/stream", RequireAPIKey, PerformProxyStream)
+	app.Get("/proxy/ws", RequireAPIKey, PrepareWebSocket, websocket.New(RelayWebSocket))
+	app.Get("/livez", Livez)
+	app.Get("/readyz", Readyz)
+	// kept for probes configured before /livez
+	app.Get("/health", Livez)
+	app.Get("/bodies/:id", RequireAPIKey, GetBodyChunk)
+	app.Get("/jobs", RequireAPIKey, ListJobs)
+	app.Get("/jobs/:id", RequireAPIKey, GetJob)
+	app.Delete("/sessions/:id", RequireAPIKey, DeleteSession)
+	app.Get("/recordings", RequireAPIKey, GetRecordings)
+	app.Get("/recordings/export", RequireAPIKey, ExportRecordings)
+	app.Delete("/cache", RequireAdminKey, DeleteCache)
+	app.Get("/proxies/status", RequireAPIKey, GetProxiesStatus)
+	app.Get("/workers/status", RequireAPIKey, GetWorkersStatus)
+	app.Get("/breakers", RequireAPIKey, GetBreakers)
+	app.Delete("/breakers", RequireAdminKey, ResetBreakers)
+	app.Get("/backoffs", RequireAPIKey, GetBackoffs)
+	app.Get("/domain-limits", RequireAPIKey, GetDomainLimits)
+	switch cfg.ClusterMode {
+	case "coordinator":
+		app.Post("/cluster/heartbeat", RequireClusterToken, PostClusterHeartbeat)
+		app.Get("/cluster/workers", RequireAPIKey, GetClusterWorkers)
+	case "worker":
+		app.Post("/cluster/execute", RequireClusterToken, ExecuteClusterJob)
+	}
+	app.Put("/domain-limits", RequireAdminKey, PutDomainLimits)
+	app.Get("/metrics", RequireAPIKey, MetricsPrometheus)
+	app.Get("/metrics.json", RequireAPIKey, MetricsJSON)
 	app.Get("/docs", Docs)
 	app.Get("/proxy", Docs)
 	app.Get("/swagger/*", swagger.HandlerDefault) // default
@@ -196,6 +1112,195 @@ func main() {
 	// 	OAuth2RedirectUrl: "http://localhost:3010/swagger/oauth2-redirect.html",
 	// }))
 
-	log.Info().Msg("Starting server on :3010")
-	log.Fatal().Err(app.Listen(":3010")).Msg("Server stopped")
+	defaultJobTimeout = time.Duration(cfg.DefaultTimeout)
+	maxBodyBytes = cfg.MaxBodyBytes
+	maxStreamBodyBytes = cfg.StreamMaxBodyBytes
+	maxResponseHeaders = cfg.ResponseMaxHeaders
+	maxResponseHeaderBytes = cfg.ResponseMaxHeaderBytes
+	maxResponseHeaderValueBytes = cfg.ResponseMaxHeaderValueBytes
+	oversizedHeaderAction = cfg.ResponseOversizedHeader
+	wsIdleTimeout = time.Duration(cfg.WSIdleTimeout)
+	wsMaxMessageBytes = cfg.WSMaxMessageBytes
+	batchConcurrency = cfg.BatchConcurrency
+	batchMaxJobs = cfg.BatchMaxJobs
+
+	allow_cidrs, err := ParseCIDRs(cfg.TargetAllowCIDRs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid target_allow_cidrs")
+	}
+	deny_cidrs, err := ParseCIDRs(cfg.TargetDenyCIDRs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid target_deny_cidrs")
+	}
+	switch cfg.CacheBackend {
+	case "memory":
+		responseCache = NewMemoryCache(cfg.CacheMaxEntries)
+	case "redis":
+		cache, err := NewRedisCache(cfg.CacheRedisURL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to connect to the Redis cache")
+		}
+		responseCache = cache
+	}
+	defaultCacheTTL = time.Duration(cfg.CacheTTL)
+
+	targetPolicy = &TargetPolicy{
+		AllowSchemes:  cfg.TargetAllowSchemes,
+		AllowHosts:    cfg.TargetAllowHosts,
+		DenyHosts:     cfg.TargetDenyHosts,
+		AllowCIDRs:    allow_cidrs,
+		DenyCIDRs:     deny_cidrs,
+		AllowPrivate:  cfg.AllowPrivateTargets,
+		AllowlistOnly: cfg.TargetMode == "allowlist_only",
+	}
+	if targetPolicy.AllowlistOnly {
+		log.Info().Strs("allow_hosts", targetPolicy.AllowHosts).Msg("Only allow listed hosts can be reached")
+	}
+	if targetPolicy.AllowPrivate {
+		log.Warn().Msg("Private targets allowed, jobs can reach internal addresses")
+	}
+
+	if cfg.UpstreamProxy != "" {
+		if _, err := ProxyDialer(cfg.UpstreamProxy); err != nil {
+			log.Fatal().Err(err).Msg("Invalid upstream proxy")
+		}
+		upstreamProxy = cfg.UpstreamProxy
+	}
+
+	pool_source := cfg.ProxyPoolFile
+	if pool_source == "" {
+		pool_source = cfg.ProxyPoolURL
+	}
+	if pool_source != "" {
+		proxies, err := LoadProxyList(pool_source)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load proxy pool")
+		}
+		pool, err := NewProxyPool(proxies, cfg.ProxyRotation)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid proxy pool")
+		}
+		proxyPool = pool
+		log.Info().Int("proxies", len(proxies)).Str("rotation", pool.strategy).Msg("Loaded proxy pool")
+
+		if cfg.ProxyCheckURL != "" {
+			check := HealthCheck{
+				URL:              cfg.ProxyCheckURL,
+				Interval:         time.Duration(cfg.ProxyCheckInterval),
+				Timeout:          defaultCheckTimeout,
+				FailureThreshold: cfg.ProxyCheckFailures,
+			}
+			if check.Interval < check.Timeout {
+				check.Timeout = check.Interval
+			}
+			go pool.RunHealthChecks(check)
+		}
+	}
+
+	if cfg.BreakerFailures > 0 {
+		breakers = NewCircuitBreakers(cfg.BreakerFailures, time.Duration(cfg.BreakerCooldown), cfg.BreakerPerProxy)
+	}
+	if cfg.HostBackoffBase > 0 {
+		hostBackoffs = NewHostBackoff(time.Duration(cfg.HostBackoffBase), time.Duration(cfg.HostBackoffMax))
+	}
+	if cfg.HistoryDSN != "" {
+		opened, err := OpenHistory(cfg.HistoryDriver, cfg.HistoryDSN, time.Duration(cfg.HistoryRetention), cfg.HistoryMaxBodyBytes)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open the job history")
+		}
+		history = opened
+		log.Info().Str("driver", cfg.HistoryDriver).Dur("retention", time.Duration(cfg.HistoryRetention)).Msg("Recording job history")
+	}
+	if cfg.RecordingSize > 0 {
+		recorder = NewRecorder(cfg.RecordingSize, cfg.RecordingMaxBodyBytes)
+	}
+	sessions = NewSessionStore(time.Duration(cfg.SessionTTL), cfg.MaxSessions)
+	workerPool = NewWorkerPool(cfg.Workers, cfg.WorkerQueueSize, time.Duration(cfg.WorkerQueueWait))
+	asyncJobs = NewJobQueue(NewJobStore(asyncJobTTL), cfg.AsyncWorkers, cfg.AsyncQueueSize)
+
+	chain, err := LoadJobMiddlewares(cfg.JobMiddlewares)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load job middlewares")
+	}
+	jobMiddlewares = chain
+
+	if cfg.SigningSchemesFile != "" {
+		schemes, err := LoadSigningSchemes(cfg.SigningSchemesFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load signing schemes")
+		}
+		signingSchemes = schemes
+	}
+
+	if cfg.DeadLetterFile != "" {
+		deadLetterSinks = append(deadLetterSinks, NewFileDeadLetterSink(cfg.DeadLetterFile))
+	}
+	if cfg.DeadLetterURL != "" {
+		deadLetterSinks = append(deadLetterSinks, NewHTTPDeadLetterSink(cfg.DeadLetterURL))
+	}
+
+	callbackMaxAttempts = cfg.WebhookMaxAttempts
+	if cfg.WebhookSecret != "" {
+		callbackSecret = []byte(cfg.WebhookSecret)
+	} else {
+		log.Warn().Msg("No webhook secret set, job callbacks are not signed")
+	}
+
+	clusterToken = cfg.ClusterToken
+	switch cfg.ClusterMode {
+	case "coordinator":
+		coordinator = NewCoordinator(time.Duration(cfg.ClusterHeartbeatInterval))
+		log.Info().Msg("Running as the cluster coordinator, jobs are dispatched to workers")
+	case "worker":
+		worker_id := cfg.ClusterWorkerID
+		if worker_id == "" {
+			worker_id, _ = os.Hostname()
+		}
+		clusterNode = NewClusterNode(cfg.ClusterCoordinatorURL, ClusterWorker{
+			ID:   worker_id,
+			URL:  cfg.ClusterAdvertiseURL,
+			Tags: ParseClusterTags(cfg.ClusterTags),
+		})
+		go clusterNode.Run(time.Duration(cfg.ClusterHeartbeatInterval))
+	}
+
+	if cfg.TLSCertFile != "" {
+		loaded, err := LoadServerTLS(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load TLS certificate")
+		}
+		serverTLS = loaded
+		if cfg.TLSReloadInterval > 0 {
+			go serverTLS.Watch(time.Duration(cfg.TLSReloadInterval))
+		}
+	}
+
+	grpc_server := NewGRPCServer()
+	go func() {
+		if err := ServeGRPC(grpc_server, cfg.GRPCAddr); err != nil {
+			log.Fatal().Err(err).Msg("gRPC server stopped")
+		}
+	}()
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to listen")
+	}
+	if serverTLS != nil {
+		listener = tls.NewListener(listener, serverTLS.Config())
+	}
+	log.Info().Str("addr", cfg.Addr).Bool("tls", serverTLS != nil).Bool("mutual_tls", serverTLS != nil && serverTLS.Mutual()).Msg("Starting server")
+	go func() {
+		if err := app.Listener(listener); err != nil {
+			log.Fatal().Err(err).Msg("Server stopped")
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	received := <-signals
+	// a second signal exits right away
+	signal.Stop(signals)
+	log.Info().Str("signal", received.String()).Dur("timeout", time.Duration(cfg.ShutdownTimeout)).Msg("Shutting down")
+	Shutdown(app, grpc_server, time.Duration(cfg.ShutdownTimeout))
 }
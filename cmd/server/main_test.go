@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+// TestMain lets jobs reach the httptest servers on the loopback address
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	targetPolicy = &TargetPolicy{AllowSchemes: []string{"http", "https"}, AllowPrivate: true}
+	sessions = NewSessionStore(time.Minute, 100)
+	os.Exit(m.Run())
+}
+
+// postJob posts the job to POST /proxy, returning the status and body
+func postJob(t *testing.T, job ProxyJob) (int, []byte) {
+	t.Helper()
+	app := fiber.New()
+	app.Post("/proxy", PerformProxyJob)
+	body, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(fiber.MethodPost, "/proxy", strings.NewReader(string(body)))
+	request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	return response.StatusCode, data
+}
+
+func TestSlowUpstreamTimesOut(t *testing.T) {
+	disconnected := make(chan time.Time, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			disconnected <- time.Now()
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	previous := workerPool
+	workerPool = NewWorkerPool(1, 0, time.Second)
+	defer func() { workerPool = previous }()
+
+	start := time.Now()
+	_, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet, TimeoutMs: 200})
+	var job_err *JobError
+	if !errors.As(err, &job_err) || job_err.Status != http.StatusGatewayTimeout {
+		t.Fatalf("err = %v, want a 504 timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timed out after %s, want about 200ms", elapsed)
+	}
+
+	// the upstream call is aborted rather than left running
+	select {
+	case at := <-disconnected:
+		if at.Sub(start) > time.Second {
+			t.Fatalf("upstream disconnected %s after the job started", at.Sub(start))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream connection still open after the timeout")
+	}
+	// and the request goroutine gives its worker back
+	deadline := time.Now().Add(time.Second)
+	for workerPool.busy.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("worker still busy after the timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    int
+		timeout_ms int
+		want       time.Duration
+		invalid    bool
+	}{
+		{"default", 0, 0, defaultJobTimeout, false},
+		{"seconds", 5, 0, 5 * time.Second, false},
+		{"one second", 1, 0, time.Second, false},
+		{"maximum", int(maxTimeout / time.Second), 0, maxTimeout, false},
+		{"milliseconds", 0, 1500, 1500 * time.Millisecond, false},
+		{"milliseconds win", 5, 250, 250 * time.Millisecond, false},
+		{"negative", -1, 0, 0, true},
+		{"too large", int(maxTimeout/time.Second) + 1, 0, 0, true},
+		{"negative milliseconds", 0, -1, 0, true},
+		{"too many milliseconds", 0, int(maxTimeout/time.Millisecond) + 1, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := ProxyJob{URL: "http://127.0.0.1/", Method: http.MethodGet, Timeout: test.timeout, TimeoutMs: test.timeout_ms}
+			_, err := ValidateJob(context.Background(), job, maxBodyBytes)
+			if test.invalid {
+				var job_err *JobError
+				if !errors.As(err, &job_err) || job_err.Status != http.StatusBadRequest {
+					t.Fatalf("err = %v, want a 400", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := JobTimeout(job); got != test.want {
+				t.Fatalf("timeout = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMethods(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", "GET, PATCH, HEAD, OPTIONS")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.Method + " " + string(body)))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		sent   string
+		status int
+		want   string
+	}{
+		{"patch", http.MethodPatch, `{"name": "new"}`, http.MethodPatch, http.StatusOK, `PATCH {"name": "new"}`},
+		{"head without a body", http.MethodHead, "", http.MethodHead, http.StatusOK, ""},
+		{"options", http.MethodOptions, "", http.MethodOptions, http.StatusNoContent, ""},
+		{"lowercase", "patch", "x", http.MethodPatch, http.StatusOK, "PATCH x"},
+		{"mixed case", "Get", "", http.MethodGet, http.StatusOK, "GET "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: test.method, Body: test.body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) > 0 {
+				t.Fatal(response.Errs)
+			}
+			if response.StatusCode != test.status || string(response.Body) != test.want {
+				t.Fatalf("got %d %q, want %d %q", response.StatusCode, response.Body, test.status, test.want)
+			}
+			if got := headerValue(response.Headers, "X-Method"); got != test.sent {
+				t.Fatalf("upstream got %s, want %s", got, test.sent)
+			}
+			if test.method == http.MethodOptions && headerValue(response.Headers, "Allow") == "" {
+				t.Fatal("options response has no Allow header")
+			}
+		})
+	}
+
+	_, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: "BREW"})
+	if err == nil || ClassifyError(err).Code != ErrorInvalidJob {
+		t.Fatalf("err = %v, want an invalid_job error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// maxMetaRefreshes caps how many meta refresh redirects are followed per job
+const maxMetaRefreshes = 5
+
+var (
+	metaTagPattern     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern   = regexp.MustCompile(`(?s)([\w-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	refreshURL         = regexp.MustCompile(`(?i)^\s*\d*(?:\.\d*)?\s*[;,]?\s*(?:url\s*=\s*)?['"]?([^'"]*)['"]?\s*$`)
+	jsRedirectPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?:window\.|document\.|top\.|self\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']`),
+		regexp.MustCompile(`(?:window\.|document\.|top\.|self\.)?location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`),
+	}
+)
+
+// isHTML sniffs the body, pages are often served without a text/html Content-Type
+func isHTML(body []byte) bool {
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
+
+// FindMetaRefresh returns the target of a <meta http-equiv="refresh"> tag
+func FindMetaRefresh(body []byte) string {
+	for _, tag := range metaTagPattern.FindAll(body, -1) {
+		attributes := make(map[string]string)
+		for _, match := range attributePattern.FindAllSubmatch(tag, -1) {
+			attributes[strings.ToLower(string(match[1]))] = string(match[2]) + string(match[3]) + string(match[4])
+		}
+		if !strings.EqualFold(attributes["http-equiv"], "refresh") {
+			continue
+		}
+		if match := refreshURL.FindStringSubmatch(attributes["content"]); match != nil {
+			return strings.TrimSpace(match[1])
+		}
+	}
+	return ""
+}
+
+// FindJSRedirect returns the target of a common JavaScript redirect
+func FindJSRedirect(body []byte) string {
+	for _, pattern := range jsRedirectPatterns {
+		if match := pattern.FindSubmatch(body); match != nil {
+			return string(match[1])
+		}
+	}
+	return ""
+}
+
+// FollowHTMLRedirects follows meta refresh redirects of an HTML response and/or
+// reports a detected JavaScript redirect, depending on the job settings
+func FollowHTMLRedirects(ctx context.Context, client *fiber.Client, job ProxyJob, response ProxyResponse) ProxyResponse {
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Logger()
+
+	page_url := job.URL
+	if response.FinalURL != "" {
+		page_url = response.FinalURL
+	}
+	for job.FollowMetaRefresh && response.MetaRefreshes < maxMetaRefreshes && isHTML(response.Body) {
+		target := FindMetaRefresh(response.Body)
+		if target == "" {
+			break
+		}
+		next_url, err := resolveURL(page_url, target)
+		if err != nil || next_url == page_url {
+			break
+		}
+		// the target comes from the page, it may name any scheme or host
+		if err := targetPolicy.CheckURL(next_url); err != nil {
+			logger.Warn().Err(err).Str("target", next_url).Msg("Meta refresh blocked by policy")
+			response.Errs = []error{err}
+			return response
+		}
+
+		if err := domainLimits.Wait(ctx, next_url); err != nil {
+			response.Errs = []error{err}
+			return response
+		}
+		logger.Debug().Str("target", next_url).Msg("Following meta refresh")
+		agent := NewAgent(client, fiber.MethodGet, next_url)
+		follow_job := job
+		follow_job.Body = ""
+		if !sameHost(job.URL, next_url) {
+			follow_job = withoutCredentials(follow_job)
+		}
+		if err := PrepareAgent(agent, follow_job); err != nil {
+			fiber.ReleaseAgent(agent)
+			response.Errs = []error{err}
+			return response
+		}
+		ApplyDeadline(ctx, agent)
+
+		resp := AcquireUpstreamResponse()
+		agent.SetResponse(resp)
+		status_code, body, errs := SendRequest(agent)
+		headers, truncated := CaptureHeaders(resp, job.KeepHopByHopHeaders)
+		fiber.ReleaseResponse(resp)
+		job.session.Record(next_url, headers)
+		if len(errs) > 0 {
+			response.Errs = errs
+			return response
+		}
+
+		page_url = next_url
+		response.StatusCode = status_code
+		response.Body = body
+		response.Headers = headers
+		response.HeadersTruncated = truncated
+		response.MetaRefreshes++
+	}
+	if response.MetaRefreshes > 0 || response.Redirects > 0 {
+		response.FinalURL = page_url
+	}
+
+	if job.DetectJSRedirect && isHTML(response.Body) {
+		if target := FindJSRedirect(response.Body); target != "" {
+			if resolved, err := resolveURL(page_url, target); err == nil {
+				response.JSRedirect = resolved
+			}
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetaRefreshCredentials(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ := r.Cookie("session")
+		var session string
+		if cookie != nil {
+			session = cookie.Value
+		}
+		fmt.Fprintf(w, "%q %q %q", r.Header.Get("Authorization"), r.Header.Get("X-Api-Key"), session)
+	}
+	other := httptest.NewServer(http.HandlerFunc(echo))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same-host":
+			fmt.Fprint(w, `<html><head><meta http-equiv="refresh" content="0; url=/landing"></head></html>`)
+		case "/other-host":
+			fmt.Fprintf(w, `<html><head><meta http-equiv="refresh" content="0; url=%s/landing"></head></html>`, other.URL)
+		default:
+			echo(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"same host keeps credentials", "/same-host", `"Basic dXNlcjpwYXNz" "secret" "abc"`},
+		{"other host drops credentials", "/other-host", `"" "" ""`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := ExecuteJob(context.Background(), ProxyJob{
+				URL:               server.URL + test.path,
+				Method:            http.MethodGet,
+				FollowMetaRefresh: true,
+				BasicAuthUser:     "user",
+				BasicAuthPass:     "pass",
+				AuthType:          AuthTypeBasic,
+				Headers:           map[string]string{"X-Api-Key": "secret"},
+				Cookies:           map[string]string{"session": "abc"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) > 0 {
+				t.Fatal(response.Errs)
+			}
+			if response.MetaRefreshes != 1 || string(response.Body) != test.body {
+				t.Fatalf("got %d meta refreshes and %s, want 1 and %s", response.MetaRefreshes, response.Body, test.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// metric names, kept identical to the names exported in Prometheus format
+const (
+	metricRequestsTotal    = "proxier_requests_total"
+	metricUpstreamDuration = "proxier_upstream_duration_seconds"
+	metricCacheHitDuration = "proxier_cache_hit_duration_seconds"
+	metricTimeoutsTotal    = "proxier_timeouts_total"
+	metricInFlight         = "proxier_in_flight_requests"
+	metricProxyRequests    = "proxier_upstream_proxy_requests_total"
+	metricQueueDepth       = "proxier_async_queue_depth"
+	metricWorkerQueueDepth = "proxier_worker_queue_depth"
+	metricWorkersBusy      = "proxier_workers_busy"
+	metricWorkerRejections = "proxier_worker_rejections_total"
+	metricWebSockets       = "proxier_websocket_connections"
+)
+
+// number of recent latency samples kept for percentile summaries
+const latencySampleSize = 1024
+
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type requestLabels struct {
+	Method string `json:"method"`
+	Status string `json:"status"`
+}
+
+type proxyLabels struct {
+	Proxy  string `json:"proxy"`
+	Result string `json:"result"`
+}
+
+// Histogram is a cumulative latency histogram that also keeps a window of
+// recent samples to report percentiles
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+	samples []float64
+	next    int
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+		samples: make([]float64, 0, latencySampleSize),
+	}
+}
+
+func (h *Histogram) observe(value float64) {
+	for i, le := range h.buckets {
+		if value <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+
+	if len(h.samples) < latencySampleSize {
+		h.samples = append(h.samples, value)
+	} else {
+		h.samples[h.next] = value
+		h.next = (h.next + 1) % latencySampleSize
+	}
+}
+
+func (h *Histogram) percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// snapshot copies the histogram with its percentiles, the metrics mutex must be held
+func (h *Histogram) snapshot() histogramSnapshot {
+	snapshot := histogramSnapshot{
+		Count:   h.count,
+		Sum:     h.sum,
+		Buckets: make([]bucketSnapshot, len(h.buckets)),
+	}
+	for i, le := range h.buckets {
+		snapshot.Buckets[i] = bucketSnapshot{LE: le, Count: h.counts[i]}
+	}
+	sorted := append([]float64(nil), h.samples...)
+	sort.Float64s(sorted)
+	snapshot.P50 = h.percentile(sorted, 0.50)
+	snapshot.P90 = h.percentile(sorted, 0.90)
+	snapshot.P99 = h.percentile(sorted, 0.99)
+	return snapshot
+}
+
+// Metrics holds the instrumentation of the proxy handlers
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	proxies  map[proxyLabels]uint64
+	timeouts uint64
+	inFlight int64
+	latency  *Histogram
+	// cacheHits is kept apart so hits don't pull the upstream latency down
+	cacheHits *Histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[requestLabels]uint64),
+		proxies:   make(map[proxyLabels]uint64),
+		latency:   NewHistogram(defaultLatencyBuckets),
+		cacheHits: NewHistogram(defaultLatencyBuckets),
+	}
+}
+
+var metrics = NewMetrics()
+
+// StatusClass maps a status code to its class label, 0 meaning the request errored
+func StatusClass(status_code int) string {
+	if status_code <= 0 {
+		return "error"
+	}
+	return strconv.Itoa(status_code/100) + "xx"
+}
+
+func (m *Metrics) IncRequest(method string, status_code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{Method: method, Status: StatusClass(status_code)}]++
+}
+
+// IncProxyRequest counts a job sent through an upstream proxy, the proxy
+// label being its URL without password
+func (m *Metrics) IncProxyRequest(proxy string, success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxies[proxyLabels{Proxy: proxy, Result: result}]++
+}
+
+func (m *Metrics) IncTimeout() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeouts++
+}
+
+func (m *Metrics) AddInFlight(delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight += delta
+}
+
+// InFlight is the number of jobs being performed
+func (m *Metrics) InFlight() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inFlight
+}
+
+func (m *Metrics) ObserveUpstream(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency.observe(d.Seconds())
+}
+
+// ObserveCacheHit records the time a job took to be served from the cache
+func (m *Metrics) ObserveCacheHit(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheHits.observe(d.Seconds())
+}
+
+type counterSnapshot struct {
+	Labels requestLabels `json:"labels"`
+	Value  uint64        `json:"value"`
+}
+
+type proxyCounterSnapshot struct {
+	Labels proxyLabels `json:"labels"`
+	Value  uint64      `json:"value"`
+}
+
+type bucketSnapshot struct {
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+type histogramSnapshot struct {
+	Count   uint64           `json:"count"`
+	Sum     float64          `json:"sum"`
+	Buckets []bucketSnapshot `json:"buckets"`
+	P50     float64          `json:"p50"`
+	P90     float64          `json:"p90"`
+	P99     float64          `json:"p99"`
+}
+
+// MetricsSnapshot is a point in time copy of all metrics
+type MetricsSnapshot struct {
+	Requests []counterSnapshot `json:"proxier_requests_total"`
+	Upstream histogramSnapshot `json:"proxier_upstream_duration_seconds"`
+	CacheHit histogramSnapshot `json:"proxier_cache_hit_duration_seconds"`
+	Timeouts uint64            `json:"proxier_timeouts_total"`
+	InFlight int64             `json:"proxier_in_flight_requests"`
+
+	Proxies    []proxyCounterSnapshot `json:"proxier_upstream_proxy_requests_total"`
+	QueueDepth int                    `json:"proxier_async_queue_depth"`
+
+	WorkerQueueDepth int    `json:"proxier_worker_queue_depth"`
+	WorkersBusy      int64  `json:"proxier_workers_busy"`
+	WorkerRejections uint64 `json:"proxier_worker_rejections_total"`
+	WebSockets       int64  `json:"proxier_websocket_connections"`
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Requests: make([]counterSnapshot, 0, len(m.requests)),
+		Timeouts: m.timeouts,
+		InFlight: m.inFlight,
+	}
+	for labels, value := range m.requests {
+		snapshot.Requests = append(snapshot.Requests, counterSnapshot{Labels: labels, Value: value})
+	}
+	sort.Slice(snapshot.Requests, func(i, j int) bool {
+		a, b := snapshot.Requests[i].Labels, snapshot.Requests[j].Labels
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Status < b.Status
+	})
+
+	snapshot.Proxies = make([]proxyCounterSnapshot, 0, len(m.proxies))
+	for labels, value := range m.proxies {
+		snapshot.Proxies = append(snapshot.Proxies, proxyCounterSnapshot{Labels: labels, Value: value})
+	}
+	sort.Slice(snapshot.Proxies, func(i, j int) bool {
+		a, b := snapshot.Proxies[i].Labels, snapshot.Proxies[j].Labels
+		if a.Proxy != b.Proxy {
+			return a.Proxy < b.Proxy
+		}
+		return a.Result < b.Result
+	})
+
+	if asyncJobs != nil {
+		snapshot.QueueDepth = asyncJobs.Depth()
+	}
+	if workerPool != nil {
+		snapshot.WorkerQueueDepth = workerPool.Depth()
+		snapshot.WorkersBusy = workerPool.busy.Load()
+		snapshot.WorkerRejections = workerPool.rejected.Load()
+	}
+	snapshot.WebSockets = wsConnections.Load()
+
+	snapshot.Upstream = m.latency.snapshot()
+	snapshot.CacheHit = m.cacheHits.snapshot()
+
+	return snapshot
+}
+
+// MetricsJSON exposes the proxy metrics as JSON
+// @Description Returns the proxy metrics as JSON with percentile summaries
+func MetricsJSON(c *fiber.Ctx) error {
+	return c.JSON(metrics.Snapshot())
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeHistogram(buf *bytes.Buffer, name string, help string, h histogramSnapshot) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	for _, bucket := range h.Buckets {
+		fmt.Fprintf(buf, "%s_bucket{le=%q} %d\n", name, formatFloat(bucket.LE), bucket.Count)
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+	fmt.Fprintf(buf, "%s_sum %s\n", name, formatFloat(h.Sum))
+	fmt.Fprintf(buf, "%s_count %d\n", name, h.Count)
+}
+
+// Prometheus renders the snapshot in the Prometheus text exposition format
+func (s MetricsSnapshot) Prometheus() []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# HELP %s Proxy requests by method and upstream status class.\n", metricRequestsTotal)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", metricRequestsTotal)
+	for _, counter := range s.Requests {
+		fmt.Fprintf(&buf, "%s{method=%q,status=%q} %d\n", metricRequestsTotal, counter.Labels.Method, counter.Labels.Status, counter.Value)
+	}
+
+	writeHistogram(&buf, metricUpstreamDuration, "Upstream round trip latency.", s.Upstream)
+	writeHistogram(&buf, metricCacheHitDuration, "Latency of jobs served from the response cache.", s.CacheHit)
+
+	fmt.Fprintf(&buf, "# HELP %s Proxy requests that hit their deadline.\n", metricTimeoutsTotal)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", metricTimeoutsTotal)
+	fmt.Fprintf(&buf, "%s %d\n", metricTimeoutsTotal, s.Timeouts)
+
+	fmt.Fprintf(&buf, "# HELP %s Proxy requests currently being performed.\n", metricInFlight)
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", metricInFlight)
+	fmt.Fprintf(&buf, "%s %d\n", metricInFlight, s.InFlight)
+
+	fmt.Fprintf(&buf, "# HELP %s Jobs sent through an upstream proxy by proxy and result.\n", metricProxyRequests)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", metricProxyRequests)
+	for _, counter := range s.Proxies {
+		fmt.Fprintf(&buf, "%s{proxy=%q,result=%q} %d\n", metricProxyRequests, counter.Labels.Proxy, counter.Labels.Result, counter.Value)
+	}
+
+	fmt.Fprintf(&buf, "# HELP %s Async jobs waiting for a worker.\n", metricQueueDepth)
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", metricQueueDepth)
+	fmt.Fprintf(&buf, "%s %d\n", metricQueueDepth, s.QueueDepth)
+
+	fmt.Fprintf(&buf, "# HELP %s Jobs waiting for a worker of the pool.\n", metricWorkerQueueDepth)
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", metricWorkerQueueDepth)
+	fmt.Fprintf(&buf, "%s %d\n", metricWorkerQueueDepth, s.WorkerQueueDepth)
+
+	fmt.Fprintf(&buf, "# HELP %s Workers of the pool performing a job.\n", metricWorkersBusy)
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", metricWorkersBusy)
+	fmt.Fprintf(&buf, "%s %d\n", metricWorkersBusy, s.WorkersBusy)
+
+	fmt.Fprintf(&buf, "# HELP %s Jobs shed because the worker queue was full.\n", metricWorkerRejections)
+	fmt.Fprintf(&buf, "# TYPE %s counter\n", metricWorkerRejections)
+	fmt.Fprintf(&buf, "%s %d\n", metricWorkerRejections, s.WorkerRejections)
+
+	fmt.Fprintf(&buf, "# HELP %s Open WebSocket tunnels.\n", metricWebSockets)
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", metricWebSockets)
+	fmt.Fprintf(&buf, "%s %d\n", metricWebSockets, s.WebSockets)
+
+	return buf.Bytes()
+}
+
+// MetricsPrometheus exposes the proxy metrics in the Prometheus text format
+// @Description Returns the proxy metrics in the Prometheus text format
+func MetricsPrometheus(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.Send(metrics.Snapshot().Prometheus())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{0, "error"},
+		{200, "2xx"},
+		{204, "2xx"},
+		{302, "3xx"},
+		{404, "4xx"},
+		{503, "5xx"},
+	}
+	for _, test := range tests {
+		if got := StatusClass(test.status); got != test.want {
+			t.Errorf("StatusClass(%d) = %q, want %q", test.status, got, test.want)
+		}
+	}
+}
+
+// scrapeMetrics returns the lines of GET /metrics
+func scrapeMetrics(t *testing.T) []string {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/metrics", MetricsPrometheus)
+	response, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/metrics", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content_type := response.Header.Get(fiber.HeaderContentType); !strings.HasPrefix(content_type, "text/plain; version=0.0.4") {
+		t.Fatalf("content type = %q, want the Prometheus text format", content_type)
+	}
+	data, _ := io.ReadAll(response.Body)
+	return strings.Split(string(data), "\n")
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	previous := metrics
+	metrics = NewMetrics()
+	defer func() { metrics = previous }()
+
+	server := statusServer(t)
+	jobs := []ProxyJob{
+		{URL: server.URL + "/status/200", Method: http.MethodGet},
+		{URL: server.URL + "/status/201", Method: http.MethodGet},
+		{URL: server.URL + "/status/404", Method: http.MethodGet},
+		{URL: server.URL + "/status/503", Method: http.MethodPost},
+		{URL: refusedURL(t), Method: http.MethodGet},
+		{URL: server.URL + "/delay/300/status/200", Method: http.MethodGet, TimeoutMs: 50},
+	}
+	for _, job := range jobs {
+		ExecuteJob(context.Background(), job)
+	}
+
+	lines := scrapeMetrics(t)
+	tests := []string{
+		`proxier_requests_total{method="GET",status="2xx"} 2`,
+		`proxier_requests_total{method="GET",status="4xx"} 1`,
+		`proxier_requests_total{method="POST",status="5xx"} 1`,
+		// the refused and the timed out job
+		`proxier_requests_total{method="GET",status="error"} 2`,
+		`proxier_timeouts_total 1`,
+		`proxier_in_flight_requests 0`,
+		`# TYPE proxier_upstream_duration_seconds histogram`,
+	}
+	for _, want := range tests {
+		found := false
+		for _, line := range lines {
+			if line == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+
+	snapshot := metrics.Snapshot()
+	if snapshot.Upstream.Count < 5 {
+		t.Errorf("upstream latency count = %d, want one per upstream round trip", snapshot.Upstream.Count)
+	}
+	// buckets are cumulative
+	for i := 1; i < len(snapshot.Upstream.Buckets); i++ {
+		if snapshot.Upstream.Buckets[i].Count < snapshot.Upstream.Buckets[i-1].Count {
+			t.Fatalf("bucket %v below the one before", snapshot.Upstream.Buckets[i])
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	PaginationModeLinkHeader = "link_header"
+	PaginationModeJSONPath   = "json_path"
+
+	defaultMaxPages = 10
+)
+
+// PaginationOptions represents the structure of the pagination settings of a proxy job
+// @Description Pagination settings for following next links
+// @Param mode query string true "Where to find the next link: link_header or json_path"
+// @Param next_path query string false "JSONPath to the next link in the body, e.g. $.links.next"
+// @Param max_pages query int false "Maximum number of pages to fetch"
+// @Param delay_ms query int false "Delay between pages in milliseconds"
+type PaginationOptions struct {
+	Mode     string `json:"mode"`
+	NextPath string `json:"next_path"`
+	MaxPages int    `json:"max_pages"`
+	DelayMs  int    `json:"delay_ms"`
+}
+
+// PerformPaginatedRequest performs the job and keeps following next links
+// until there are none left, max_pages is reached or the context expires.
+// The first page is fetched with the given agent.
+func PerformPaginatedRequest(ctx context.Context, client *fiber.Client, agent *fiber.Agent, job ProxyJob, response_chan chan ProxyResponse) {
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Logger()
+
+	options := *job.Pagination
+	if options.MaxPages <= 0 {
+		options.MaxPages = defaultMaxPages
+	}
+
+	var result ProxyResponse
+	page_url := job.URL
+	for requests := 0; ; requests++ {
+		// the first page was let through by ExecuteJob
+		if requests > 0 {
+			if err := domainLimits.Wait(ctx, page_url); err != nil {
+				result.Errs = []error{err}
+				break
+			}
+		}
+		if agent == nil {
+			agent = NewAgent(client, job.Method, page_url)
+		}
+		status_code, body, headers, truncated, next, retry_after, errs := fetchPage(ctx, agent, job, options)
+		agent = nil
+
+		if len(errs) > 0 {
+			logger.Error().Errs("errors", errs).Int("page", result.PageCount+1).Msg("Page request failed")
+			result.Errs = errs
+			break
+		}
+
+		if status_code == fiber.StatusTooManyRequests && retry_after > 0 {
+			logger.Warn().Dur("retry_after", retry_after).Msg("Rate limited while paginating")
+			if err := wait(ctx, retry_after); err != nil {
+				result.StatusCode = status_code
+				result.Errs = []error{err}
+				break
+			}
+			continue
+		}
+
+		result.StatusCode = status_code
+		result.Body = body
+		result.Headers = headers
+		result.HeadersTruncated = truncated
+		result.Pages = append(result.Pages, body)
+		result.PageCount++
+
+		if status_code >= 300 || next == "" || result.PageCount >= options.MaxPages {
+			break
+		}
+
+		next_url, err := resolveURL(page_url, next)
+		if err != nil {
+			result.Errs = []error{err}
+			break
+		}
+		// next links come from the upstream, an agent for a scheme other than
+		// http or https would have no host client
+		if err := targetPolicy.CheckURL(next_url); err != nil {
+			logger.Warn().Err(err).Str("next", next_url).Msg("Next page blocked by policy")
+			result.Errs = []error{err}
+			break
+		}
+		page_url = next_url
+
+		if err := wait(ctx, time.Duration(options.DelayMs)*time.Millisecond); err != nil {
+			result.Errs = []error{err}
+			break
+		}
+	}
+
+	logger.Info().Int("status_code", result.StatusCode).Int("pages", result.PageCount).Msg("Paginated request completed")
+	response_chan <- result
+}
+
+// fetchPage sends a single page request and extracts the next link and any Retry-After delay
+func fetchPage(ctx context.Context, agent *fiber.Agent, job ProxyJob, options PaginationOptions) (int, []byte, map[string][]string, bool, string, time.Duration, []error) {
+	// the agent is released once the request is sent
+	page_url := agent.Request().URI().String()
+	if err := PrepareAgent(agent, job); err != nil {
+		fiber.ReleaseAgent(agent)
+		return 0, nil, nil, false, "", 0, []error{err}
+	}
+	ApplyDeadline(ctx, agent)
+
+	resp := AcquireUpstreamResponse()
+	defer fiber.ReleaseResponse(resp)
+	agent.SetResponse(resp)
+
+	status_code, body, errs := SendRequest(agent)
+	if len(errs) > 0 {
+		return 0, nil, nil, false, "", 0, errs
+	}
+
+	var retry_after time.Duration
+	if seconds, err := strconv.Atoi(string(resp.Header.Peek(fiber.HeaderRetryAfter))); err == nil && seconds > 0 {
+		retry_after = time.Duration(seconds) * time.Second
+	}
+
+	var next string
+	switch options.Mode {
+	case PaginationModeJSONPath:
+		next = lookupJSONPath(body, options.NextPath)
+	default:
+		next = parseLinkNext(string(resp.Header.Peek(fiber.HeaderLink)))
+	}
+
+	headers, truncated := CaptureHeaders(resp, job.KeepHopByHopHeaders)
+	job.session.Record(page_url, headers)
+	return status_code, body, headers, truncated, next, retry_after, nil
+}
+
+// parseLinkNext returns the rel="next" target of a Link header
+func parseLinkNext(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || !strings.EqualFold(key, "rel") {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+				if strings.EqualFold(rel, "next") {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// lookupJSONPath resolves a simple JSONPath such as $.links.next or $.pages[0].url
+// against a JSON body and returns the string found there
+func lookupJSONPath(body []byte, path string) string {
+	var node interface{}
+	if err := json.Unmarshal(body, &node); err != nil {
+		return ""
+	}
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.ReplaceAll(path, "[", ".")
+	path = strings.ReplaceAll(path, "]", "")
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch value := node.(type) {
+			case map[string]interface{}:
+				node = value[key]
+			case []interface{}:
+				index, err := strconv.Atoi(key)
+				if err != nil || index < 0 || index >= len(value) {
+					return ""
+				}
+				node = value[index]
+			default:
+				return ""
+			}
+		}
+	}
+
+	next, _ := node.(string)
+	return next
+}
+
+// resolveURL resolves a possibly relative next link against the current page URL
+func resolveURL(base string, next string) (string, error) {
+	base_url, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	next_url, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+	return base_url.ResolveReference(next_url).String(), nil
+}
+
+// wait sleeps for the given duration unless the context expires first
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return errors.New("timeout budget exhausted")
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseLinkNext(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{`<https://api.example.com/items?page=2>; rel="next"`, "https://api.example.com/items?page=2"},
+		{`</items?page=1>; rel="prev", </items?page=3>; rel="next"`, "/items?page=3"},
+		{`</items?page=3>; rel="next last"`, "/items?page=3"},
+		{`</items?page=3>; REL=Next`, "/items?page=3"},
+		{`</items?page=1>; rel="prev"`, ""},
+		{`/items?page=3; rel="next"`, ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := parseLinkNext(test.header); got != test.want {
+			t.Errorf("parseLinkNext(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	body := []byte(`{"links": {"next": "/page/2", "count": 3}, "pages": [{"url": "/a"}, {"url": "/b"}]}`)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$.links.next", "/page/2"},
+		{"links.next", "/page/2"},
+		{"$.pages[1].url", "/b"},
+		{"$.pages[2].url", ""},
+		{"$.links.count", ""},
+		{"$.links.missing", ""},
+		{"$", ""},
+	}
+	for _, test := range tests {
+		if got := lookupJSONPath(body, test.path); got != test.want {
+			t.Errorf("lookupJSONPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+	if got := lookupJSONPath([]byte("not json"), "$.next"); got != "" {
+		t.Errorf("lookupJSONPath of a non JSON body = %q", got)
+	}
+}
+
+// pageServer serves 3 pages of /<kind>?page=<n> linking to the next one.
+// link pages link with a Link header and json pages in the body, fail
+// answers page 2 with 500, limited answers page 2 with 429 once and other
+// links page 2 with an ftp URL.
+func pageServer(t *testing.T) *httptest.Server {
+	var limited atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		kind := r.URL.Path[1:]
+		next := ""
+		if page < 3 {
+			next = fmt.Sprintf("/%s?page=%d", kind, page+1)
+		}
+		switch {
+		case kind == "fail" && page == 2:
+			w.WriteHeader(http.StatusInternalServerError)
+		case kind == "limited" && page == 2 && !limited.Swap(true):
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case kind == "other":
+			next = "ftp://example.com/"
+		}
+		if kind == "json" || kind == "other" {
+			fmt.Fprintf(w, `{"page": %d, "links": {"next": %q}}`, page, next)
+			return
+		}
+		if next != "" {
+			w.Header().Set("Link", fmt.Sprintf(`</first>; rel="first", <%s>; rel="next"`, next))
+		}
+		fmt.Fprintf(w, "page %d", page)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPagination(t *testing.T) {
+	server := pageServer(t)
+
+	tests := []struct {
+		name        string
+		path        string
+		options     PaginationOptions
+		pages       int
+		status_code int
+		err_code    string
+	}{
+		{"link header", "/link?page=1", PaginationOptions{Mode: PaginationModeLinkHeader}, 3, http.StatusOK, ""},
+		{"link header by default", "/link?page=1", PaginationOptions{}, 3, http.StatusOK, ""},
+		{"max pages", "/link?page=1", PaginationOptions{Mode: PaginationModeLinkHeader, MaxPages: 2}, 2, http.StatusOK, ""},
+		{"json path", "/json?page=1", PaginationOptions{Mode: PaginationModeJSONPath, NextPath: "$.links.next"}, 3, http.StatusOK, ""},
+		{"json path not found", "/json?page=1", PaginationOptions{Mode: PaginationModeJSONPath, NextPath: "$.next"}, 1, http.StatusOK, ""},
+		{"link header in json path mode", "/link?page=1", PaginationOptions{Mode: PaginationModeJSONPath, NextPath: "$.next"}, 1, http.StatusOK, ""},
+		{"stops at an error status", "/fail?page=1", PaginationOptions{Mode: PaginationModeLinkHeader, DelayMs: 10}, 2, http.StatusInternalServerError, ""},
+		{"waits out a 429", "/limited?page=1", PaginationOptions{Mode: PaginationModeLinkHeader}, 3, http.StatusOK, ""},
+		{"next link blocked by policy", "/other?page=1", PaginationOptions{Mode: PaginationModeJSONPath, NextPath: "$.links.next"}, 0, 0, PolicySchemeNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := test.options
+			response, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL + test.path, Method: http.MethodGet, Timeout: 5, Pagination: &options})
+			if test.err_code != "" {
+				// the policy rejection is the error of the job
+				if err == nil || ClassifyError(err).Code != test.err_code {
+					t.Fatalf("got %v, want a %s error", err, test.err_code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) > 0 {
+				t.Fatal(response.Errs)
+			}
+			if response.PageCount != test.pages || len(response.Pages) != test.pages {
+				t.Fatalf("page_count = %d with %d pages, want %d", response.PageCount, len(response.Pages), test.pages)
+			}
+			if response.StatusCode != test.status_code {
+				t.Fatalf("status = %d, want %d", response.StatusCode, test.status_code)
+			}
+			if strings.HasPrefix(test.path, "/link") {
+				for i, page := range response.Pages {
+					if want := fmt.Sprintf("page %d", i+1); string(page) != want {
+						t.Fatalf("page %d is %q, want %q", i+1, page, want)
+					}
+				}
+				if string(response.Body) != string(response.Pages[test.pages-1]) {
+					t.Fatalf("body = %q, want the last page", response.Body)
+				}
+			}
+		})
+	}
+}
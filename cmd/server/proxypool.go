@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Rotation strategies of the proxy pool, set with PROXIER_PROXY_ROTATION
+const (
+	RotationRoundRobin = "round_robin"
+	RotationRandom     = "random"
+	RotationSticky     = "sticky"
+)
+
+// ProxyPool hands out upstream proxies to jobs that don't set a ProxyURL.
+// With the sticky strategy all requests to a host go through the same proxy.
+//
+// When health checks run, proxies failing FailureThreshold probes in a row are
+// left out of rotation until a probe succeeds again. If every proxy is down
+// the whole pool is used rather than failing all jobs.
+type ProxyPool struct {
+	proxies  []*poolProxy
+	strategy string
+	next     uint64
+
+	mu sync.RWMutex
+}
+
+type poolProxy struct {
+	url       string
+	healthy   bool
+	failures  int
+	checkedAt time.Time
+	latency   time.Duration
+	lastError string
+}
+
+func NewProxyPool(proxies []string, strategy string) (*ProxyPool, error) {
+	if len(proxies) == 0 {
+		return nil, errors.New("proxy pool is empty")
+	}
+	switch strategy {
+	case "":
+		strategy = RotationRoundRobin
+	case RotationRoundRobin, RotationRandom, RotationSticky:
+	default:
+		return nil, fmt.Errorf("unknown proxy rotation %q", strategy)
+	}
+	pool := &ProxyPool{strategy: strategy}
+	for _, proxy_url := range proxies {
+		if _, err := ProxyDialer(proxy_url); err != nil {
+			return nil, err
+		}
+		pool.proxies = append(pool.proxies, &poolProxy{url: proxy_url, healthy: true})
+	}
+	return pool, nil
+}
+
+// proxyPool is loaded from PROXIER_PROXY_POOL_FILE or PROXIER_PROXY_POOL_URL
+var proxyPool *ProxyPool
+
+// Pick returns the proxy to send a request to target_url through
+func (p *ProxyPool) Pick(target_url string) string {
+	return p.pickFrom(p.healthy(), target_url)
+}
+
+// PickOther returns a proxy other than current for retrying a request to
+// target_url, or current if no other proxy is available
+func (p *ProxyPool) PickOther(target_url string, current string) string {
+	var candidates []string
+	for _, proxy_url := range p.healthy() {
+		if proxy_url != current {
+			candidates = append(candidates, proxy_url)
+		}
+	}
+	if len(candidates) == 0 {
+		return current
+	}
+	return p.pickFrom(candidates, target_url)
+}
+
+func (p *ProxyPool) pickFrom(candidates []string, target_url string) string {
+	switch p.strategy {
+	case RotationRandom:
+		return candidates[rand.Intn(len(candidates))]
+	case RotationSticky:
+		host := target_url
+		if parsed, err := url.Parse(target_url); err == nil {
+			host = strings.ToLower(parsed.Hostname())
+		}
+		h := fnv.New32a()
+		h.Write([]byte(host))
+		return candidates[h.Sum32()%uint32(len(candidates))]
+	}
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+func (p *ProxyPool) healthy() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var healthy, all []string
+	for _, proxy := range p.proxies {
+		all = append(all, proxy.url)
+		if proxy.healthy {
+			healthy = append(healthy, proxy.url)
+		}
+	}
+	if len(healthy) == 0 {
+		return all
+	}
+	return healthy
+}
+
+// HealthyCount is the number of proxies that passed their last health checks
+func (p *ProxyPool) HealthyCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	count := 0
+	for _, proxy := range p.proxies {
+		if proxy.healthy {
+			count++
+		}
+	}
+	return count
+}
+
+// HealthCheck configures the probes of the pool proxies, from the
+// proxy_check_url, proxy_check_interval and proxy_check_failures settings
+type HealthCheck struct {
+	URL              string
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+}
+
+// defaultCheckTimeout bounds a probe, shortened to the interval if that is less
+const defaultCheckTimeout = 10 * time.Second
+
+// RunHealthChecks probes every proxy right away and then on every interval
+func (p *ProxyPool) RunHealthChecks(check HealthCheck) {
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, proxy := range p.proxies {
+			wg.Add(1)
+			go func(proxy *poolProxy) {
+				defer wg.Done()
+				p.probe(proxy, check)
+			}(proxy)
+		}
+		wg.Wait()
+		<-ticker.C
+	}
+}
+
+// probe fetches the check URL through the proxy, any response below 500 counts
+// as the proxy being up
+func (p *ProxyPool) probe(proxy *poolProxy, check HealthCheck) {
+	start := time.Now()
+	err := probeProxy(proxy.url, check)
+	latency := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.checkedAt = start
+	proxy.latency = latency
+	if err == nil {
+		if !proxy.healthy {
+			log.Info().Str("proxy", RedactProxyURL(proxy.url)).Msg("Upstream proxy recovered")
+		}
+		proxy.healthy = true
+		proxy.failures = 0
+		proxy.lastError = ""
+		return
+	}
+
+	proxy.failures++
+	proxy.lastError = err.Error()
+	if proxy.healthy && proxy.failures >= check.FailureThreshold {
+		log.Warn().Str("proxy", RedactProxyURL(proxy.url)).Err(err).Int("failures", proxy.failures).Msg("Evicting upstream proxy")
+		proxy.healthy = false
+	}
+}
+
+func probeProxy(proxy_url string, check HealthCheck) error {
+	dial, err := ProxyDialer(proxy_url)
+	if err != nil {
+		return err
+	}
+	agent := fiber.Get(check.URL).Timeout(check.Timeout)
+	host_client, err := hostClient(agent)
+	if err != nil {
+		fiber.ReleaseAgent(agent)
+		return err
+	}
+	host_client.Dial = dial
+	status_code, _, errs := agent.Bytes()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	if status_code >= 500 {
+		return fmt.Errorf("check returned %d", status_code)
+	}
+	return nil
+}
+
+type proxyStatus struct {
+	URL       string     `json:"url"`
+	Healthy   bool       `json:"healthy"`
+	Failures  int        `json:"failures"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	LatencyMs int64      `json:"latency_ms,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// Status reports the health of every proxy, passwords left out
+func (p *ProxyPool) Status() []proxyStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	statuses := make([]proxyStatus, len(p.proxies))
+	for i, proxy := range p.proxies {
+		statuses[i] = proxyStatus{
+			URL:       RedactProxyURL(proxy.url),
+			Healthy:   proxy.healthy,
+			Failures:  proxy.failures,
+			LastError: proxy.lastError,
+		}
+		if !proxy.checkedAt.IsZero() {
+			checked_at := proxy.checkedAt
+			statuses[i].CheckedAt = &checked_at
+			statuses[i].LatencyMs = proxy.latency.Milliseconds()
+		}
+	}
+	return statuses
+}
+
+// GetProxiesStatus returns the health of the upstream proxy pool
+// @Description Returns the rotation strategy and the health of every proxy of the pool
+func GetProxiesStatus(c *fiber.Ctx) error {
+	if proxyPool == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No proxy pool configured",
+		})
+	}
+
+	statuses := proxyPool.Status()
+	healthy := 0
+	for _, status := range statuses {
+		if status.Healthy {
+			healthy++
+		}
+	}
+	return c.JSON(fiber.Map{
+		"rotation": proxyPool.strategy,
+		"healthy":  healthy,
+		"proxies":  statuses,
+	})
+}
+
+// ParseProxyList reads a JSON array of proxy URLs, or one URL per line with
+// blank lines and # comments skipped
+func ParseProxyList(data []byte) ([]string, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var proxies []string
+		if err := json.Unmarshal([]byte(trimmed), &proxies); err != nil {
+			return nil, fmt.Errorf("invalid proxy list: %w", err)
+		}
+		return proxies, nil
+	}
+
+	var proxies []string
+	for _, line := range strings.Split(trimmed, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		proxies = append(proxies, line)
+	}
+	return proxies, nil
+}
+
+// LoadProxyList reads the proxy list from a file, or fetches it from an API
+// when source is an http:// or https:// URL
+func LoadProxyList(source string) ([]string, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		status_code, body, errs := fiber.Get(source).Timeout(30 * time.Second).Bytes()
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		if status_code != fiber.StatusOK {
+			return nil, fmt.Errorf("proxy list endpoint returned %d", status_code)
+		}
+		return ParseProxyList(body)
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProxyList(data)
+}
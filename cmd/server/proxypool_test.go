@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var poolProxies = []string{"http://proxy-a:3128", "http://proxy-b:3128", "http://proxy-c:3128"}
+
+func TestNewProxyPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxies  []string
+		strategy string
+		ok       bool
+	}{
+		{"round robin by default", poolProxies, "", true},
+		{"sticky", poolProxies, RotationSticky, true},
+		{"empty pool", nil, RotationRandom, false},
+		{"unknown rotation", poolProxies, "least_used", false},
+		{"unsupported proxy scheme", []string{"ftp://proxy-a:21"}, RotationRoundRobin, false},
+	}
+	for _, test := range tests {
+		pool, err := NewProxyPool(test.proxies, test.strategy)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got %v, want ok: %v", test.name, err, test.ok)
+		}
+		if err == nil && test.strategy == "" && pool.strategy != RotationRoundRobin {
+			t.Errorf("%s: strategy = %q", test.name, pool.strategy)
+		}
+	}
+}
+
+func TestProxyPoolPick(t *testing.T) {
+	round_robin, _ := NewProxyPool(poolProxies, RotationRoundRobin)
+	for i := 0; i < 6; i++ {
+		if got := round_robin.Pick("http://example.com/"); got != poolProxies[i%3] {
+			t.Fatalf("pick %d = %s, want %s", i+1, got, poolProxies[i%3])
+		}
+	}
+
+	random, _ := NewProxyPool(poolProxies, RotationRandom)
+	seen := map[string]int{}
+	for i := 0; i < 300; i++ {
+		seen[random.Pick("http://example.com/")]++
+	}
+	for _, proxy_url := range poolProxies {
+		if seen[proxy_url] == 0 {
+			t.Fatalf("random rotation never picked %s: %v", proxy_url, seen)
+		}
+	}
+	if len(seen) != len(poolProxies) {
+		t.Fatalf("random rotation picked proxies out of the pool: %v", seen)
+	}
+}
+
+func TestProxyPoolSticky(t *testing.T) {
+	pool, _ := NewProxyPool(poolProxies, RotationSticky)
+	tests := []struct {
+		name  string
+		first string
+		other string
+	}{
+		{"other path", "http://api.example.com/a", "http://api.example.com/b?page=2"},
+		{"other case", "http://api.example.com/", "http://API.Example.com/"},
+		{"other port and scheme", "http://api.example.com/", "https://api.example.com:8443/"},
+	}
+	for _, test := range tests {
+		first := pool.Pick(test.first)
+		for i := 0; i < 5; i++ {
+			if got := pool.Pick(test.other); got != first {
+				t.Fatalf("%s: %s went through %s, %s through %s", test.name, test.first, first, test.other, got)
+			}
+		}
+	}
+
+	// hosts are spread over the pool
+	used := map[string]bool{}
+	for _, host := range []string{"a.test", "b.test", "c.test", "d.test", "e.test", "f.test", "g.test", "h.test"} {
+		used[pool.Pick("http://"+host+"/")] = true
+	}
+	if len(used) < 2 {
+		t.Fatalf("8 hosts all went through %v", used)
+	}
+}
+
+func TestProxyPoolPickOther(t *testing.T) {
+	for _, strategy := range []string{RotationRoundRobin, RotationRandom, RotationSticky} {
+		pool, _ := NewProxyPool(poolProxies, strategy)
+		for i := 0; i < 10; i++ {
+			current := pool.Pick("http://example.com/")
+			if next := pool.PickOther("http://example.com/", current); next == current {
+				t.Fatalf("%s: retry went through %s again", strategy, current)
+			}
+		}
+	}
+
+	single, _ := NewProxyPool(poolProxies[:1], RotationRoundRobin)
+	if got := single.PickOther("http://example.com/", poolProxies[0]); got != poolProxies[0] {
+		t.Fatalf("single proxy pool retried through %s", got)
+	}
+}
+
+func TestProxyPoolHealthChecks(t *testing.T) {
+	var failing atomic.Bool
+	check := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer check.Close()
+	up := "http://" + newStubProxy(t, false).addr()
+	down := strings.TrimSuffix(refusedURL(t), "/")
+	pool, err := NewProxyPool([]string{up, down}, RotationRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	health_check := HealthCheck{URL: check.URL, Timeout: time.Second, FailureThreshold: 2}
+
+	steps := []struct {
+		name string
+		// probed are the proxies probed in the step
+		probed []string
+		// check_fails makes the check URL answer 502
+		check_fails bool
+		// picked are the proxies Pick hands out after the step
+		picked []string
+	}{
+		{"one failure is tolerated", []string{up, down}, false, []string{up, down}},
+		{"evicted at the threshold", []string{up, down}, false, []string{up}},
+		{"5xx answers count as failures", []string{up}, true, []string{up}},
+		{"whole pool used once every proxy is down", []string{up}, true, []string{up, down}},
+		{"readmitted by a passing probe", []string{up}, false, []string{up}},
+	}
+	for _, step := range steps {
+		failing.Store(step.check_fails)
+		for _, proxy := range pool.proxies {
+			for _, probed := range step.probed {
+				if proxy.url == probed {
+					pool.probe(proxy, health_check)
+				}
+			}
+		}
+
+		picked := map[string]bool{}
+		for i := 0; i < 4; i++ {
+			picked[pool.Pick("http://example.com/")] = true
+		}
+		if len(picked) != len(step.picked) {
+			t.Fatalf("%s: picked %v, want %v", step.name, picked, step.picked)
+		}
+		for _, proxy_url := range step.picked {
+			if !picked[proxy_url] {
+				t.Fatalf("%s: picked %v, want %v", step.name, picked, step.picked)
+			}
+		}
+	}
+
+	statuses := pool.Status()
+	if !statuses[0].Healthy || statuses[0].Failures != 0 || statuses[0].LastError != "" || statuses[0].CheckedAt == nil {
+		t.Fatalf("readmitted proxy status = %+v", statuses[0])
+	}
+	if statuses[1].Healthy || statuses[1].Failures != 2 || statuses[1].LastError == "" || pool.HealthyCount() != 1 {
+		t.Fatalf("evicted proxy status = %+v", statuses[1])
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// MergeQueryParams appends the percent-encoded params to the query of raw_url,
+// keeping any query string it already has untouched. Keys with several values
+// are repeated, in the order given.
+func MergeQueryParams(raw_url string, params map[string][]string) string {
+	if len(params) == 0 {
+		return raw_url
+	}
+
+	encoded := url.Values(params).Encode()
+	if encoded == "" {
+		return raw_url
+	}
+
+	fragment := ""
+	if i := strings.IndexByte(raw_url, '#'); i >= 0 {
+		raw_url, fragment = raw_url[:i], raw_url[i:]
+	}
+
+	switch {
+	case !strings.Contains(raw_url, "?"):
+		raw_url += "?"
+	case !strings.HasSuffix(raw_url, "?") && !strings.HasSuffix(raw_url, "&"):
+		raw_url += "&"
+	}
+	return raw_url + encoded + fragment
+}
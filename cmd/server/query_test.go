@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestMergeQueryParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		params map[string][]string
+		want   string
+	}{
+		{"no params", "http://example.com/a?x=1", nil, "http://example.com/a?x=1"},
+		{"new query", "http://example.com/a", map[string][]string{"q": {"go"}}, "http://example.com/a?q=go"},
+		{"existing query kept", "http://example.com/a?x=%2F", map[string][]string{"q": {"go"}}, "http://example.com/a?x=%2F&q=go"},
+		{"trailing question mark", "http://example.com/a?", map[string][]string{"q": {"go"}}, "http://example.com/a?q=go"},
+		{"repeated values", "http://example.com/", map[string][]string{"tag": {"a", "b"}}, "http://example.com/?tag=a&tag=b"},
+		{"special characters", "http://example.com/", map[string][]string{"q": {"a&b=c d"}}, "http://example.com/?q=a%26b%3Dc+d"},
+		{"unicode", "http://example.com/", map[string][]string{"name": {"café ü"}}, "http://example.com/?name=caf%C3%A9+%C3%BC"},
+		{"fragment last", "http://example.com/a#top", map[string][]string{"q": {"go"}}, "http://example.com/a?q=go#top"},
+		{"sorted keys", "http://example.com/", map[string][]string{"b": {"2"}, "a": {"1"}}, "http://example.com/?a=1&b=2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MergeQueryParams(test.url, test.params); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryParamsSent(t *testing.T) {
+	received := make(chan url.Values, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Query()
+	}))
+	defer server.Close()
+
+	params := map[string][]string{"q": {"a&b=c d"}, "tag": {"x", "y"}, "name": {"café"}}
+	response, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL + "/search?page=2", Method: http.MethodGet, QueryParams: params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Errs) > 0 {
+		t.Fatal(response.Errs)
+	}
+
+	got := <-received
+	want := url.Values{"page": {"2"}, "q": {"a&b=c d"}, "tag": {"x", "y"}, "name": {"café"}}
+	if got.Encode() != want.Encode() {
+		t.Fatalf("upstream got %v, want %v", got, want)
+	}
+}
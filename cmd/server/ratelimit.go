@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// DomainLimit lets Rate requests a second through to the hosts of Domain,
+// with bursts of up to Burst requests. Domain is a host name or a
+// "*.example.com" wildcard, a host takes the limit of its exact name before
+// the longest matching wildcard. Requests over the limit wait for their turn
+// as long as the job deadline allows, or fail right away with Reject.
+type DomainLimit struct {
+	Domain string  `json:"domain"`
+	Rate   float64 `json:"rate"`
+	Burst  int     `json:"burst"`
+	Reject bool    `json:"reject,omitempty"`
+}
+
+// DomainRateLimitError is returned for requests the limit of their domain
+// doesn't let through in time, answered with 429 and a Retry-After
+type DomainRateLimitError struct {
+	Domain     string
+	RetryAfter time.Duration
+}
+
+func (e *DomainRateLimitError) Error() string {
+	return "rate limit exceeded for " + e.Domain
+}
+
+// domainBucket is the token bucket of a limit, shared by every job to its
+// hosts. Tokens go negative while requests wait for their turn.
+type domainBucket struct {
+	limit   DomainLimit
+	tokens  float64
+	updated time.Time
+}
+
+// DomainLimiter holds the token buckets of the per domain rate limits
+type DomainLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*domainBucket
+}
+
+// domainLimits are loaded from PROXIER_DOMAIN_LIMITS_FILE and replaced with
+// PUT /domain-limits
+var domainLimits = &DomainLimiter{buckets: map[string]*domainBucket{}}
+
+// LoadDomainLimits reads a JSON array of domain limits
+func LoadDomainLimits(path string) ([]DomainLimit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var limits []DomainLimit
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return nil, fmt.Errorf("invalid domain limits file %s: %w", path, err)
+	}
+	return limits, nil
+}
+
+// Set replaces the limits. Buckets of domains still limited keep their
+// tokens, capped by the new burst, so an update doesn't hand out a burst.
+func (l *DomainLimiter) Set(limits []DomainLimit) error {
+	buckets := make(map[string]*domainBucket, len(limits))
+	for _, limit := range limits {
+		limit.Domain = strings.ToLower(strings.TrimSpace(limit.Domain))
+		if limit.Domain == "" || strings.Contains(limit.Domain, "/") {
+			return fmt.Errorf("invalid domain %q", limit.Domain)
+		}
+		if limit.Rate <= 0 || math.IsInf(limit.Rate, 0) || math.IsNaN(limit.Rate) {
+			return fmt.Errorf("%s: rate must be positive", limit.Domain)
+		}
+		if limit.Burst < 1 {
+			return fmt.Errorf("%s: burst must be at least 1", limit.Domain)
+		}
+		if _, ok := buckets[limit.Domain]; ok {
+			return fmt.Errorf("%s: limited twice", limit.Domain)
+		}
+		buckets[limit.Domain] = &domainBucket{limit: limit, tokens: float64(limit.Burst)}
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for domain, bucket := range buckets {
+		if previous, ok := l.buckets[domain]; ok {
+			previous.refill(now)
+			bucket.tokens = math.Min(previous.tokens, float64(bucket.limit.Burst))
+			bucket.updated = now
+		}
+	}
+	l.buckets = buckets
+	return nil
+}
+
+func (b *domainBucket) refill(now time.Time) {
+	if !b.updated.IsZero() {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate)
+	}
+	b.updated = now
+}
+
+// bucketFor returns the bucket limiting host, nil when it is not limited.
+// The mutex must be held.
+func (l *DomainLimiter) bucketFor(host string) *domainBucket {
+	host = strings.ToLower(host)
+	if bucket, ok := l.buckets[host]; ok {
+		return bucket
+	}
+	for suffix := host; ; {
+		_, rest, ok := strings.Cut(suffix, ".")
+		if !ok {
+			return nil
+		}
+		if bucket, ok := l.buckets["*."+rest]; ok {
+			return bucket
+		}
+		suffix = rest
+	}
+}
+
+// Wait takes a token for a request to target_url, waiting for it when the
+// bucket is empty. A request that would have to wait past the deadline of
+// ctx, or at all when its limit rejects, gets a *DomainRateLimitError.
+func (l *DomainLimiter) Wait(ctx context.Context, target_url string) error {
+	parsed, err := url.Parse(target_url)
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	bucket := l.bucketFor(parsed.Hostname())
+	if bucket == nil {
+		l.mu.Unlock()
+		return nil
+	}
+	bucket.refill(now)
+	bucket.tokens--
+	var delay time.Duration
+	if bucket.tokens < 0 {
+		delay = time.Duration(-bucket.tokens / bucket.limit.Rate * float64(time.Second))
+	}
+	deadline, has_deadline := ctx.Deadline()
+	if delay > 0 && (bucket.limit.Reject || (has_deadline && now.Add(delay).After(deadline))) {
+		bucket.tokens++
+		l.mu.Unlock()
+		return &DomainRateLimitError{bucket.limit.Domain, delay}
+	}
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+
+	log.Debug().Str("url", target_url).Str("domain", bucket.limit.Domain).Dur("wait", delay).Msg("Waiting for domain rate limit")
+	if err := wait(ctx, delay); err != nil {
+		// the turn is not taken, hand it to the next waiter
+		l.mu.Lock()
+		bucket.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+type domainLimitStatus struct {
+	DomainLimit
+	Tokens float64 `json:"tokens"`
+}
+
+// Status reports every limit with the tokens left in its bucket
+func (l *DomainLimiter) Status() []domainLimitStatus {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	statuses := make([]domainLimitStatus, 0, len(l.buckets))
+	for _, bucket := range l.buckets {
+		bucket.refill(now)
+		statuses = append(statuses, domainLimitStatus{bucket.limit, math.Round(bucket.tokens*100) / 100})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Domain < statuses[j].Domain
+	})
+	return statuses
+}
+
+// GetDomainLimits returns the per domain rate limits
+// @Description Returns every domain rate limit with the requests its bucket lets through right away, negative while requests wait
+func GetDomainLimits(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"limits": domainLimits.Status(),
+	})
+}
+
+// PutDomainLimits replaces the per domain rate limits
+// @Description Replaces all domain rate limits with the JSON array in the body, an empty array lifts them
+// @Description Needs an admin API key when API keys are configured
+func PutDomainLimits(c *fiber.Ctx) error {
+	var limits []DomainLimit
+	if err := json.Unmarshal(c.Body(), &limits); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if err := domainLimits.Set(limits); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid domain limits: " + err.Error(),
+		})
+	}
+	log.Info().Int("limits", len(limits)).Str("key_id", KeyIDFrom(requestContext(c))).Msg("Domain rate limits updated")
+	return c.JSON(fiber.Map{
+		"limits": domainLimits.Status(),
+	})
+}
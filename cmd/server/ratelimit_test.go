@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDomainLimiterSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits []DomainLimit
+		err    bool
+	}{
+		{"valid", []DomainLimit{{Domain: "Example.com", Rate: 1, Burst: 1}, {Domain: "*.example.com", Rate: 0.5, Burst: 10}}, false},
+		{"no limits", nil, false},
+		{"empty domain", []DomainLimit{{Domain: " ", Rate: 1, Burst: 1}}, true},
+		{"domain with a path", []DomainLimit{{Domain: "example.com/api", Rate: 1, Burst: 1}}, true},
+		{"zero rate", []DomainLimit{{Domain: "example.com", Rate: 0, Burst: 1}}, true},
+		{"zero burst", []DomainLimit{{Domain: "example.com", Rate: 1, Burst: 0}}, true},
+		{"limited twice", []DomainLimit{{Domain: "example.com", Rate: 1, Burst: 1}, {Domain: "EXAMPLE.com", Rate: 2, Burst: 2}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := &DomainLimiter{buckets: map[string]*domainBucket{}}
+			err := limiter.Set(test.limits)
+			if (err != nil) != test.err {
+				t.Fatalf("err = %v, want an error: %v", err, test.err)
+			}
+			if err == nil && len(limiter.Status()) != len(test.limits) {
+				t.Fatalf("status = %+v, want %d limits", limiter.Status(), len(test.limits))
+			}
+		})
+	}
+}
+
+func TestDomainLimiterWait(t *testing.T) {
+	limits := []DomainLimit{
+		{Domain: "api.example.com", Rate: 0.1, Burst: 1, Reject: true},
+		{Domain: "*.example.com", Rate: 0.1, Burst: 3, Reject: true},
+		{Domain: "*.wait.test", Rate: 0.1, Burst: 1},
+	}
+
+	tests := []struct {
+		name string
+		urls []string
+		// limited are the requests rejected with a DomainRateLimitError
+		limited []bool
+	}{
+		{"exact host before the wildcard", []string{"https://api.example.com/", "https://API.example.com/v2"}, []bool{false, true}},
+		{"wildcard burst", []string{"http://a.example.com/", "http://b.c.example.com/", "http://a.example.com/", "http://d.example.com/"}, []bool{false, false, false, true}},
+		{"wildcard without the bare domain", []string{"http://example.com/", "http://example.com/", "http://example.com/"}, []bool{false, false, false}},
+		{"unlimited host", []string{"http://other.test/", "http://other.test/"}, []bool{false, false}},
+		// waiting 10s is past the deadline, the token is handed back
+		{"wait past the deadline", []string{"http://a.wait.test/", "http://b.wait.test/", "http://a.wait.test/"}, []bool{false, true, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := &DomainLimiter{buckets: map[string]*domainBucket{}}
+			if err := limiter.Set(limits); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			for i, target_url := range test.urls {
+				err := limiter.Wait(ctx, target_url)
+				if !test.limited[i] {
+					if err != nil {
+						t.Fatalf("request %d to %s: %v", i+1, target_url, err)
+					}
+					continue
+				}
+				var limit_err *DomainRateLimitError
+				if !errors.As(err, &limit_err) {
+					t.Fatalf("request %d to %s got %v, want a rate limit error", i+1, target_url, err)
+				}
+				if limit_err.RetryAfter <= 0 || limit_err.RetryAfter > 10*time.Second {
+					t.Fatalf("retry after %s, want up to the 10s refill", limit_err.RetryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestDomainLimiterRefill(t *testing.T) {
+	limiter := &DomainLimiter{buckets: map[string]*domainBucket{}}
+	if err := limiter.Set([]DomainLimit{{Domain: "example.com", Rate: 20, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(ctx, "http://example.com/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst goes right away, the next two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("3 requests at 20 a second with a burst of 1 took %s", elapsed)
+	}
+
+	// an update keeps the empty bucket instead of handing out a new burst
+	limits := []DomainLimit{{Domain: "example.com", Rate: 0.1, Burst: 5, Reject: true}}
+	if err := limiter.Set(limits); err != nil {
+		t.Fatal(err)
+	}
+	var limit_err *DomainRateLimitError
+	if err := limiter.Wait(ctx, "http://example.com/"); !errors.As(err, &limit_err) {
+		t.Fatalf("request after an update got %v, want a rate limit error", err)
+	}
+
+	// a cancelled wait gives its turn back
+	if err := limiter.Set([]DomainLimit{{Domain: "example.com", Rate: 0.1, Burst: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter.Wait(cancelled, "http://example.com/"); err == nil {
+		t.Fatal("cancelled wait took a token")
+	}
+	if tokens := limiter.Status()[0].Tokens; tokens < 0 {
+		t.Fatalf("tokens = %v after a cancelled wait, want it handed back", tokens)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// harCreator names the worker in exported HAR files
+const harCreator = "proxier"
+
+// Recording is a job as sent upstream and the response it got. Credentials
+// are redacted like in dead letters, and so are the cookies set by the
+// upstream; bodies are cut at the recorder limit.
+type Recording struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	// DurationMs covers the upstream requests, redirects and retries included
+	DurationMs int64 `json:"duration_ms"`
+
+	Method         string            `json:"method"`
+	URL            string            `json:"url"`
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	RequestCookies map[string]string `json:"request_cookies,omitempty"`
+	RequestBody    []byte            `json:"-"`
+	RequestSize    int               `json:"request_size"`
+
+	StatusCode      int                 `json:"status_code,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	ResponseBody    []byte              `json:"-"`
+	ResponseSize    int                 `json:"response_size"`
+
+	UpstreamProxy string   `json:"upstream_proxy,omitempty"`
+	FinalURL      string   `json:"final_url,omitempty"`
+	Redirects     int      `json:"redirects,omitempty"`
+	Attempts      int      `json:"attempts,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
+
+	// owner is the API key ID of the job, only it may list the recording
+	owner string
+}
+
+// Recorder keeps the last jobs in a ring buffer
+type Recorder struct {
+	mu           sync.Mutex
+	recordings   []Recording
+	next         int
+	full         bool
+	maxBodyBytes int
+}
+
+func NewRecorder(size int, max_body_bytes int) *Recorder {
+	return &Recorder{
+		recordings:   make([]Recording, size),
+		maxBodyBytes: max_body_bytes,
+	}
+}
+
+// recorder is sized by recording_size, nil when recording is off
+var recorder *Recorder
+
+func (r *Recorder) truncate(body []byte) []byte {
+	if len(body) > r.maxBodyBytes {
+		body = body[:r.maxBodyBytes]
+	}
+	return append([]byte(nil), body...)
+}
+
+// Add records the job and its upstream response, before scripts and
+// compression of the job change it
+func (r *Recorder) Add(ctx context.Context, job ProxyJob, response ProxyResponse) {
+	if r == nil {
+		return
+	}
+	id, err := randomID()
+	if err != nil {
+		return
+	}
+	redacted := RedactJob(job)
+	recording := Recording{
+		ID:              id,
+		StartedAt:       response.StartedAt,
+		DurationMs:      time.Since(response.StartedAt).Milliseconds(),
+		Method:          job.Method,
+		URL:             RedactURL(job.URL),
+		RequestHeaders:  redacted.Headers,
+		RequestCookies:  redacted.Cookies,
+		RequestBody:     r.truncate([]byte(job.Body)),
+		RequestSize:     len(job.Body),
+		StatusCode:      response.StatusCode,
+		ResponseHeaders: RedactResponseHeaders(response.Headers),
+		ResponseBody:    r.truncate(response.Body),
+		ResponseSize:    len(response.Body),
+		UpstreamProxy:   response.UpstreamProxy,
+		FinalURL:        RedactURL(response.FinalURL),
+		Redirects:       response.Redirects,
+		Attempts:        response.Attempts,
+		Errors:          errorStrings(response.Errs),
+		owner:           KeyIDFrom(ctx),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recordings[r.next] = recording
+	r.next = (r.next + 1) % len(r.recordings)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns up to limit recordings of owner, newest first
+func (r *Recorder) List(owner string, limit int) []Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.recordings)
+	}
+	var recordings []Recording
+	for i := 1; i <= count && len(recordings) < limit; i++ {
+		recording := r.recordings[(r.next-i+len(r.recordings))%len(r.recordings)]
+		if recording.owner == owner {
+			recordings = append(recordings, recording)
+		}
+	}
+	return recordings
+}
+
+// recordingsLimit reads the limit query of the recording endpoints, all
+// recordings without one
+func recordingsLimit(c *fiber.Ctx) (int, bool) {
+	limit := len(recorder.recordings)
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return 0, false
+		}
+		limit = parsed
+	}
+	return limit, true
+}
+
+// GetRecordings lists the recorded jobs of the API key
+// @Description Returns the last recorded jobs, newest first, without their bodies
+// @Param limit query int false "Number of recordings to return"
+func GetRecordings(c *fiber.Ctx) error {
+	if recorder == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Recording is disabled",
+		})
+	}
+	limit, ok := recordingsLimit(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid limit",
+		})
+	}
+	recordings := recorder.List(KeyIDFrom(requestContext(c)), limit)
+	if recordings == nil {
+		recordings = []Recording{}
+	}
+	return c.JSON(fiber.Map{
+		"recordings": recordings,
+	})
+}
+
+// ExportRecordings downloads the recorded jobs of the API key
+// @Description Returns the last recorded jobs as a HAR 1.2 file, oldest first, to open in browser devtools
+// @Param format query string true "Export format, only har"
+// @Param limit query int false "Number of recordings to export"
+func ExportRecordings(c *fiber.Ctx) error {
+	if recorder == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Recording is disabled",
+		})
+	}
+	if c.Query("format") != "har" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Unsupported format, use har",
+		})
+	}
+	limit, ok := recordingsLimit(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid limit",
+		})
+	}
+
+	recordings := recorder.List(KeyIDFrom(requestContext(c)), limit)
+	entries := make([]harEntry, len(recordings))
+	for i, recording := range recordings {
+		entries[len(recordings)-1-i] = recording.har()
+	}
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="proxier.har"`)
+	return c.JSON(harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harNameVersion{Name: harCreator, Version: "1.0"},
+		Entries: entries,
+	}})
+}
+
+// HAR 1.2, see http://www.softwareishard.com/blog/har-12-spec/
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string         `json:"version"`
+	Creator harNameVersion `json:"creator"`
+	Entries []harEntry     `json:"entries"`
+}
+
+type harNameVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+
+	// custom fields start with an underscore
+	UpstreamProxy string `json:"_upstreamProxy,omitempty"`
+	Attempts      int    `json:"_attempts,omitempty"`
+	RecordingID   string `json:"_recordingId"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+// harText returns the body as HAR content text, base64 unless it is UTF-8
+func harText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harPairs(values map[string]string) []harNameValue {
+	pairs := make([]harNameValue, 0, len(values))
+	for name, value := range values {
+		pairs = append(pairs, harNameValue{name, value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs
+}
+
+func (r Recording) har() harEntry {
+	request := harRequest{
+		Method:      r.Method,
+		URL:         r.URL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     harPairs(r.RequestCookies),
+		Headers:     harPairs(r.RequestHeaders),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    r.RequestSize,
+	}
+	if parsed, err := url.Parse(r.URL); err == nil {
+		for name, values := range parsed.Query() {
+			for _, value := range values {
+				request.QueryString = append(request.QueryString, harNameValue{name, value})
+			}
+		}
+		sort.SliceStable(request.QueryString, func(i, j int) bool { return request.QueryString[i].Name < request.QueryString[j].Name })
+	}
+	if r.RequestSize > 0 {
+		text, _ := harText(r.RequestBody)
+		request.PostData = &harPostData{
+			MimeType: headerValueOf(r.RequestHeaders, fiber.HeaderContentType),
+			Text:     text,
+		}
+	}
+
+	response := harResponse{
+		Status:      r.StatusCode,
+		StatusText:  http.StatusText(r.StatusCode),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     []harNameValue{},
+		Content: harContent{
+			Size:     r.ResponseSize,
+			MimeType: headerValue(r.ResponseHeaders, fiber.HeaderContentType),
+		},
+		RedirectURL: headerValue(r.ResponseHeaders, fiber.HeaderLocation),
+		HeadersSize: -1,
+		BodySize:    r.ResponseSize,
+	}
+	names := make([]string, 0, len(r.ResponseHeaders))
+	for name := range r.ResponseHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.ResponseHeaders[name] {
+			response.Headers = append(response.Headers, harNameValue{name, value})
+		}
+	}
+	for _, cookie := range ParseSetCookies(r.ResponseHeaders) {
+		response.Cookies = append(response.Cookies, harNameValue{cookie.Name, cookie.Value})
+	}
+	response.Content.Text, response.Content.Encoding = harText(r.ResponseBody)
+	if len(r.ResponseBody) < r.ResponseSize {
+		response.Content.Comment = "truncated to " + strconv.Itoa(len(r.ResponseBody)) + " bytes"
+	}
+
+	entry := harEntry{
+		StartedDateTime: r.StartedAt,
+		Time:            r.DurationMs,
+		Request:         request,
+		Response:        response,
+		Timings:         harTimings{Send: 0, Wait: r.DurationMs, Receive: 0},
+		UpstreamProxy:   r.UpstreamProxy,
+		Attempts:        r.Attempts,
+		RecordingID:     r.ID,
+	}
+	if len(r.Errors) > 0 {
+		entry.Comment = "failed: " + r.Errors[0]
+	}
+	return entry
+}
+
+// headerValueOf looks up a header of a job case insensitively
+func headerValueOf(headers map[string]string, key string) string {
+	for name, value := range headers {
+		if textproto.CanonicalMIMEHeaderKey(name) == key {
+			return value
+		}
+	}
+	return ""
+}
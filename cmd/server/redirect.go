@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/textproto"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// defaultMaxRedirects is used when a job follows redirects without a MaxRedirects
+const defaultMaxRedirects = 10
+
+// maxRedirectsLimit is the highest MaxRedirects a job may ask for
+const maxRedirectsLimit = 50
+
+// FollowRedirects follows 3xx responses up to the job's MaxRedirects, reporting
+// an error once the cap is hit. 301, 302 and 303 continue as a GET without body,
+// 307 and 308 repeat the request. Credentials are not sent on to other hosts.
+//
+// Every hop goes through a new agent, the HostClient of an agent only ever dials
+// the host of its first URL.
+func FollowRedirects(ctx context.Context, client *fiber.Client, job ProxyJob, response ProxyResponse) ProxyResponse {
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Logger()
+
+	max_redirects := job.MaxRedirects
+	if max_redirects == 0 {
+		max_redirects = defaultMaxRedirects
+	}
+
+	page_url := job.URL
+	follow_job := job
+	for fasthttp.StatusCodeIsRedirect(response.StatusCode) {
+		location := headerValue(response.Headers, fiber.HeaderLocation)
+		if location == "" {
+			break
+		}
+		if response.Redirects >= max_redirects {
+			response.Errs = []error{fmt.Errorf("stopped after %d redirects", max_redirects)}
+			return response
+		}
+		next_url, err := resolveURL(page_url, location)
+		if err != nil {
+			response.Errs = []error{fmt.Errorf("invalid redirect location %q: %w", location, err)}
+			return response
+		}
+		// the location comes from the upstream, it may name any scheme or host
+		if err := targetPolicy.CheckURL(next_url); err != nil {
+			logger.Warn().Err(err).Str("target", next_url).Msg("Redirect blocked by policy")
+			response.Errs = []error{err}
+			return response
+		}
+
+		status_code := response.StatusCode
+		if status_code != fiber.StatusTemporaryRedirect && status_code != fiber.StatusPermanentRedirect && follow_job.Method != fiber.MethodHead {
+			follow_job.Method = fiber.MethodGet
+			follow_job.Body = ""
+		}
+		if !sameHost(job.URL, next_url) {
+			follow_job = withoutCredentials(follow_job)
+		}
+
+		if err := domainLimits.Wait(ctx, next_url); err != nil {
+			response.Errs = []error{err}
+			return response
+		}
+		logger.Debug().Int("status_code", status_code).Str("target", next_url).Msg("Following redirect")
+		agent := NewAgent(client, follow_job.Method, next_url)
+		if err := PrepareAgent(agent, follow_job); err != nil {
+			fiber.ReleaseAgent(agent)
+			response.Errs = []error{err}
+			return response
+		}
+		ApplyDeadline(ctx, agent)
+
+		resp := AcquireUpstreamResponse()
+		agent.SetResponse(resp)
+		status_code, body, errs := SendRequest(agent)
+		headers, truncated := CaptureHeaders(resp, job.KeepHopByHopHeaders)
+		fiber.ReleaseResponse(resp)
+		job.session.Record(next_url, headers)
+		if len(errs) > 0 {
+			response.Errs = errs
+			return response
+		}
+
+		page_url = next_url
+		response.StatusCode = status_code
+		response.Body = body
+		response.Headers = headers
+		response.HeadersTruncated = truncated
+		response.Redirects++
+	}
+	if response.Redirects > 0 {
+		response.FinalURL = page_url
+	}
+	return response
+}
+
+func sameHost(a string, b string) bool {
+	a_url, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	b_url, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return a_url.Scheme == b_url.Scheme && a_url.Host == b_url.Host
+}
+
+// withoutCredentials drops basic auth, cookies and sensitive headers from the job
+func withoutCredentials(job ProxyJob) ProxyJob {
+	job.BasicAuthUser = ""
+	job.BasicAuthPass = ""
+	job.Cookies = nil
+
+	headers := make(map[string]string, len(job.Headers))
+	for key, value := range job.Headers {
+		if !sensitiveHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+			headers[key] = value
+		}
+	}
+	job.Headers = headers
+	return job
+}
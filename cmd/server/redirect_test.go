@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFollowRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "other %q", r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hop1":
+			http.Redirect(w, r, "/hop2", http.StatusFound)
+		case "/hop2":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/see-other":
+			http.Redirect(w, r, "/echo", http.StatusSeeOther)
+		case "/temporary":
+			http.Redirect(w, r, "/echo", http.StatusTemporaryRedirect)
+		case "/other-host":
+			http.Redirect(w, r, other.URL+"/landing", http.StatusFound)
+		case "/file":
+			w.Header().Set("Location", "file:///etc/passwd")
+			w.WriteHeader(http.StatusFound)
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s", r.Method, body)
+		default:
+			fmt.Fprint(w, "done")
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		job       ProxyJob
+		status    int
+		body      string
+		location  string
+		redirects int
+		err       string
+	}{
+		{"two hops followed", ProxyJob{URL: "/hop1", FollowRedirects: true}, 200, "done", "", 2, ""},
+		{"not followed", ProxyJob{URL: "/hop1"}, 302, "", "/hop2", 0, ""},
+		{"loop hits the default cap", ProxyJob{URL: "/loop", FollowRedirects: true}, 0, "", "", 0, "stopped after 10 redirects"},
+		{"loop hits max_redirects", ProxyJob{URL: "/loop", FollowRedirects: true, MaxRedirects: 3}, 0, "", "", 0, "stopped after 3 redirects"},
+		{"two hops over max_redirects", ProxyJob{URL: "/hop1", FollowRedirects: true, MaxRedirects: 1}, 0, "", "", 0, "stopped after 1 redirects"},
+		{"303 continues as get", ProxyJob{URL: "/see-other", Method: http.MethodPost, Body: "data", FollowRedirects: true}, 200, "GET ", "", 1, ""},
+		{"307 repeats the request", ProxyJob{URL: "/temporary", Method: http.MethodPost, Body: "data", FollowRedirects: true}, 200, "POST data", "", 1, ""},
+		{"credentials not sent to other hosts", ProxyJob{URL: "/other-host", FollowRedirects: true, BasicAuthUser: "user", BasicAuthPass: "pass", AuthType: AuthTypeBasic}, 200, `other ""`, "", 1, ""},
+		{"location blocked by the policy", ProxyJob{URL: "/file", FollowRedirects: true}, 0, "", "", 0, "not allowed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := test.job
+			job.URL = server.URL + job.URL
+			if job.Method == "" {
+				job.Method = http.MethodGet
+			}
+			response, err := ExecuteJob(context.Background(), job)
+			if test.err != "" {
+				// failed jobs report the error rather than a response
+				if err == nil {
+					if len(response.Errs) == 0 {
+						t.Fatalf("got %d %q, want an error", response.StatusCode, response.Body)
+					}
+					err = errors.Join(response.Errs...)
+				}
+				if !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("err = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) > 0 {
+				t.Fatal(response.Errs)
+			}
+			if response.StatusCode != test.status || (test.body != "" && string(response.Body) != test.body) {
+				t.Fatalf("got %d %q, want %d %q", response.StatusCode, response.Body, test.status, test.body)
+			}
+			if got := headerValue(response.Headers, "Location"); got != test.location {
+				t.Fatalf("location = %q, want %q", got, test.location)
+			}
+			if response.Redirects != test.redirects {
+				t.Fatalf("redirects = %d, want %d", response.Redirects, test.redirects)
+			}
+		})
+	}
+
+	_, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet, FollowRedirects: true, MaxRedirects: maxRedirectsLimit + 1})
+	if err == nil || ClassifyError(err).Code != ErrorInvalidJob {
+		t.Fatalf("err = %v, want an invalid_job error", err)
+	}
+}
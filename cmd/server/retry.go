@@ -0,0 +1,133 @@
+package main
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxRetries       = 10
+	retryBaseBackoff = 100 * time.Millisecond
+	retryMaxBackoff  = 10 * time.Second
+)
+
+// RetrySpec is the retry policy of a job. It replaces max_retries and
+// retry_on_status, and unlike them only retries network errors when
+// RetryOnNetworkErrors is set. Backoffs default to 100ms and 10s.
+type RetrySpec struct {
+	MaxAttempts          int   `json:"max_attempts"`
+	BackoffBaseMs        int   `json:"backoff_base_ms"`
+	BackoffMaxMs         int   `json:"backoff_max_ms"`
+	RetryOnStatus        []int `json:"retry_on_status"`
+	RetryOnNetworkErrors bool  `json:"retry_on_network_errors"`
+}
+
+// AttemptError is an attempt of a job that failed, by error or by a status
+// the job retries on
+type AttemptError struct {
+	Attempt    int           `json:"attempt"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Errors     []ErrorDetail `json:"errors,omitempty"`
+	Proxy      string        `json:"proxy,omitempty"`
+}
+
+// RetryPolicy is the retry behavior of a job, from its RetrySpec or else its
+// max_retries and retry_on_status, and its retry_if_body_matches
+type RetryPolicy struct {
+	MaxAttempts   int
+	BackoffBase   time.Duration
+	BackoffMax    time.Duration
+	OnStatus      []int
+	OnBody        *regexp.Regexp
+	OnNetwork     bool
+	NonIdempotent bool
+}
+
+// ValidRetrySpec reports whether the retry spec of the job is usable
+func ValidRetrySpec(spec *RetrySpec) bool {
+	if spec == nil {
+		return true
+	}
+	return spec.MaxAttempts >= 0 && spec.MaxAttempts <= maxRetries+1 &&
+		spec.BackoffBaseMs >= 0 && spec.BackoffMaxMs >= 0 &&
+		time.Duration(spec.BackoffMaxMs)*time.Millisecond <= maxTimeout &&
+		(spec.BackoffMaxMs == 0 || spec.BackoffBaseMs <= spec.BackoffMaxMs)
+}
+
+// RetryPolicyFor resolves the retry policy of the job
+func RetryPolicyFor(job ProxyJob) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:   job.MaxRetries + 1,
+		BackoffBase:   retryBaseBackoff,
+		BackoffMax:    retryMaxBackoff,
+		OnStatus:      job.RetryOnStatus,
+		OnBody:        job.retryBody,
+		OnNetwork:     true,
+		NonIdempotent: job.RetryNonIdempotent,
+	}
+	if spec := job.Retry; spec != nil {
+		policy.MaxAttempts = max(spec.MaxAttempts, 1)
+		policy.OnStatus = spec.RetryOnStatus
+		policy.OnNetwork = spec.RetryOnNetworkErrors
+		if spec.BackoffBaseMs > 0 {
+			policy.BackoffBase = time.Duration(spec.BackoffBaseMs) * time.Millisecond
+		}
+		if spec.BackoffMaxMs > 0 {
+			policy.BackoffMax = time.Duration(spec.BackoffMaxMs) * time.Millisecond
+		}
+		policy.BackoffMax = max(policy.BackoffMax, policy.BackoffBase)
+	}
+	return policy
+}
+
+// Retries tells if the job may be sent more than once
+func (p RetryPolicy) Retries() bool {
+	return p.MaxAttempts > 1
+}
+
+// Backoff is the delay before retry number attempt+1: base, 2*base, 4*base...
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.BackoffBase << attempt
+	if backoff <= 0 || backoff > p.BackoffMax {
+		return p.BackoffMax
+	}
+	return backoff
+}
+
+// Failed tells if an attempt failed in a way the policy cares about: by a
+// transport error, a status it retries on or a body matching OnBody
+func (p RetryPolicy) Failed(status_code int, body []byte, errs []error) bool {
+	if len(errs) > 0 {
+		return true
+	}
+	for _, status := range p.OnStatus {
+		if status == status_code {
+			return true
+		}
+	}
+	return p.OnBody != nil && p.OnBody.Match(body)
+}
+
+// ShouldRetry tells if an attempt is worth retrying: network errors are if the
+// policy says so, except for bodies over the size limit and blocked targets,
+// statuses from OnStatus and bodies matching OnBody only for idempotent
+// methods unless the job opts in with RetryNonIdempotent
+func (p RetryPolicy) ShouldRetry(method string, status_code int, body []byte, errs []error) bool {
+	if len(errs) > 0 {
+		return p.OnNetwork && !isBodyTooLarge(errs) && policyError(errs) == nil
+	}
+	if !isIdempotent(method) && !p.NonIdempotent {
+		return false
+	}
+	return p.Failed(status_code, body, nil)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, fiber.MethodPut, fiber.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers its first failures requests with status and a captcha
+// page, and "ok" after that
+func flakyServer(t *testing.T, failures int64, status int) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(status)
+			fmt.Fprint(w, "<html>Please solve the captcha</html>")
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryIfBodyMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		retries  int
+		failures int64
+		body     string
+		attempts int
+	}{
+		{"retried until the body is fine", http.MethodGet, 3, 1, "ok", 2},
+		{"capped by the retries", http.MethodGet, 1, 5, "<html>Please solve the captcha</html>", 2},
+		{"without retries", http.MethodGet, 0, 1, "<html>Please solve the captcha</html>", 1},
+		{"non-idempotent method", http.MethodPost, 3, 1, "<html>Please solve the captcha</html>", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := flakyServer(t, test.failures, http.StatusOK)
+			response, err := ExecuteJob(context.Background(), ProxyJob{
+				URL:                server.URL,
+				Method:             test.method,
+				MaxRetries:         test.retries,
+				RetryIfBodyMatches: `(?i)captcha`,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != http.StatusOK || string(response.Body) != test.body {
+				t.Fatalf("got %d %q, want 200 %q", response.StatusCode, response.Body, test.body)
+			}
+			if response.Attempts != test.attempts || requests.Load() != int64(test.attempts) {
+				t.Fatalf("attempts = %d with %d requests, want %d", response.Attempts, requests.Load(), test.attempts)
+			}
+			// every matching attempt is reported, the last one too when retries ran out
+			if want := min(int(test.failures), test.attempts); len(response.AttemptErrors) != want {
+				t.Fatalf("attempt_errors = %+v, want %d", response.AttemptErrors, want)
+			}
+		})
+	}
+
+	_, err := ExecuteJob(context.Background(), ProxyJob{URL: "http://127.0.0.1/", Method: http.MethodGet, RetryIfBodyMatches: "(unclosed"})
+	if err == nil || ClassifyError(err).Code != ErrorInvalidJob {
+		t.Fatalf("err = %v, want an invalid_job error", err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name string
+		job  ProxyJob
+		want []time.Duration
+	}{
+		{"default doubling", ProxyJob{MaxRetries: 3}, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}},
+		{"capped", ProxyJob{Retry: &RetrySpec{MaxAttempts: 5, BackoffBaseMs: 50, BackoffMaxMs: 120}}, []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 120 * time.Millisecond, 120 * time.Millisecond}},
+		{"larger base under the default max", ProxyJob{Retry: &RetrySpec{MaxAttempts: 2, BackoffBaseMs: 300, BackoffMaxMs: 0}}, []time.Duration{300 * time.Millisecond, 600 * time.Millisecond}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := RetryPolicyFor(test.job)
+			for attempt, want := range test.want {
+				if got := policy.Backoff(attempt); got != want {
+					t.Fatalf("backoff %d = %s, want %s", attempt, got, want)
+				}
+			}
+		})
+	}
+	if got := RetryPolicyFor(ProxyJob{}).Backoff(63); got != retryMaxBackoff {
+		t.Fatalf("overflowing backoff = %s, want %s", got, retryMaxBackoff)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      ProxyJob
+		failures int64
+		status   int
+		attempts int
+	}{
+		{"succeeds within 3 retries", ProxyJob{MaxRetries: 3, RetryOnStatus: []int{503}}, 2, http.StatusOK, 3},
+		{"gives up after the retries", ProxyJob{MaxRetries: 3, RetryOnStatus: []int{503}}, 10, http.StatusServiceUnavailable, 4},
+		{"status not retried", ProxyJob{MaxRetries: 3, RetryOnStatus: []int{502}}, 1, http.StatusServiceUnavailable, 1},
+		{"post not retried", ProxyJob{Method: http.MethodPost, MaxRetries: 3, RetryOnStatus: []int{503}}, 1, http.StatusServiceUnavailable, 1},
+		{"post retried on opting in", ProxyJob{Method: http.MethodPost, MaxRetries: 3, RetryOnStatus: []int{503}, RetryNonIdempotent: true}, 1, http.StatusOK, 2},
+		{"retry spec", ProxyJob{Retry: &RetrySpec{MaxAttempts: 3, BackoffBaseMs: 10, RetryOnStatus: []int{503}}}, 2, http.StatusOK, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := flakyServer(t, test.failures, http.StatusServiceUnavailable)
+			job := test.job
+			job.URL = server.URL
+			if job.Method == "" {
+				job.Method = http.MethodGet
+			}
+			response, err := ExecuteJob(context.Background(), job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("status = %d, want %d", response.StatusCode, test.status)
+			}
+			if response.Attempts != test.attempts || requests.Load() != int64(test.attempts) {
+				t.Fatalf("attempts = %d with %d requests, want %d", response.Attempts, requests.Load(), test.attempts)
+			}
+		})
+	}
+}
+
+func TestRetriesStopAtDeadline(t *testing.T) {
+	server, requests := flakyServer(t, 100, http.StatusServiceUnavailable)
+	start := time.Now()
+	// backoffs of 100, 200 and 400ms don't fit in 350ms
+	ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet, MaxRetries: 10, RetryOnStatus: []int{503}, TimeoutMs: 350})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("retried for %s past the 350ms deadline", elapsed)
+	}
+	if got := requests.Load(); got > 3 {
+		t.Fatalf("sent %d requests, want at most 3 within the deadline", got)
+	}
+}
+
+func TestRetriesOnNetworkErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      ProxyJob
+		attempts int
+	}{
+		{"max_retries retries them", ProxyJob{MaxRetries: 2}, 3},
+		{"post retried too", ProxyJob{Method: http.MethodPost, MaxRetries: 2}, 3},
+		{"retry spec without network errors", ProxyJob{Retry: &RetrySpec{MaxAttempts: 3, BackoffBaseMs: 10}}, 1},
+		{"retry spec with network errors", ProxyJob{Retry: &RetrySpec{MaxAttempts: 3, BackoffBaseMs: 10, RetryOnNetworkErrors: true}}, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := test.job
+			job.URL = refusedURL(t)
+			if job.Method == "" {
+				job.Method = http.MethodGet
+			}
+			response, err := ExecuteJob(context.Background(), job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) == 0 {
+				t.Fatal("job against a closed port succeeded")
+			}
+			if response.Attempts != test.attempts || len(response.AttemptErrors) != test.attempts {
+				t.Fatalf("attempts = %d with %d attempt errors, want %d", response.Attempts, len(response.AttemptErrors), test.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// limits of the rewrite rules a job brings along
+const (
+	maxJobRewriteRules    = 32
+	maxRewritePatternSize = 1024
+)
+
+// RewriteRule rewrites the requests and responses of jobs whose target
+// matches Host and Path. Host is a host name or a "*.example.com" wildcard and
+// Path a path prefix, either matches anything when empty.
+type RewriteRule struct {
+	Host     string          `json:"host"`
+	Path     string          `json:"path"`
+	Request  *RewriteActions `json:"request,omitempty"`
+	Response *RewriteActions `json:"response,omitempty"`
+}
+
+// RewriteActions are applied in order: RemoveHeaders, SetHeaders, AddHeaders,
+// then Body. AddHeaders keeps existing values of the header, SetHeaders
+// replaces them.
+type RewriteActions struct {
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	AddHeaders    map[string]string `json:"add_headers,omitempty"`
+	Body          []BodyRewrite     `json:"body,omitempty"`
+}
+
+// BodyRewrite replaces every match of the regular expression Pattern with
+// Replace, where $1 or ${name} expand to the submatches
+type BodyRewrite struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+// rewriteRules are loaded from PROXIER_REWRITE_RULES_FILE, they apply to
+// every job before the rules of the job itself
+var rewriteRules []RewriteRule
+
+// LoadRewriteRules reads and compiles a JSON array of rewrite rules
+func LoadRewriteRules(path string) ([]RewriteRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []RewriteRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rewrite rules file %s: %w", path, err)
+	}
+	return CompileRewriteRules(rules)
+}
+
+// CompileRewriteRules returns a copy of the rules with their body patterns
+// compiled, the rules passed in are left untouched
+func CompileRewriteRules(rules []RewriteRule) ([]RewriteRule, error) {
+	compiled := make([]RewriteRule, len(rules))
+	for i, rule := range rules {
+		var err error
+		if rule.Request, err = compileActions(rule.Request); err != nil {
+			return nil, fmt.Errorf("rewrite rule %d: request: %w", i, err)
+		}
+		if rule.Response, err = compileActions(rule.Response); err != nil {
+			return nil, fmt.Errorf("rewrite rule %d: response: %w", i, err)
+		}
+		compiled[i] = rule
+	}
+	return compiled, nil
+}
+
+func compileActions(actions *RewriteActions) (*RewriteActions, error) {
+	if actions == nil {
+		return nil, nil
+	}
+	copied := *actions
+	copied.Body = make([]BodyRewrite, len(actions.Body))
+	for i, rewrite := range actions.Body {
+		if rewrite.Pattern == "" {
+			return nil, errors.New("empty body pattern")
+		}
+		if len(rewrite.Pattern) > maxRewritePatternSize {
+			return nil, fmt.Errorf("body pattern longer than %d bytes", maxRewritePatternSize)
+		}
+		re, err := regexp.Compile(rewrite.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rewrite.re = re
+		copied.Body[i] = rewrite
+	}
+	return &copied, nil
+}
+
+// Matches tells whether the rule applies to target_url
+func (r RewriteRule) Matches(target_url string) bool {
+	parsed, err := url.Parse(target_url)
+	if err != nil {
+		return false
+	}
+	if r.Host != "" {
+		host := strings.ToLower(parsed.Hostname())
+		pattern := strings.ToLower(r.Host)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if !strings.HasSuffix(host, "."+suffix) {
+				return false
+			}
+		} else if host != pattern {
+			return false
+		}
+	}
+	if r.Path != "" {
+		path := parsed.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		if !strings.HasPrefix(path, r.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteRulesFor returns the server wide rules and then the rules of the
+// job, so the job has the last word
+func rewriteRulesFor(job ProxyJob) []RewriteRule {
+	if len(job.RewriteRules) == 0 {
+		return rewriteRules
+	}
+	return append(append([]RewriteRule(nil), rewriteRules...), job.RewriteRules...)
+}
+
+// hasResponseBodyRewrites tells whether any rule of the job rewrites the
+// response body, which needs the whole body in memory
+func hasResponseBodyRewrites(rules []RewriteRule) bool {
+	for _, rule := range rules {
+		if rule.Response != nil && len(rule.Response.Body) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func rewriteBody(body []byte, rewrites []BodyRewrite) []byte {
+	for _, rewrite := range rewrites {
+		body = rewrite.re.ReplaceAll(body, []byte(rewrite.Replace))
+	}
+	return body
+}
+
+// RewriteRequest applies the request actions of the matching rules to the
+// request of the agent, its URL is the one matched
+func RewriteRequest(agent *fiber.Agent, job ProxyJob) {
+	req := agent.Request()
+	target_url := req.URI().String()
+	for _, rule := range rewriteRulesFor(job) {
+		if rule.Request == nil || !rule.Matches(target_url) {
+			continue
+		}
+		for _, name := range rule.Request.RemoveHeaders {
+			req.Header.Del(name)
+		}
+		for name, value := range rule.Request.SetHeaders {
+			req.Header.Set(name, value)
+		}
+		for name, value := range rule.Request.AddHeaders {
+			req.Header.Add(name, value)
+		}
+		if len(rule.Request.Body) > 0 {
+			req.SetBody(rewriteBody(req.Body(), rule.Request.Body))
+		}
+	}
+}
+
+// RewriteResponseHeaders applies the header actions of the rules matching
+// target_url to captured response headers
+func RewriteResponseHeaders(headers map[string][]string, job ProxyJob, target_url string) map[string][]string {
+	for _, rule := range rewriteRulesFor(job) {
+		if rule.Response == nil || !rule.Matches(target_url) {
+			continue
+		}
+		for _, name := range rule.Response.RemoveHeaders {
+			deleteHeader(headers, name)
+		}
+		for name, value := range rule.Response.SetHeaders {
+			deleteHeader(headers, name)
+			headers[name] = []string{value}
+		}
+		for name, value := range rule.Response.AddHeaders {
+			if key, ok := headerKey(headers, name); ok {
+				headers[key] = append(headers[key], value)
+			} else {
+				headers[name] = []string{value}
+			}
+		}
+	}
+	return headers
+}
+
+// RewriteResponse applies the response actions of the rules matching the
+// final URL. Bodies with a Content-Encoding are left as they are, the
+// patterns are matched against the bytes as received.
+func RewriteResponse(job ProxyJob, response ProxyResponse) ProxyResponse {
+	rules := rewriteRulesFor(job)
+	if len(rules) == 0 {
+		return response
+	}
+	if response.Headers == nil {
+		response.Headers = map[string][]string{}
+	} else {
+		// cached responses share their headers
+		headers := make(map[string][]string, len(response.Headers))
+		for name, values := range response.Headers {
+			headers[name] = append([]string(nil), values...)
+		}
+		response.Headers = headers
+	}
+	response.Headers = RewriteResponseHeaders(response.Headers, job, response.FinalURL)
+
+	encoding := headerValue(response.Headers, fiber.HeaderContentEncoding)
+	if job.RawBytes || (encoding != "" && !strings.EqualFold(encoding, "identity")) {
+		return response
+	}
+	rewritten := false
+	for _, rule := range rules {
+		if rule.Response == nil || len(rule.Response.Body) == 0 || !rule.Matches(response.FinalURL) {
+			continue
+		}
+		response.Body = rewriteBody(response.Body, rule.Response.Body)
+		rewritten = true
+	}
+	if rewritten {
+		if key, ok := headerKey(response.Headers, fiber.HeaderContentLength); ok {
+			response.Headers[key] = []string{strconv.Itoa(len(response.Body))}
+		}
+	}
+	return response
+}
+
+// headerKey returns the key a header is captured under, matched case insensitively
+func headerKey(headers map[string][]string, name string) (string, bool) {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func deleteHeader(headers map[string][]string, name string) {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			delete(headers, key)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRewriteRequest(t *testing.T) {
+	// the server echoes the headers the rules act on and the body
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "remove=%q set=%q add=%q body=%q",
+			r.Header.Get("X-Remove"), r.Header.Get("X-Set"), strings.Join(r.Header.Values("X-Add"), ","), body)
+	}))
+	defer server.Close()
+
+	headers := map[string]string{"X-Remove": "gone", "X-Set": "old", "X-Add": "one"}
+	tests := []struct {
+		name string
+		rule RewriteRule
+		body string
+		want string
+	}{
+		{"no rule", RewriteRule{}, "", `remove="gone" set="old" add="one" body=""`},
+		{"remove", RewriteRule{Request: &RewriteActions{RemoveHeaders: []string{"x-remove"}}}, "", `remove="" set="old" add="one" body=""`},
+		{"set replaces", RewriteRule{Request: &RewriteActions{SetHeaders: map[string]string{"X-Set": "new"}}}, "", `remove="gone" set="new" add="one" body=""`},
+		{"add keeps the existing value", RewriteRule{Request: &RewriteActions{AddHeaders: map[string]string{"X-Add": "two"}}}, "", `remove="gone" set="old" add="one,two" body=""`},
+		{"remove then set", RewriteRule{Request: &RewriteActions{RemoveHeaders: []string{"X-Set"}, SetHeaders: map[string]string{"X-Set": "new"}}}, "", `remove="gone" set="new" add="one" body=""`},
+		{"body with submatches", RewriteRule{Request: &RewriteActions{Body: []BodyRewrite{{Pattern: `user=(\w+)`, Replace: "name=$1"}}}}, "user=alice&user=bob", `remove="gone" set="old" add="one" body="name=alice&name=bob"`},
+		{"body rewrites in order", RewriteRule{Request: &RewriteActions{Body: []BodyRewrite{{Pattern: "a", Replace: "b"}, {Pattern: "b", Replace: "c"}}}}, "ab", `remove="gone" set="old" add="one" body="cc"`},
+		{"matching path", RewriteRule{Path: "/api", Request: &RewriteActions{SetHeaders: map[string]string{"X-Set": "new"}}}, "", `remove="gone" set="new" add="one" body=""`},
+		{"other path", RewriteRule{Path: "/other", Request: &RewriteActions{SetHeaders: map[string]string{"X-Set": "new"}}}, "", `remove="gone" set="old" add="one" body=""`},
+		{"other host", RewriteRule{Host: "*.example.com", Request: &RewriteActions{SetHeaders: map[string]string{"X-Set": "new"}}}, "", `remove="gone" set="old" add="one" body=""`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := ExecuteJob(context.Background(), ProxyJob{
+				URL:          server.URL + "/api/items",
+				Method:       http.MethodPost,
+				Body:         test.body,
+				Headers:      headers,
+				RewriteRules: []RewriteRule{test.rule},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(response.Body) != test.want {
+				t.Fatalf("upstream got %s, want %s", response.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestRewriteResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions RewriteActions
+		// encoding is the Content-Encoding of the response
+		encoding string
+		raw      bool
+		header   string
+		values   string
+		body     string
+	}{
+		{"remove", RewriteActions{RemoveHeaders: []string{"x-powered-by"}}, "", false, "X-Powered-By", "", "hello world"},
+		{"set replaces", RewriteActions{SetHeaders: map[string]string{"cache-control": "no-store"}}, "", false, "Cache-Control", "no-store", "hello world"},
+		{"add appends", RewriteActions{AddHeaders: map[string]string{"vary": "Cookie"}}, "", false, "Vary", "Accept,Cookie", "hello world"},
+		{"add creates", RewriteActions{AddHeaders: map[string]string{"X-Rewritten": "yes"}}, "", false, "X-Rewritten", "yes", "hello world"},
+		{"body updates the length", RewriteActions{Body: []BodyRewrite{{Pattern: `world`, Replace: "there, world"}}}, "", false, "Content-Length", "18", "hello there, world"},
+		{"identity encoding rewritten", RewriteActions{Body: []BodyRewrite{{Pattern: "world", Replace: "go"}}}, "identity", false, "Content-Length", "8", "hello go"},
+		{"encoded body left alone", RewriteActions{Body: []BodyRewrite{{Pattern: "world", Replace: "go"}}}, "gzip", false, "Content-Length", "11", "hello world"},
+		{"raw bytes left alone", RewriteActions{Body: []BodyRewrite{{Pattern: "world", Replace: "go"}}}, "", true, "Content-Length", "11", "hello world"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := CompileRewriteRules([]RewriteRule{{Response: &test.actions}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers := map[string][]string{
+				"X-Powered-By":   {"php"},
+				"Cache-Control":  {"max-age=60", "public"},
+				"Vary":           {"Accept"},
+				"Content-Length": {"11"},
+			}
+			if test.encoding != "" {
+				headers["Content-Encoding"] = []string{test.encoding}
+			}
+			original := ProxyResponse{Headers: headers, Body: []byte("hello world"), FinalURL: "http://example.com/"}
+
+			response := RewriteResponse(ProxyJob{RewriteRules: rules, RawBytes: test.raw}, original)
+			if got := strings.Join(headerValues(response.Headers, test.header), ","); got != test.values {
+				t.Fatalf("%s = %q, want %q", test.header, got, test.values)
+			}
+			if string(response.Body) != test.body {
+				t.Fatalf("body = %q, want %q", response.Body, test.body)
+			}
+			// cached responses share their headers
+			if len(headers["Cache-Control"]) != 2 || strings.Join(headers["Vary"], ",") != "Accept" {
+				t.Fatalf("rewrite changed the original headers: %v", headers)
+			}
+		})
+	}
+}
+
+func TestRewriteRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule RewriteRule
+		url  string
+		want bool
+	}{
+		{RewriteRule{}, "http://example.com/", true},
+		{RewriteRule{Host: "example.com"}, "http://EXAMPLE.com:8080/", true},
+		{RewriteRule{Host: "example.com"}, "http://api.example.com/", false},
+		{RewriteRule{Host: "*.example.com"}, "http://api.example.com/", true},
+		{RewriteRule{Host: "*.example.com"}, "http://example.com/", false},
+		{RewriteRule{Path: "/api"}, "http://example.com/api/items", true},
+		{RewriteRule{Path: "/api"}, "http://example.com/web", false},
+		{RewriteRule{Path: "/"}, "http://example.com", true},
+	}
+	for _, test := range tests {
+		if got := test.rule.Matches(test.url); got != test.want {
+			t.Errorf("%+v matches %s = %v, want %v", test.rule, test.url, got, test.want)
+		}
+	}
+}
+
+func TestCompileRewriteRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		err     string
+	}{
+		{"valid", `(\d+)`, ""},
+		{"empty pattern", "", "empty body pattern"},
+		{"invalid pattern", "(", "missing closing )"},
+		{"pattern too long", strings.Repeat("a", maxRewritePatternSize+1), "longer than"},
+	}
+	for _, test := range tests {
+		rules := []RewriteRule{{Response: &RewriteActions{Body: []BodyRewrite{{Pattern: test.pattern}}}}}
+		compiled, err := CompileRewriteRules(rules)
+		if test.err == "" {
+			if err != nil || compiled[0].Response.Body[0].re == nil {
+				t.Errorf("%s: got %v, want the pattern compiled", test.name, err)
+			}
+			if rules[0].Response.Body[0].re != nil {
+				t.Errorf("%s: the rules passed in were changed", test.name)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// limits applied to every response script
+const (
+	scriptTimeout           = 100 * time.Millisecond
+	scriptMemoryBudget uint = 1e6
+	scriptMaxNodes     uint = 1000
+)
+
+// ScriptEnv is what a response script can see. Scripts are expr expressions
+// (https://expr-lang.org), e.g. `fromJSON(body).items[0]`.
+type ScriptEnv struct {
+	StatusCode int    `expr:"status_code"`
+	Body       string `expr:"body"`
+}
+
+// ScriptRegistry holds the compiled response scripts by name
+type ScriptRegistry struct {
+	programs map[string]*vm.Program
+}
+
+var scripts = &ScriptRegistry{programs: map[string]*vm.Program{}}
+
+// LoadScripts compiles the scripts of a JSON file mapping names to expressions
+func LoadScripts(path string) (*ScriptRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources map[string]string
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return nil, fmt.Errorf("invalid scripts file %s: %w", path, err)
+	}
+
+	registry := &ScriptRegistry{programs: make(map[string]*vm.Program, len(sources))}
+	for name, source := range sources {
+		program, err := expr.Compile(source, expr.Env(ScriptEnv{}), expr.MaxNodes(scriptMaxNodes))
+		if err != nil {
+			return nil, fmt.Errorf("script %q: %w", name, err)
+		}
+		registry.programs[name] = program
+	}
+	return registry, nil
+}
+
+func (r *ScriptRegistry) Has(name string) bool {
+	_, ok := r.programs[name]
+	return ok
+}
+
+// Run evaluates the named script over the response and replaces the body with
+// its result: strings are used as is, anything else is encoded as JSON
+func (r *ScriptRegistry) Run(name string, response ProxyResponse) (ProxyResponse, error) {
+	program, ok := r.programs[name]
+	if !ok {
+		return response, fmt.Errorf("unknown script %q", name)
+	}
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	result_chan := make(chan result, 1)
+	go func() {
+		machine := vm.VM{MemoryBudget: scriptMemoryBudget}
+		value, err := machine.Run(program, ScriptEnv{
+			StatusCode: response.StatusCode,
+			Body:       string(response.Body),
+		})
+		result_chan <- result{value, err}
+	}()
+
+	timer := time.NewTimer(scriptTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return response, errors.New("script timed out")
+	case res := <-result_chan:
+		if res.err != nil {
+			return response, res.err
+		}
+		if value, ok := res.value.(string); ok {
+			response.Body = []byte(value)
+			return response, nil
+		}
+		body, err := json.Marshal(res.value)
+		if err != nil {
+			return response, err
+		}
+		response.Body = body
+		return response, nil
+	}
+}
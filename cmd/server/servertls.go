@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ServerTLS is the certificate of the listeners and, for mutual TLS, the CAs
+// client certificates must be signed by. Both are read again from their
+// files by Watch when the files change, so certificates can be rotated
+// without a restart.
+type ServerTLS struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  []time.Time
+}
+
+// serverTLS is set from tls_cert_file, nil when the listeners are plaintext
+var serverTLS *ServerTLS
+
+// LoadServerTLS reads the certificate and key, and the client CAs when
+// client_ca_file is set, which turns mutual TLS on
+func LoadServerTLS(cert_file string, key_file string, client_ca_file string) (*ServerTLS, error) {
+	s := &ServerTLS{certFile: cert_file, keyFile: key_file, clientCAFile: client_ca_file}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ServerTLS) files() []string {
+	files := []string{s.certFile, s.keyFile}
+	if s.clientCAFile != "" {
+		files = append(files, s.clientCAFile)
+	}
+	return files
+}
+
+// modified returns the modification times of the files
+func (s *ServerTLS) modified() ([]time.Time, error) {
+	times := make([]time.Time, 0, 3)
+	for _, file := range s.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, info.ModTime())
+	}
+	return times, nil
+}
+
+func (s *ServerTLS) load() error {
+	mod_times, err := s.modified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("invalid TLS certificate: %w", err)
+	}
+	var client_cas *x509.CertPool
+	if s.clientCAFile != "" {
+		data, err := os.ReadFile(s.clientCAFile)
+		if err != nil {
+			return err
+		}
+		client_cas = x509.NewCertPool()
+		if !client_cas.AppendCertsFromPEM(data) {
+			return errors.New("no certificates found in the client CA file")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = &cert
+	s.clientCAs = client_cas
+	s.modTimes = mod_times
+	return nil
+}
+
+// Mutual tells whether client certificates are required
+func (s *ServerTLS) Mutual() bool {
+	return s.clientCAFile != ""
+}
+
+// Config returns the TLS config of a listener negotiating next_protos, each
+// handshake uses the certificate and client CAs loaded last
+func (s *ServerTLS) Config(next_protos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: next_protos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   next_protos,
+				Certificates: []tls.Certificate{*s.cert},
+			}
+			if s.clientCAs != nil {
+				config.ClientCAs = s.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// Watch reloads the files every interval once one of them changed, keeping
+// the loaded certificate when the new files are invalid
+func (s *ServerTLS) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		mod_times, err := s.modified()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to check TLS certificate files")
+			continue
+		}
+		s.mu.RLock()
+		changed := false
+		for i, mod_time := range mod_times {
+			changed = changed || !mod_time.Equal(s.modTimes[i])
+		}
+		s.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := s.load(); err != nil {
+			log.Error().Err(err).Msg("Failed to reload TLS certificate, keeping the previous one")
+			continue
+		}
+		log.Info().Str("cert_file", s.certFile).Msg("Reloaded TLS certificate")
+	}
+}
+
+// certIdentity names the client of a verified certificate: its common name,
+// or else its first DNS, URI or email SAN
+func certIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := state.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
+
+// grpcCertIdentity is certIdentity for the peer of a gRPC call
+func grpcCertIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	return certIdentity(&info.State)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testCA issues the certificates of the server TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "proxier test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for template and its key, in PEM
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der})
+}
+
+// serverCert writes a certificate named name for 127.0.0.1 to dir, its
+// files modified at mod_time
+func (ca *testCA) serverCert(t *testing.T, dir string, name string, mod_time time.Time) (string, string) {
+	t.Helper()
+	cert, key := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: name}, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	cert_file, key_file := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeTLSFile(t, cert_file, cert, mod_time)
+	writeTLSFile(t, key_file, key, mod_time)
+	return cert_file, key_file
+}
+
+func writeTLSFile(t *testing.T, path string, data []byte, mod_time time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod_time, mod_time); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedName handshakes with the listener and returns the common name of
+// its certificate
+func servedName(t *testing.T, addr string, ca *testCA) string {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestServerTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	loaded_at := time.Now().Add(-time.Minute)
+	cert_file, key_file := ca.serverCert(t, dir, "first", loaded_at)
+	s, err := LoadServerTLS(cert_file, key_file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	addr := listener.Addr().String()
+	if name := servedName(t, addr, ca); name != "first" {
+		t.Fatalf("served %q, want the loaded certificate", name)
+	}
+
+	go s.Watch(10 * time.Millisecond)
+	ca.serverCert(t, dir, "second", loaded_at.Add(time.Second))
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, addr, ca) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate never served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// invalid files keep the certificate loaded last
+	writeTLSFile(t, cert_file, []byte("not a certificate"), loaded_at.Add(2*time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if name := servedName(t, addr, ca); name != "second" {
+		t.Fatalf("served %q after an invalid rotation, want the previous certificate", name)
+	}
+
+	if _, err := LoadServerTLS(cert_file, key_file, ""); err == nil {
+		t.Fatal("invalid certificate loaded")
+	}
+	ca.serverCert(t, dir, "third", loaded_at)
+	if _, err := LoadServerTLS(cert_file, key_file, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Fatal("missing client CA file loaded")
+	}
+}
+
+func TestCertIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/worker")
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		verified bool
+		want     string
+	}{
+		{"common name", &x509.Certificate{Subject: pkix.Name{CommonName: "client"}, DNSNames: []string{"client.example.com"}}, true, "client"},
+		{"dns san", &x509.Certificate{DNSNames: []string{"client.example.com", "other.example.com"}}, true, "client.example.com"},
+		{"uri san", &x509.Certificate{URIs: []*url.URL{spiffe}}, true, "spiffe://example.com/worker"},
+		{"email san", &x509.Certificate{EmailAddresses: []string{"ops@example.com"}}, true, "ops@example.com"},
+		{"no identity", &x509.Certificate{}, true, ""},
+		{"not verified", &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}, false, ""},
+	}
+	for _, test := range tests {
+		state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.cert}}
+		if test.verified {
+			state.VerifiedChains = [][]*x509.Certificate{{test.cert}}
+		}
+		if got := certIdentity(state); got != test.want {
+			t.Errorf("%s: certIdentity = %q, want %q", test.name, got, test.want)
+		}
+	}
+	if got := certIdentity(nil); got != "" {
+		t.Errorf("certIdentity without TLS = %q", got)
+	}
+}
+
+func TestMutualTLSKeys(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	cert_file, key_file := ca.serverCert(t, dir, "server", time.Now())
+	ca_file := filepath.Join(dir, "ca.pem")
+	writeTLSFile(t, ca_file, ca.pem, time.Now())
+	s, err := LoadServerTLS(cert_file, key_file, ca_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Mutual() {
+		t.Fatal("client CA file set without mutual TLS")
+	}
+
+	previous := apiKeys
+	defer func() { apiKeys = previous }()
+	apiKeys, err = LoadAPIKeys(writeAPIKeys(t, []APIKey{{ID: "worker", CertIdentity: "worker.example.com"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/whoami", RequireAPIKey, func(c *fiber.Ctx) error {
+		return c.SendString(KeyIDFrom(requestContext(c)))
+	})
+	go app.Listener(tls.NewListener(listener, s.Config()))
+	defer app.Shutdown()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	tests := []struct {
+		name string
+		// identity is the common name of the client certificate, none without
+		identity string
+		status   int
+		key_id   string
+	}{
+		{"certificate of an API key", "worker.example.com", fiber.StatusOK, "worker"},
+		{"unknown certificate", "other.example.com", fiber.StatusUnauthorized, ""},
+		{"no certificate", "", 0, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &tls.Config{RootCAs: pool}
+			if test.identity != "" {
+				cert, key := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: test.identity}})
+				pair, err := tls.X509KeyPair(cert, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				config.Certificates = []tls.Certificate{pair}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			response, err := client.Get("https://" + listener.Addr().String() + "/whoami")
+			if test.status == 0 {
+				// the handshake fails without a client certificate
+				if err == nil {
+					response.Body.Close()
+					t.Fatalf("got %d, want the handshake rejected", response.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != test.status || (test.key_id != "" && string(body) != test.key_id) {
+				t.Fatalf("got %d %q, want %d %q", response.StatusCode, body, test.status, test.key_id)
+			}
+		})
+	}
+}
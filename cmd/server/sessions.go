@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/net/publicsuffix"
+)
+
+// maxSessionIDLength bounds the session_id clients pick
+const maxSessionIDLength = 128
+
+// Session is the cookie jar of a session_id. Cookies set by the responses of
+// its jobs are sent with its following jobs, by the rules of a browser jar.
+type Session struct {
+	jar     *cookiejar.Jar
+	expires time.Time
+}
+
+// Apply adds the cookies of the session matching the URL of the agent, the
+// cookies of the job itself are set after and win
+func (s *Session) Apply(agent *fiber.Agent) {
+	if s == nil {
+		return
+	}
+	target, err := url.Parse(agent.Request().URI().String())
+	if err != nil {
+		return
+	}
+	for _, cookie := range s.jar.Cookies(target) {
+		agent.Cookie(cookie.Name, cookie.Value)
+	}
+}
+
+// Record stores the Set-Cookie headers of a response from target_url
+func (s *Session) Record(target_url string, headers map[string][]string) {
+	if s == nil {
+		return
+	}
+	set_cookie := headerValues(headers, fiber.HeaderSetCookie)
+	if len(set_cookie) == 0 {
+		return
+	}
+	target, err := url.Parse(target_url)
+	if err != nil {
+		return
+	}
+	cookies := (&http.Response{Header: http.Header{fiber.HeaderSetCookie: set_cookie}}).Cookies()
+	s.jar.SetCookies(target, cookies)
+}
+
+// sessionKey scopes session IDs to the API key using them
+type sessionKey struct {
+	owner string
+	id    string
+}
+
+// SessionStore keeps the sessions of jobs, each expires ttl after its last
+// job. Past maxSessions no new session is opened until others expire.
+type SessionStore struct {
+	mu          sync.Mutex
+	sessions    map[sessionKey]*Session
+	ttl         time.Duration
+	maxSessions int
+}
+
+func NewSessionStore(ttl time.Duration, max_sessions int) *SessionStore {
+	store := &SessionStore{
+		sessions:    make(map[sessionKey]*Session),
+		ttl:         ttl,
+		maxSessions: max_sessions,
+	}
+	go store.janitor()
+	return store
+}
+
+// sessions is sized by session_ttl and max_sessions
+var sessions *SessionStore
+
+// Open returns the session of owner named id, creating it on first use
+func (s *SessionStore) Open(owner string, id string) (*Session, error) {
+	now := time.Now()
+	key := sessionKey{owner, id}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[key]
+	if !ok || now.After(session.expires) {
+		delete(s.sessions, key)
+		if len(s.sessions) >= s.maxSessions {
+			s.sweep(now)
+		}
+		if len(s.sessions) >= s.maxSessions {
+			return nil, &JobError{fiber.StatusServiceUnavailable, "Too many sessions"}
+		}
+		// the public suffix list keeps cookies set for a whole suffix such as
+		// co.uk from being sent to every site under it
+		jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+		if err != nil {
+			return nil, err
+		}
+		session = &Session{jar: jar}
+		s.sessions[key] = session
+	}
+	session.expires = now.Add(s.ttl)
+	return session, nil
+}
+
+// Delete drops the session of owner named id, reporting whether it existed
+func (s *SessionStore) Delete(owner string, id string) bool {
+	key := sessionKey{owner, id}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[key]
+	delete(s.sessions, key)
+	return ok && time.Now().Before(session.expires)
+}
+
+// sweep drops expired sessions, the mutex must be held
+func (s *SessionStore) sweep(now time.Time) {
+	for key, session := range s.sessions {
+		if now.After(session.expires) {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+func (s *SessionStore) janitor() {
+	ticker := time.NewTicker(s.ttl / 2)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.mu.Lock()
+		s.sweep(now)
+		s.mu.Unlock()
+	}
+}
+
+// OpenSession opens the session of the job for the API key of ctx
+func OpenSession(ctx context.Context, job ProxyJob) (ProxyJob, error) {
+	if job.SessionID == "" || sessions == nil {
+		return job, nil
+	}
+	session, err := sessions.Open(KeyIDFrom(ctx), job.SessionID)
+	if err != nil {
+		return job, err
+	}
+	job.session = session
+	return job, nil
+}
+
+// DeleteSession drops a session and its cookies
+// @Description Drops the cookie jar of a session_id, its next job starts a new session
+// @Param id path string true "Session ID used by the jobs"
+func DeleteSession(c *fiber.Ctx) error {
+	if !sessions.Delete(KeyIDFrom(requestContext(c)), c.Params("id")) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Session not found or expired",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
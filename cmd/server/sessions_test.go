@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func sessionCookies(t *testing.T, session *Session, target_url string) []string {
+	t.Helper()
+	agent := NewAgent(&fiber.Client{}, fiber.MethodGet, target_url)
+	session.Apply(agent)
+	var names []string
+	agent.Request().Header.VisitAllCookie(func(key, _ []byte) {
+		names = append(names, string(key))
+	})
+	sort.Strings(names)
+	return names
+}
+
+func TestSessionCookieScoping(t *testing.T) {
+	tests := []struct {
+		name       string
+		set_url    string
+		set_cookie []string
+		get_url    string
+		want       []string
+	}{
+		{"host cookie on its host", "http://a.example.com/", []string{"id=1"}, "http://a.example.com/", []string{"id"}},
+		{"host cookie on a sibling host", "http://a.example.com/", []string{"id=1"}, "http://b.example.com/", nil},
+		{"host cookie on a subdomain", "http://example.com/", []string{"id=1"}, "http://a.example.com/", nil},
+		{"domain cookie on a sibling host", "http://a.example.com/", []string{"id=1; Domain=example.com"}, "http://b.example.com/", []string{"id"}},
+		{"domain cookie on another site", "http://a.example.com/", []string{"id=1; Domain=example.com"}, "http://example.org/", nil},
+		{"domain of another site", "http://example.com/", []string{"id=1; Domain=example.org"}, "http://example.org/", nil},
+		{"domain of a public suffix", "http://shop.co.uk/", []string{"id=1; Domain=co.uk"}, "http://other.co.uk/", nil},
+		{"path cookie under its path", "http://example.com/api/login", []string{"id=1; Path=/api"}, "http://example.com/api/items", []string{"id"}},
+		{"path cookie outside its path", "http://example.com/api/login", []string{"id=1; Path=/api"}, "http://example.com/apix", nil},
+		{"default path of the set URL", "http://example.com/api/login", []string{"id=1"}, "http://example.com/", nil},
+		{"secure cookie over https", "https://example.com/", []string{"id=1; Secure"}, "https://example.com/", []string{"id"}},
+		{"secure cookie over http", "https://example.com/", []string{"id=1; Secure"}, "http://example.com/", nil},
+		{"expired cookie", "http://example.com/", []string{"id=1; Max-Age=0"}, "http://example.com/", nil},
+		{"past expiry", "http://example.com/", []string{"id=1; Expires=Thu, 01 Jan 1970 00:00:00 GMT"}, "http://example.com/", nil},
+		{"only matching cookies", "http://a.example.com/", []string{"host=1", "site=1; Domain=example.com"}, "http://b.example.com/", []string{"site"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewSessionStore(time.Minute, 10)
+			session, err := store.Open("key", "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			session.Record(test.set_url, map[string][]string{fiber.HeaderSetCookie: test.set_cookie})
+
+			got := sessionCookies(t, session, test.get_url)
+			if len(got) != len(test.want) {
+				t.Fatalf("cookies sent to %s = %v, want %v", test.get_url, got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("cookies sent to %s = %v, want %v", test.get_url, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionStoreScopesSessionsToOwners(t *testing.T) {
+	store := NewSessionStore(time.Minute, 10)
+	first, err := store.Open("first", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Record("http://example.com/", map[string][]string{fiber.HeaderSetCookie: {"id=1"}})
+
+	second, err := store.Open("second", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionCookies(t, second, "http://example.com/"); len(got) != 0 {
+		t.Fatalf("session of another owner sent %v", got)
+	}
+	again, err := store.Open("first", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionCookies(t, again, "http://example.com/"); len(got) != 1 {
+		t.Fatalf("reopened session sent %v, want [id]", got)
+	}
+}
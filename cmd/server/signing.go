@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SigningScheme describes a custom HMAC request signature. The template is
+// expanded into the canonical string with these placeholders:
+//
+//	{method} {host} {path} {query} {timestamp} {body_hash}
+//
+// where {body_hash} is the hex digest of the body with the scheme algorithm.
+type SigningScheme struct {
+	Template        string `json:"template"`
+	Algorithm       string `json:"algorithm"`
+	Header          string `json:"header"`
+	KeyEnv          string `json:"key_env"`
+	TimestampHeader string `json:"timestamp_header"`
+	Encoding        string `json:"encoding"`
+
+	key []byte
+}
+
+// signingSchemes are loaded from PROXIER_SIGNING_SCHEMES_FILE
+var signingSchemes = map[string]*SigningScheme{}
+
+// LoadSigningSchemes reads a JSON file mapping scheme names to their definition
+// and resolves each signing key from the environment variable it references
+func LoadSigningSchemes(path string) (map[string]*SigningScheme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schemes map[string]*SigningScheme
+	if err := json.Unmarshal(data, &schemes); err != nil {
+		return nil, fmt.Errorf("invalid signing schemes file %s: %w", path, err)
+	}
+
+	for name, scheme := range schemes {
+		if scheme.Header == "" || scheme.Template == "" {
+			return nil, fmt.Errorf("signing scheme %q needs a header and a template", name)
+		}
+		if hashFunc(scheme.Algorithm) == nil {
+			return nil, fmt.Errorf("signing scheme %q: unsupported algorithm %q", name, scheme.Algorithm)
+		}
+		key := os.Getenv(scheme.KeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("signing scheme %q: key variable %s is not set", name, scheme.KeyEnv)
+		}
+		scheme.key = []byte(key)
+	}
+	return schemes, nil
+}
+
+func hashFunc(algorithm string) func() hash.Hash {
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New
+	case "", "sha256":
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	}
+	return nil
+}
+
+// Sign computes the signature of the request and sets it on the request headers
+func (s *SigningScheme) Sign(req *fiber.Request, now time.Time) {
+	h := hashFunc(s.Algorithm)
+
+	body_hash := h()
+	body_hash.Write(req.Body())
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	canonical := strings.NewReplacer(
+		"{method}", string(req.Header.Method()),
+		"{host}", string(req.URI().Host()),
+		"{path}", string(req.URI().Path()),
+		"{query}", string(req.URI().QueryString()),
+		"{timestamp}", timestamp,
+		"{body_hash}", hex.EncodeToString(body_hash.Sum(nil)),
+	).Replace(s.Template)
+
+	mac := hmac.New(h, s.key)
+	mac.Write([]byte(canonical))
+	sum := mac.Sum(nil)
+
+	signature := hex.EncodeToString(sum)
+	if strings.EqualFold(s.Encoding, "base64") {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+
+	if s.TimestampHeader != "" {
+		req.Header.Set(s.TimestampHeader, timestamp)
+	}
+	req.Header.Set(s.Header, signature)
+}
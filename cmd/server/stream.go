@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultMaxStreamBodyBytes = 1 << 30
+
+	streamedHeader = "X-Proxier-Streamed"
+	// headersTruncatedHeader flags streams whose upstream headers were cut at
+	// response_max_headers, response_max_header_bytes or
+	// response_max_header_value_bytes
+	headersTruncatedHeader = "X-Proxier-Headers-Truncated"
+)
+
+// maxStreamBodyBytes caps the bodies of /proxy/stream, set from stream_max_body_bytes
+var maxStreamBodyBytes = defaultMaxStreamBodyBytes
+
+// streamedHeaders are set by the server itself for the streamed body
+var streamedHeaders = map[string]bool{
+	fiber.HeaderContentLength: true,
+	fiber.HeaderConnection:    true,
+	fiber.HeaderDate:          true,
+	"Transfer-Encoding":       true,
+}
+
+// unsupportedStreamOption names the first option of the job that needs the
+// whole body in memory, those can't be used when streaming
+func unsupportedStreamOption(job ProxyJob) string {
+	switch {
+	case job.Pagination != nil:
+		return "pagination"
+	case job.Script != "":
+		return "script"
+	case job.CacheBody:
+		return "cache_body"
+	case job.CompressResponseBody:
+		return "compress_response_body"
+	case job.FollowRedirects:
+		return "follow_redirects"
+	case job.FollowMetaRefresh || job.DetectJSRedirect:
+		return "follow_meta_refresh"
+	case job.MaxRetries > 0 || job.Retry != nil || job.RetryIfBodyMatches != "":
+		return "retry"
+	case len(job.Extract) > 0:
+		return "extract"
+	case hasResponseBodyRewrites(job.RewriteRules):
+		return "rewrite_rules"
+	case job.BasicAuthUser != "" && job.AuthType != AuthTypeBasic:
+		// the request is not sent again to answer a challenge
+		return "auth challenges, set auth_type to basic"
+	}
+	return ""
+}
+
+// idleConn moves the read deadline forward on every read once streaming
+// starts, so that the timeout bounds gaps in the body rather than its length
+type idleConn struct {
+	net.Conn
+	idle      time.Duration
+	streaming atomic.Bool
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	if c.streaming.Load() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.idle))
+	}
+	return c.Conn.Read(p)
+}
+
+// limitedBody fails the stream once more than remaining bytes are read
+type limitedBody struct {
+	body      io.Reader
+	limit     int
+	remaining int
+	close     func(err error)
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		var probe [1]byte
+		n, err := b.body.Read(probe[:])
+		if n > 0 {
+			b.err = fmt.Errorf("response body exceeds %d bytes", b.limit)
+			return 0, b.err
+		}
+		return 0, err
+	}
+	if len(p) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= n
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close is called by fasthttp once the body is sent or the client went away
+func (b *limitedBody) Close() error {
+	b.close(b.err)
+	return nil
+}
+
+// PerformProxyStream handles proxy jobs whose body is streamed back as is
+// @Description Performs the job and answers with the upstream status, headers and body instead of a JSON envelope.
+// @Description The timeout applies until the upstream headers arrive, then to each pause in the body. Scripts, retries, redirects, pagination, compression and auth challenges are not supported.
+// @Param job body ProxyJob true "Proxy job, max_body_bytes may go up to stream_max_body_bytes"
+func PerformProxyStream(c *fiber.Ctx) error {
+	started := time.Now()
+
+	var job ProxyJob
+	if err := c.BodyParser(&job); err != nil {
+		log.Error().Err(err).Str("handler", "PerformProxyStream").Msg("Failed to parse request body")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if coordinator != nil {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
+			"error": "The coordinator does not stream, send the job to a worker",
+		})
+	}
+	parent := requestContext(c)
+	job, err := ValidateJob(parent, job, maxStreamBodyBytes)
+	if err != nil {
+		return sendJobError(c, err)
+	}
+	if option := unsupportedStreamOption(job); option != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Streaming does not support " + option,
+		})
+	}
+	job, err = OpenSession(parent, job)
+	if err != nil {
+		return sendJobError(c, err)
+	}
+	logger := log.With().Str("url", job.URL).Str("method", job.Method).Str("key_id", KeyIDFrom(parent)).Logger()
+
+	if job.ProxyURL == "" && proxyPool != nil {
+		job.ProxyURL = proxyPool.Pick(job.URL)
+		job.pooled = true
+	}
+
+	limit := maxStreamBodyBytes
+	if job.MaxBodyBytes > 0 {
+		limit = job.MaxBodyBytes
+	}
+	timeout := JobTimeout(job)
+	logger.Info().Dur("timeout", timeout).Int("max_body_bytes", limit).Msg("Received stream request")
+
+	if breakers != nil {
+		if err := breakers.Allow(job); err != nil {
+			logger.Warn().Err(err).Msg("Failing fast, circuit is open")
+			return sendJobError(c, err)
+		}
+	}
+	limit_ctx, limit_cancel := context.WithTimeout(parent, timeout)
+	err = domainLimits.Wait(limit_ctx, job.URL)
+	limit_cancel()
+	if err != nil {
+		logger.Warn().Err(err).Msg("Domain rate limit exceeded")
+		return sendJobError(c, err)
+	}
+
+	agent := NewAgent(&fiber.Client{}, job.Method, job.URL)
+	if agent == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid HTTP method",
+		})
+	}
+	if err := PrepareAgent(agent, job); err != nil {
+		fiber.ReleaseAgent(agent)
+		return sendJobError(c, err)
+	}
+	// the limit is enforced while streaming, fasthttp would only check Content-Length
+	agent.HostClient.MaxResponseBodySize = 0
+	agent.HostClient.StreamResponseBody = true
+
+	var conn *idleConn
+	dial := agent.HostClient.Dial
+	agent.HostClient.Dial = func(addr string) (net.Conn, error) {
+		upstream, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = &idleConn{Conn: upstream, idle: timeout}
+		return conn, nil
+	}
+	agent.HostClient.MaxConns = 1
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	metrics.AddInFlight(1)
+	resp := fiber.AcquireResponse()
+	// the worker is held until the body is streamed, so streams count
+	// against the worker pool like other jobs
+	worker_done := make(chan struct{})
+	release := func() {
+		close(worker_done)
+		fiber.ReleaseResponse(resp)
+		fiber.ReleaseAgent(agent)
+		cancel()
+		metrics.AddInFlight(-1)
+	}
+
+	var (
+		request_mu sync.Mutex
+		running    bool
+		abandoned  bool
+	)
+	request_done := make(chan error, 1)
+	err = RunOnWorker(ctx, func() {
+		request_mu.Lock()
+		if abandoned {
+			request_mu.Unlock()
+			release()
+			return
+		}
+		running = true
+		request_mu.Unlock()
+
+		if ctx.Err() != nil {
+			request_done <- ctx.Err()
+			<-worker_done
+			return
+		}
+		// from when the worker starts, time spent in the queue is not added
+		ApplyDeadline(ctx, agent)
+		request_started := time.Now()
+		err := agent.HostClient.Do(agent.Request(), resp)
+		metrics.ObserveUpstream(time.Since(request_started))
+		request_done <- err
+		<-worker_done
+	})
+	if err != nil {
+		release()
+		logger.Warn().Msg("Worker pool is full, shedding stream")
+		return sendJobError(c, err)
+	}
+
+	select {
+	case err = <-request_done:
+	case <-ctx.Done():
+		request_mu.Lock()
+		if !running {
+			// timed out while queued, the worker releases the request
+			abandoned = true
+			request_mu.Unlock()
+			return streamFailed(c, job, logger, ctx.Err())
+		}
+		request_mu.Unlock()
+		// the request has the same deadline and returns right away
+		err = <-request_done
+	}
+	proxy := RedactProxyURL(UpstreamProxyFor(job))
+	if proxy != "" {
+		metrics.IncProxyRequest(proxy, err == nil)
+	}
+	if breakers != nil {
+		if err != nil {
+			breakers.Record(job, breakerFailed(0, []error{err}))
+		} else {
+			breakers.Record(job, breakerFailed(resp.StatusCode(), nil))
+		}
+	}
+	if err != nil {
+		release()
+		return streamFailed(c, job, logger, err)
+	}
+	metrics.IncRequest(job.Method, resp.StatusCode())
+	if conn != nil {
+		conn.streaming.Store(true)
+	}
+
+	if length := resp.Header.ContentLength(); length > limit {
+		release()
+		logger.Warn().Int("content_length", length).Int("max_body_bytes", limit).Msg("Response body too large to stream")
+		RecordDeadLetter(job, []string{fmt.Sprintf("response body exceeds %d bytes", limit)})
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": fmt.Sprintf("Response body exceeds %d bytes", limit),
+		})
+	}
+
+	status_code := resp.StatusCode()
+	c.Status(status_code)
+	c.Response().Header.SetNoDefaultContentType(true)
+	headers, truncated := CaptureHeaders(resp, job.KeepHopByHopHeaders)
+	if truncated {
+		logger.Warn().Int("max_headers", maxResponseHeaders).Int("max_header_bytes", maxResponseHeaderBytes).Int("max_header_value_bytes", maxResponseHeaderValueBytes).Msg("Response headers truncated")
+		c.Set(headersTruncatedHeader, "true")
+	}
+	job.session.Record(job.URL, headers)
+	// server wide body rewrites are skipped, the body is streamed as received
+	headers = RewriteResponseHeaders(headers, job, job.URL)
+	for name, values := range headers {
+		if streamedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			continue
+		}
+		for _, value := range values {
+			c.Response().Header.Add(name, value)
+		}
+	}
+	// tells upstream responses apart from errors of the worker itself
+	c.Set(streamedHeader, "true")
+	if proxy != "" {
+		c.Set("X-Proxier-Upstream-Proxy", proxy)
+	}
+
+	stream := resp.BodyStream()
+	if stream == nil {
+		// HEAD requests and bodiless statuses
+		release()
+		return nil
+	}
+
+	c.Response().SetBodyStream(&limitedBody{
+		body:      stream,
+		limit:     limit,
+		remaining: limit,
+		close: func(err error) {
+			release()
+			if err != nil {
+				logger.Error().Err(err).Msg("Stream aborted")
+				RecordDeadLetter(job, []string{err.Error()})
+				return
+			}
+			logger.Info().Int("status_code", status_code).Dur("duration", time.Since(started)).Msg("Stream completed")
+		},
+	}, resp.Header.ContentLength())
+	return nil
+}
+
+// streamFailed answers a stream request whose upstream request failed
+func streamFailed(c *fiber.Ctx, job ProxyJob, logger zerolog.Logger, err error) error {
+	if policy_err := policyError([]error{err}); policy_err != nil {
+		return sendJobError(c, policy_err)
+	}
+	detail := ClassifyError(err)
+	if detail.Code == ErrorTimeout {
+		logger.Warn().Msg("Request timed out")
+		metrics.IncTimeout()
+		metrics.IncRequest(job.Method, 0)
+		RecordDeadLetter(job, []string{"request timed out"})
+		return sendJobError(c, &JobError{fiber.StatusGatewayTimeout, "Request timed out"})
+	}
+
+	logger.Error().Err(err).Str("code", detail.Code).Msg("Request failed")
+	metrics.IncRequest(job.Method, 0)
+	RecordDeadLetter(job, []string{err.Error()})
+	return c.Status(fiber.StatusBadGateway).JSON(errorBody(detail, err.Error()))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func streamRequest(t *testing.T, app *fiber.App, job ProxyJob) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(fiber.MethodPost, "/proxy/stream", strings.NewReader(string(body)))
+	request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestStreamRunsOnWorkerPool(t *testing.T) {
+	started := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-unblock
+		}
+		fmt.Fprint(w, "streamed")
+	}))
+	defer upstream.Close()
+
+	previous := workerPool
+	// no queue, a stream waits at most queue_wait for the worker
+	workerPool = NewWorkerPool(1, 0, 200*time.Millisecond)
+	defer func() { workerPool = previous }()
+
+	app := fiber.New()
+	app.Post("/proxy/stream", PerformProxyStream)
+
+	slow := make(chan *http.Response, 1)
+	go func() {
+		slow <- streamRequest(t, app, ProxyJob{URL: upstream.URL + "/slow", Method: fiber.MethodGet, Timeout: 5})
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow stream never reached the upstream")
+	}
+
+	// the only worker is held while the slow body streams
+	response := streamRequest(t, app, ProxyJob{URL: upstream.URL + "/fast", Method: fiber.MethodGet, Timeout: 5})
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != fiber.StatusServiceUnavailable || !strings.Contains(string(body), ErrorOverloaded) {
+		t.Fatalf("stream without a free worker got %d %s, want 503 %s", response.StatusCode, body, ErrorOverloaded)
+	}
+	if response.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Fatal("shed stream has no Retry-After")
+	}
+
+	close(unblock)
+	response = <-slow
+	body, _ = io.ReadAll(response.Body)
+	if response.StatusCode != fiber.StatusOK || string(body) != "streamed" {
+		t.Fatalf("slow stream got %d %q", response.StatusCode, body)
+	}
+	if workerPool.busy.Load() != 0 {
+		// the worker is given back once the body is sent
+		deadline := time.Now().Add(time.Second)
+		for workerPool.busy.Load() != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if workerPool.busy.Load() != 0 {
+			t.Fatal("worker still busy after the stream completed")
+		}
+	}
+
+	response = streamRequest(t, app, ProxyJob{URL: upstream.URL + "/fast", Method: fiber.MethodGet, Timeout: 5})
+	body, _ = io.ReadAll(response.Body)
+	if response.StatusCode != fiber.StatusOK || string(body) != "streamed" {
+		t.Fatalf("stream after the worker was freed got %d %q", response.StatusCode, body)
+	}
+}
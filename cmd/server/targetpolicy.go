@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// Codes of a *PolicyError
+const (
+	PolicySchemeNotAllowed = "scheme_not_allowed"
+	PolicyTargetDenied     = "target_denied"
+	PolicyPrivateAddress   = "private_address"
+)
+
+const targetDialTimeout = 10 * time.Second
+
+// PolicyError is a job target rejected by the target policy
+type PolicyError struct {
+	Code    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// TargetPolicy decides which upstreams jobs may reach. Deny lists win over
+// allow lists, and once an allow list is set targets have to match it: host
+// names are matched with * globs, e.g. *.example.com, and the addresses they
+// resolve to by CIDR. With AllowlistOnly every host has to be allow listed,
+// an empty allow list rejecting them all; addresses given as the host may be
+// in AllowCIDRs instead.
+//
+// Loopback, private, link-local and other non public addresses are blocked
+// unless AllowPrivate is set or they are in AllowCIDRs. Addresses are checked
+// after DNS resolution and the checked address is the one dialed, so a name
+// cannot resolve to a public address for the check and a private one later.
+type TargetPolicy struct {
+	AllowSchemes []string
+	AllowHosts   []string
+	DenyHosts    []string
+	AllowCIDRs   []*net.IPNet
+	DenyCIDRs    []*net.IPNet
+	AllowPrivate bool
+
+	AllowlistOnly bool
+}
+
+// targetPolicy is configured from the target_* settings, blocking private
+// addresses by default
+var targetPolicy = &TargetPolicy{AllowSchemes: []string{"http", "https"}}
+
+// ParseCIDRs parses addresses and networks, a lone address being its own /32 or /128
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// CheckURL checks the scheme and host of a target before anything is resolved
+func (p *TargetPolicy) CheckURL(raw_url string) error {
+	parsed, err := url.Parse(raw_url)
+	if err != nil {
+		return &PolicyError{PolicyTargetDenied, "Invalid target URL"}
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	allowed := false
+	for _, allow := range p.AllowSchemes {
+		if strings.EqualFold(allow, scheme) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return &PolicyError{PolicySchemeNotAllowed, fmt.Sprintf("Scheme %q is not allowed", parsed.Scheme)}
+	}
+	return p.CheckHost(parsed.Hostname())
+}
+
+// CheckHost checks a host name against the host lists, and addresses given
+// as the host against the address rules
+func (p *TargetPolicy) CheckHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		if p.AllowlistOnly && !matchHost(p.AllowHosts, host) && !inNetworks(p.AllowCIDRs, ip) {
+			return p.notAllowListed(host)
+		}
+		return p.CheckIP(ip)
+	}
+	if matchHost(p.DenyHosts, host) {
+		return &PolicyError{PolicyTargetDenied, fmt.Sprintf("Host %s is denied", host)}
+	}
+	if p.AllowlistOnly && !matchHost(p.AllowHosts, host) {
+		return p.notAllowListed(host)
+	}
+	if len(p.AllowHosts) > 0 && !matchHost(p.AllowHosts, host) {
+		return &PolicyError{PolicyTargetDenied, fmt.Sprintf("Host %s is not allowed", host)}
+	}
+	return nil
+}
+
+// notAllowListed rejects a host in allowlist_only mode, logged for audit
+func (p *TargetPolicy) notAllowListed(host string) error {
+	log.Warn().Str("host", host).Msg("Host rejected, not in the allow list")
+	return &PolicyError{PolicyTargetDenied, fmt.Sprintf("Host %s is not in the allow list", host)}
+}
+
+// CheckIP checks a resolved address
+func (p *TargetPolicy) CheckIP(ip net.IP) error {
+	if inNetworks(p.DenyCIDRs, ip) {
+		return &PolicyError{PolicyTargetDenied, fmt.Sprintf("Address %s is denied", ip)}
+	}
+	if inNetworks(p.AllowCIDRs, ip) {
+		return nil
+	}
+	if len(p.AllowCIDRs) > 0 {
+		return &PolicyError{PolicyTargetDenied, fmt.Sprintf("Address %s is not allowed", ip)}
+	}
+	if !p.AllowPrivate && isPrivate(ip) {
+		return &PolicyError{PolicyPrivateAddress, fmt.Sprintf("Address %s is not public", ip)}
+	}
+	return nil
+}
+
+// Resolve checks the host and returns its addresses once every one of them
+// has passed the policy
+func (p *TargetPolicy) Resolve(host string) ([]net.IPAddr, error) {
+	if err := p.CheckHost(host); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), targetDialTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, resolved := range addrs {
+		if err := p.CheckIP(resolved.IP); err != nil {
+			return nil, err
+		}
+	}
+	return addrs, nil
+}
+
+// CheckProxy checks the host of a proxy given by a job, which would otherwise
+// let the job reach any address through it
+func (p *TargetPolicy) CheckProxy(proxy_url string) error {
+	parsed, err := url.Parse(proxy_url)
+	if err != nil {
+		return &PolicyError{PolicyTargetDenied, "Invalid proxy URL"}
+	}
+	_, err = p.Resolve(parsed.Hostname())
+	return err
+}
+
+// Guard wraps the dial func of an agent so that every connection, redirects
+// included, is checked after resolution. Direct connections dial the checked
+// address; through a proxy the target is resolved here too, the proxy being
+// trusted to resolve it the same way.
+func (p *TargetPolicy) Guard(next fasthttp.DialFunc) fasthttp.DialFunc {
+	return p.guard(next, nil)
+}
+
+// guard is Guard reporting the resolution and dial times to timer
+func (p *TargetPolicy) guard(next fasthttp.DialFunc, timer *phaseTimer) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		resolving := time.Now()
+		addrs, err := p.Resolve(host)
+		if err != nil {
+			return nil, err
+		}
+		if timer != nil {
+			timer.observe(&timer.timing.DNS, resolving)
+			defer timer.observe(&timer.timing.Connect, time.Now())
+		}
+		if next != nil {
+			return next(addr)
+		}
+
+		dialer := &net.Dialer{Timeout: targetDialTimeout}
+		for _, resolved := range addrs {
+			conn, dial_err := dialer.Dial("tcp", net.JoinHostPort(resolved.IP.String(), port))
+			if dial_err == nil {
+				return conn, nil
+			}
+			err = dial_err
+		}
+		if err == nil {
+			err = fmt.Errorf("no addresses found for %s", host)
+		}
+		return nil, err
+	}
+}
+
+// policyError returns the first *PolicyError of errs, if any
+func policyError(errs []error) *PolicyError {
+	for _, err := range errs {
+		var policy_err *PolicyError
+		if errors.As(err, &policy_err) {
+			return policy_err
+		}
+	}
+	return nil
+}
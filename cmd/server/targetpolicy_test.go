@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAllowlistOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	tests := []struct {
+		name   string
+		policy *TargetPolicy
+		url    string
+		// allowed is whether the host passes both before and after resolution
+		allowed bool
+	}{
+		{"allow listed host", &TargetPolicy{AllowHosts: []string{"localhost"}, AllowlistOnly: true}, "http://localhost:" + port + "/", true},
+		{"allow listed glob", &TargetPolicy{AllowHosts: []string{"*.example.com"}, AllowlistOnly: true}, "http://api.example.com/", true},
+		{"host not allow listed", &TargetPolicy{AllowHosts: []string{"*.example.com"}, AllowlistOnly: true}, "http://localhost:" + port + "/", false},
+		{"empty allow list rejects everything", &TargetPolicy{AllowlistOnly: true}, "http://localhost:" + port + "/", false},
+		{"address not allow listed", &TargetPolicy{AllowHosts: []string{"localhost"}, AllowlistOnly: true}, "http://127.0.0.1:" + port + "/", false},
+		{"address in the allowed networks", &TargetPolicy{AllowCIDRs: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}}, AllowlistOnly: true}, "http://127.0.0.1:" + port + "/", true},
+		{"deny list still wins", &TargetPolicy{AllowHosts: []string{"*.example.com"}, DenyHosts: []string{"admin.example.com"}, AllowlistOnly: true}, "http://admin.example.com/", false},
+		{"permissive without an allow list", &TargetPolicy{}, "http://localhost:" + port + "/", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := *test.policy
+			policy.AllowSchemes = []string{"http", "https"}
+			policy.AllowPrivate = true
+
+			err := policy.CheckURL(test.url)
+			var policy_err *PolicyError
+			if test.allowed {
+				if err != nil {
+					t.Fatalf("CheckURL got %v, want the host allowed", err)
+				}
+			} else if !errors.As(err, &policy_err) || policy_err.Code != PolicyTargetDenied {
+				t.Fatalf("CheckURL got %v, want a %s policy error", err, PolicyTargetDenied)
+			}
+
+			// the dial of every connection is checked too, redirect hops included
+			parsed, _ := url.Parse(test.url)
+			if parsed.Port() != port {
+				return
+			}
+			conn, err := policy.Guard(nil)(parsed.Host)
+			if test.allowed {
+				if err != nil {
+					t.Fatalf("Guard got %v, want the host dialed", err)
+				}
+				conn.Close()
+			} else if !errors.As(err, &policy_err) || policy_err.Code != PolicyTargetDenied {
+				t.Fatalf("Guard got %v, want a %s policy error", err, PolicyTargetDenied)
+			}
+		})
+	}
+
+	previous := targetPolicy
+	defer func() { targetPolicy = previous }()
+	targetPolicy = &TargetPolicy{AllowSchemes: []string{"http"}, AllowHosts: []string{"allowed.example.com"}, AllowPrivate: true, AllowlistOnly: true}
+	status, data := postJob(t, ProxyJob{URL: server.URL, Method: http.MethodGet})
+	if status != http.StatusForbidden {
+		t.Fatalf("job to a host not allow listed got %d %s, want 403", status, data)
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	_, internal, _ := net.ParseCIDR("10.1.0.0/16")
+	tests := []struct {
+		name   string
+		policy TargetPolicy
+		ip     string
+		// code is the policy error code, "" when the address is allowed
+		code string
+	}{
+		{"public", TargetPolicy{}, "93.184.216.34", ""},
+		{"loopback", TargetPolicy{}, "127.0.0.1", PolicyPrivateAddress},
+		{"ipv6 loopback", TargetPolicy{}, "::1", PolicyPrivateAddress},
+		{"rfc1918 10/8", TargetPolicy{}, "10.0.0.5", PolicyPrivateAddress},
+		{"rfc1918 172.16/12", TargetPolicy{}, "172.20.1.1", PolicyPrivateAddress},
+		{"rfc1918 192.168/16", TargetPolicy{}, "192.168.1.1", PolicyPrivateAddress},
+		{"metadata link-local", TargetPolicy{}, "169.254.169.254", PolicyPrivateAddress},
+		{"ipv6 link-local", TargetPolicy{}, "fe80::1", PolicyPrivateAddress},
+		{"ipv6 unique local", TargetPolicy{}, "fd00::1", PolicyPrivateAddress},
+		{"ipv4-mapped loopback", TargetPolicy{}, "::ffff:127.0.0.1", PolicyPrivateAddress},
+		{"ipv4-mapped metadata", TargetPolicy{}, "::ffff:169.254.169.254", PolicyPrivateAddress},
+		{"unspecified", TargetPolicy{}, "0.0.0.0", PolicyPrivateAddress},
+		{"private allowed", TargetPolicy{AllowPrivate: true}, "10.0.0.5", ""},
+		{"private in the allowed networks", TargetPolicy{AllowCIDRs: []*net.IPNet{internal}}, "10.1.2.3", ""},
+		{"outside the allowed networks", TargetPolicy{AllowCIDRs: []*net.IPNet{internal}}, "93.184.216.34", PolicyTargetDenied},
+		{"denied network wins", TargetPolicy{AllowPrivate: true, AllowCIDRs: []*net.IPNet{internal}, DenyCIDRs: []*net.IPNet{internal}}, "10.1.2.3", PolicyTargetDenied},
+	}
+	for _, test := range tests {
+		err := test.policy.CheckIP(net.ParseIP(test.ip))
+		var policy_err *PolicyError
+		if test.code == "" {
+			if err != nil {
+				t.Errorf("%s: CheckIP(%s) = %v, want allowed", test.name, test.ip, err)
+			}
+		} else if !errors.As(err, &policy_err) || policy_err.Code != test.code {
+			t.Errorf("%s: CheckIP(%s) = %v, want a %s error", test.name, test.ip, err, test.code)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TargetPolicy
+		host   string
+		code   string
+	}{
+		{"no lists", TargetPolicy{}, "api.example.com", ""},
+		{"allow listed", TargetPolicy{AllowHosts: []string{"*.example.com"}}, "api.example.com", ""},
+		{"not allow listed", TargetPolicy{AllowHosts: []string{"*.example.com"}}, "example.org", PolicyTargetDenied},
+		{"denied", TargetPolicy{DenyHosts: []string{"internal.example.com"}}, "internal.example.com", PolicyTargetDenied},
+		{"deny wins over allow", TargetPolicy{AllowHosts: []string{"*.example.com"}, DenyHosts: []string{"internal.example.com"}}, "internal.example.com", PolicyTargetDenied},
+		{"case and trailing dot ignored", TargetPolicy{DenyHosts: []string{"internal.example.com"}}, "Internal.Example.com.", PolicyTargetDenied},
+		{"address host checked as an address", TargetPolicy{}, "169.254.169.254", PolicyPrivateAddress},
+	}
+	for _, test := range tests {
+		err := test.policy.CheckHost(test.host)
+		var policy_err *PolicyError
+		if test.code == "" {
+			if err != nil {
+				t.Errorf("%s: CheckHost(%s) = %v, want allowed", test.name, test.host, err)
+			}
+		} else if !errors.As(err, &policy_err) || policy_err.Code != test.code {
+			t.Errorf("%s: CheckHost(%s) = %v, want a %s error", test.name, test.host, err, test.code)
+		}
+	}
+}
+
+func TestGuardChecksResolvedAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// the name passes the host lists, the address it resolves to doesn't
+	policy := &TargetPolicy{AllowSchemes: []string{"http"}, AllowHosts: []string{"localhost"}}
+	if err := policy.CheckURL("http://localhost:" + port + "/"); err != nil {
+		t.Fatalf("CheckURL got %v, want the name allowed before resolution", err)
+	}
+	var policy_err *PolicyError
+	if _, err := policy.Guard(nil)("localhost:" + port); !errors.As(err, &policy_err) || policy_err.Code != PolicyPrivateAddress {
+		t.Fatalf("Guard got %v, want a %s error", err, PolicyPrivateAddress)
+	}
+
+	// through a proxy the target is resolved and checked before the proxy is dialed
+	dialed := false
+	proxied := policy.Guard(func(addr string) (net.Conn, error) {
+		dialed = true
+		return net.Dial("tcp", server.Listener.Addr().String())
+	})
+	if _, err := proxied("localhost:" + port); !errors.As(err, &policy_err) || dialed {
+		t.Fatalf("proxied Guard got %v, dialed: %v, want a policy error before dialing", err, dialed)
+	}
+}
+
+func TestRedirectHopsChecked(t *testing.T) {
+	final := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer final.Close()
+	_, port, _ := net.SplitHostPort(final.Listener.Addr().String())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:"+port+"/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	previous := targetPolicy
+	defer func() { targetPolicy = previous }()
+	// the job reaches 127.0.0.1, its redirect goes to a denied host
+	targetPolicy = &TargetPolicy{AllowSchemes: []string{"http"}, DenyHosts: []string{"localhost"}, AllowPrivate: true}
+	status, data := postJob(t, ProxyJob{URL: server.URL, Method: http.MethodGet, FollowRedirects: true})
+	if status != http.StatusForbidden {
+		t.Fatalf("redirect to a denied host got %d %s, want 403", status, data)
+	}
+}
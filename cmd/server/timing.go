@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/valyala/fasthttp"
+)
+
+// PhaseTiming is the time the last attempt of a job spent in each phase.
+// Skipped phases are 0: dns and connect on a reused connection, tls on plain
+// http. TTFB and total count from the request being sent, like the
+// starttransfer and total times of curl.
+type PhaseTiming struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+}
+
+// Log adds the phases to a log line as dns_ms, connect_ms, tls_ms, ttfb_ms
+// and total_ms
+func (t PhaseTiming) Log(event *zerolog.Event) *zerolog.Event {
+	return event.
+		Int64("dns_ms", t.DNS.Milliseconds()).
+		Int64("connect_ms", t.Connect.Milliseconds()).
+		Int64("tls_ms", t.TLS.Milliseconds()).
+		Int64("ttfb_ms", t.TTFB.Milliseconds()).
+		Int64("total_ms", t.Total.Milliseconds())
+}
+
+// phaseTimer times the requests of one agent. The dial func set by
+// SetDialer reports dns, connect and tls, the connections it dials the first
+// byte of each response. A nil timer times nothing.
+type phaseTimer struct {
+	mu      sync.Mutex
+	started time.Time
+	first   time.Time
+	timing  PhaseTiming
+}
+
+// Start resets the timer for the next request of the agent
+func (t *phaseTimer) Start() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.started = time.Now()
+	t.first = time.Time{}
+	t.timing = PhaseTiming{}
+}
+
+// Done returns the timing of the request since Start
+func (t *phaseTimer) Done() PhaseTiming {
+	if t == nil {
+		return PhaseTiming{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := t.timing
+	timing.Total = time.Since(t.started)
+	if !t.first.IsZero() {
+		timing.TTFB = t.first.Sub(t.started)
+	}
+	return timing
+}
+
+// observe adds the time since started to a phase
+func (t *phaseTimer) observe(phase *time.Duration, started time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*phase += time.Since(started)
+}
+
+func (t *phaseTimer) read() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.first.IsZero() && !t.started.IsZero() {
+		t.first = time.Now()
+	}
+}
+
+// Dial wraps the dial func of host_client so its connections are timed. TLS
+// connections are handshaken here to time the handshake, fasthttp skipping
+// its own for connections that have a Handshake method.
+func (t *phaseTimer) Dial(host_client *fasthttp.HostClient, dial fasthttp.DialFunc) fasthttp.DialFunc {
+	if t == nil {
+		return dial
+	}
+	return func(addr string) (net.Conn, error) {
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		if !host_client.IsTLS {
+			return &timedConn{conn, t}, nil
+		}
+
+		tls_conn := tls.Client(conn, clientTLSConfig(host_client.TLSConfig, addr))
+		started := time.Now()
+		tls_conn.SetDeadline(started.Add(targetDialTimeout))
+		if err := tls_conn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tls_conn.SetDeadline(time.Time{})
+		t.observe(&t.timing.TLS, started)
+		return &timedTLSConn{timedConn{tls_conn, t}}, nil
+	}
+}
+
+// clientTLSConfig is the config fasthttp would handshake addr with
+func clientTLSConfig(config *tls.Config, addr string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			config.ServerName = host
+		} else {
+			config.ServerName = addr
+		}
+	}
+	return config
+}
+
+// timedConn tells its timer when the response starts arriving
+type timedConn struct {
+	net.Conn
+	timer *phaseTimer
+}
+
+func (c *timedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.timer.read()
+	}
+	return n, err
+}
+
+type timedTLSConn struct {
+	timedConn
+}
+
+func (c *timedTLSConn) Handshake() error {
+	return c.Conn.(*tls.Conn).Handshake()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+func TestPhaseTimer(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	timer := &phaseTimer{}
+	client := &fasthttp.HostClient{Addr: server.Listener.Addr().String(), IsTLS: true, TLSConfig: &tls.Config{RootCAs: pool}}
+	client.Dial = timer.Dial(client, targetPolicy.guard(nil, timer))
+
+	tests := []struct {
+		name string
+		// dialed is whether the request opens a connection
+		dialed bool
+	}{
+		{"new connection", true},
+		{"reused connection", false},
+	}
+	for _, test := range tests {
+		req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		req.SetRequestURI(server.URL)
+		timer.Start()
+		if err := client.Do(req, resp); err != nil || string(resp.Body()) != "ok" {
+			t.Fatalf("%s: got %q %v", test.name, resp.Body(), err)
+		}
+		timing := timer.Done()
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+
+		if dialed := timing.Connect > 0 && timing.TLS > 0; dialed != test.dialed {
+			t.Fatalf("%s: %+v, want a connect and tls time: %v", test.name, timing, test.dialed)
+		}
+		if !test.dialed && timing.DNS != 0 {
+			t.Fatalf("%s: dns took %s, want 0", test.name, timing.DNS)
+		}
+		if timing.TTFB < delay || timing.Total < timing.TTFB || timing.Total < timing.DNS+timing.Connect+timing.TLS {
+			t.Fatalf("%s: %+v, want a ttfb over %s within the total", test.name, timing, delay)
+		}
+	}
+}
+
+func TestRequestCompletedTiming(t *testing.T) {
+	previous_logger, previous_level := log.Logger, zerolog.GlobalLevel()
+	defer func() {
+		log.Logger = previous_logger
+		zerolog.SetGlobalLevel(previous_level)
+	}()
+	var buf bytes.Buffer
+	log.Logger = zerolog.New(&buf)
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	if _, err := ExecuteJob(context.Background(), ProxyJob{URL: server.URL, Method: http.MethodGet}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil || entry["message"] != "Request completed" {
+			continue
+		}
+		// skipped phases are logged too, tls of a plain http request included
+		for _, field := range []string{"dns_ms", "connect_ms", "tls_ms", "ttfb_ms", "total_ms"} {
+			if _, ok := entry[field].(float64); !ok {
+				t.Fatalf("%s missing from %s", field, line)
+			}
+		}
+		return
+	}
+	t.Fatalf("no Request completed line in %s", buf.String())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// TLSConfigForJob builds the per-job TLS settings, returning nil to keep the
+// client defaults. Certificates are verified against the SNI name when one is
+// set, and against the system roots plus CACert when one is given.
+func TLSConfigForJob(job ProxyJob) (*tls.Config, error) {
+	if job.SNI == "" && !job.InsecureSkipVerify && job.CACert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         job.SNI,
+		InsecureSkipVerify: job.InsecureSkipVerify,
+	}
+	if job.CACert != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM([]byte(job.CACert)) {
+			return nil, errors.New("no certificates found in ca_cert")
+		}
+		config.RootCAs = roots
+	}
+	return config, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tlsServer is an https upstream with a self-signed certificate, counting the
+// connections it accepts. ca_cert is its certificate in PEM.
+func tlsServer(t *testing.T) (*httptest.Server, *atomic.Int64, string) {
+	var connections atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	// failed handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	ca_cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return server, &connections, string(ca_cert)
+}
+
+// selfSignedCA is the PEM of a CA certificate that signed none of the test servers
+func selfSignedCA(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "unrelated test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestConnectionsNotSharedBetweenJobs(t *testing.T) {
+	server, connections, ca_cert := tlsServer(t)
+
+	jobs := []struct {
+		name     string
+		job      ProxyJob
+		err_code string
+	}{
+		{"custom ca", ProxyJob{CACert: ca_cert}, ""},
+		{"insecure", ProxyJob{InsecureSkipVerify: true}, ""},
+		// would succeed on a connection left over by the insecure job
+		{"default verification", ProxyJob{}, ErrorTLS},
+		{"custom ca again", ProxyJob{CACert: ca_cert}, ""},
+	}
+	var previous int64
+	for _, test := range jobs {
+		job := test.job
+		job.URL = server.URL
+		job.Method = http.MethodGet
+		response, err := ExecuteJob(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.err_code != "" {
+			if len(response.Errs) == 0 || ClassifyError(response.Errs[0]).Code != test.err_code {
+				t.Fatalf("%s job got %d %v, want a %s error", test.name, response.StatusCode, response.Errs, test.err_code)
+			}
+		} else if len(response.Errs) > 0 || string(response.Body) != "secure" {
+			t.Fatalf("%s job got %v %q", test.name, response.Errs, response.Body)
+		}
+		// failed handshakes are retried on new connections
+		if got := connections.Load(); got <= previous {
+			t.Fatalf("the %s job opened no connection, want a new one for every job", test.name)
+		}
+		previous = connections.Load()
+	}
+}
+
+func TestJobTLSOptions(t *testing.T) {
+	server, _, ca_cert := tlsServer(t)
+	other_ca := selfSignedCA(t)
+
+	tests := []struct {
+		name     string
+		job      ProxyJob
+		err_code string
+	}{
+		{"default verification fails", ProxyJob{}, ErrorTLS},
+		{"insecure skips verification", ProxyJob{InsecureSkipVerify: true}, ""},
+		{"ca_cert of the server", ProxyJob{CACert: ca_cert}, ""},
+		{"unrelated ca_cert", ProxyJob{CACert: other_ca}, ErrorTLS},
+		{"sni matching the certificate", ProxyJob{CACert: ca_cert, SNI: "example.com"}, ""},
+		{"sni not in the certificate", ProxyJob{CACert: ca_cert, SNI: "other.test"}, ErrorTLS},
+		{"ca_cert without a certificate", ProxyJob{CACert: "not a pem"}, ErrorInvalidJob},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := test.job
+			job.URL = server.URL
+			job.Method = http.MethodGet
+			response, err := ExecuteJob(context.Background(), job)
+			if test.err_code == ErrorInvalidJob {
+				if err == nil || ClassifyError(err).Code != ErrorInvalidJob {
+					t.Fatalf("err = %v, want an invalid_job error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.err_code != "" {
+				if len(response.Errs) == 0 || ClassifyError(response.Errs[0]).Code != test.err_code {
+					t.Fatalf("got %d %v, want a %s error", response.StatusCode, response.Errs, test.err_code)
+				}
+				return
+			}
+			if len(response.Errs) > 0 || string(response.Body) != "secure" {
+				t.Fatalf("got %v %q", response.Errs, response.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/proxy"
+)
+
+const proxyDialTimeout = 10 * time.Second
+
+// upstreamProxy is the server wide proxy all jobs go through unless they set
+// their own ProxyURL, set from PROXIER_UPSTREAM_PROXY
+var upstreamProxy string
+
+// ProxyDialError is a failure to reach the target through an upstream proxy,
+// the proxy itself being unreachable or refusing the tunnel
+type ProxyDialError struct {
+	Proxy string
+	Err   error
+}
+
+func (e *ProxyDialError) Error() string {
+	return fmt.Sprintf("upstream proxy %s: %v", e.Proxy, e.Err)
+}
+
+func (e *ProxyDialError) Unwrap() error {
+	return e.Err
+}
+
+// UpstreamProxyFor returns the proxy URL the job should go through, if any.
+// Proxies of the pool are assigned to the job's ProxyURL by ExecuteJob.
+func UpstreamProxyFor(job ProxyJob) string {
+	if job.ProxyURL != "" {
+		return job.ProxyURL
+	}
+	return upstreamProxy
+}
+
+// ProxyDialer returns a dial func tunnelling upstream connections through the
+// proxy. http:// and https:// proxies are used with CONNECT, socks5:// with SOCKS5.
+func ProxyDialer(proxy_url string) (fasthttp.DialFunc, error) {
+	u, err := url.Parse(proxy_url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %q: missing host", proxy_url)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return connectDialer(u), nil
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(u, &net.Dialer{Timeout: proxyDialTimeout})
+		if err != nil {
+			return nil, err
+		}
+		return func(addr string) (net.Conn, error) {
+			conn, err := dialer.Dial("tcp", addr)
+			if err != nil {
+				return nil, &ProxyDialError{u.Host, err}
+			}
+			return conn, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+}
+
+// connectDialer opens an HTTP CONNECT tunnel to addr through the proxy
+func connectDialer(u *url.URL) fasthttp.DialFunc {
+	proxy_addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			proxy_addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			proxy_addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	var authorization string
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := u.User.Username() + ":" + password
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	return func(addr string) (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: proxyDialTimeout}
+		var (
+			conn net.Conn
+			err  error
+		)
+		if u.Scheme == "https" {
+			conn, err = tls.DialWithDialer(dialer, "tcp", proxy_addr, &tls.Config{ServerName: u.Hostname()})
+		} else {
+			conn, err = dialer.Dial("tcp", proxy_addr)
+		}
+		if err != nil {
+			return nil, &ProxyDialError{u.Host, err}
+		}
+
+		request := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+		if authorization != "" {
+			request += "Proxy-Authorization: " + authorization + "\r\n"
+		}
+		request += "\r\n"
+
+		conn.SetDeadline(time.Now().Add(proxyDialTimeout))
+		if _, err := conn.Write([]byte(request)); err != nil {
+			conn.Close()
+			return nil, &ProxyDialError{u.Host, err}
+		}
+
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			conn.Close()
+			return nil, &ProxyDialError{u.Host, err}
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, &ProxyDialError{u.Host, fmt.Errorf("refused CONNECT to %s: %s", addr, response.Status)}
+		}
+
+		conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+}
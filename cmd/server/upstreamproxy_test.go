@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubProxy tunnels connections like an upstream proxy, recording the
+// requests it gets as "CONNECT host:port Proxy-Authorization" or "SOCKS5 host:port"
+type stubProxy struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newStubProxy(t *testing.T, socks bool) *stubProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stubProxy{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if socks {
+				go p.serveSOCKS5(conn)
+			} else {
+				go p.serveConnect(conn)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *stubProxy) addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *stubProxy) record(request string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, request)
+}
+
+func (p *stubProxy) seen() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+func (p *stubProxy) serveConnect(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	p.record(strings.TrimSpace(request.Method + " " + request.Host + " " + request.Header.Get("Proxy-Authorization")))
+	if request.Method != http.MethodConnect {
+		fmt.Fprint(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", request.Host)
+	if err != nil {
+		fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	pipe(reader, conn, target)
+}
+
+func (p *stubProxy) serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	// greeting: version, methods, answered with no authentication
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(reader, greeting); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(reader, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// request: version, connect, reserved, address type, address, port
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return
+	}
+	var host string
+	switch header[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(reader, ip)
+		host = net.IP(ip).String()
+	case 3:
+		length, _ := reader.ReadByte()
+		name := make([]byte, length)
+		io.ReadFull(reader, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	p.record("SOCKS5 " + addr)
+
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(reader, conn, target)
+}
+
+// pipe copies between the client and the target until either side closes
+func pipe(client_reader io.Reader, client net.Conn, target net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, client_reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, target)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "through the proxy")
+	}))
+	defer target.Close()
+	target_addr := strings.TrimPrefix(target.URL, "http://")
+
+	connect := newStubProxy(t, false)
+	socks := newStubProxy(t, true)
+
+	previous := upstreamProxy
+	defer func() { upstreamProxy = previous }()
+
+	tests := []struct {
+		name         string
+		proxy        *stubProxy
+		job_proxy    string
+		server_proxy string
+		seen         string
+	}{
+		{"http proxy of the job", connect, "http://user:pass@" + connect.addr(), "", "CONNECT " + target_addr + " Basic dXNlcjpwYXNz"},
+		{"server wide http proxy", connect, "", "http://" + connect.addr(), "CONNECT " + target_addr},
+		{"socks5 proxy of the job", socks, "socks5://" + socks.addr(), "", "SOCKS5 " + target_addr},
+		{"job proxy over the server wide one", socks, "socks5://" + socks.addr(), "http://" + connect.addr(), "SOCKS5 " + target_addr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstreamProxy = test.server_proxy
+			before := len(test.proxy.seen())
+			response, err := ExecuteJob(context.Background(), ProxyJob{URL: target.URL, Method: http.MethodGet, ProxyURL: test.job_proxy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errs) > 0 || string(response.Body) != "through the proxy" {
+				t.Fatalf("got %v %q", response.Errs, response.Body)
+			}
+			seen := test.proxy.seen()[before:]
+			if len(seen) != 1 || seen[0] != test.seen {
+				t.Fatalf("proxy saw %q, want %q", seen, test.seen)
+			}
+			if response.UpstreamProxy == "" || strings.Contains(response.UpstreamProxy, "pass") {
+				t.Fatalf("upstream_proxy = %q, want the redacted proxy", response.UpstreamProxy)
+			}
+		})
+	}
+
+	upstreamProxy = ""
+	unreachable := strings.Replace(refusedURL(t), "http://", "http://user:pass@", 1)
+	response, err := ExecuteJob(context.Background(), ProxyJob{URL: target.URL, Method: http.MethodGet, ProxyURL: unreachable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Errs) == 0 || ClassifyError(response.Errs[0]).Code != ErrorUpstreamProxy {
+		t.Fatalf("errs = %v, want an %s error", response.Errs, ErrorUpstreamProxy)
+	}
+}
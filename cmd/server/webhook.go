@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// callbackTimeout bounds each delivery attempt
+	callbackTimeout = 10 * time.Second
+
+	// callbackSignatureHeader carries t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">
+	callbackSignatureHeader = "X-Proxier-Signature"
+	callbackJobIDHeader     = "X-Proxier-Job-Id"
+	callbackAttemptHeader   = "X-Proxier-Delivery-Attempt"
+)
+
+// Callback delivery states
+const (
+	CallbackPending   = "pending"
+	CallbackDelivered = "delivered"
+	CallbackFailed    = "failed"
+)
+
+// callbackMaxAttempts and callbackSecret are set from the webhook settings,
+// callbacks are not signed without a secret
+var (
+	callbackMaxAttempts = 5
+	callbackSecret      []byte
+)
+
+// callbackBackoffBase doubles after every failed delivery, up to callbackBackoffMax
+var (
+	callbackBackoffBase = time.Second
+	callbackBackoffMax  = time.Minute
+)
+
+// CallbackStatus is the delivery of the callback of an async job
+type CallbackStatus struct {
+	State    string            `json:"state"`
+	Attempts []CallbackAttempt `json:"attempts,omitempty"`
+}
+
+// CallbackAttempt is a single POST to the callback URL
+type CallbackAttempt struct {
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// CallbackPayload is the body posted to the callback URL of a finished job
+type CallbackPayload struct {
+	JobID      string         `json:"job_id"`
+	State      JobState       `json:"state"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	DurationMs int64          `json:"duration_ms"`
+	Response   *ProxyResponse `json:"response,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	ErrorCode  string         `json:"error_code,omitempty"`
+	Errors     []ErrorDetail  `json:"errors,omitempty"`
+}
+
+// CheckCallbackURL applies the target policy to a callback URL
+func CheckCallbackURL(callback_url string) error {
+	err := targetPolicy.CheckURL(callback_url)
+	var policy_err *PolicyError
+	if errors.As(err, &policy_err) {
+		return &PolicyError{policy_err.Code, "Callback URL: " + policy_err.Message}
+	}
+	return err
+}
+
+func callbackPayload(job AsyncJob) CallbackPayload {
+	payload := CallbackPayload{
+		JobID:      job.ID,
+		State:      job.State,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		Response:   job.Response,
+		Error:      job.Error,
+		ErrorCode:  job.ErrorCode,
+		Errors:     job.Errors,
+	}
+	if job.StartedAt != nil && job.FinishedAt != nil {
+		payload.DurationMs = job.FinishedAt.Sub(*job.StartedAt).Milliseconds()
+	}
+	return payload
+}
+
+// SignCallback returns the signature header value of a callback body
+func SignCallback(secret []byte, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// callbackRetryable tells whether a failed delivery is worth another attempt,
+// the receiver rejecting the callback with a 4xx other than 408 and 429 isn't
+func callbackRetryable(status_code int) bool {
+	switch {
+	case status_code == 0, status_code >= 500:
+		return true
+	case status_code == fiber.StatusRequestTimeout, status_code == fiber.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// postCallback performs one delivery attempt
+func postCallback(callback_url string, id string, attempt int, body []byte) (int, error) {
+	agent := NewAgent(&fiber.Client{}, fiber.MethodPost, callback_url)
+	host_client, err := hostClient(agent)
+	if err != nil {
+		fiber.ReleaseAgent(agent)
+		return 0, err
+	}
+	// the URL was checked when the job was submitted, its addresses are checked here
+	host_client.Dial = targetPolicy.Guard(nil)
+	agent.Timeout(callbackTimeout)
+	agent.ContentType(fiber.MIMEApplicationJSON)
+	agent.Set(callbackJobIDHeader, id)
+	agent.Set(callbackAttemptHeader, strconv.Itoa(attempt))
+	if len(callbackSecret) > 0 {
+		agent.Set(callbackSignatureHeader, SignCallback(callbackSecret, time.Now(), body))
+	}
+	agent.Body(body)
+
+	status_code, _, errs := agent.Bytes()
+	if len(errs) > 0 {
+		return 0, errs[0]
+	}
+	if status_code < 200 || status_code >= 300 {
+		return status_code, fmt.Errorf("callback endpoint returned %d", status_code)
+	}
+	return status_code, nil
+}
+
+// DeliverCallback posts the finished job to its callback URL, retrying with
+// exponential backoff, and records every attempt on the job
+func (q *JobQueue) DeliverCallback(id string, callback_url string) {
+	logger := log.With().Str("job_id", id).Logger()
+
+	job, ok := q.store.Get(id)
+	if !ok {
+		return
+	}
+	if err := CheckCallbackURL(callback_url); err != nil {
+		logger.Warn().Err(err).Msg("Callback blocked by policy")
+		q.store.callbackAttempt(id, CallbackAttempt{Attempt: 1, Time: time.Now(), Error: err.Error()})
+		q.store.callbackDone(id, CallbackFailed)
+		return
+	}
+	body, err := json.Marshal(callbackPayload(job))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encode callback")
+		q.store.callbackDone(id, CallbackFailed)
+		return
+	}
+
+	backoff := callbackBackoffBase
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		status_code, err := postCallback(callback_url, id, attempt, body)
+		record := CallbackAttempt{
+			Attempt:    attempt,
+			Time:       started,
+			StatusCode: status_code,
+			DurationMs: time.Since(started).Milliseconds(),
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		q.store.callbackAttempt(id, record)
+
+		if err == nil {
+			logger.Debug().Int("attempt", attempt).Msg("Callback delivered")
+			q.store.callbackDone(id, CallbackDelivered)
+			return
+		}
+		if attempt >= callbackMaxAttempts || !callbackRetryable(status_code) {
+			logger.Error().Err(err).Int("attempts", attempt).Msg("Callback delivery failed")
+			q.store.callbackDone(id, CallbackFailed)
+			return
+		}
+		logger.Warn().Err(err).Int("attempt", attempt).Dur("backoff", backoff).Msg("Retrying callback")
+		time.Sleep(backoff)
+		backoff = min(backoff*2, callbackBackoffMax)
+	}
+}
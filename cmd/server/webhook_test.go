@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignCallback(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+	body := []byte(`{"job_id":"abc"}`)
+	want := "t=1700000000,v1=bc4ee077667c8a94a53825913509c9a0aba9b1da8bbee8b167a3c8b05de9e5aa"
+	if got := SignCallback([]byte("whsec_test"), timestamp, body); got != want {
+		t.Fatalf("SignCallback = %q, want %q", got, want)
+	}
+	if got := SignCallback([]byte("other"), timestamp, body); got == want {
+		t.Fatal("signature doesn't depend on the secret")
+	}
+	if got := SignCallback([]byte("whsec_test"), timestamp, []byte(`{"job_id":"abd"}`)); got == want {
+		t.Fatal("signature doesn't depend on the body")
+	}
+}
+
+func TestDeliverCallback(t *testing.T) {
+	previous_base, previous_attempts, previous_secret := callbackBackoffBase, callbackMaxAttempts, callbackSecret
+	defer func() {
+		callbackBackoffBase, callbackMaxAttempts, callbackSecret = previous_base, previous_attempts, previous_secret
+	}()
+	const base = 10 * time.Millisecond
+	callbackBackoffBase, callbackMaxAttempts, callbackSecret = base, 3, []byte("whsec_test")
+
+	tests := []struct {
+		name string
+		// statuses are answered in turn, 200 once they run out
+		statuses []int
+		state    string
+		attempts int
+	}{
+		{"delivered", nil, CallbackDelivered, 1},
+		{"delivered after failures", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, CallbackDelivered, 3},
+		{"rejected", []int{http.StatusBadRequest}, CallbackFailed, 1},
+		{"gives up after the max attempts", []int{500, 500, 500, 500}, CallbackFailed, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				received []time.Time
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				received = append(received, time.Now())
+				attempt := len(received)
+				mu.Unlock()
+
+				signature := r.Header.Get(callbackSignatureHeader)
+				unix, _, _ := strings.Cut(strings.TrimPrefix(signature, "t="), ",")
+				seconds, _ := strconv.ParseInt(unix, 10, 64)
+				if signature != SignCallback([]byte("whsec_test"), time.Unix(seconds, 0), body) {
+					t.Errorf("attempt %d signed %q, want the HMAC of the body", attempt, signature)
+				}
+				if got := r.Header.Get(callbackAttemptHeader); got != strconv.Itoa(attempt) {
+					t.Errorf("attempt header = %q, want %d", got, attempt)
+				}
+				if attempt <= len(test.statuses) {
+					w.WriteHeader(test.statuses[attempt-1])
+				}
+			}))
+			defer server.Close()
+
+			store := NewJobStore(time.Minute)
+			job, err := store.create("", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.finish(job.ID, ProxyResponse{StatusCode: http.StatusOK}, nil)
+			q := &JobQueue{store: store}
+			q.DeliverCallback(job.ID, server.URL)
+
+			status, _ := store.Get(job.ID)
+			if status.Callback.State != test.state || len(status.Callback.Attempts) != test.attempts || len(received) != test.attempts {
+				t.Fatalf("callback %s after %d attempts (%d received), want %s after %d", status.Callback.State, len(status.Callback.Attempts), len(received), test.state, test.attempts)
+			}
+			// the backoff doubles between attempts
+			for i := 1; i < len(received); i++ {
+				if gap, want := received[i].Sub(received[i-1]), base<<(i-1); gap < want {
+					t.Fatalf("attempt %d came %s after the previous one, want at least %s", i+1, gap, want)
+				}
+			}
+			if last := status.Callback.Attempts[test.attempts-1]; test.state == CallbackFailed && last.Error == "" {
+				t.Fatalf("failed attempt recorded without an error: %+v", last)
+			}
+		})
+	}
+}
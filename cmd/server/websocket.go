@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// wsHandshakeTimeout bounds dialing the upstream and its WebSocket handshake
+const wsHandshakeTimeout = 10 * time.Second
+
+// wsUpstreamLocal carries the dialed upstream connection to RelayWebSocket
+const wsUpstreamLocal = "ws_upstream"
+
+// wsIdleTimeout and wsMaxMessageBytes are set from ws_idle_timeout and
+// ws_max_message_bytes, tunnels may only lower them
+var (
+	wsIdleTimeout     = time.Minute
+	wsMaxMessageBytes = 1 << 20
+)
+
+// wsConnections is the number of open tunnels
+var wsConnections atomic.Int64
+
+// wsTunnel is an upstream connection waiting for the client upgrade
+type wsTunnel struct {
+	upstream  *fasthttpws.Conn
+	idle      time.Duration
+	max_bytes int
+	logger    zerolog.Logger
+}
+
+// webSocketTarget maps ws:// and wss:// URLs to http:// and https:// for the
+// job checks, which know the target policy by its HTTP schemes
+func webSocketTarget(raw_url string) (string, bool) {
+	switch {
+	case strings.HasPrefix(strings.ToLower(raw_url), "ws://"):
+		return "http://" + raw_url[len("ws://"):], true
+	case strings.HasPrefix(strings.ToLower(raw_url), "wss://"):
+		return "https://" + raw_url[len("wss://"):], true
+	}
+	return "", false
+}
+
+// webSocketJob builds the job of a tunnel from the query of the upgrade request
+func webSocketJob(c *fiber.Ctx) (ProxyJob, error) {
+	target, ok := webSocketTarget(c.Query("url"))
+	if !ok {
+		return ProxyJob{}, &JobError{fiber.StatusBadRequest, "url must be a ws:// or wss:// URL"}
+	}
+	job := ProxyJob{
+		URL:                target,
+		Method:             fiber.MethodGet,
+		Headers:            map[string]string{},
+		ProxyURL:           c.Query("proxy_url"),
+		SNI:                c.Query("sni"),
+		InsecureSkipVerify: c.QueryBool("insecure_skip_verify"),
+	}
+	for _, header := range c.Context().QueryArgs().PeekMulti("header") {
+		name, value, ok := strings.Cut(string(header), ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return ProxyJob{}, &JobError{fiber.StatusBadRequest, "Invalid header, use Name: Value"}
+		}
+		job.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return job, nil
+}
+
+// upstreamHeaders are the headers of the upstream handshake, the WebSocket
+// headers themselves are set by the dialer
+func upstreamHeaders(job ProxyJob) http.Header {
+	headers := http.Header{}
+	for key, value := range FilterHopByHop(job.Headers) {
+		switch http.CanonicalHeaderKey(key) {
+		case "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol":
+			continue
+		}
+		headers.Set(key, value)
+	}
+	return headers
+}
+
+// PrepareWebSocket dials the upstream of a tunnel before the client upgrade
+// is accepted, so failures are still answered with an HTTP status
+// @Description Tunnels a WebSocket connection to the ws:// or wss:// url, relaying messages both ways until either side closes or the tunnel is idle.
+// @Description The subprotocols asked for by the client are offered to the upstream, the one it picks is returned to the client.
+// @Param url query string true "ws:// or wss:// target URL"
+// @Param header query []string false "Header of the upstream handshake as Name: Value, repeatable"
+// @Param proxy_url query string false "Upstream proxy to tunnel through, the proxy pool is used without it"
+// @Param sni query string false "Server name to present in the TLS handshake"
+// @Param insecure_skip_verify query bool false "Skip certificate verification of wss:// targets"
+// @Param idle_timeout_ms query int false "Close the tunnel after this long without a message, at most ws_idle_timeout"
+// @Param max_message_bytes query int false "Largest message relayed either way, at most ws_max_message_bytes"
+func PrepareWebSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+			"error": "Expected a WebSocket upgrade",
+		})
+	}
+
+	if coordinator != nil {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
+			"error": "The coordinator does not tunnel WebSockets, connect to a worker",
+		})
+	}
+
+	idle := wsIdleTimeout
+	if ms := c.QueryInt("idle_timeout_ms"); ms < 0 || time.Duration(ms)*time.Millisecond > wsIdleTimeout {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid idle_timeout_ms",
+		})
+	} else if ms > 0 {
+		idle = time.Duration(ms) * time.Millisecond
+	}
+	max_bytes := wsMaxMessageBytes
+	if n := c.QueryInt("max_message_bytes"); n < 0 || n > wsMaxMessageBytes {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid max_message_bytes",
+		})
+	} else if n > 0 {
+		max_bytes = n
+	}
+
+	parent := requestContext(c)
+	job, err := webSocketJob(c)
+	if err != nil {
+		return sendJobError(c, err)
+	}
+	job, err = ValidateJob(parent, job, maxBodyBytes)
+	if err != nil {
+		return sendJobError(c, err)
+	}
+	if job.ProxyURL == "" && proxyPool != nil {
+		job.ProxyURL = proxyPool.Pick(job.URL)
+		job.pooled = true
+	}
+	if breakers != nil {
+		if err := breakers.Allow(job); err != nil {
+			return sendJobError(c, err)
+		}
+	}
+	ws_url := "ws" + strings.TrimPrefix(job.URL, "http")
+	logger := log.With().Str("url", ws_url).Str("key_id", KeyIDFrom(parent)).Logger()
+
+	var dial fasthttp.DialFunc
+	if proxy_url := UpstreamProxyFor(job); proxy_url != "" {
+		// validated by ValidateJob and at startup
+		dial, _ = ProxyDialer(proxy_url)
+	}
+	guarded := targetPolicy.Guard(dial)
+	// validated by ValidateJob
+	tls_config, _ := TLSConfigForJob(job)
+	dialer := fasthttpws.Dialer{
+		NetDialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return guarded(addr)
+		},
+		TLSClientConfig:  tls_config,
+		HandshakeTimeout: wsHandshakeTimeout,
+	}
+	for _, protocol := range strings.Split(c.Get("Sec-WebSocket-Protocol"), ",") {
+		if protocol = strings.TrimSpace(protocol); protocol != "" {
+			dialer.Subprotocols = append(dialer.Subprotocols, protocol)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, wsHandshakeTimeout)
+	defer cancel()
+	if err := domainLimits.Wait(ctx, job.URL); err != nil {
+		logger.Warn().Err(err).Msg("Domain rate limit exceeded")
+		return sendJobError(c, err)
+	}
+	upstream, resp, err := dialer.DialContext(ctx, ws_url, upstreamHeaders(job))
+	if proxy := RedactProxyURL(UpstreamProxyFor(job)); proxy != "" {
+		metrics.IncProxyRequest(proxy, err == nil)
+	}
+	// a handshake the upstream answered without upgrading has no dial error
+	var errs []error
+	status_code := 0
+	if resp != nil {
+		status_code = resp.StatusCode
+	} else if err != nil {
+		errs = []error{err}
+	}
+	if breakers != nil {
+		breakers.Record(job, breakerFailed(status_code, errs))
+	}
+	if err != nil {
+		logger.Warn().Err(err).Int("status_code", status_code).Msg("Upstream WebSocket handshake failed")
+		if policy_err := policyError(errs); policy_err != nil {
+			return sendJobError(c, policy_err)
+		}
+		if errors.Is(err, context.DeadlineExceeded) || isTimeout(errs) {
+			return c.Status(fiber.StatusGatewayTimeout).JSON(errorBody(ErrorDetail{ErrorTimeout, err.Error(), true},
+				"Upstream WebSocket handshake timed out"))
+		}
+		body := errorBody(err, "Upstream WebSocket handshake failed: "+err.Error())
+		if status_code != 0 {
+			body["status_code"] = status_code
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(body)
+	}
+
+	if protocol := upstream.Subprotocol(); protocol != "" {
+		// the upgrader answers with the protocol set on the response
+		c.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	c.Locals(wsUpstreamLocal, &wsTunnel{upstream: upstream, idle: idle, max_bytes: max_bytes, logger: logger})
+	if err := c.Next(); err != nil {
+		// the client upgrade failed, RelayWebSocket won't run
+		upstream.Close()
+		return err
+	}
+	return nil
+}
+
+// relay copies messages from src to dst until either fails, forwarding the
+// close frame of src
+func relay(src *fasthttpws.Conn, dst *fasthttpws.Conn, touch func()) error {
+	for {
+		kind, message, err := src.ReadMessage()
+		if err != nil {
+			var close_err *fasthttpws.CloseError
+			if errors.As(err, &close_err) {
+				_ = dst.WriteControl(fasthttpws.CloseMessage,
+					fasthttpws.FormatCloseMessage(close_err.Code, close_err.Text), time.Now().Add(time.Second))
+			} else if errors.Is(err, fasthttpws.ErrReadLimit) {
+				_ = dst.WriteControl(fasthttpws.CloseMessage,
+					fasthttpws.FormatCloseMessage(fasthttpws.CloseMessageTooBig, "message too big"), time.Now().Add(time.Second))
+			}
+			return err
+		}
+		touch()
+		if err := dst.WriteMessage(kind, message); err != nil {
+			return err
+		}
+	}
+}
+
+// RelayWebSocket relays messages between the upgraded client connection and
+// the upstream dialed by PrepareWebSocket
+func RelayWebSocket(client *websocket.Conn) {
+	tunnel := client.Locals(wsUpstreamLocal).(*wsTunnel)
+	upstream := tunnel.upstream
+	defer upstream.Close()
+
+	wsConnections.Add(1)
+	defer wsConnections.Add(-1)
+	started := time.Now()
+	tunnel.logger.Info().Msg("WebSocket tunnel opened")
+
+	client.SetReadLimit(int64(tunnel.max_bytes))
+	upstream.SetReadLimit(int64(tunnel.max_bytes))
+
+	var last_message atomic.Int64
+	touch := func() { last_message.Store(time.Now().UnixNano()) }
+	touch()
+
+	done := make(chan struct{})
+	var once sync.Once
+	finish := func() {
+		once.Do(func() {
+			close(done)
+			// unblocks the reads of both directions
+			client.Close()
+			upstream.Close()
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer finish()
+		relay(client.Conn, upstream, touch)
+	}()
+	go func() {
+		defer wg.Done()
+		defer finish()
+		relay(upstream, client.Conn, touch)
+	}()
+
+	// the idle timeout spans both directions, a tunnel that only pushes
+	// messages one way stays open
+	ticker := time.NewTicker(min(tunnel.idle/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			wg.Wait()
+			tunnel.logger.Info().Dur("duration", time.Since(started)).Msg("WebSocket tunnel closed")
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, last_message.Load())) < tunnel.idle {
+				continue
+			}
+			tunnel.logger.Debug().Dur("idle_timeout", tunnel.idle).Msg("WebSocket tunnel idle")
+			deadline := time.Now().Add(time.Second)
+			message := fasthttpws.FormatCloseMessage(fasthttpws.CloseGoingAway, "idle timeout")
+			_ = client.WriteControl(fasthttpws.CloseMessage, message, deadline)
+			_ = upstream.WriteControl(fasthttpws.CloseMessage, message, deadline)
+			finish()
+		}
+	}
+}
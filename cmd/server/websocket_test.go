@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// echoServer is a WebSocket upstream echoing every message, prefixed with
+// the X-Tag header of its handshake
+func echoServer(t *testing.T) string {
+	upgrader := fasthttpws.Upgrader{Subprotocols: []string{"chat"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tag := r.Header.Get("X-Tag")
+		for {
+			kind, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(kind, append([]byte(tag), message...)); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// tunnelServer serves /proxy/ws on a local port, returning its ws:// base URL
+func tunnelServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/proxy/ws", PrepareWebSocket, websocket.New(RelayWebSocket))
+	go app.Listener(listener)
+	t.Cleanup(func() { app.Shutdown() })
+	return "ws://" + listener.Addr().String() + "/proxy/ws"
+}
+
+func TestWebSocketTunnel(t *testing.T) {
+	upstream := echoServer(t)
+	tunnel := tunnelServer(t)
+
+	query := url.Values{"url": {upstream}, "header": {"X-Tag: tag:"}}
+	dialer := fasthttpws.Dialer{Subprotocols: []string{"chat"}}
+	conn, resp, err := dialer.Dial(tunnel+"?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("dial got %v, want the tunnel opened", err)
+	}
+	defer conn.Close()
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "chat" {
+		t.Fatalf("subprotocol = %q, want the one picked by the upstream", protocol)
+	}
+
+	messages := []struct {
+		kind int
+		data string
+	}{
+		{fasthttpws.TextMessage, "hello"},
+		{fasthttpws.BinaryMessage, "\x00\x01"},
+		{fasthttpws.TextMessage, "again"},
+	}
+	for _, message := range messages {
+		if err := conn.WriteMessage(message.kind, []byte(message.data)); err != nil {
+			t.Fatal(err)
+		}
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != message.kind || string(data) != "tag:"+message.data {
+			t.Fatalf("got %d %q, want %d %q echoed", kind, data, message.kind, "tag:"+message.data)
+		}
+	}
+
+	// the tunnel closes once idle
+	idle, _, err := fasthttpws.DefaultDialer.Dial(tunnel+"?"+url.Values{"url": {upstream}, "idle_timeout_ms": {"100"}}.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	_, _, err = idle.ReadMessage()
+	var close_err *fasthttpws.CloseError
+	if !errors.As(err, &close_err) || close_err.Code != fasthttpws.CloseGoingAway {
+		t.Fatalf("idle tunnel got %v, want a going away close", err)
+	}
+}
+
+func TestWebSocketTunnelRejected(t *testing.T) {
+	upstream := echoServer(t)
+	tunnel := tunnelServer(t)
+
+	previous := targetPolicy
+	defer func() { targetPolicy = previous }()
+
+	tests := []struct {
+		name   string
+		policy *TargetPolicy
+		query  url.Values
+		status int
+	}{
+		{"private upstream", &TargetPolicy{AllowSchemes: []string{"http", "https"}}, url.Values{"url": {upstream}}, fiber.StatusForbidden},
+		{"name resolving to a private upstream", &TargetPolicy{AllowSchemes: []string{"http", "https"}}, url.Values{"url": {strings.Replace(upstream, "127.0.0.1", "localhost", 1)}}, fiber.StatusForbidden},
+		{"address not allowed", &TargetPolicy{AllowSchemes: []string{"http", "https"}, AllowCIDRs: []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}}}, url.Values{"url": {upstream}}, fiber.StatusForbidden},
+		{"scheme not allowed", &TargetPolicy{AllowSchemes: []string{"https"}, AllowPrivate: true}, url.Values{"url": {upstream}}, fiber.StatusForbidden},
+		{"not a websocket url", previous, url.Values{"url": {"http://example.com/"}}, fiber.StatusBadRequest},
+		{"idle timeout over the limit", previous, url.Values{"url": {upstream}, "idle_timeout_ms": {"3600000"}}, fiber.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targetPolicy = test.policy
+			conn, resp, err := fasthttpws.DefaultDialer.Dial(tunnel+"?"+test.query.Encode(), nil)
+			if err == nil {
+				conn.Close()
+				t.Fatal("tunnel opened, want it rejected")
+			}
+			if resp == nil || resp.StatusCode != test.status {
+				t.Fatalf("got %v %v, want %d", resp, err, test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrOverloaded is returned when a job finds the worker pool queue full,
+// answered with 503 and a Retry-After
+var ErrOverloaded = errors.New("worker pool is full")
+
+// overloadRetryAfter is the Retry-After of jobs shed by the worker pool
+const overloadRetryAfter = time.Second
+
+// WorkerPool performs the upstream requests of jobs on a fixed number of
+// workers. Jobs wait in a bounded queue for a free worker; when it is full
+// they are shed right away, or once they waited queueWait for room.
+type WorkerPool struct {
+	tasks     chan func()
+	workers   int
+	queueWait time.Duration
+
+	busy     atomic.Int64
+	rejected atomic.Uint64
+}
+
+func NewWorkerPool(workers int, queue_size int, queue_wait time.Duration) *WorkerPool {
+	pool := &WorkerPool{
+		tasks:     make(chan func(), queue_size),
+		workers:   workers,
+		queueWait: queue_wait,
+	}
+	for i := 0; i < workers; i++ {
+		go pool.worker()
+	}
+	return pool
+}
+
+// workerPool is sized by workers, worker_queue_size and worker_queue_wait
+var workerPool *WorkerPool
+
+func (p *WorkerPool) worker() {
+	for task := range p.tasks {
+		p.busy.Add(1)
+		task()
+		p.busy.Add(-1)
+	}
+}
+
+// Submit queues the task, or returns ErrOverloaded if the queue stays full.
+// Tasks should check their own context, they may run after it is done.
+func (p *WorkerPool) Submit(ctx context.Context, task func()) error {
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+	}
+
+	if p.queueWait > 0 {
+		timer := time.NewTimer(p.queueWait)
+		defer timer.Stop()
+		select {
+		case p.tasks <- task:
+			return nil
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	p.rejected.Add(1)
+	return ErrOverloaded
+}
+
+// Depth is the number of tasks waiting for a worker
+func (p *WorkerPool) Depth() int {
+	return len(p.tasks)
+}
+
+type dedicatedWorkerContextKey struct{}
+
+// withDedicatedWorker marks jobs that already run on a bounded worker of
+// their own, like async jobs, which then skip the worker pool
+func withDedicatedWorker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dedicatedWorkerContextKey{}, true)
+}
+
+// RunOnWorker runs the task on the worker pool, or on its own goroutine for
+// jobs of a dedicated worker and when there is no pool
+func RunOnWorker(ctx context.Context, task func()) error {
+	if dedicated, _ := ctx.Value(dedicatedWorkerContextKey{}).(bool); dedicated || workerPool == nil {
+		go task()
+		return nil
+	}
+	return workerPool.Submit(ctx, task)
+}
+
+type workerPoolStatus struct {
+	Workers    int    `json:"workers"`
+	Busy       int64  `json:"busy"`
+	QueueSize  int    `json:"queue_size"`
+	QueueDepth int    `json:"queue_depth"`
+	Rejected   uint64 `json:"rejected"`
+}
+
+func (p *WorkerPool) Status() workerPoolStatus {
+	return workerPoolStatus{
+		Workers:    p.workers,
+		Busy:       p.busy.Load(),
+		QueueSize:  cap(p.tasks),
+		QueueDepth: len(p.tasks),
+		Rejected:   p.rejected.Load(),
+	}
+}
+
+// GetWorkersStatus reports the load of the worker pool
+// @Description Returns the number of busy workers, queued jobs and jobs shed since startup
+func GetWorkersStatus(c *fiber.Ctx) error {
+	return c.JSON(workerPool.Status())
+}
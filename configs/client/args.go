@@ -1,6 +1,9 @@
 package client_args
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+)
 
 type CliArgs struct {
 	ProxyURL string `json:"proxy_url"`
@@ -9,4 +12,9 @@ type CliArgs struct {
 type ProxyServerConfig struct {
 	Host    string        `json:"host"`
 	Timeout time.Duration `json:"timeout"`
+	APIKey  string        `json:"api_key"`
+
+	// TLS verifies https:// servers and carries the client certificate of
+	// servers using mutual TLS, the system roots are used without it
+	TLS *tls.Config `json:"-"`
 }
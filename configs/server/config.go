@@ -0,0 +1,614 @@
+// Package server_config loads the proxier server settings from an optional
+// JSON or YAML file, overridden by PROXIER_* environment variables
+package server_config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration reads "30s" style durations, or a plain number of seconds
+type Duration time.Duration
+
+func parseDuration(text string) (Duration, error) {
+	if seconds, err := strconv.Atoi(text); err == nil {
+		return Duration(time.Duration(seconds) * time.Second), nil
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", text)
+	}
+	return Duration(d), nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	parsed, err := parseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := parseDuration(node.Value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the server configuration. Every field has a PROXIER_ environment
+// variable of the same name in upper case, e.g. PROXIER_MAX_BODY_BYTES.
+type Config struct {
+	Addr     string `json:"addr" yaml:"addr"`
+	GRPCAddr string `json:"grpc_addr" yaml:"grpc_addr"`
+
+	// TLSCertFile and TLSKeyFile serve both listeners over TLS, they are
+	// reloaded when changed every TLSReloadInterval unless it is 0.
+	// TLSClientCAFile turns on mutual TLS: clients need a certificate
+	// signed by one of its CAs.
+	TLSCertFile       string   `json:"tls_cert_file" yaml:"tls_cert_file"`
+	TLSKeyFile        string   `json:"tls_key_file" yaml:"tls_key_file"`
+	TLSClientCAFile   string   `json:"tls_client_ca_file" yaml:"tls_client_ca_file"`
+	TLSReloadInterval Duration `json:"tls_reload_interval" yaml:"tls_reload_interval"`
+
+	// ClusterMode is coordinator or worker, a standalone server when empty.
+	// Workers register with the coordinator at ClusterCoordinatorURL every
+	// ClusterHeartbeatInterval, telling it their ClusterAdvertiseURL and
+	// ClusterTags ("region=eu" style), and the coordinator dispatches the jobs
+	// it takes to them. Both sides authenticate with ClusterToken.
+	ClusterMode              string   `json:"cluster_mode" yaml:"cluster_mode"`
+	ClusterToken             string   `json:"cluster_token" yaml:"cluster_token"`
+	ClusterCoordinatorURL    string   `json:"cluster_coordinator_url" yaml:"cluster_coordinator_url"`
+	ClusterAdvertiseURL      string   `json:"cluster_advertise_url" yaml:"cluster_advertise_url"`
+	ClusterWorkerID          string   `json:"cluster_worker_id" yaml:"cluster_worker_id"`
+	ClusterTags              []string `json:"cluster_tags" yaml:"cluster_tags"`
+	ClusterHeartbeatInterval Duration `json:"cluster_heartbeat_interval" yaml:"cluster_heartbeat_interval"`
+
+	// On SIGINT or SIGTERM new jobs are refused and queued and running jobs
+	// get up to ShutdownTimeout to finish before the server exits
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+
+	LogLevel  string `json:"log_level" yaml:"log_level"`
+	LogFormat string `json:"log_format" yaml:"log_format"`
+
+	// DefaultTimeout applies to jobs that set neither timeout nor timeout_ms
+	DefaultTimeout Duration `json:"default_timeout" yaml:"default_timeout"`
+	MaxBodyBytes   int      `json:"max_body_bytes" yaml:"max_body_bytes"`
+
+	// StreamMaxBodyBytes caps the bodies of POST /proxy/stream, which are not
+	// held in memory
+	StreamMaxBodyBytes int `json:"stream_max_body_bytes" yaml:"stream_max_body_bytes"`
+
+	// Response headers past ResponseMaxHeaders or ResponseMaxHeaderBytes in
+	// total are left out of responses, which are flagged headers_truncated.
+	// Header blocks are read whole into a buffer of twice
+	// ResponseMaxHeaderBytes, requests with larger ones fail.
+	ResponseMaxHeaders     int `json:"response_max_headers" yaml:"response_max_headers"`
+	ResponseMaxHeaderBytes int `json:"response_max_header_bytes" yaml:"response_max_header_bytes"`
+	// A single header value over ResponseMaxHeaderValueBytes is cut to the cap
+	// or left out, as ResponseOversizedHeader (truncate or drop) has it, which
+	// also flags the response headers_truncated.
+	ResponseMaxHeaderValueBytes int    `json:"response_max_header_value_bytes" yaml:"response_max_header_value_bytes"`
+	ResponseOversizedHeader     string `json:"response_oversized_header" yaml:"response_oversized_header"`
+
+	// WebSocket tunnels of GET /proxy/ws are closed after WSIdleTimeout
+	// without a message either way, messages are at most WSMaxMessageBytes
+	WSIdleTimeout     Duration `json:"ws_idle_timeout" yaml:"ws_idle_timeout"`
+	WSMaxMessageBytes int      `json:"ws_max_message_bytes" yaml:"ws_max_message_bytes"`
+
+	// BatchMaxJobs caps the jobs of one batch, over HTTP or gRPC
+	BatchConcurrency int `json:"batch_concurrency" yaml:"batch_concurrency"`
+	BatchMaxJobs     int `json:"batch_max_jobs" yaml:"batch_max_jobs"`
+	AsyncWorkers     int `json:"async_workers" yaml:"async_workers"`
+	AsyncQueueSize   int `json:"async_queue_size" yaml:"async_queue_size"`
+
+	// Workers perform the upstream requests of sync and batch jobs. Jobs that
+	// find the queue full wait WorkerQueueWait for room, then get a 503.
+	Workers         int      `json:"workers" yaml:"workers"`
+	WorkerQueueSize int      `json:"worker_queue_size" yaml:"worker_queue_size"`
+	WorkerQueueWait Duration `json:"worker_queue_wait" yaml:"worker_queue_wait"`
+
+	// Jobs to a target host fail fast for BreakerCooldown once BreakerFailures
+	// of them failed in a row, 0 turns circuit breaking off. BreakerPerProxy
+	// keeps a breaker per upstream proxy of each host.
+	BreakerFailures int      `json:"breaker_failures" yaml:"breaker_failures"`
+	BreakerCooldown Duration `json:"breaker_cooldown" yaml:"breaker_cooldown"`
+	BreakerPerProxy bool     `json:"breaker_per_proxy" yaml:"breaker_per_proxy"`
+
+	// Retries to a failing target host share a backoff growing from
+	// HostBackoffBase to HostBackoffMax, 0 leaves each job to back off alone
+	HostBackoffBase Duration `json:"host_backoff_base" yaml:"host_backoff_base"`
+	HostBackoffMax  Duration `json:"host_backoff_max" yaml:"host_backoff_max"`
+
+	// DomainLimitsFile is a JSON array of per domain rate limits of the
+	// upstream requests, they can be replaced at runtime with PUT /domain-limits
+	DomainLimitsFile string `json:"domain_limits_file" yaml:"domain_limits_file"`
+
+	// Sessions expire SessionTTL after their last job, past MaxSessions jobs
+	// opening a new session get a 503
+	SessionTTL  Duration `json:"session_ttl" yaml:"session_ttl"`
+	MaxSessions int      `json:"max_sessions" yaml:"max_sessions"`
+
+	UpstreamProxy      string   `json:"upstream_proxy" yaml:"upstream_proxy"`
+	ProxyPoolFile      string   `json:"proxy_pool_file" yaml:"proxy_pool_file"`
+	ProxyPoolURL       string   `json:"proxy_pool_url" yaml:"proxy_pool_url"`
+	ProxyRotation      string   `json:"proxy_rotation" yaml:"proxy_rotation"`
+	ProxyCheckURL      string   `json:"proxy_check_url" yaml:"proxy_check_url"`
+	ProxyCheckInterval Duration `json:"proxy_check_interval" yaml:"proxy_check_interval"`
+	ProxyCheckFailures int      `json:"proxy_check_failures" yaml:"proxy_check_failures"`
+
+	// Target policy of jobs, see TargetPolicy of the server. Lists are comma
+	// separated in the environment; private addresses are blocked unless
+	// AllowPrivateTargets is set or they are in TargetAllowCIDRs. TargetMode
+	// allowlist_only rejects every host not in TargetAllowHosts, even with
+	// the list empty.
+	TargetMode          string   `json:"target_mode" yaml:"target_mode"`
+	TargetAllowSchemes  []string `json:"target_allow_schemes" yaml:"target_allow_schemes"`
+	TargetAllowHosts    []string `json:"target_allow_hosts" yaml:"target_allow_hosts"`
+	TargetDenyHosts     []string `json:"target_deny_hosts" yaml:"target_deny_hosts"`
+	TargetAllowCIDRs    []string `json:"target_allow_cidrs" yaml:"target_allow_cidrs"`
+	TargetDenyCIDRs     []string `json:"target_deny_cidrs" yaml:"target_deny_cidrs"`
+	AllowPrivateTargets bool     `json:"allow_private_targets" yaml:"allow_private_targets"`
+
+	// CacheBackend is memory or redis, the response cache is off when empty.
+	// CacheTTL applies to responses without Cache-Control or Expires.
+	CacheBackend    string   `json:"cache_backend" yaml:"cache_backend"`
+	CacheTTL        Duration `json:"cache_ttl" yaml:"cache_ttl"`
+	CacheMaxEntries int      `json:"cache_max_entries" yaml:"cache_max_entries"`
+	CacheRedisURL   string   `json:"cache_redis_url" yaml:"cache_redis_url"`
+
+	// APIKeysFile is a JSON array of API keys, without it anyone may use the proxy
+	APIKeysFile string `json:"api_keys_file" yaml:"api_keys_file"`
+
+	JobMiddlewares     string `json:"job_middlewares" yaml:"job_middlewares"`
+	ScriptsFile        string `json:"scripts_file" yaml:"scripts_file"`
+	SigningSchemesFile string `json:"signing_schemes_file" yaml:"signing_schemes_file"`
+	RewriteRulesFile   string `json:"rewrite_rules_file" yaml:"rewrite_rules_file"`
+	DeadLetterFile     string `json:"dead_letter_file" yaml:"dead_letter_file"`
+	DeadLetterURL      string `json:"dead_letter_url" yaml:"dead_letter_url"`
+
+	// WebhookSecret signs the callbacks of async jobs with HMAC-SHA256, they
+	// are delivered up to WebhookMaxAttempts times
+	WebhookSecret      string `json:"webhook_secret" yaml:"webhook_secret"`
+	WebhookMaxAttempts int    `json:"webhook_max_attempts" yaml:"webhook_max_attempts"`
+
+	// RecordingSize keeps that many of the last jobs for GET /recordings, 0
+	// turns recording off. Bodies are kept up to RecordingMaxBodyBytes.
+	RecordingSize         int `json:"recording_size" yaml:"recording_size"`
+	RecordingMaxBodyBytes int `json:"recording_max_body_bytes" yaml:"recording_max_body_bytes"`
+
+	// HistoryDSN turns on the job history, a file path for SQLite or a
+	// connection URL for Postgres. Jobs are pruned after HistoryRetention.
+	HistoryDriver       string   `json:"history_driver" yaml:"history_driver"`
+	HistoryDSN          string   `json:"history_dsn" yaml:"history_dsn"`
+	HistoryRetention    Duration `json:"history_retention" yaml:"history_retention"`
+	HistoryMaxBodyBytes int      `json:"history_max_body_bytes" yaml:"history_max_body_bytes"`
+}
+
+// Default returns the settings used when nothing is configured
+func Default() Config {
+	return Config{
+		Addr:                     ":3010",
+		GRPCAddr:                 ":3011",
+		LogLevel:                 "info",
+		LogFormat:                "console",
+		DefaultTimeout:           Duration(30 * time.Second),
+		MaxBodyBytes:             32 << 20,
+		StreamMaxBodyBytes:       1 << 30,
+		ResponseMaxHeaders:       256,
+		ResponseMaxHeaderBytes:   64 << 10,
+		ShutdownTimeout:          Duration(30 * time.Second),
+		ClusterHeartbeatInterval: Duration(5 * time.Second),
+		WSIdleTimeout:            Duration(time.Minute),
+		WSMaxMessageBytes:        1 << 20,
+		BatchConcurrency:         16,
+		BatchMaxJobs:             1000,
+		AsyncWorkers:             16,
+		AsyncQueueSize:           1024,
+		Workers:                  256,
+		WorkerQueueSize:          1024,
+		BreakerFailures:          5,
+		BreakerCooldown:          Duration(30 * time.Second),
+		HostBackoffMax:           Duration(30 * time.Second),
+		SessionTTL:               Duration(30 * time.Minute),
+		MaxSessions:              10000,
+		WebhookMaxAttempts:       5,
+
+		ResponseMaxHeaderValueBytes: 8 << 10,
+		ResponseOversizedHeader:     "truncate",
+
+		RecordingMaxBodyBytes: 64 << 10,
+		HistoryDriver:         "sqlite",
+		HistoryRetention:      Duration(7 * 24 * time.Hour),
+		HistoryMaxBodyBytes:   4 << 10,
+		ProxyRotation:         "round_robin",
+		ProxyCheckInterval:    Duration(30 * time.Second),
+		ProxyCheckFailures:    3,
+		TargetMode:            "permissive",
+		TargetAllowSchemes:    []string{"http", "https"},
+		CacheTTL:              Duration(time.Minute),
+		CacheMaxEntries:       10000,
+	}
+}
+
+// Load reads the config file at path, if any, applies the environment
+// overrides and validates the result
+func Load(path string) (Config, error) {
+	config := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			decoder := json.NewDecoder(strings.NewReader(string(data)))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(&config)
+		case ".yaml", ".yml":
+			decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+			decoder.KnownFields(true)
+			err = decoder.Decode(&config)
+		default:
+			err = errors.New("use a .json, .yaml or .yml file")
+		}
+		if err != nil {
+			return config, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+	text := func(name string, dst *string) {
+		if value := os.Getenv(name); value != "" {
+			*dst = value
+		}
+	}
+	number := func(name string, dst *int) {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", name, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(name string, dst *Duration) {
+		if value := os.Getenv(name); value != "" {
+			d, err := parseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if value := os.Getenv(name); value != "" {
+			*dst = nil
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	boolean := func(name string, dst *bool) {
+		if value := os.Getenv(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a boolean", name, value))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	text("PROXIER_ADDR", &c.Addr)
+	text("PROXIER_GRPC_ADDR", &c.GRPCAddr)
+	text("PROXIER_TLS_CERT_FILE", &c.TLSCertFile)
+	text("PROXIER_TLS_KEY_FILE", &c.TLSKeyFile)
+	text("PROXIER_TLS_CLIENT_CA_FILE", &c.TLSClientCAFile)
+	duration("PROXIER_TLS_RELOAD_INTERVAL", &c.TLSReloadInterval)
+	duration("PROXIER_SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	text("PROXIER_CLUSTER_MODE", &c.ClusterMode)
+	text("PROXIER_CLUSTER_TOKEN", &c.ClusterToken)
+	text("PROXIER_CLUSTER_COORDINATOR_URL", &c.ClusterCoordinatorURL)
+	text("PROXIER_CLUSTER_ADVERTISE_URL", &c.ClusterAdvertiseURL)
+	text("PROXIER_CLUSTER_WORKER_ID", &c.ClusterWorkerID)
+	list("PROXIER_CLUSTER_TAGS", &c.ClusterTags)
+	duration("PROXIER_CLUSTER_HEARTBEAT_INTERVAL", &c.ClusterHeartbeatInterval)
+	text("PROXIER_LOG_LEVEL", &c.LogLevel)
+	text("PROXIER_LOG_FORMAT", &c.LogFormat)
+	duration("PROXIER_DEFAULT_TIMEOUT", &c.DefaultTimeout)
+	number("PROXIER_MAX_BODY_BYTES", &c.MaxBodyBytes)
+	number("PROXIER_STREAM_MAX_BODY_BYTES", &c.StreamMaxBodyBytes)
+	number("PROXIER_RESPONSE_MAX_HEADERS", &c.ResponseMaxHeaders)
+	number("PROXIER_RESPONSE_MAX_HEADER_BYTES", &c.ResponseMaxHeaderBytes)
+	number("PROXIER_RESPONSE_MAX_HEADER_VALUE_BYTES", &c.ResponseMaxHeaderValueBytes)
+	text("PROXIER_RESPONSE_OVERSIZED_HEADER", &c.ResponseOversizedHeader)
+	duration("PROXIER_WS_IDLE_TIMEOUT", &c.WSIdleTimeout)
+	number("PROXIER_WS_MAX_MESSAGE_BYTES", &c.WSMaxMessageBytes)
+	number("PROXIER_BATCH_CONCURRENCY", &c.BatchConcurrency)
+	number("PROXIER_BATCH_MAX_JOBS", &c.BatchMaxJobs)
+	number("PROXIER_ASYNC_WORKERS", &c.AsyncWorkers)
+	number("PROXIER_ASYNC_QUEUE_SIZE", &c.AsyncQueueSize)
+	number("PROXIER_WORKERS", &c.Workers)
+	number("PROXIER_WORKER_QUEUE_SIZE", &c.WorkerQueueSize)
+	duration("PROXIER_WORKER_QUEUE_WAIT", &c.WorkerQueueWait)
+	number("PROXIER_BREAKER_FAILURES", &c.BreakerFailures)
+	duration("PROXIER_BREAKER_COOLDOWN", &c.BreakerCooldown)
+	boolean("PROXIER_BREAKER_PER_PROXY", &c.BreakerPerProxy)
+	duration("PROXIER_HOST_BACKOFF_BASE", &c.HostBackoffBase)
+	duration("PROXIER_HOST_BACKOFF_MAX", &c.HostBackoffMax)
+	duration("PROXIER_SESSION_TTL", &c.SessionTTL)
+	number("PROXIER_MAX_SESSIONS", &c.MaxSessions)
+	text("PROXIER_UPSTREAM_PROXY", &c.UpstreamProxy)
+	text("PROXIER_PROXY_POOL_FILE", &c.ProxyPoolFile)
+	text("PROXIER_PROXY_POOL_URL", &c.ProxyPoolURL)
+	text("PROXIER_PROXY_ROTATION", &c.ProxyRotation)
+	text("PROXIER_PROXY_CHECK_URL", &c.ProxyCheckURL)
+	duration("PROXIER_PROXY_CHECK_INTERVAL", &c.ProxyCheckInterval)
+	number("PROXIER_PROXY_CHECK_FAILURES", &c.ProxyCheckFailures)
+	text("PROXIER_TARGET_MODE", &c.TargetMode)
+	list("PROXIER_TARGET_ALLOW_SCHEMES", &c.TargetAllowSchemes)
+	list("PROXIER_TARGET_ALLOW_HOSTS", &c.TargetAllowHosts)
+	list("PROXIER_TARGET_DENY_HOSTS", &c.TargetDenyHosts)
+	list("PROXIER_TARGET_ALLOW_CIDRS", &c.TargetAllowCIDRs)
+	list("PROXIER_TARGET_DENY_CIDRS", &c.TargetDenyCIDRs)
+	boolean("PROXIER_ALLOW_PRIVATE_TARGETS", &c.AllowPrivateTargets)
+	text("PROXIER_CACHE_BACKEND", &c.CacheBackend)
+	duration("PROXIER_CACHE_TTL", &c.CacheTTL)
+	number("PROXIER_CACHE_MAX_ENTRIES", &c.CacheMaxEntries)
+	text("PROXIER_CACHE_REDIS_URL", &c.CacheRedisURL)
+	text("PROXIER_API_KEYS_FILE", &c.APIKeysFile)
+	text("PROXIER_JOB_MIDDLEWARES", &c.JobMiddlewares)
+	text("PROXIER_SCRIPTS_FILE", &c.ScriptsFile)
+	text("PROXIER_SIGNING_SCHEMES_FILE", &c.SigningSchemesFile)
+	text("PROXIER_REWRITE_RULES_FILE", &c.RewriteRulesFile)
+	text("PROXIER_DOMAIN_LIMITS_FILE", &c.DomainLimitsFile)
+	text("PROXIER_DEAD_LETTER_FILE", &c.DeadLetterFile)
+	text("PROXIER_DEAD_LETTER_URL", &c.DeadLetterURL)
+	text("PROXIER_WEBHOOK_SECRET", &c.WebhookSecret)
+	number("PROXIER_WEBHOOK_MAX_ATTEMPTS", &c.WebhookMaxAttempts)
+	number("PROXIER_RECORDING_SIZE", &c.RecordingSize)
+	number("PROXIER_RECORDING_MAX_BODY_BYTES", &c.RecordingMaxBodyBytes)
+	text("PROXIER_HISTORY_DRIVER", &c.HistoryDriver)
+	text("PROXIER_HISTORY_DSN", &c.HistoryDSN)
+	duration("PROXIER_HISTORY_RETENTION", &c.HistoryRetention)
+	number("PROXIER_HISTORY_MAX_BODY_BYTES", &c.HistoryMaxBodyBytes)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		invalid("addr %q: %v", c.Addr, err)
+	}
+	if _, _, err := net.SplitHostPort(c.GRPCAddr); err != nil {
+		invalid("grpc_addr %q: %v", c.GRPCAddr, err)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		invalid("tls_cert_file and tls_key_file must be set together")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		invalid("tls_client_ca_file needs tls_cert_file and tls_key_file")
+	}
+	if c.TLSReloadInterval < 0 {
+		invalid("tls_reload_interval %s: must not be negative", time.Duration(c.TLSReloadInterval))
+	}
+	if c.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout %s: must be positive", time.Duration(c.ShutdownTimeout))
+	}
+	switch c.ClusterMode {
+	case "":
+	case "coordinator", "worker":
+		if c.ClusterToken == "" {
+			invalid("cluster_token must be set in cluster_mode %s", c.ClusterMode)
+		}
+	default:
+		invalid("cluster_mode %q: use coordinator or worker", c.ClusterMode)
+	}
+	isHTTPURL := func(value string) bool {
+		return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+	}
+	if c.ClusterMode == "worker" && !isHTTPURL(c.ClusterCoordinatorURL) {
+		invalid("cluster_coordinator_url %q: must be an http:// or https:// URL in cluster_mode worker", c.ClusterCoordinatorURL)
+	}
+	if c.ClusterMode == "worker" && !isHTTPURL(c.ClusterAdvertiseURL) {
+		invalid("cluster_advertise_url %q: must be an http:// or https:// URL in cluster_mode worker", c.ClusterAdvertiseURL)
+	}
+	for _, tag := range c.ClusterTags {
+		if name, _, ok := strings.Cut(tag, "="); !ok || name == "" {
+			invalid("cluster_tags %q: use name=value", tag)
+		}
+	}
+	if c.ClusterHeartbeatInterval <= 0 {
+		invalid("cluster_heartbeat_interval %s: must be positive", time.Duration(c.ClusterHeartbeatInterval))
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "trace", "debug", "info", "warn", "error", "fatal", "panic", "disabled":
+	default:
+		invalid("log_level %q: use trace, debug, info, warn, error, fatal, panic or disabled", c.LogLevel)
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "json", "console":
+	default:
+		invalid("log_format %q: use json or console", c.LogFormat)
+	}
+	if c.DefaultTimeout <= 0 || time.Duration(c.DefaultTimeout) > 10*time.Minute {
+		invalid("default_timeout %s: must be positive and at most 10m", time.Duration(c.DefaultTimeout))
+	}
+	if c.MaxBodyBytes <= 0 {
+		invalid("max_body_bytes %d: must be positive", c.MaxBodyBytes)
+	}
+	if c.StreamMaxBodyBytes <= 0 {
+		invalid("stream_max_body_bytes %d: must be positive", c.StreamMaxBodyBytes)
+	}
+	if c.ResponseMaxHeaders <= 0 {
+		invalid("response_max_headers %d: must be positive", c.ResponseMaxHeaders)
+	}
+	if c.ResponseMaxHeaderBytes <= 0 {
+		invalid("response_max_header_bytes %d: must be positive", c.ResponseMaxHeaderBytes)
+	}
+	if c.ResponseMaxHeaderValueBytes <= 0 {
+		invalid("response_max_header_value_bytes %d: must be positive", c.ResponseMaxHeaderValueBytes)
+	}
+	switch c.ResponseOversizedHeader {
+	case "truncate", "drop":
+	default:
+		invalid("response_oversized_header %q: use truncate or drop", c.ResponseOversizedHeader)
+	}
+	if c.WSIdleTimeout <= 0 || time.Duration(c.WSIdleTimeout) > 24*time.Hour {
+		invalid("ws_idle_timeout %s: must be positive and at most 24h", time.Duration(c.WSIdleTimeout))
+	}
+	if c.WSMaxMessageBytes <= 0 {
+		invalid("ws_max_message_bytes %d: must be positive", c.WSMaxMessageBytes)
+	}
+	if c.BatchConcurrency <= 0 {
+		invalid("batch_concurrency %d: must be positive", c.BatchConcurrency)
+	}
+	if c.BatchMaxJobs <= 0 {
+		invalid("batch_max_jobs %d: must be positive", c.BatchMaxJobs)
+	}
+	if c.AsyncWorkers <= 0 {
+		invalid("async_workers %d: must be positive", c.AsyncWorkers)
+	}
+	if c.AsyncQueueSize < 0 {
+		invalid("async_queue_size %d: must not be negative", c.AsyncQueueSize)
+	}
+	if c.Workers <= 0 {
+		invalid("workers %d: must be positive", c.Workers)
+	}
+	if c.WorkerQueueSize < 0 {
+		invalid("worker_queue_size %d: must not be negative", c.WorkerQueueSize)
+	}
+	if c.WorkerQueueWait < 0 || time.Duration(c.WorkerQueueWait) > 10*time.Second {
+		invalid("worker_queue_wait %s: must not be negative and at most 10s", time.Duration(c.WorkerQueueWait))
+	}
+	if c.BreakerFailures < 0 {
+		invalid("breaker_failures %d: must not be negative", c.BreakerFailures)
+	}
+	if c.BreakerFailures > 0 && c.BreakerCooldown <= 0 {
+		invalid("breaker_cooldown %s: must be positive", time.Duration(c.BreakerCooldown))
+	}
+	if c.HostBackoffBase < 0 {
+		invalid("host_backoff_base %s: must not be negative", time.Duration(c.HostBackoffBase))
+	}
+	if c.HostBackoffBase > 0 && c.HostBackoffMax < c.HostBackoffBase {
+		invalid("host_backoff_max %s: must be at least host_backoff_base", time.Duration(c.HostBackoffMax))
+	}
+	if c.SessionTTL <= 0 || time.Duration(c.SessionTTL) > 7*24*time.Hour {
+		invalid("session_ttl %s: must be positive and at most 168h", time.Duration(c.SessionTTL))
+	}
+	if c.MaxSessions <= 0 {
+		invalid("max_sessions %d: must be positive", c.MaxSessions)
+	}
+	if c.WebhookMaxAttempts <= 0 || c.WebhookMaxAttempts > 20 {
+		invalid("webhook_max_attempts %d: must be between 1 and 20", c.WebhookMaxAttempts)
+	}
+	if c.RecordingSize < 0 {
+		invalid("recording_size %d: must not be negative", c.RecordingSize)
+	}
+	if c.RecordingMaxBodyBytes < 0 {
+		invalid("recording_max_body_bytes %d: must not be negative", c.RecordingMaxBodyBytes)
+	}
+	switch c.HistoryDriver {
+	case "sqlite", "postgres":
+	default:
+		invalid("history_driver %q: use sqlite or postgres", c.HistoryDriver)
+	}
+	if c.HistoryRetention <= 0 {
+		invalid("history_retention %s: must be positive", time.Duration(c.HistoryRetention))
+	}
+	if c.HistoryMaxBodyBytes < 0 {
+		invalid("history_max_body_bytes %d: must not be negative", c.HistoryMaxBodyBytes)
+	}
+	if c.ProxyPoolFile != "" && c.ProxyPoolURL != "" {
+		invalid("proxy_pool_file and proxy_pool_url: set only one of them")
+	}
+	switch c.ProxyRotation {
+	case "round_robin", "random", "sticky":
+	default:
+		invalid("proxy_rotation %q: use round_robin, random or sticky", c.ProxyRotation)
+	}
+	if c.ProxyCheckURL != "" {
+		if parsed, err := url.Parse(c.ProxyCheckURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			invalid("proxy_check_url %q: use an absolute http or https URL", c.ProxyCheckURL)
+		}
+	}
+	if c.ProxyCheckInterval <= 0 {
+		invalid("proxy_check_interval %s: must be positive", time.Duration(c.ProxyCheckInterval))
+	}
+	if c.ProxyCheckFailures <= 0 {
+		invalid("proxy_check_failures %d: must be positive", c.ProxyCheckFailures)
+	}
+	if c.TargetMode != "permissive" && c.TargetMode != "allowlist_only" {
+		invalid("target_mode %q: use permissive or allowlist_only", c.TargetMode)
+	}
+	if len(c.TargetAllowSchemes) == 0 {
+		invalid("target_allow_schemes: allow at least one scheme")
+	}
+	for _, scheme := range c.TargetAllowSchemes {
+		if scheme != "http" && scheme != "https" {
+			invalid("target_allow_schemes %q: use http or https", scheme)
+		}
+	}
+	for _, cidrs := range [][]string{c.TargetAllowCIDRs, c.TargetDenyCIDRs} {
+		for _, cidr := range cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+				invalid("target CIDR %q: use an address or a CIDR such as 10.0.0.0/8", cidr)
+			}
+		}
+	}
+	switch c.CacheBackend {
+	case "", "memory":
+	case "redis":
+		if c.CacheRedisURL == "" {
+			invalid("cache_redis_url: required by the redis cache backend")
+		}
+	default:
+		invalid("cache_backend %q: use memory or redis", c.CacheBackend)
+	}
+	if c.CacheTTL <= 0 || time.Duration(c.CacheTTL) > 24*time.Hour {
+		invalid("cache_ttl %s: must be positive and at most 24h", time.Duration(c.CacheTTL))
+	}
+	if c.CacheMaxEntries <= 0 {
+		invalid("cache_max_entries %d: must be positive", c.CacheMaxEntries)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,158 @@
+package server_config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name string, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  string
+	}{
+		{"json", "config.json", `{"addr": ":8080", "default_timeout": "45s", "session_ttl": 600, "target_allow_hosts": ["example.com"]}`, ""},
+		{"yaml", "config.yaml", "addr: \":8080\"\ndefault_timeout: 45s\nsession_ttl: 600\ntarget_allow_hosts: [example.com]\n", ""},
+		{"unknown json field", "config.json", `{"adress": ":8080"}`, "unknown field"},
+		{"unknown yaml field", "config.yml", "adress: \":8080\"\n", "not found"},
+		{"invalid duration", "config.json", `{"default_timeout": "soon"}`, `invalid duration "soon"`},
+		{"other extension", "config.toml", `addr = ":8080"`, "use a .json, .yaml or .yml file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := Load(writeConfig(t, test.file, test.data))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an error with %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Addr != ":8080" || config.DefaultTimeout != Duration(45*time.Second) || config.SessionTTL != Duration(10*time.Minute) {
+				t.Fatalf("loaded %s, %s and %s", config.Addr, time.Duration(config.DefaultTimeout), time.Duration(config.SessionTTL))
+			}
+			// settings missing from the file keep their default
+			if config.GRPCAddr != Default().GRPCAddr || config.Workers != Default().Workers {
+				t.Fatalf("defaults lost: grpc_addr %q, workers %d", config.GRPCAddr, config.Workers)
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("missing config file loaded")
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"addr": ":8080", "max_body_bytes": 1024}`)
+	t.Setenv("PROXIER_ADDR", ":9090")
+	t.Setenv("PROXIER_MAX_BODY_BYTES", "2048")
+	t.Setenv("PROXIER_DEFAULT_TIMEOUT", "15")
+	t.Setenv("PROXIER_HOST_BACKOFF_BASE", "250ms")
+	t.Setenv("PROXIER_TARGET_MODE", "allowlist_only")
+	t.Setenv("PROXIER_TARGET_ALLOW_HOSTS", " example.com, ,*.example.org ")
+	t.Setenv("PROXIER_BREAKER_PER_PROXY", "true")
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Addr != ":9090" || config.MaxBodyBytes != 2048 {
+		t.Fatalf("addr %q and max_body_bytes %d, want the environment over the file", config.Addr, config.MaxBodyBytes)
+	}
+	if config.DefaultTimeout != Duration(15*time.Second) || config.HostBackoffBase != Duration(250*time.Millisecond) {
+		t.Fatalf("default_timeout %s and host_backoff_base %s", time.Duration(config.DefaultTimeout), time.Duration(config.HostBackoffBase))
+	}
+	if config.TargetMode != "allowlist_only" || !config.BreakerPerProxy {
+		t.Fatalf("target_mode %q and breaker_per_proxy %v", config.TargetMode, config.BreakerPerProxy)
+	}
+	if want := []string{"example.com", "*.example.org"}; !reflect.DeepEqual(config.TargetAllowHosts, want) {
+		t.Fatalf("target_allow_hosts = %q, want %q", config.TargetAllowHosts, want)
+	}
+}
+
+func TestEnvInvalid(t *testing.T) {
+	t.Setenv("PROXIER_WORKERS", "many")
+	t.Setenv("PROXIER_SESSION_TTL", "a while")
+	t.Setenv("PROXIER_ALLOW_PRIVATE_TARGETS", "maybe")
+
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("invalid environment loaded")
+	}
+	// every invalid variable is reported at once
+	for _, name := range []string{"PROXIER_WORKERS", "PROXIER_SESSION_TTL", "PROXIER_ALLOW_PRIVATE_TARGETS"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not name %s", err, name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		err    string
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"cluster worker", func(c *Config) {
+			c.ClusterMode, c.ClusterToken = "worker", "secret"
+			c.ClusterCoordinatorURL, c.ClusterAdvertiseURL = "http://coordinator:3010", "http://worker-1:3010"
+		}, ""},
+		{"cluster without a token", func(c *Config) { c.ClusterMode = "coordinator" }, "cluster_token must be set"},
+		{"unknown cluster mode", func(c *Config) { c.ClusterMode = "leader"; c.ClusterToken = "secret" }, `cluster_mode "leader"`},
+		{"worker without coordinator", func(c *Config) {
+			c.ClusterMode, c.ClusterToken, c.ClusterAdvertiseURL = "worker", "secret", "http://worker-1:3010"
+		}, "cluster_coordinator_url"},
+		{"cluster tag without a name", func(c *Config) { c.ClusterTags = []string{"=eu"} }, "cluster_tags"},
+		{"negative body limit", func(c *Config) { c.MaxBodyBytes = -1 }, "max_body_bytes -1: must be positive"},
+		{"negative queue size", func(c *Config) { c.WorkerQueueSize = -1 }, "worker_queue_size -1: must not be negative"},
+		{"default timeout over the limit", func(c *Config) { c.DefaultTimeout = Duration(time.Hour) }, "default_timeout 1h0m0s"},
+		{"invalid addr", func(c *Config) { c.Addr = "3010" }, `addr "3010"`},
+		{"tls cert without key", func(c *Config) { c.TLSCertFile = "server.crt" }, "tls_cert_file and tls_key_file"},
+		{"unknown target mode", func(c *Config) { c.TargetMode = "strict" }, `target_mode "strict"`},
+		{"unknown scheme", func(c *Config) { c.TargetAllowSchemes = []string{"ftp"} }, `target_allow_schemes "ftp"`},
+		{"invalid cidr", func(c *Config) { c.TargetDenyCIDRs = []string{"10.0.0.0/33"} }, `target CIDR "10.0.0.0/33"`},
+		{"host backoff max under base", func(c *Config) {
+			c.HostBackoffBase, c.HostBackoffMax = Duration(time.Second), Duration(time.Millisecond)
+		}, "host_backoff_max 1ms: must be at least host_backoff_base"},
+		{"negative host backoff", func(c *Config) { c.HostBackoffBase = Duration(-time.Second) }, "host_backoff_base -1s"},
+		{"redis without url", func(c *Config) { c.CacheBackend = "redis" }, "cache_redis_url"},
+		{"two proxy pools", func(c *Config) { c.ProxyPoolFile, c.ProxyPoolURL = "pool.txt", "http://pool/" }, "set only one of them"},
+	}
+	for _, test := range tests {
+		config := Default()
+		test.change(&config)
+		err := config.Validate()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: got %v, want it valid", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want an error with %q", test.name, err, test.err)
+		}
+	}
+
+	// every invalid setting is reported at once
+	config := Default()
+	config.MaxBodyBytes, config.Workers = 0, 0
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "max_body_bytes") || !strings.Contains(err.Error(), "workers") {
+		t.Fatalf("got %v, want both settings reported", err)
+	}
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header carrying the signature of a callback
+const SignatureHeader = "X-Proxier-Signature"
+
+// ErrInvalidSignature is returned for callbacks not signed with the secret,
+// or signed longer than the tolerance ago
+var ErrInvalidSignature = errors.New("proxier: invalid callback signature")
+
+// VerifyCallback checks the X-Proxier-Signature of a callback body signed with
+// the webhook secret of the server and decodes it. Callbacks signed more than
+// tolerance ago are rejected to stop replays, 0 accepts any age.
+func VerifyCallback(secret []byte, signature string, body []byte, tolerance time.Duration) (Callback, error) {
+	var timestamp, digest string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			digest = value
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Callback{}, ErrInvalidSignature
+	}
+	expected, err := hex.DecodeString(digest)
+	if err != nil {
+		return Callback{}, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return Callback{}, ErrInvalidSignature
+	}
+	if tolerance > 0 && time.Since(time.Unix(unix, 0)).Abs() > tolerance {
+		return Callback{}, ErrInvalidSignature
+	}
+
+	var callback Callback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return Callback{}, err
+	}
+	return callback, nil
+}
@@ -0,0 +1,288 @@
+// Package client is a Go client for the proxier worker API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	client_args "aslon1213/proxy_worker/configs/client"
+)
+
+// Error is a job the server could not perform, e.g. an invalid job or a
+// timeout. Code is one of the ErrorDetail codes, Retryable tells whether the
+// job may succeed when sent again later.
+type Error struct {
+	Status    int
+	Message   string
+	Code      string
+	Retryable bool
+	// Errs are the upstream errors when the request itself failed
+	Errs []ErrorDetail
+}
+
+func (e *Error) Error() string {
+	if len(e.Errs) > 0 {
+		messages := make([]string, len(e.Errs))
+		for i, detail := range e.Errs {
+			messages[i] = detail.Message
+		}
+		return fmt.Sprintf("proxier: %d: %s", e.Status, strings.Join(messages, "; "))
+	}
+	return fmt.Sprintf("proxier: %d: %s", e.Status, e.Message)
+}
+
+// decodeErrorDetails reads upstream errors, servers before error codes sent
+// their messages only
+func decodeErrorDetails(raws []json.RawMessage) []ErrorDetail {
+	var details []ErrorDetail
+	for _, raw := range raws {
+		var detail ErrorDetail
+		if json.Unmarshal(raw, &detail) == nil && detail.Message != "" {
+			details = append(details, detail)
+			continue
+		}
+		var message string
+		if json.Unmarshal(raw, &message) == nil {
+			details = append(details, ErrorDetail{Code: ErrorUpstream, Message: message})
+		}
+	}
+	return details
+}
+
+// Client talks to a proxier worker
+type Client struct {
+	base    string
+	api_key string
+	http    *http.Client
+}
+
+// New creates a client for the server at config.Host, e.g. "localhost:3010"
+// or "https://proxier.internal", which may be a coordinator as well. A zero Timeout leaves requests unbounded
+// unless their context has a deadline. Servers using mutual TLS need
+// config.TLS with a client certificate.
+func New(config client_args.ProxyServerConfig) *Client {
+	base := strings.TrimRight(config.Host, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	client := &http.Client{Timeout: config.Timeout}
+	if config.TLS != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config.TLS
+		client.Transport = transport
+	}
+	return &Client{
+		base:    base,
+		api_key: config.APIKey,
+		http:    client,
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.api_key != "" {
+		req.Header.Set("X-API-Key", c.api_key)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// the status of a proxied response is the upstream status, so failures are
+	// told apart by their body: {"error": ...} or {"errs": [...]} alone
+	var failure struct {
+		Error      *string           `json:"error"`
+		Code       string            `json:"code"`
+		Retryable  bool              `json:"retryable"`
+		Errs       []json.RawMessage `json:"errs"`
+		StatusCode *int              `json:"status_code"`
+	}
+	if resp.StatusCode >= 400 && json.Unmarshal(data, &failure) == nil && failure.StatusCode == nil &&
+		(failure.Error != nil || len(failure.Errs) > 0) {
+		failed := &Error{
+			Status:    resp.StatusCode,
+			Message:   "upstream request failed",
+			Code:      failure.Code,
+			Retryable: failure.Retryable,
+			Errs:      decodeErrorDetails(failure.Errs),
+		}
+		if failure.Error != nil {
+			failed.Message = *failure.Error
+		}
+		if failed.Code == "" && len(failed.Errs) > 0 {
+			failed.Code, failed.Retryable = failed.Errs[0].Code, failed.Errs[0].Retryable
+		}
+		return failed
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("proxier: invalid response (%d): %w", resp.StatusCode, err)
+	}
+	return nil
+}
+
+// Do performs the job and waits for its response. Upstream error statuses are
+// returned as a Response, an *Error means the job could not be performed.
+func (c *Client) Do(ctx context.Context, job Job) (Response, error) {
+	var response Response
+	err := c.do(ctx, http.MethodPost, "/proxy", job, &response)
+	return response, err
+}
+
+// Stream performs the job with POST /proxy/stream and returns the upstream
+// response as is, its body read straight from the connection. The caller
+// must close the body. Errors of the worker are returned as an *Error.
+func (c *Client) Stream(ctx context.Context, job Job) (*http.Response, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/proxy/stream", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.api_key != "" {
+		req.Header.Set("X-API-Key", c.api_key)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("X-Proxier-Streamed") != "" {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	var failure struct {
+		Error     string `json:"error"`
+		Code      string `json:"code"`
+		Retryable bool   `json:"retryable"`
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(body, &failure) != nil || failure.Error == "" {
+		failure.Error = strings.TrimSpace(string(body))
+	}
+	return nil, &Error{Status: resp.StatusCode, Message: failure.Error, Code: failure.Code, Retryable: failure.Retryable}
+}
+
+// Batch performs the jobs concurrently on the server and returns their
+// responses in order. A job that failed has its errors in the Errs of its
+// slot. concurrency 0 uses the server default.
+func (c *Client) Batch(ctx context.Context, jobs []Job, concurrency int) ([]Response, error) {
+	path := "/proxy/batch"
+	if concurrency > 0 {
+		path += "?concurrency=" + strconv.Itoa(concurrency)
+	}
+	var responses []Response
+	err := c.do(ctx, http.MethodPost, path, jobs, &responses)
+	return responses, err
+}
+
+// Submit queues the job on the server and returns its ID without waiting
+func (c *Client) Submit(ctx context.Context, job Job) (string, error) {
+	var status JobStatus
+	if err := c.do(ctx, http.MethodPost, "/proxy?async=true", job, &status); err != nil {
+		return "", err
+	}
+	return status.ID, nil
+}
+
+// Job returns the state of an async job
+func (c *Client) Job(ctx context.Context, id string) (JobStatus, error) {
+	var status JobStatus
+	err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &status)
+	return status, err
+}
+
+// History lists the finished jobs of the API key, newest first
+func (c *Client) History(ctx context.Context, query HistoryQuery) ([]HistoryJob, error) {
+	params := url.Values{}
+	if query.State != "" {
+		params.Set("status", string(query.State))
+	}
+	if !query.Since.IsZero() {
+		params.Set("since", query.Since.Format(time.RFC3339))
+	}
+	if !query.Until.IsZero() {
+		params.Set("until", query.Until.Format(time.RFC3339))
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	var out struct {
+		Jobs []HistoryJob `json:"jobs"`
+	}
+	err := c.do(ctx, http.MethodGet, "/jobs?"+params.Encode(), nil, &out)
+	return out.Jobs, err
+}
+
+// DeleteSession drops the cookie jar of a session
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// Wait polls the async job every interval until it is done or failed
+func (c *Client) Wait(ctx context.Context, id string, interval time.Duration) (Response, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := c.Job(ctx, id)
+		if err != nil {
+			return Response{}, err
+		}
+		switch status.State {
+		case JobDone:
+			if status.Response == nil {
+				return Response{}, errors.New("proxier: done job has no response")
+			}
+			return *status.Response, nil
+		case JobFailed:
+			failed := &Error{Status: http.StatusBadGateway, Message: status.Error, Code: status.ErrorCode, Errs: status.Errors}
+			if failed.Code == "" && len(failed.Errs) > 0 {
+				failed.Code, failed.Retryable = failed.Errs[0].Code, failed.Errs[0].Retryable
+			}
+			return Response{}, failed
+		}
+
+		select {
+		case <-ctx.Done():
+			return Response{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
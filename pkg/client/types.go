@@ -0,0 +1,316 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Job is a proxy job, see ProxyJob of the server for what each option does
+type Job struct {
+	URL         string              `json:"url"`
+	Method      string              `json:"method"`
+	Headers     map[string]string   `json:"headers,omitempty"`
+	Body        string              `json:"body,omitempty"`
+	Cookies     map[string]string   `json:"cookies,omitempty"`
+	QueryParams map[string][]string `json:"query_params,omitempty"`
+	Timeout     int                 `json:"timeout,omitempty"`
+	TimeoutMs   int                 `json:"timeout_ms,omitempty"`
+
+	BasicAuthUser string `json:"basic_auth_user,omitempty"`
+	BasicAuthPass string `json:"basic_auth_pass,omitempty"`
+
+	ProxyURL           string `json:"proxy_url,omitempty"`
+	SNI                string `json:"sni,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+	CACert             string `json:"ca_cert,omitempty"`
+
+	KeepHopByHopHeaders  bool   `json:"keep_hop_by_hop_headers,omitempty"`
+	RawBytes             bool   `json:"raw_bytes,omitempty"`
+	MaxBodyBytes         int    `json:"max_body_bytes,omitempty"`
+	CacheBody            bool   `json:"cache_body,omitempty"`
+	ResponseEncoding     string `json:"response_encoding,omitempty"`
+	CompressResponseBody bool   `json:"compress_response_body,omitempty"`
+
+	FollowRedirects   bool `json:"follow_redirects,omitempty"`
+	MaxRedirects      int  `json:"max_redirects,omitempty"`
+	FollowMetaRefresh bool `json:"follow_meta_refresh,omitempty"`
+	DetectJSRedirect  bool `json:"detect_js_redirect,omitempty"`
+
+	SigningScheme string `json:"signing_scheme,omitempty"`
+
+	MaxRetries         int    `json:"max_retries,omitempty"`
+	RetryOnStatus      []int  `json:"retry_on_status,omitempty"`
+	RetryNonIdempotent bool   `json:"retry_non_idempotent,omitempty"`
+	Retry              *Retry `json:"retry,omitempty"`
+
+	CacheTTL int  `json:"cache_ttl,omitempty"`
+	NoCache  bool `json:"no_cache,omitempty"`
+
+	SessionID   string `json:"session_id,omitempty"`
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// WorkerTags pick the workers a coordinator dispatches the job to
+	WorkerTags map[string]string `json:"worker_tags,omitempty"`
+
+	Script string `json:"script,omitempty"`
+
+	// Extract names the fields to pull out of the body into Extracted
+	Extract     map[string]Extractor `json:"extract,omitempty"`
+	ExtractOnly bool                 `json:"extract_only,omitempty"`
+
+	RewriteRules []RewriteRule `json:"rewrite_rules,omitempty"`
+
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Extractor is one field to extract, with exactly one of JSON (a gjson
+// path), CSS or XPath. Attr takes an attribute instead of the text of
+// matches, All every match instead of the first.
+type Extractor struct {
+	JSON  string `json:"json,omitempty"`
+	CSS   string `json:"css,omitempty"`
+	XPath string `json:"xpath,omitempty"`
+	Attr  string `json:"attr,omitempty"`
+	All   bool   `json:"all,omitempty"`
+}
+
+// RewriteRule edits the request and response of jobs whose target matches
+// Host, a host name or "*.example.com", and the path prefix Path
+type RewriteRule struct {
+	Host     string          `json:"host,omitempty"`
+	Path     string          `json:"path,omitempty"`
+	Request  *RewriteActions `json:"request,omitempty"`
+	Response *RewriteActions `json:"response,omitempty"`
+}
+
+// RewriteActions are applied in the order of the fields
+type RewriteActions struct {
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	AddHeaders    map[string]string `json:"add_headers,omitempty"`
+	Body          []BodyRewrite     `json:"body,omitempty"`
+}
+
+// BodyRewrite replaces the matches of the regular expression Pattern
+type BodyRewrite struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// Retry is the retry policy of a job, replacing MaxRetries and RetryOnStatus
+type Retry struct {
+	MaxAttempts          int   `json:"max_attempts,omitempty"`
+	BackoffBaseMs        int   `json:"backoff_base_ms,omitempty"`
+	BackoffMaxMs         int   `json:"backoff_max_ms,omitempty"`
+	RetryOnStatus        []int `json:"retry_on_status,omitempty"`
+	RetryOnNetworkErrors bool  `json:"retry_on_network_errors,omitempty"`
+}
+
+// AttemptError is a failed attempt of a job
+type AttemptError struct {
+	Attempt    int           `json:"attempt"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Errors     []ErrorDetail `json:"errors,omitempty"`
+	Proxy      string        `json:"proxy,omitempty"`
+}
+
+// Codes of ErrorDetail. Jobs rejected by the target policy have the code of
+// the rejection: scheme_not_allowed, target_denied or private_address.
+const (
+	ErrorDNSFailure        = "dns_failure"
+	ErrorConnectionRefused = "connection_refused"
+	ErrorConnectionReset   = "connection_reset"
+	ErrorTLS               = "tls_error"
+	ErrorTimeout           = "timeout"
+	ErrorUpstreamProxy     = "upstream_proxy_failure"
+	ErrorBodyTooLarge      = "body_too_large"
+	ErrorUpstream          = "upstream_error"
+)
+
+// ErrorDetail is an upstream error of a job. Retryable tells whether the job
+// may succeed when sent again later.
+type ErrorDetail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+// Pagination fetches the following pages of a paginated API
+type Pagination struct {
+	Mode     string `json:"mode,omitempty"`
+	NextPath string `json:"next_path,omitempty"`
+	MaxPages int    `json:"max_pages,omitempty"`
+	DelayMs  int    `json:"delay_ms,omitempty"`
+}
+
+// Cookie is a cookie set by the upstream
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Domain   string     `json:"domain,omitempty"`
+	Path     string     `json:"path,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	MaxAge   int        `json:"max_age,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+	HTTPOnly bool       `json:"http_only,omitempty"`
+	SameSite string     `json:"same_site,omitempty"`
+}
+
+// Response is the result of a proxy job. Body is always the decoded upstream
+// body, whatever encoding the server used to send it.
+type Response struct {
+	StatusCode int                 `json:"status_code"`
+	Body       []byte              `json:"-"`
+	Headers    map[string][]string `json:"headers"`
+	Errs       []ErrorDetail       `json:"errs"`
+	Cookies    []Cookie            `json:"cookies,omitempty"`
+
+	BodyEncoding string         `json:"body_encoding,omitempty"`
+	Extracted    map[string]any `json:"extracted,omitempty"`
+	StartedAt    time.Time      `json:"started_at"`
+	DurationMs   int64          `json:"duration_ms"`
+
+	UpstreamProxy string         `json:"upstream_proxy,omitempty"`
+	Attempts      int            `json:"attempts,omitempty"`
+	AttemptErrors []AttemptError `json:"attempt_errors,omitempty"`
+	CacheStatus   string         `json:"cache_status,omitempty"`
+	FinalURL      string         `json:"final_url,omitempty"`
+	Redirects     int            `json:"redirects,omitempty"`
+	MetaRefreshes int            `json:"meta_refreshes,omitempty"`
+	JSRedirect    string         `json:"js_redirect,omitempty"`
+
+	BodyID   string `json:"body_id,omitempty"`
+	BodySize int    `json:"body_size,omitempty"`
+
+	Pages     [][]byte `json:"pages,omitempty"`
+	PageCount int      `json:"page_count,omitempty"`
+}
+
+func (r *Response) UnmarshalJSON(data []byte) error {
+	type envelope Response
+	var raw struct {
+		envelope
+		Body json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = Response(raw.envelope)
+
+	if len(raw.Body) == 0 || string(raw.Body) == "null" {
+		return nil
+	}
+	var body string
+	if err := json.Unmarshal(raw.Body, &body); err != nil {
+		return err
+	}
+
+	switch r.BodyEncoding {
+	case "string":
+		r.Body = []byte(body)
+	case "gzip+base64":
+		compressed, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return err
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		r.Body, err = io.ReadAll(reader)
+		return err
+	default:
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return err
+		}
+		r.Body = decoded
+	}
+	return nil
+}
+
+// JobState is the state of an async job
+type JobState string
+
+const (
+	JobPending JobState = "pending"
+	JobRunning JobState = "running"
+	JobDone    JobState = "done"
+	JobFailed  JobState = "failed"
+)
+
+// JobStatus is the state of an async job, with its response once done
+type JobStatus struct {
+	ID         string        `json:"id"`
+	State      JobState      `json:"state"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Response   *Response     `json:"response,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	ErrorCode  string        `json:"error_code,omitempty"`
+	Errors     []ErrorDetail `json:"errors,omitempty"`
+
+	Callback *CallbackStatus `json:"callback,omitempty"`
+}
+
+// HistoryJob is a finished job of the job history
+type HistoryJob struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	State      JobState  `json:"state"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMs int64     `json:"duration_ms"`
+
+	StatusCode    int           `json:"status_code,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	Errors        []ErrorDetail `json:"errors,omitempty"`
+	UpstreamProxy string        `json:"upstream_proxy,omitempty"`
+	Attempts      int           `json:"attempts,omitempty"`
+	ResponseSize  int           `json:"response_size"`
+}
+
+// HistoryQuery filters the job history, zero fields match every job
+type HistoryQuery struct {
+	State JobState
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// CallbackStatus is the delivery of the callback of an async job
+type CallbackStatus struct {
+	State    string            `json:"state"`
+	Attempts []CallbackAttempt `json:"attempts,omitempty"`
+}
+
+// CallbackAttempt is a single POST to the callback URL
+type CallbackAttempt struct {
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// Callback is the body posted to the callback URL of an async job
+type Callback struct {
+	JobID      string        `json:"job_id"`
+	State      JobState      `json:"state"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	DurationMs int64         `json:"duration_ms"`
+	Response   *Response     `json:"response,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	ErrorCode  string        `json:"error_code,omitempty"`
+	Errors     []ErrorDetail `json:"errors,omitempty"`
+}